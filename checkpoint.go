@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kheob/ml/nn"
+)
+
+// latestCheckpoint is the file in a checkpoint directory naming the most
+// recent checkpoint in it.
+const latestCheckpoint = "latest"
+
+// checkpointer saves numbered checkpoints of a training run to a directory
+// every so many epochs or minutes. Each checkpoint has the config of the run
+// saved alongside it, as for a trained model, so it can be resumed.
+type checkpointer struct {
+	dir string
+	// every is the number of epochs between checkpoints and interval the
+	// time between them, zero for never.
+	every    int
+	interval time.Duration
+	cfg      trainConfig
+
+	last time.Time
+	next int
+	// latest is the path of the last checkpoint saved.
+	latest string
+}
+
+// newCheckpointer returns a checkpointer for the run described by cfg,
+// carrying on the numbering of any checkpoints already in the directory.
+func newCheckpointer(cfg trainConfig) (*checkpointer, error) {
+	dir := checkpointDir(cfg)
+	c := &checkpointer{
+		dir:      dir,
+		every:    cfg.Checkpoint.Epochs,
+		interval: time.Duration(cfg.Checkpoint.Minutes * float64(time.Minute)),
+		cfg:      cfg,
+		last:     time.Now(),
+		next:     1,
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "checkpoint-*.model"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range existing {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(path), "checkpoint-%d.model", &n); err == nil && n >= c.next {
+			c.next = n + 1
+		}
+	}
+	return c, nil
+}
+
+// checkpointDir returns the directory to save checkpoints of the run
+// described by cfg in, by default next to the model.
+func checkpointDir(cfg trainConfig) string {
+	if cfg.Checkpoint.Dir != "" {
+		return cfg.Checkpoint.Dir
+	}
+	return strings.TrimSuffix(cfg.Model, filepath.Ext(cfg.Model)) + "_checkpoints"
+}
+
+// ready reports whether the interval has passed since the last
+// checkpoint.
+func (c *checkpointer) ready() bool {
+	return c.interval > 0 && time.Since(c.last) >= c.interval
+}
+
+// epochDone saves a checkpoint at the end of an epoch if one is due.
+func (c *checkpointer) epochDone(net nn.Network, epoch int) error {
+	cp := nn.Checkpoint{Epoch: epoch + 1}
+	if (c.every > 0 && (epoch+1)%c.every == 0) || c.ready() {
+		return c.save(net, cp)
+	}
+	return nil
+}
+
+// save writes the next numbered checkpoint and marks it as the latest.
+func (c *checkpointer) save(net nn.Network, cp nn.Checkpoint) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("checkpoint-%03d.model", c.next)
+	path := filepath.Join(c.dir, name)
+	if err := net.SaveCheckpoint(path, cp); err != nil {
+		return fmt.Errorf("saving checkpoint: %w", err)
+	}
+	if err := c.cfg.save(path + ".yaml"); err != nil {
+		return fmt.Errorf("saving checkpoint config: %w", err)
+	}
+	latest := filepath.Join(c.dir, latestCheckpoint)
+	if err := os.WriteFile(latest+".tmp", []byte(name+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(latest+".tmp", latest); err != nil {
+		return err
+	}
+	fmt.Printf("saved checkpoint %s\n", path)
+	c.latest = path
+	c.next++
+	c.last = time.Now()
+	return nil
+}
+
+// resolveCheckpoint returns the checkpoint file at path, or the latest
+// checkpoint if path is a checkpoint directory.
+func resolveCheckpoint(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+	b, err := os.ReadFile(filepath.Join(path, latestCheckpoint))
+	if err != nil {
+		return "", fmt.Errorf("no latest checkpoint in %s: %w", path, err)
+	}
+	return filepath.Join(path, strings.TrimSpace(string(b))), nil
+}
+
+// keepVersions makes way for a new version of the model at path, and its
+// config, by copying them to path.1 and path.1.yaml after moving those to
+// path.2 and so on, keeping n earlier versions in all. The model itself is
+// left in place until the new one replaces it.
+func keepVersions(path string, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	for _, ext := range []string{"", ".yaml"} {
+		version := func(i int) string {
+			if i == 0 {
+				return path + ext
+			}
+			return fmt.Sprintf("%s.%d%s", path, i, ext)
+		}
+		for i := n - 1; i >= 1; i-- {
+			if err := os.Rename(version(i), version(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := copyFile(version(0), version(1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file at src to dst through a temporary file that is
+// synced to disk and renamed into place, as nn saves models, so a crash part
+// way through never leaves a truncated copy at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	// CreateTemp leaves the file readable by its owner alone
+	err = out.Chmod(0644)
+	if err == nil {
+		_, err = io.Copy(out, in)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
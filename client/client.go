@@ -0,0 +1,46 @@
+// Package client calls the Inference gRPC service of ml serve, started with
+// -grpc-addr.
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/kheob/ml/proto/inferencepb"
+)
+
+// Client is a connection to a model served by ml serve. It is safe for
+// concurrent use.
+type Client struct {
+	conn *grpc.ClientConn
+	inf  inferencepb.InferenceClient
+}
+
+// Dial connects to the gRPC service of ml serve at addr. Unless opts say
+// otherwise the connection is not encrypted, as ml serve does not use TLS.
+func Dial(addr string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, inf: inferencepb.NewInferenceClient(conn)}, nil
+}
+
+// Predict classifies an image given by its 784 pixel values, row by row, each
+// in the range 0-255.
+func (c *Client) Predict(ctx context.Context, pixels []float64) (*inferencepb.PredictResponse, error) {
+	return c.inf.Predict(ctx, &inferencepb.PredictRequest{Pixels: pixels})
+}
+
+// Info describes the model being served.
+func (c *Client) Info(ctx context.Context) (*inferencepb.ModelInfo, error) {
+	return c.inf.Info(ctx, &inferencepb.InfoRequest{})
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
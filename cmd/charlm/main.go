@@ -0,0 +1,140 @@
+// Command charlm is an example of training a next-character model on a text
+// file with an LSTM layer. Run it with
+//
+//	go run ./cmd/charlm -text input.txt
+//
+// After every epoch it prints text generated by the model, starting from
+// the first characters of the file and picking each next character at
+// random by the probabilities the model gives. The model can be saved with
+// -model and loaded with nn.LoadNetwork, along with the text it was trained
+// on to give the alphabet.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/nn"
+	"gonum.org/v1/gonum/mat"
+)
+
+func main() {
+	text := flag.String("text", "", "Text file to train on")
+	steps := flag.Int("steps", 32, "Number of characters the model sees before predicting the next")
+	hidden := flag.Int("hidden", 128, "Number of hidden values of the LSTM layer")
+	epochs := flag.Int("epochs", 10, "Number of passes over the text")
+	batchSize := flag.Int("batch-size", 32, "Number of sequences per mini-batch")
+	rate := flag.Float64("lr", 0.005, "Learning rate of the Adam optimizer")
+	generate := flag.Int("generate", 200, "Number of characters to generate after each epoch")
+	temperature := flag.Float64("temperature", 0.8, "Temperature to sample generated characters at, lower for safer choices")
+	model := flag.String("model", "", "Path to save the trained model to")
+	seed := flag.Int64("seed", 1, "Random seed for the weights, shuffling and sampling")
+	flag.Parse()
+	if *text == "" {
+		fmt.Fprintln(os.Stderr, "charlm: -text is required")
+		os.Exit(2)
+	}
+	if err := run(*text, *steps, *hidden, *epochs, *batchSize, *rate, *generate, *temperature, *model, *seed); err != nil {
+		fmt.Fprintln(os.Stderr, "charlm:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, steps, hidden, epochs, batchSize int, rate float64, generate int, temperature float64, model string, seed int64) error {
+	data, err := dataset.ReadText(path, steps)
+	if err != nil {
+		return err
+	}
+	chars := data.Features()
+	fmt.Printf("%d sequences over an alphabet of %d characters\n", data.Len(), chars)
+	net := nn.NewSequential(
+		nn.LSTM(chars, hidden, steps, false),
+		nn.Dense(hidden, chars),
+		nn.Softmax(),
+	).Network(rate, nn.WithSeed(seed), nn.WithOptimizer(&nn.Adam{}), nn.WithInitializer(nn.XavierUniform{}))
+
+	rng := rand.New(rand.NewSource(seed))
+	for epoch := 0; epoch < epochs; epoch++ {
+		order := rng.Perm(data.Len())
+		total, batches := 0.0, 0
+		for start := 0; start < len(order); start += batchSize {
+			end := start + batchSize
+			if end > len(order) {
+				end = len(order)
+			}
+			var inputs, targets [][]float64
+			for _, i := range order[start:end] {
+				s := data.At(i)
+				inputs, targets = append(inputs, s.Inputs), append(targets, s.Targets)
+			}
+			loss, err := net.TrainBatch(inputs, targets)
+			if err != nil {
+				return err
+			}
+			total += loss
+			batches++
+		}
+		fmt.Printf("epoch %d: loss %.4f\n", epoch+1, total/float64(batches))
+		sample, err := sampleText(net, data, generate, temperature, rng)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n\n", sample)
+	}
+	if model != "" {
+		return net.Save(model)
+	}
+	return nil
+}
+
+// sampleText generates n characters following the first sequence of the
+// text, sampling each from the outputs of the network softened by the
+// temperature.
+func sampleText(net nn.Network, data *dataset.Text, n int, temperature float64, rng *rand.Rand) (string, error) {
+	first := data.At(0).Inputs
+	var text []rune
+	for s := 0; s < data.Steps(); s++ {
+		text = append(text, data.Alphabet[argmax(first[s*data.Features():(s+1)*data.Features()])])
+	}
+	for i := 0; i < n; i++ {
+		inputs, err := data.Encode(text[len(text)-data.Steps():])
+		if err != nil {
+			return "", err
+		}
+		outputs := mat.Col(nil, 0, net.Predict(inputs))
+		text = append(text, data.Alphabet[pick(outputs, temperature, rng)])
+	}
+	return string(text), nil
+}
+
+// pick picks an index at random with the probabilities p raised to the
+// power of 1/temperature.
+func pick(p []float64, temperature float64, rng *rand.Rand) int {
+	weights := make([]float64, len(p))
+	sum := 0.0
+	for i, v := range p {
+		weights[i] = math.Pow(v, 1/temperature)
+		sum += weights[i]
+	}
+	r := rng.Float64() * sum
+	for i, w := range weights {
+		if r -= w; r < 0 {
+			return i
+		}
+	}
+	return len(p) - 1
+}
+
+func argmax(v []float64) int {
+	best := 0
+	for i := range v {
+		if v[i] > v[best] {
+			best = i
+		}
+	}
+	return best
+}
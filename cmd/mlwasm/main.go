@@ -0,0 +1,76 @@
+//go:build js && wasm
+
+// Command mlwasm runs a trained network in the browser. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o ml.wasm ./cmd/mlwasm
+//
+// and load it with the wasm_exec.js that comes with Go. It defines two
+// JavaScript functions:
+//
+//	mlLoad(bytes)     loads a model file from a Uint8Array
+//	mlPredict(pixels) classifies the 784 pixel values of a 28 x 28
+//	                  greyscale image, in the range 0-255, returning
+//	                  {label, outputs}
+//
+// Both return an Error if something goes wrong.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"syscall/js"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/nn"
+)
+
+var net *nn.Network
+
+func main() {
+	js.Global().Set("mlLoad", js.FuncOf(load))
+	js.Global().Set("mlPredict", js.FuncOf(predict))
+	// keep running so the functions stay callable
+	select {}
+}
+
+func load(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 {
+		return jsError(fmt.Errorf("mlLoad takes the model bytes"))
+	}
+	b := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(b, args[0])
+	n, err := nn.ReadNetwork(bytes.NewReader(b))
+	if err != nil {
+		return jsError(err)
+	}
+	net = &n
+	return nil
+}
+
+func predict(this js.Value, args []js.Value) interface{} {
+	if net == nil {
+		return jsError(fmt.Errorf("no model loaded, call mlLoad first"))
+	}
+	if len(args) != 1 || args[0].Get("length").Int() != net.Inputs() {
+		return jsError(fmt.Errorf("mlPredict takes an array of %d pixel values", net.Inputs()))
+	}
+	inputs := make([]float64, net.Inputs())
+	for i := range inputs {
+		inputs[i] = dataset.PixelInput(args[0].Index(i).Float())
+	}
+
+	outputs := net.Predict(inputs)
+	values := make([]interface{}, net.Outputs())
+	for i := range values {
+		values[i] = outputs.At(i, 0)
+	}
+	return map[string]interface{}{
+		"label":   net.Classify(inputs),
+		"outputs": values,
+	}
+}
+
+// jsError returns err as a JavaScript Error.
+func jsError(err error) interface{} {
+	return js.Global().Get("Error").New(err.Error())
+}
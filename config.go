@@ -0,0 +1,596 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/eval"
+	"github.com/kheob/ml/nn"
+	"gopkg.in/yaml.v3"
+)
+
+// trainConfig holds everything needed to reproduce a training run. It can
+// be loaded from a YAML file with ml train -config, and flags given on the
+// command line override the values in the file.
+type trainConfig struct {
+	Model   string `yaml:"model"`
+	Dataset string `yaml:"dataset"`
+	// TrainData is the path of the training data, or empty for the default
+	// directory of the dataset.
+	TrainData string `yaml:"train_data"`
+	// MemoryLimit caps how many megabytes the training data may take up in
+	// memory, beyond which it is streamed from disk every epoch instead.
+	// Zero means no limit.
+	MemoryLimit int64 `yaml:"memory_limit_mb"`
+	// SkipBadRows skips the rows of CSV training data that cannot be read,
+	// logging each, rather than stopping at the first.
+	SkipBadRows bool `yaml:"skip_bad_rows,omitempty"`
+	// KeepVersions is how many earlier versions of the model to keep when
+	// saving over it, the last as <model>.1, the one before as <model>.2
+	// and so on, each with its config.
+	KeepVersions int `yaml:"keep_versions,omitempty"`
+
+	// Task is what the network learns: classify, to predict the label of
+	// each sample, or autoencoder, to reproduce the inputs of each sample
+	// through a smaller code.
+	Task string `yaml:"task"`
+
+	// CSV describes the layout of the training data when Dataset is csv.
+	CSV csvConfig `yaml:"csv,omitempty"`
+
+	// Conv lists the convolutional layers to put before the hidden layers,
+	// for image datasets.
+	Conv    convLayers `yaml:"conv,omitempty"`
+	Hidden  sizes      `yaml:"hidden"`
+	Softmax bool       `yaml:"softmax"`
+	// Loss names the loss to train with, or is empty for cross-entropy
+	// with a softmax output layer and mse otherwise.
+	Loss string `yaml:"loss,omitempty"`
+	// LabelSmoothing is the fraction of the way the one-hot targets are
+	// moved towards an even spread over the classes while training.
+	LabelSmoothing float64 `yaml:"label_smoothing,omitempty"`
+	// Mixup is the alpha of the Beta distribution the weights for mixing
+	// the samples of each mini-batch in pairs are drawn from, or zero to
+	// train on the samples as they are.
+	Mixup float64 `yaml:"mixup,omitempty"`
+	// Init names the initializer for the starting weights.
+	Init string `yaml:"init"`
+	// Dropout holds the dropout rate of each hidden layer, or a single
+	// rate for all of them.
+	Dropout rates `yaml:"dropout,omitempty"`
+	// Embedding is the number of values each category of the categorical
+	// columns of a csv dataset is embedded as.
+	Embedding int `yaml:"embedding"`
+
+	Epochs    int     `yaml:"epochs"`
+	Shuffle   bool    `yaml:"shuffle"`
+	Sampler   string  `yaml:"sampler,omitempty"`
+	Augment   string  `yaml:"augment,omitempty"`
+	ValSplit  float64 `yaml:"val_split"`
+	EarlyStop struct {
+		// Patience is the number of epochs without improvement in the
+		// validation Metric, loss or accuracy, to stop after. Zero never
+		// stops early.
+		Patience int    `yaml:"patience"`
+		Metric   string `yaml:"metric"`
+	} `yaml:"early_stop"`
+	BatchSize    int     `yaml:"batch_size"`
+	LearningRate float64 `yaml:"learning_rate"`
+	// WeightDecay and L1 weigh the L2 and L1 penalties on the weights.
+	WeightDecay float64 `yaml:"weight_decay"`
+	L1          float64 `yaml:"l1"`
+	Optimizer   string  `yaml:"optimizer"`
+	// Momentum, Rho, Beta1, Beta2 and Epsilon are the hyperparameters of
+	// the optimizers that have them, or zero for their defaults.
+	Momentum float64 `yaml:"momentum,omitempty"`
+	Rho      float64 `yaml:"rho,omitempty"`
+	Beta1    float64 `yaml:"beta1,omitempty"`
+	Beta2    float64 `yaml:"beta2,omitempty"`
+	Epsilon  float64 `yaml:"epsilon,omitempty"`
+	// LayerRates scales the learning rate of the layers with weights,
+	// counted from 1 at the inputs, by the factor given for each.
+	LayerRates layerRates `yaml:"layer_rates,omitempty"`
+	// Lookahead holds the settings of a lookahead optimizer, or zero for
+	// their defaults.
+	Lookahead struct {
+		Steps int     `yaml:"steps,omitempty"`
+		Alpha float64 `yaml:"alpha,omitempty"`
+	} `yaml:"lookahead,omitempty"`
+	Precision string `yaml:"precision"`
+	Schedule  struct {
+		Name  string  `yaml:"name"`
+		Min   float64 `yaml:"min"`
+		Step  int     `yaml:"step"`
+		Gamma float64 `yaml:"gamma"`
+		// Warmup is the fraction of the epochs the onecycle schedule
+		// spends raising the learning rate, or zero for its default.
+		Warmup float64 `yaml:"warmup,omitempty"`
+		// WarmupSteps is the number of mini-batches to ramp the learning
+		// rate up over before the schedule takes over, 0 for none.
+		WarmupSteps int `yaml:"warmup_steps,omitempty"`
+	} `yaml:"schedule"`
+
+	// Checkpoint controls saving checkpoints to Dir, by default
+	// <model>_checkpoints, every Epochs epochs or Minutes minutes. Zero
+	// turns either off.
+	Checkpoint struct {
+		Dir     string  `yaml:"dir,omitempty"`
+		Epochs  int     `yaml:"epochs"`
+		Minutes float64 `yaml:"minutes"`
+	} `yaml:"checkpoint"`
+
+	// Ensemble trains several networks to save as an ensemble file in place
+	// of a single model, if Method is set. bagging trains Size networks,
+	// Parallel at a time, each on a bootstrap resample of the training data.
+	// snapshot trains one network with a learning rate that falls along a
+	// cosine and restarts Size times over the epochs, taking a snapshot at
+	// the end of each cycle. Combine is how the ensemble combines the
+	// outputs of the networks, average or vote.
+	Ensemble struct {
+		Method   string `yaml:"method,omitempty"`
+		Size     int    `yaml:"size"`
+		Parallel int    `yaml:"parallel"`
+		Combine  string `yaml:"combine"`
+	} `yaml:"ensemble"`
+
+	// Log is the path of a file to record the metrics of every epoch in, as
+	// CSV if it ends in .csv and JSON lines otherwise.
+	Log string `yaml:"log,omitempty"`
+	// Metrics names the metrics to report on the validation data after
+	// every epoch, beyond its loss and accuracy.
+	Metrics metricNames `yaml:"metrics,omitempty"`
+	// RunID identifies the run in the training log. A new one is made for
+	// every run, other than when resuming from a checkpoint.
+	RunID string `yaml:"run_id"`
+
+	// Workers is the number of goroutines each mini-batch is split
+	// between.
+	Workers int `yaml:"workers"`
+	// CheckFinite stops training with the epoch, batch and layer at fault
+	// as soon as a NaN or infinity turns up.
+	CheckFinite bool `yaml:"check_finite"`
+
+	// Comment is saved in the metadata of the model, to note anything
+	// worth knowing about it.
+	Comment string `yaml:"comment,omitempty"`
+
+	// Quiet turns off the progress display. It has no effect on the run so
+	// is not saved.
+	Quiet bool `yaml:"-"`
+
+	// Seed is the random seed for the run, which sets the initial weights
+	// and the order samples are shuffled in, or zero to pick one from the
+	// current time. The seed actually used is saved with the model.
+	Seed int64 `yaml:"seed"`
+}
+
+// csvConfig holds the settings for reading tabular data with the csv
+// dataset.
+type csvConfig struct {
+	LabelColumn int    `yaml:"label_column"`
+	Header      bool   `yaml:"header"`
+	Delimiter   string `yaml:"delimiter"`
+	Normalize   string `yaml:"normalize"`
+	// NormalizeColumns overrides Normalize for some columns, as a comma
+	// separated list of column:normalization pairs such as 3:zscore,5:none.
+	NormalizeColumns string `yaml:"normalize_columns,omitempty"`
+	OneHot           bool   `yaml:"one_hot"`
+	// Regression predicts the number in the label column rather than a
+	// class, with a linear output layer.
+	Regression bool `yaml:"regression,omitempty"`
+	// Categorical is a comma separated list of the columns holding
+	// categories rather than numbers, such as 2,5, which are embedded by
+	// the first layer of the network.
+	Categorical string `yaml:"categorical,omitempty"`
+}
+
+func defaultCSVConfig() csvConfig {
+	return csvConfig{Delimiter: ",", Normalize: "minmax", OneHot: true}
+}
+
+// registerOptimizer adds flags for the optimizer of c to fs.
+func (c *trainConfig) registerOptimizer(fs *flag.FlagSet) {
+	fs.StringVar(&c.Optimizer, "optimizer", c.Optimizer, "Optimizer to train with: sgd, momentum, nesterov, adagrad, adadelta (best with -lr 1), rmsprop, adam, adamw, which takes -weight-decay as its decoupled weight decay, or lbfgs, which trains small networks on the whole of the data at once, taking -epochs as its most iterations. Wrap one in lookahead, e.g. lookahead(adam), to have it look ahead -lookahead-steps steps")
+	fs.Float64Var(&c.Momentum, "momentum", c.Momentum, "Momentum of the momentum and nesterov optimizers (default 0.9)")
+	fs.Float64Var(&c.Rho, "rho", c.Rho, "Decay of the running averages of rmsprop (default 0.9) and adadelta (default 0.95)")
+	fs.Float64Var(&c.Beta1, "beta1", c.Beta1, "Decay of the running average of the gradients for adam and adamw (default 0.9)")
+	fs.Float64Var(&c.Beta2, "beta2", c.Beta2, "Decay of the running average of the squared gradients for adam and adamw (default 0.999)")
+	fs.IntVar(&c.Lookahead.Steps, "lookahead-steps", c.Lookahead.Steps, "Steps the optimizer wrapped by lookahead takes before the slow weights catch up (default 5)")
+	fs.Float64Var(&c.Lookahead.Alpha, "lookahead-alpha", c.Lookahead.Alpha, "Fraction of the way the slow weights of lookahead move towards the fast ones (default 0.5)")
+	fs.Var(&c.LayerRates, "layer-rates", "Comma separated factors to scale the learning rate of some layers by, each layer:factor counting the layers with weights from 1 at the inputs, e.g. 1:0.1,3:10")
+	fs.Float64Var(&c.Epsilon, "epsilon", c.Epsilon, "Term added by adagrad, rmsprop, adam and adamw to the denominator to keep it from zero (default 1e-8), and by adadelta to both averages (default 1e-6)")
+}
+
+// optimizer returns the optimizer described by c.
+func (c trainConfig) optimizer() (nn.Optimizer, error) {
+	for _, v := range []float64{c.Momentum, c.Rho, c.Beta1, c.Beta2} {
+		if v < 0 || v >= 1 {
+			return nil, fmt.Errorf("momentum, rho and betas must be between 0 and 1, got %g", v)
+		}
+	}
+	if c.Epsilon < 0 {
+		return nil, fmt.Errorf("epsilon must not be negative, got %g", c.Epsilon)
+	}
+	if c.Optimizer == "lbfgs" {
+		// fit trains with L-BFGS itself, so the network keeps a plain one
+		return nn.SGD{}, nil
+	}
+	if c.Lookahead.Steps < 0 || c.Lookahead.Alpha < 0 || c.Lookahead.Alpha > 1 {
+		return nil, fmt.Errorf("lookahead steps must not be negative and alpha must be between 0 and 1, got %d and %g", c.Lookahead.Steps, c.Lookahead.Alpha)
+	}
+	opt, err := nn.OptimizerByName(c.Optimizer)
+	if err != nil {
+		return nil, err
+	}
+	inner := opt
+	if o, ok := opt.(*nn.Lookahead); ok {
+		o.K, o.Alpha = c.Lookahead.Steps, c.Lookahead.Alpha
+		inner = o.Inner
+	}
+	switch o := inner.(type) {
+	case *nn.Momentum:
+		o.Momentum = c.Momentum
+	case *nn.AdaGrad:
+		o.Epsilon = c.Epsilon
+	case *nn.AdaDelta:
+		o.Rho, o.Epsilon = c.Rho, c.Epsilon
+	case *nn.RMSProp:
+		o.Decay, o.Epsilon = c.Rho, c.Epsilon
+	case *nn.Adam:
+		o.Beta1, o.Beta2, o.Epsilon = c.Beta1, c.Beta2, c.Epsilon
+	case *nn.AdamW:
+		o.Beta1, o.Beta2, o.Epsilon = c.Beta1, c.Beta2, c.Epsilon
+		o.WeightDecay = c.WeightDecay
+	}
+	return opt, nil
+}
+
+// register adds flags for c to fs.
+func (c *csvConfig) register(fs *flag.FlagSet) {
+	fs.IntVar(&c.LabelColumn, "label-column", c.LabelColumn, "csv: index of the label column, negative to count from the end")
+	fs.BoolVar(&c.Header, "header", c.Header, "csv: skip a header row")
+	fs.StringVar(&c.Delimiter, "delimiter", c.Delimiter, "csv: field delimiter, use \\t for tabs")
+	fs.StringVar(&c.Normalize, "normalize", c.Normalize, "csv: normalization for the input columns: none, minmax or zscore")
+	fs.StringVar(&c.NormalizeColumns, "normalize-columns", c.NormalizeColumns, "csv: per column normalization overriding -normalize, e.g. 3:zscore,5:none")
+	fs.BoolVar(&c.OneHot, "one-hot", c.OneHot, "csv: one-hot encode the label, otherwise use a single output holding the class index")
+	fs.BoolVar(&c.Regression, "regression", c.Regression, "csv: predict the number in the label column rather than a class")
+	fs.StringVar(&c.Categorical, "categorical", c.Categorical, "csv: comma separated indexes of the columns holding categories rather than numbers, e.g. 2,5")
+}
+
+// options converts c to the options for dataset.OpenCSV.
+func (c csvConfig) options() (dataset.CSVOptions, error) {
+	opts := dataset.CSVOptions{
+		LabelColumn: c.LabelColumn,
+		Header:      c.Header,
+		OneHot:      c.OneHot,
+		Regression:  c.Regression,
+	}
+
+	delim := c.Delimiter
+	if delim == "\\t" {
+		delim = "\t"
+	}
+	if utf8.RuneCountInString(delim) != 1 {
+		return opts, fmt.Errorf("delimiter must be a single character, got %q", c.Delimiter)
+	}
+	opts.Delimiter, _ = utf8.DecodeRuneInString(delim)
+
+	var err error
+	if opts.Normalization, err = dataset.ParseNormalization(c.Normalize); err != nil {
+		return opts, err
+	}
+	for _, col := range strings.Split(c.Categorical, ",") {
+		if col = strings.TrimSpace(col); col == "" {
+			continue
+		}
+		i, err := strconv.Atoi(col)
+		if err != nil || i < 0 {
+			return opts, fmt.Errorf("invalid categorical column %q", col)
+		}
+		opts.Categorical = append(opts.Categorical, i)
+	}
+	if c.NormalizeColumns != "" {
+		opts.ColumnNormalization = map[int]dataset.Normalization{}
+		for _, pair := range strings.Split(c.NormalizeColumns, ",") {
+			col, name, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			i, err := strconv.Atoi(col)
+			if !ok || err != nil {
+				return opts, fmt.Errorf("invalid column normalization %q", pair)
+			}
+			if opts.ColumnNormalization[i], err = dataset.ParseNormalization(name); err != nil {
+				return opts, err
+			}
+		}
+	}
+	return opts, nil
+}
+
+func defaultTrainConfig() trainConfig {
+	var c trainConfig
+	c.Model = "data/mnist.model"
+	c.Dataset = "mnist"
+	c.Task = "classify"
+	c.CSV = defaultCSVConfig()
+	c.Hidden = sizes{200}
+	c.Embedding = 8
+	c.Init = "uniform"
+	c.Epochs = 5
+	c.EarlyStop.Metric = "loss"
+	c.BatchSize = 1
+	c.Workers = 1
+	c.LearningRate = 0.1
+	c.Optimizer = "sgd"
+	c.Precision = "float64"
+	c.Schedule.Name = "constant"
+	c.Schedule.Step = 1
+	c.Schedule.Gamma = 0.5
+	c.Ensemble.Size = 5
+	c.Ensemble.Parallel = 1
+	c.Ensemble.Combine = "average"
+	return c
+}
+
+// load reads the YAML config file at path over the top of c.
+func (c *trainConfig) load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(b)))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// save writes c to path as YAML.
+func (c trainConfig) save(path string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// rates is a list of rates from 0 up to but not including 1, written as a
+// comma separated list on the command line.
+type rates []float64
+
+func (r *rates) String() string {
+	parts := make([]string, len(*r))
+	for i, v := range *r {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *rates) Set(v string) error {
+	var parsed rates
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil || x < 0 || x >= 1 {
+			return fmt.Errorf("invalid rate %q", f)
+		}
+		parsed = append(parsed, x)
+	}
+	*r = parsed
+	return nil
+}
+
+// layerRates maps layers with weights, counted from 1 at the inputs, to the
+// factors to scale their learning rates by, written as a comma separated
+// list of layer:factor on the command line.
+type layerRates map[int]float64
+
+func (r *layerRates) String() string {
+	layers := make([]int, 0, len(*r))
+	for l := range *r {
+		layers = append(layers, l)
+	}
+	sort.Ints(layers)
+	parts := make([]string, len(layers))
+	for i, l := range layers {
+		parts[i] = strconv.Itoa(l) + ":" + strconv.FormatFloat((*r)[l], 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *layerRates) Set(v string) error {
+	parsed := layerRates{}
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		layer, factor, ok := strings.Cut(f, ":")
+		l, err := strconv.Atoi(layer)
+		if !ok || err != nil || l < 1 {
+			return fmt.Errorf("invalid layer rate %q, want layer:factor with layers counted from 1", f)
+		}
+		x, err := strconv.ParseFloat(factor, 64)
+		if err != nil || x < 0 {
+			return fmt.Errorf("invalid layer rate factor %q", factor)
+		}
+		parsed[l] = x
+	}
+	*r = parsed
+	return nil
+}
+
+// options returns the rates as an option for a network, which counts the
+// layers from 0.
+func (r layerRates) options() []nn.Option {
+	if len(r) == 0 {
+		return nil
+	}
+	rates := make(map[int]float64, len(r))
+	for l, x := range r {
+		rates[l-1] = x
+	}
+	return []nn.Option{nn.WithLayerRates(rates)}
+}
+
+// check returns an error if a rate is for a layer past the given number of
+// layers with weights.
+func (r layerRates) check(weighted int) error {
+	for l := range r {
+		if l > weighted {
+			return fmt.Errorf("cannot set the learning rate of layer %d of a model with %d layers with weights", l, weighted)
+		}
+	}
+	return nil
+}
+
+// metricNames is a list of the names of metrics to report, as
+// eval.MetricByName takes them, written as a comma separated list on the
+// command line.
+type metricNames []string
+
+func (m *metricNames) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *metricNames) Set(v string) error {
+	var parsed metricNames
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if _, err := eval.MetricByName(f); err != nil {
+			return err
+		}
+		parsed = append(parsed, f)
+	}
+	*m = parsed
+	return nil
+}
+
+// metrics returns a new metric for each name.
+func (m metricNames) metrics() ([]eval.Metric, error) {
+	metrics := make([]eval.Metric, len(m))
+	for i, name := range m {
+		var err error
+		if metrics[i], err = eval.MetricByName(name); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+// sizes is a list of positive whole numbers such as layer sizes, written as
+// a comma separated list on the command line.
+type sizes []int
+
+func (s *sizes) String() string {
+	parts := make([]string, len(*s))
+	for i, n := range *s {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *sizes) Set(v string) error {
+	var parsed sizes
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid size %q", f)
+		}
+		parsed = append(parsed, n)
+	}
+	*s = parsed
+	return nil
+}
+
+// convLayers is a list of convolutional layers, written on the command line
+// and in config files as a comma separated list of filters:kernel with an
+// optional :stride and :padding, such as 8:5,16:3:2:1. A layer may be
+// followed by max:kernel or avg:kernel, with an optional :stride, to pool
+// its outputs, as in 8:5,max:2.
+type convLayers []nn.Conv2D
+
+func (c *convLayers) String() string {
+	var parts []string
+	for _, l := range *c {
+		parts = append(parts, fmt.Sprintf("%d:%d:%d:%d", l.Filters, l.Kernel, l.Stride, l.Padding))
+		if l.Pool.Type != nn.NoPooling {
+			parts = append(parts, fmt.Sprintf("%s:%d:%d", l.Pool.Type, l.Pool.Kernel, l.Pool.Stride))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (c *convLayers) Set(v string) error {
+	var parsed convLayers
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		parts := strings.Split(f, ":")
+		if pooling, err := nn.PoolingByName(parts[0]); err == nil && pooling != nn.NoPooling {
+			if len(parsed) == 0 || parsed[len(parsed)-1].Pool.Type != nn.NoPooling {
+				return fmt.Errorf("pooling %q must follow a convolutional layer", f)
+			}
+			values, err := convValues(f, parts[1:], 1, 2)
+			if err != nil {
+				return err
+			}
+			parsed[len(parsed)-1].Pool = nn.Pool2D{Type: pooling, Kernel: values[0], Stride: values[1]}
+			continue
+		}
+		values, err := convValues(f, parts, 2, 4)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, nn.Conv2D{Filters: values[0], Kernel: values[1], Stride: values[2], Padding: values[3]})
+	}
+	*c = parsed
+	return nil
+}
+
+// convValues parses between min and max numbers of a convolutional or
+// pooling layer, returning max of them with any left out as zero. Only
+// the optional ones may be zero, which leaves them at their defaults.
+func convValues(layer string, parts []string, min, max int) ([]int, error) {
+	if len(parts) < min || len(parts) > max {
+		return nil, fmt.Errorf("invalid layer %q", layer)
+	}
+	values := make([]int, max)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n == 0 && i < min {
+			return nil, fmt.Errorf("invalid layer %q", layer)
+		}
+		values[i] = n
+	}
+	return values, nil
+}
+
+func (c convLayers) MarshalYAML() (interface{}, error) {
+	return c.String(), nil
+}
+
+func (c *convLayers) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return c.Set(s)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/eval"
+)
+
+// crossValidate estimates how well the training described by cfg does on
+// unseen data by k-fold cross-validation: it trains k networks, each
+// holding back a different kth of the training data to evaluate on, and
+// reports the mean and standard deviation of their metrics.
+func crossValidate(cfg trainConfig, k int) error {
+	if k < 2 {
+		return fmt.Errorf("cross-validation needs at least 2 folds, got %d", k)
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UTC().UnixNano()
+	}
+	if _, err := networkOptions(cfg); err != nil {
+		return err
+	}
+	set, err := loadTrainingSet(cfg)
+	if err != nil {
+		return err
+	}
+	indexed, ok := set.data.(dataset.Indexed)
+	if !ok {
+		return fmt.Errorf("cross-validation needs the training data to fit in memory")
+	}
+	if indexed.Len() < k {
+		return fmt.Errorf("cannot split %d samples into %d folds", indexed.Len(), k)
+	}
+
+	train, held := dataset.KFold(indexed, k, rand.New(rand.NewSource(cfg.Seed)))
+	folds := make([]eval.Metrics, k)
+	for i := range folds {
+		fold := set
+		fold.data = train[i]
+		netOpts, err := networkOptions(cfg)
+		if err != nil {
+			return err
+		}
+		net, _, err := buildNetwork(cfg, fold, netOpts, "")
+		if err != nil {
+			return err
+		}
+		data, opts, err := fitSetup(cfg, fold)
+		if err != nil {
+			return err
+		}
+		opts.output = io.Discard
+		if err := fit(&net, data, opts); err != nil {
+			return fmt.Errorf("fold %d: training: %w", i+1, err)
+		}
+		if folds[i], err = eval.Evaluate(net, held[i], eval.Options{Regression: opts.regression}); err != nil {
+			return fmt.Errorf("fold %d: %w", i+1, err)
+		}
+		fmt.Printf("fold %d/%d: %d samples, %s\n", i+1, k, held[i].Len(), foldSummary(folds[i]))
+	}
+
+	fmt.Println()
+	mean, std := meanStd(folds, func(m eval.Metrics) float64 { return m.Loss })
+	fmt.Printf("loss: %.4f ± %.4f\n", mean, std)
+	if folds[0].Regression {
+		mean, std = meanStd(folds, func(m eval.Metrics) float64 { return m.RMSE })
+		fmt.Printf("rmse: %.4f ± %.4f\n", mean, std)
+		mean, std = meanStd(folds, func(m eval.Metrics) float64 { return m.MAE })
+		fmt.Printf("mae: %.4f ± %.4f\n", mean, std)
+		return nil
+	}
+	mean, std = meanStd(folds, func(m eval.Metrics) float64 { return m.Accuracy })
+	fmt.Printf("accuracy: %.2f%% ± %.2f%% over %d folds\n", 100*mean, 100*std, k)
+	return nil
+}
+
+// foldSummary describes the metrics of a single fold.
+func foldSummary(m eval.Metrics) string {
+	if m.Regression {
+		return fmt.Sprintf("loss %.4f, rmse %.4f, mae %.4f", m.Loss, m.RMSE, m.MAE)
+	}
+	return fmt.Sprintf("loss %.4f, accuracy %.2f%%", m.Loss, 100*m.Accuracy)
+}
+
+// meanStd returns the mean and sample standard deviation of the metric
+// picked out by value over folds.
+func meanStd(folds []eval.Metrics, value func(eval.Metrics) float64) (mean, std float64) {
+	for _, m := range folds {
+		mean += value(m)
+	}
+	mean /= float64(len(folds))
+	for _, m := range folds {
+		d := value(m) - mean
+		std += d * d
+	}
+	return mean, math.Sqrt(std / float64(len(folds)-1))
+}
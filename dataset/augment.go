@@ -0,0 +1,322 @@
+package dataset
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Transform alters the inputs of a training sample at random, so that the
+// network sees a slightly different version of each image every epoch.
+// This is known as data augmentation, and makes up for having only so many
+// images to learn from.
+type Transform interface {
+	// Apply returns a transformed copy of inputs, leaving inputs as they
+	// are.
+	Apply(inputs []float64, rng *rand.Rand) []float64
+}
+
+// TransformFunc adapts a function to a Transform.
+type TransformFunc func(inputs []float64, rng *rand.Rand) []float64
+
+// Apply calls f.
+func (f TransformFunc) Apply(inputs []float64, rng *rand.Rand) []float64 {
+	return f(inputs, rng)
+}
+
+// Chain returns a transform applying each of ts in turn.
+func Chain(ts ...Transform) Transform {
+	return chain(ts)
+}
+
+type chain []Transform
+
+func (c chain) Apply(inputs []float64, rng *rand.Rand) []float64 {
+	for _, t := range c {
+		inputs = t.Apply(inputs, rng)
+	}
+	return inputs
+}
+
+// background is the input for a blank pixel, which fills in any part of an
+// image moved in from outside it.
+var background = PixelInput(0)
+
+// Translate returns a transform shifting square images by up to max pixels
+// across and down, a whole number of pixels each way.
+func Translate(max int) Transform {
+	return TransformFunc(func(inputs []float64, rng *rand.Rand) []float64 {
+		side := imageSide(inputs)
+		dx, dy := rng.Intn(2*max+1)-max, rng.Intn(2*max+1)-max
+		out := make([]float64, len(inputs))
+		for y := 0; y < side; y++ {
+			for x := 0; x < side; x++ {
+				sx, sy := x-dx, y-dy
+				if sx < 0 || sx >= side || sy < 0 || sy >= side {
+					out[y*side+x] = background
+					continue
+				}
+				out[y*side+x] = inputs[sy*side+sx]
+			}
+		}
+		return out
+	})
+}
+
+// Rotate returns a transform turning square images about their centre by
+// up to degrees either way.
+func Rotate(degrees float64) Transform {
+	return TransformFunc(func(inputs []float64, rng *rand.Rand) []float64 {
+		theta := (2*rng.Float64() - 1) * degrees * math.Pi / 180
+		sin, cos := math.Sincos(theta)
+		// each pixel of the output is taken from where turning it back
+		// lands in the input
+		return warp(inputs, func(x, y float64) (float64, float64) {
+			return cos*x + sin*y, -sin*x + cos*y
+		})
+	})
+}
+
+// Scale returns a transform resizing square images about their centre by a
+// factor between 1-amount and 1+amount.
+func Scale(amount float64) Transform {
+	return TransformFunc(func(inputs []float64, rng *rand.Rand) []float64 {
+		factor := 1 + (2*rng.Float64()-1)*amount
+		return warp(inputs, func(x, y float64) (float64, float64) {
+			return x / factor, y / factor
+		})
+	})
+}
+
+// GaussianNoise returns a transform adding noise drawn from a normal
+// distribution with standard deviation sigma to every pixel, keeping each
+// within the range of a pixel.
+func GaussianNoise(sigma float64) Transform {
+	return TransformFunc(func(inputs []float64, rng *rand.Rand) []float64 {
+		out := make([]float64, len(inputs))
+		for i, v := range inputs {
+			out[i] = math.Max(background, math.Min(PixelInput(255), v+sigma*rng.NormFloat64()))
+		}
+		return out
+	})
+}
+
+// SaltAndPepper returns a transform turning each pixel white or black, with
+// even odds, with probability p.
+func SaltAndPepper(p float64) Transform {
+	return TransformFunc(func(inputs []float64, rng *rand.Rand) []float64 {
+		out := append([]float64(nil), inputs...)
+		for i := range out {
+			if rng.Float64() >= p {
+				continue
+			}
+			out[i] = background
+			if rng.Intn(2) == 0 {
+				out[i] = PixelInput(255)
+			}
+		}
+		return out
+	})
+}
+
+// Elastic returns a transform distorting square images as if drawn on a
+// rubber sheet, as described by Simard, Steinkraus and Platt in "Best
+// Practices for Convolutional Neural Networks Applied to Visual Document
+// Analysis". Each pixel is moved by a random amount, smoothed with a
+// Gaussian of standard deviation sigma pixels so that nearby pixels move
+// together, and scaled by alpha. Values around 34 and 4 suit MNIST.
+func Elastic(alpha, sigma float64) Transform {
+	kernel := gaussianKernel(sigma)
+	return TransformFunc(func(inputs []float64, rng *rand.Rand) []float64 {
+		side := imageSide(inputs)
+		field := func() []float64 {
+			f := make([]float64, len(inputs))
+			for i := range f {
+				f[i] = 2*rng.Float64() - 1
+			}
+			f = smooth(f, side, kernel)
+			for i := range f {
+				f[i] *= alpha
+			}
+			return f
+		}
+		dx, dy := field(), field()
+		c := float64(side-1) / 2
+		return warp(inputs, func(x, y float64) (float64, float64) {
+			i := int(math.Round(y+c))*side + int(math.Round(x+c))
+			return x + dx[i], y + dy[i]
+		})
+	})
+}
+
+// gaussianKernel returns a normalized one dimensional Gaussian kernel with
+// the given standard deviation, reaching out three deviations each side.
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return kernel
+}
+
+// smooth convolves the square image f with kernel across and then down,
+// taking anything outside the image as zero.
+func smooth(f []float64, side int, kernel []float64) []float64 {
+	radius := len(kernel) / 2
+	pass := func(in []float64, step func(x, y, k int) (int, int)) []float64 {
+		out := make([]float64, len(in))
+		for y := 0; y < side; y++ {
+			for x := 0; x < side; x++ {
+				sum := 0.0
+				for k, w := range kernel {
+					sx, sy := step(x, y, k-radius)
+					if sx >= 0 && sx < side && sy >= 0 && sy < side {
+						sum += w * in[sy*side+sx]
+					}
+				}
+				out[y*side+x] = sum
+			}
+		}
+		return out
+	}
+	f = pass(f, func(x, y, k int) (int, int) { return x + k, y })
+	return pass(f, func(x, y, k int) (int, int) { return x, y + k })
+}
+
+// warp returns a square image whose pixels are read from inputs at the
+// point source gives for them, both relative to the centre of the image,
+// interpolating between the four nearest pixels.
+func warp(inputs []float64, source func(x, y float64) (float64, float64)) []float64 {
+	side := imageSide(inputs)
+	c := float64(side-1) / 2
+	at := func(x, y int) float64 {
+		if x < 0 || x >= side || y < 0 || y >= side {
+			return background
+		}
+		return inputs[y*side+x]
+	}
+	out := make([]float64, len(inputs))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			sx, sy := source(float64(x)-c, float64(y)-c)
+			sx, sy = sx+c, sy+c
+			x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+			fx, fy := sx-float64(x0), sy-float64(y0)
+			top := at(x0, y0)*(1-fx) + at(x0+1, y0)*fx
+			bottom := at(x0, y0+1)*(1-fx) + at(x0+1, y0+1)*fx
+			out[y*side+x] = top*(1-fy) + bottom*fy
+		}
+	}
+	return out
+}
+
+// imageSide returns the width and height of the square image held by
+// inputs.
+func imageSide(inputs []float64) int {
+	return int(math.Sqrt(float64(len(inputs))))
+}
+
+// ParseAugmentation returns the transform described by spec, a comma
+// separated list of transforms applied in turn, each a name and amounts:
+// shift:2 to translate by up to 2 pixels, rotate:15 to rotate by up to 15
+// degrees, scale:0.1 to resize by up to 10%, elastic:34:4 for elastic
+// distortion with an alpha of 34 and sigma of 4, noise:0.1 to add Gaussian
+// noise with a standard deviation of 0.1 and salt-pepper:0.05 to turn 5% of
+// the pixels white or black.
+func ParseAugmentation(spec string) (Transform, error) {
+	var ts []Transform
+	for _, part := range strings.Split(spec, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		name, amounts := fields[0], make([]float64, len(fields)-1)
+		for i, f := range fields[1:] {
+			var err error
+			if amounts[i], err = strconv.ParseFloat(f, 64); err != nil || amounts[i] <= 0 {
+				return nil, fmt.Errorf("dataset: invalid augmentation %q", part)
+			}
+		}
+		want := 1
+		if name == "elastic" {
+			want = 2
+		}
+		if len(amounts) != want {
+			return nil, fmt.Errorf("dataset: augmentation %q needs %d amounts", part, want)
+		}
+		switch name {
+		case "shift":
+			if amounts[0] != math.Trunc(amounts[0]) {
+				return nil, fmt.Errorf("dataset: shift must be a whole number of pixels, got %q", part)
+			}
+			ts = append(ts, Translate(int(amounts[0])))
+		case "rotate":
+			ts = append(ts, Rotate(amounts[0]))
+		case "scale":
+			if amounts[0] >= 1 {
+				return nil, fmt.Errorf("dataset: scale must be under 1, got %q", part)
+			}
+			ts = append(ts, Scale(amounts[0]))
+		case "elastic":
+			ts = append(ts, Elastic(amounts[0], amounts[1]))
+		case "noise":
+			ts = append(ts, GaussianNoise(amounts[0]))
+		case "salt-pepper":
+			if amounts[0] >= 1 {
+				return nil, fmt.Errorf("dataset: salt-pepper must be a probability under 1, got %q", part)
+			}
+			ts = append(ts, SaltAndPepper(amounts[0]))
+		default:
+			return nil, fmt.Errorf("dataset: unknown augmentation %q, want shift, rotate, scale, elastic, noise or salt-pepper", name)
+		}
+	}
+	return Chain(ts...), nil
+}
+
+// Augment returns the samples of d with their inputs transformed by t,
+// afresh each time they are read, using rng. The result can be indexed if
+// d can.
+func Augment(d Dataset, t Transform, rng *rand.Rand) Dataset {
+	a := augmented{d: d, t: t, rng: rng}
+	if indexed, ok := d.(Indexed); ok {
+		return augmentedIndexed{a, indexed}
+	}
+	return a
+}
+
+type augmented struct {
+	d   Dataset
+	t   Transform
+	rng *rand.Rand
+}
+
+func (a augmented) Each(fn func(Sample) error) error {
+	return a.d.Each(func(s Sample) error {
+		s.Inputs = a.t.Apply(s.Inputs, a.rng)
+		return fn(s)
+	})
+}
+
+type augmentedIndexed struct {
+	augmented
+	indexed Indexed
+}
+
+func (a augmentedIndexed) Len() int {
+	return a.indexed.Len()
+}
+
+func (a augmentedIndexed) At(i int) Sample {
+	s := a.indexed.At(i)
+	s.Inputs = a.t.Apply(s.Inputs, a.rng)
+	return s
+}
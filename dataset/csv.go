@@ -0,0 +1,458 @@
+package dataset
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Normalization is a way of scaling the values in a column of a CSV file
+// before they are used as network inputs.
+type Normalization int
+
+const (
+	// NoNormalization uses values as they are.
+	NoNormalization Normalization = iota
+	// MinMax scales values to the range 0-1.
+	MinMax
+	// ZScore scales values to have a mean of zero and a standard deviation
+	// of one.
+	ZScore
+)
+
+var normalizationNames = map[string]Normalization{
+	"none":   NoNormalization,
+	"minmax": MinMax,
+	"zscore": ZScore,
+}
+
+// ParseNormalization returns the normalization named none, minmax or zscore.
+func ParseNormalization(name string) (Normalization, error) {
+	n, ok := normalizationNames[name]
+	if !ok {
+		return 0, fmt.Errorf("dataset: unknown normalization %q", name)
+	}
+	return n, nil
+}
+
+func (n Normalization) String() string {
+	for name, v := range normalizationNames {
+		if v == n {
+			return name
+		}
+	}
+	return fmt.Sprintf("Normalization(%d)", int(n))
+}
+
+// CSVOptions describes the layout of a CSV file of tabular data.
+type CSVOptions struct {
+	// LabelColumn is the index of the column holding the class label, or
+	// the target value for regression. Negative indexes count back from
+	// the end, so -1 is the last column.
+	LabelColumn int
+	// Header is set if the first row holds column names.
+	Header bool
+	// Delimiter separates the fields, ',' if left as zero.
+	Delimiter rune
+	// Normalization is used for every input column not listed in
+	// ColumnNormalization, which is keyed by column index in the file.
+	Normalization       Normalization
+	ColumnNormalization map[int]Normalization
+	// OneHot gives each class its own target output. Otherwise there is a
+	// single target holding the class index, which suits binary
+	// classification with one output.
+	OneHot bool
+	// Regression reads the label column as a number for the network to
+	// predict, used as is as the single target of each sample. There are
+	// no classes, and OneHot is ignored.
+	Regression bool
+	// Categorical lists the columns, by index in the file, that hold
+	// categories rather than numbers. Each is given as a single input
+	// holding the integer ID of its category, as an nn.Embedding layer
+	// takes, and they come before the numeric inputs in the order listed.
+	Categorical []int
+	// BadRow, if set, is called with the error of each row that cannot be
+	// read, such as for a value that is not a number, and the row is
+	// skipped rather than ending the read.
+	BadRow func(*RowError)
+}
+
+// RowError is a row of a CSV file that cannot be read.
+type RowError struct {
+	Path string
+	// Line is the line of the file the row starts on, and Column the
+	// column, counting from 1, of the bad value, or 0 if it is the row as
+	// a whole that is bad.
+	Line, Column int
+	Err          error
+}
+
+func (e *RowError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: column %d: %v", e.Path, e.Line, e.Column, e.Err)
+}
+
+func (e *RowError) Unwrap() error { return e.Err }
+
+// columnStats holds what is needed to normalize a column.
+type columnStats struct {
+	min, max   float64
+	mean, std  float64
+	normalizer Normalization
+}
+
+func (c columnStats) normalize(x float64) float64 {
+	switch c.normalizer {
+	case MinMax:
+		if c.max == c.min {
+			return 0
+		}
+		return (x - c.min) / (c.max - c.min)
+	case ZScore:
+		if c.std == 0 {
+			return 0
+		}
+		return (x - c.mean) / c.std
+	}
+	return x
+}
+
+// CSV is a tabular classification or regression dataset read from a CSV
+// file. Every column other than the label is used as an input, numeric
+// unless it is listed as categorical. It is read from disk every time it is
+// iterated over; use Load to keep it in memory.
+type CSV struct {
+	path  string
+	opts  CSVOptions
+	label int
+	// inputs holds the index in the file of each input column.
+	inputs []int
+	header []string
+
+	// Columns holds the name of every input column, taken from the header
+	// or numbered if there is none.
+	Columns []string
+	// Classes holds each distinct label, in the order of the class indexes
+	// they are given. It is empty for regression.
+	Classes []string
+	// Categories holds the distinct values of each categorical column, in
+	// the order of the IDs they are given. A value that is not among them,
+	// as in test data, is given the ID after the last.
+	Categories [][]string
+
+	classIndex    map[string]int
+	categoryIndex []map[string]int
+	stats         []columnStats
+
+	// stream is read in place of the file at path if it is not nil.
+	stream io.Reader
+}
+
+// OpenCSV reads through the CSV file at path to find its classes and the
+// statistics needed to normalize each column.
+func OpenCSV(path string, opts CSVOptions) (*CSV, error) {
+	d := &CSV{path: path, opts: opts}
+
+	var sum, sumSq []float64
+	labels := map[string]bool{}
+	categories := make([]map[string]bool, len(opts.Categorical))
+	rows := 0
+	err := d.eachRecord(func(line int, record []string) error {
+		if d.stats == nil {
+			if err := d.setup(len(record)); err != nil {
+				return err
+			}
+			sum = make([]float64, len(d.stats))
+			sumSq = make([]float64, len(d.stats))
+		}
+		for i := range categories {
+			if categories[i] == nil {
+				categories[i] = map[string]bool{}
+			}
+			categories[i][strings.TrimSpace(record[d.inputs[i]])] = true
+		}
+		values, label, err := d.parse(line, record)
+		if err != nil {
+			return err
+		}
+		for i, x := range values {
+			s := &d.stats[i]
+			s.min, s.max = math.Min(s.min, x), math.Max(s.max, x)
+			sum[i] += x
+			sumSq[i] += x * x
+		}
+		if opts.Regression {
+			if _, err := d.target(line, label); err != nil {
+				return err
+			}
+		} else {
+			labels[label] = true
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, fmt.Errorf("%s: no data", path)
+	}
+
+	for i := range d.stats {
+		s := &d.stats[i]
+		s.mean = sum[i] / float64(rows)
+		s.std = math.Sqrt(math.Max(sumSq[i]/float64(rows)-s.mean*s.mean, 0))
+	}
+	for label := range labels {
+		d.Classes = append(d.Classes, label)
+	}
+	sortLabels(d.Classes)
+	d.classIndex = make(map[string]int, len(d.Classes))
+	for i, c := range d.Classes {
+		d.classIndex[c] = i
+	}
+	for _, values := range categories {
+		var sorted []string
+		index := make(map[string]int, len(values))
+		for v := range values {
+			sorted = append(sorted, v)
+		}
+		sortLabels(sorted)
+		for i, v := range sorted {
+			index[v] = i
+		}
+		d.Categories = append(d.Categories, sorted)
+		d.categoryIndex = append(d.categoryIndex, index)
+	}
+	return d, nil
+}
+
+// Like returns the dataset in the CSV file at path, which must have the same
+// layout as d, normalized with the statistics and classes of d. This is how
+// test data should be read, so that it is scaled the same way as the
+// training data.
+func (d *CSV) Like(path string) *CSV {
+	like := *d
+	like.path = path
+	return &like
+}
+
+// Stream returns the dataset in the rows read from r, which must have the
+// same layout as d, normalized with the statistics and classes of d, as for
+// Like. It can be iterated over only once, and name stands for the file in
+// errors.
+func (d *CSV) Stream(name string, r io.Reader) *CSV {
+	s := d.Like(name)
+	s.stream = r
+	return s
+}
+
+// Inputs returns the number of inputs each sample has.
+func (d *CSV) Inputs() int {
+	return len(d.stats)
+}
+
+// CategoryCounts returns the number of IDs each categorical column may be
+// given, counting one for values not in the training data, as an
+// nn.Embedding layer for the inputs takes.
+func (d *CSV) CategoryCounts() []int {
+	counts := make([]int, len(d.Categories))
+	for i, c := range d.Categories {
+		counts[i] = len(c) + 1
+	}
+	return counts
+}
+
+// Outputs returns the number of target outputs each sample has.
+func (d *CSV) Outputs() int {
+	if d.opts.OneHot && !d.opts.Regression {
+		return len(d.Classes)
+	}
+	return 1
+}
+
+func (d *CSV) Each(fn func(Sample) error) error {
+	return d.eachRecord(func(line int, record []string) error {
+		if len(record) != len(d.stats)+1 {
+			return &RowError{Path: d.path, Line: line, Err: fmt.Errorf("got %d columns, want %d", len(record), len(d.stats)+1)}
+		}
+		values, label, err := d.parse(line, record)
+		if err != nil {
+			return err
+		}
+		for i, x := range values {
+			values[i] = d.stats[i].normalize(x)
+		}
+		if d.opts.Regression {
+			target, err := d.target(line, label)
+			if err != nil {
+				return err
+			}
+			return fn(Sample{Inputs: values, Targets: []float64{target}})
+		}
+		class, ok := d.classIndex[label]
+		if !ok {
+			return &RowError{Path: d.path, Line: line, Column: d.label + 1, Err: fmt.Errorf("unknown label %q", label)}
+		}
+
+		targets := []float64{float64(class)}
+		if d.opts.OneHot {
+			targets = make([]float64, len(d.Classes))
+			targets[class] = 1
+		}
+		return fn(Sample{Inputs: values, Targets: targets, Label: class})
+	})
+}
+
+// setup works out the label column and input columns from the number of
+// columns in the first row.
+func (d *CSV) setup(columns int) error {
+	d.label = d.opts.LabelColumn
+	if d.label < 0 {
+		d.label += columns
+	}
+	if d.label < 0 || d.label >= columns {
+		return fmt.Errorf("%s: label column %d out of range for %d columns", d.path, d.opts.LabelColumn, columns)
+	}
+	categorical := map[int]bool{}
+	for _, c := range d.opts.Categorical {
+		if c < 0 || c >= columns || c == d.label || categorical[c] {
+			return fmt.Errorf("%s: invalid categorical column %d for %d columns with the label in column %d", d.path, c, columns, d.label)
+		}
+		categorical[c] = true
+		d.inputs = append(d.inputs, c)
+	}
+	for c := 0; c < columns; c++ {
+		if c != d.label && !categorical[c] {
+			d.inputs = append(d.inputs, c)
+		}
+	}
+	d.stats = make([]columnStats, columns-1)
+	for i := range d.stats {
+		n, ok := d.opts.ColumnNormalization[d.inputs[i]]
+		if !ok {
+			n = d.opts.Normalization
+		}
+		if i < len(d.opts.Categorical) {
+			n = NoNormalization
+		}
+		d.stats[i] = columnStats{min: math.Inf(1), max: math.Inf(-1), normalizer: n}
+	}
+	d.Columns = make([]string, len(d.stats))
+	for i, c := range d.inputs {
+		d.Columns[i] = strconv.Itoa(c)
+		if c < len(d.header) {
+			d.Columns[i] = d.header[c]
+		}
+	}
+	return nil
+}
+
+// parse splits the record on line into its input values and label.
+// Categorical columns are given their IDs, or zero before they are known.
+func (d *CSV) parse(line int, record []string) ([]float64, string, error) {
+	values := make([]float64, len(d.stats))
+	for i := range values {
+		field := strings.TrimSpace(record[d.inputs[i]])
+		if i < len(d.opts.Categorical) {
+			if d.categoryIndex != nil {
+				id, ok := d.categoryIndex[i][field]
+				if !ok {
+					id = len(d.Categories[i])
+				}
+				values[i] = float64(id)
+			}
+			continue
+		}
+		x, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, "", &RowError{Path: d.path, Line: line, Column: d.inputs[i] + 1, Err: fmt.Errorf("invalid number %q", field)}
+		}
+		values[i] = x
+	}
+	return values, strings.TrimSpace(record[d.label]), nil
+}
+
+// target parses the label of a regression sample on line.
+func (d *CSV) target(line int, label string) (float64, error) {
+	x, err := strconv.ParseFloat(label, 64)
+	if err != nil {
+		return 0, &RowError{Path: d.path, Line: line, Column: d.label + 1, Err: fmt.Errorf("invalid target %q", label)}
+	}
+	return x, nil
+}
+
+// eachRecord calls fn with every data row of the file and the line it
+// starts on, skipping the header. Rows that cannot be read, or for which fn
+// returns a *RowError, are passed to the BadRow option if it is set.
+func (d *CSV) eachRecord(fn func(line int, record []string) error) error {
+	in := d.stream
+	if in == nil {
+		f, err := os.Open(d.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	r := csv.NewReader(bufio.NewReader(in))
+	if d.opts.Delimiter != 0 {
+		r.Comma = d.opts.Delimiter
+	}
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil && row == 1 && d.opts.Header {
+			if d.header == nil {
+				for _, name := range record {
+					d.header = append(d.header, strings.TrimSpace(name))
+				}
+			}
+			continue
+		}
+		if pe, ok := err.(*csv.ParseError); ok {
+			err = &RowError{Path: d.path, Line: pe.StartLine, Err: pe.Err}
+		} else if err == nil {
+			line, _ := r.FieldPos(0)
+			err = fn(line, record)
+		}
+		if re, ok := err.(*RowError); ok && d.opts.BadRow != nil {
+			d.opts.BadRow(re)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sortLabels sorts labels numerically if they are all numbers, otherwise
+// alphabetically.
+func sortLabels(labels []string) {
+	numeric := true
+	for _, l := range labels {
+		if _, err := strconv.ParseFloat(l, 64); err != nil {
+			numeric = false
+			break
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if numeric {
+			a, _ := strconv.ParseFloat(labels[i], 64)
+			b, _ := strconv.ParseFloat(labels[j], 64)
+			return a < b
+		}
+		return labels[i] < labels[j]
+	})
+}
@@ -0,0 +1,241 @@
+// Package dataset loads and iterates over training and test data for
+// neural networks.
+package dataset
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Sample is a single input vector along with its target outputs and, for
+// classification, its class label.
+type Sample struct {
+	Inputs  []float64
+	Targets []float64
+	Label   int
+}
+
+// Dataset is a source of samples that can be iterated over once per epoch.
+type Dataset interface {
+	// Each calls fn for every sample in turn. The slices in a sample must
+	// not be modified. If fn returns an error iteration stops and Each
+	// returns that error.
+	Each(fn func(Sample) error) error
+}
+
+// Indexed is a dataset whose samples can be looked up by index, which lets
+// them be shuffled or split.
+type Indexed interface {
+	Dataset
+	Len() int
+	At(i int) Sample
+}
+
+// Memory is a dataset held entirely in memory. The inputs and targets of all
+// samples share two flat backing slices, so iterating over it is cheap and
+// it needs little more memory than the values themselves.
+type Memory struct {
+	numInputs, numTargets int
+	inputs, targets       []float64
+	labels                []int
+}
+
+// NewMemory returns an empty in-memory dataset for samples with the given
+// number of inputs and targets.
+func NewMemory(numInputs, numTargets int) *Memory {
+	return &Memory{numInputs: numInputs, numTargets: numTargets}
+}
+
+// Append adds a copy of s to the dataset.
+func (m *Memory) Append(s Sample) {
+	m.inputs = append(m.inputs, s.Inputs...)
+	m.targets = append(m.targets, s.Targets...)
+	m.labels = append(m.labels, s.Label)
+}
+
+// Len returns the number of samples in the dataset.
+func (m *Memory) Len() int {
+	return len(m.labels)
+}
+
+// At returns the sample at index i. The slices point into the dataset.
+func (m *Memory) At(i int) Sample {
+	return Sample{
+		Inputs:  m.inputs[i*m.numInputs : (i+1)*m.numInputs : (i+1)*m.numInputs],
+		Targets: m.targets[i*m.numTargets : (i+1)*m.numTargets : (i+1)*m.numTargets],
+		Label:   m.labels[i],
+	}
+}
+
+// Each calls fn for every sample in order.
+func (m *Memory) Each(fn func(Sample) error) error {
+	for i := 0; i < m.Len(); i++ {
+		if err := fn(m.At(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bytes returns roughly how much memory the samples take up.
+func (m *Memory) Bytes() int64 {
+	return int64(len(m.inputs)+len(m.targets))*8 + int64(len(m.labels))*8
+}
+
+// Subset is a selection of the samples of another dataset.
+type Subset struct {
+	d       Indexed
+	indexes []int
+}
+
+// NewSubset returns the samples of d at the given indexes.
+func NewSubset(d Indexed, indexes []int) *Subset {
+	return &Subset{d: d, indexes: indexes}
+}
+
+// Len returns the number of samples in the subset.
+func (s *Subset) Len() int {
+	return len(s.indexes)
+}
+
+// At returns the sample at index i of the subset.
+func (s *Subset) At(i int) Sample {
+	return s.d.At(s.indexes[i])
+}
+
+// Each calls fn for every sample in the subset in order.
+func (s *Subset) Each(fn func(Sample) error) error {
+	for i := range s.indexes {
+		if err := fn(s.At(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Split randomly divides d in two, with fraction of the samples going to
+// the second part. This is typically used to hold back validation data.
+func Split(d Indexed, fraction float64, rng *rand.Rand) (rest, held *Subset) {
+	perm := rng.Perm(d.Len())
+	n := int(math.Round(fraction * float64(d.Len())))
+	return NewSubset(d, perm[n:]), NewSubset(d, perm[:n])
+}
+
+// SplitIndexes randomly divides the indexes up to n in two, with fraction
+// of them going to the second part. The indexes in each part are in order.
+func SplitIndexes(n int, fraction float64, rng *rand.Rand) (rest, held []int) {
+	perm := rng.Perm(n)
+	k := int(math.Round(fraction * float64(n)))
+	rest, held = perm[k:], perm[:k]
+	sort.Ints(rest)
+	sort.Ints(held)
+	return rest, held
+}
+
+// StratifiedSplit is like Split but keeps the proportion of each class the
+// same in both parts, going by the labels of the samples, so that rare
+// classes are not left out of a small held back part by chance.
+func StratifiedSplit(d Indexed, fraction float64, rng *rand.Rand) (rest, held *Subset) {
+	labels := make([]int, d.Len())
+	for i := range labels {
+		labels[i] = d.At(i).Label
+	}
+	r, h := StratifiedIndexes(labels, fraction, rng)
+	return NewSubset(d, r), NewSubset(d, h)
+}
+
+// StratifiedIndexes randomly divides the indexes of labels in two, with
+// fraction of the indexes of each label going to the second part. The
+// indexes in each part are in order.
+func StratifiedIndexes(labels []int, fraction float64, rng *rand.Rand) (rest, held []int) {
+	classes := map[int][]int{}
+	var order []int
+	for i, l := range labels {
+		if _, ok := classes[l]; !ok {
+			order = append(order, l)
+		}
+		classes[l] = append(classes[l], i)
+	}
+	// go through the classes in the order they first turn up, so the same
+	// seed always gives the same split
+	for _, l := range order {
+		indexes := classes[l]
+		rng.Shuffle(len(indexes), func(i, j int) { indexes[i], indexes[j] = indexes[j], indexes[i] })
+		n := int(math.Round(fraction * float64(len(indexes))))
+		held = append(held, indexes[:n]...)
+		rest = append(rest, indexes[n:]...)
+	}
+	sort.Ints(rest)
+	sort.Ints(held)
+	return rest, held
+}
+
+// Bootstrap returns as many samples as there are in d, drawn from it at
+// random with replacement, as for bagging.
+func Bootstrap(d Indexed, rng *rand.Rand) *Subset {
+	indexes := make([]int, d.Len())
+	for i := range indexes {
+		indexes[i] = rng.Intn(d.Len())
+	}
+	return NewSubset(d, indexes)
+}
+
+// KFold randomly divides d into k folds of as near the same size as can be,
+// for k-fold cross-validation. It returns the samples held out in each fold
+// and, for each fold, the samples of all the others to train on.
+func KFold(d Indexed, k int, rng *rand.Rand) (train, held []*Subset) {
+	perm := rng.Perm(d.Len())
+	train, held = make([]*Subset, k), make([]*Subset, k)
+	for i := 0; i < k; i++ {
+		start, end := i*len(perm)/k, (i+1)*len(perm)/k
+		rest := make([]int, 0, len(perm)-(end-start))
+		rest = append(append(rest, perm[:start]...), perm[end:]...)
+		train[i], held[i] = NewSubset(d, rest), NewSubset(d, perm[start:end])
+	}
+	return train, held
+}
+
+// Reconstruction is a dataset whose targets are the inputs of another, for
+// training an autoencoder to reproduce them.
+type Reconstruction struct {
+	d Dataset
+}
+
+// NewReconstruction returns the samples of d with their inputs as their
+// targets.
+func NewReconstruction(d Dataset) *Reconstruction {
+	return &Reconstruction{d: d}
+}
+
+// Each calls fn for every sample of the underlying dataset in order.
+func (r *Reconstruction) Each(fn func(Sample) error) error {
+	return r.d.Each(func(s Sample) error {
+		s.Targets = s.Inputs
+		return fn(s)
+	})
+}
+
+var errOverLimit = errors.New("dataset: over memory limit")
+
+// Load reads all of d into memory. If limit is positive and the samples
+// would take up more than limit bytes, loading stops and d is returned as is
+// to be streamed instead.
+func Load(d Dataset, numInputs, numTargets int, limit int64) (Dataset, error) {
+	m := NewMemory(numInputs, numTargets)
+	err := d.Each(func(s Sample) error {
+		m.Append(s)
+		if limit > 0 && m.Bytes() > limit {
+			return errOverLimit
+		}
+		return nil
+	})
+	if errors.Is(err, errOverLimit) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,148 @@
+package dataset
+
+import (
+	"image"
+	_ "image/jpeg" // register the JPEG decoder for DecodeImage
+	_ "image/png"  // and PNG
+	"io"
+	"math"
+)
+
+// DecodeImage reads a PNG or JPEG image and converts it to network inputs
+// the way the MNIST images were prepared: the image is made greyscale with
+// light ink on a dark background, cropped to the ink, scaled to fit a 20 x
+// 20 box and centred by its centre of mass in a 28 x 28 image. The pixels
+// are then normalized as for training with PixelInput.
+func DecodeImage(r io.Reader) ([]float64, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	ink := inkLevels(img)
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// crop to the pixels with a fair amount of ink
+	x0, y0, x1, y1 := w, h, -1, -1
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if ink[y*w+x] >= 64 {
+				x0, y0 = minInt(x0, x), minInt(y0, y)
+				x1, y1 = maxInt(x1, x), maxInt(y1, y)
+			}
+		}
+	}
+	inputs := make([]float64, ImagePixels)
+	if x1 < 0 {
+		// a blank image
+		for i := range inputs {
+			inputs[i] = PixelInput(0)
+		}
+		return inputs, nil
+	}
+	cw, ch := x1-x0+1, y1-y0+1
+
+	// scale so the longer side fills 20 pixels, keeping the aspect ratio
+	scale := 20 / float64(maxInt(cw, ch))
+	tw := maxInt(1, int(math.Round(float64(cw)*scale)))
+	th := maxInt(1, int(math.Round(float64(ch)*scale)))
+	scaled := make([]float64, tw*th)
+	for ty := 0; ty < th; ty++ {
+		sy0, sy1 := float64(ty)/scale, float64(ty+1)/scale
+		for tx := 0; tx < tw; tx++ {
+			sx0, sx1 := float64(tx)/scale, float64(tx+1)/scale
+			// average the source pixels under the target pixel, weighted by
+			// how much of each is covered
+			var sum, area float64
+			for sy := int(sy0); sy < int(math.Ceil(sy1)) && sy < ch; sy++ {
+				wy := overlap(float64(sy), sy0, sy1)
+				for sx := int(sx0); sx < int(math.Ceil(sx1)) && sx < cw; sx++ {
+					a := wy * overlap(float64(sx), sx0, sx1)
+					sum += a * ink[(y0+sy)*w+x0+sx]
+					area += a
+				}
+			}
+			if area > 0 {
+				scaled[ty*tw+tx] = sum / area
+			}
+		}
+	}
+
+	// centre the scaled digit by its centre of mass
+	var mass, mx, my float64
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			v := scaled[y*tw+x]
+			mass += v
+			mx += v * float64(x)
+			my += v * float64(y)
+		}
+	}
+	ox := clampInt(int(math.Round(14-mx/mass)), 0, 28-tw)
+	oy := clampInt(int(math.Round(14-my/mass)), 0, 28-th)
+
+	pixels := make([]float64, ImagePixels)
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			pixels[(oy+y)*28+ox+x] = scaled[y*tw+x]
+		}
+	}
+	for i, v := range pixels {
+		inputs[i] = PixelInput(v)
+	}
+	return inputs, nil
+}
+
+// inkLevels returns the amount of ink in each pixel of img, row by row, in
+// the range 0-255. Transparent pixels count as paper, and the image is
+// inverted if it has a light background, going by its border.
+func inkLevels(img image.Image) []float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	levels := make([]float64, w*h)
+	var border float64
+	var borderCount int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			// luminance of the premultiplied colour laid over white paper
+			lum := (299*float64(r)+587*float64(g)+114*float64(bl))/1000 + float64(0xffff-a)
+			v := lum / 0xffff * 255
+			levels[y*w+x] = v
+			if x == 0 || y == 0 || x == w-1 || y == h-1 {
+				border += v
+				borderCount++
+			}
+		}
+	}
+	if border/float64(borderCount) > 127 {
+		for i, v := range levels {
+			levels[i] = 255 - v
+		}
+	}
+	return levels
+}
+
+// overlap returns how much of the unit interval starting at i lies within
+// [a, b).
+func overlap(i, a, b float64) float64 {
+	return math.Max(0, math.Min(i+1, b)-math.Max(i, a))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clampInt(v, lo, hi int) int {
+	return maxInt(lo, minInt(v, hi))
+}
@@ -0,0 +1,137 @@
+package dataset
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// RemoteFile is a file that makes up a downloadable dataset, along with the
+// MD5 checksum it is published with.
+type RemoteFile struct {
+	Name string
+	MD5  string
+}
+
+// Source describes where a dataset can be downloaded from. Each file is
+// fetched from the first mirror that has it.
+type Source struct {
+	Name    string
+	Mirrors []string
+	Files   []RemoteFile
+}
+
+// MNISTSource is the original MNIST dataset in IDX format.
+var MNISTSource = Source{
+	Name: "mnist",
+	Mirrors: []string{
+		"https://ossci-datasets.s3.amazonaws.com/mnist/",
+		"http://yann.lecun.com/exdb/mnist/",
+	},
+	Files: []RemoteFile{
+		{"train-images-idx3-ubyte.gz", "f68b3c2dcbeaaa9fbdd348bbdeb94873"},
+		{"train-labels-idx1-ubyte.gz", "d53e105ee54ea40749a09fcbcd1e9432"},
+		{"t10k-images-idx3-ubyte.gz", "9fb629c4189551a2d022fa330f9573f3"},
+		{"t10k-labels-idx1-ubyte.gz", "ec29112dd5afa0611ce80d1b7f02629c"},
+	},
+}
+
+// FashionMNISTSource is Zalando's Fashion-MNIST dataset in IDX format.
+var FashionMNISTSource = Source{
+	Name: "fashion-mnist",
+	Mirrors: []string{
+		"http://fashion-mnist.s3-website.eu-central-1.amazonaws.com/",
+	},
+	Files: []RemoteFile{
+		{"train-images-idx3-ubyte.gz", "8d4fb7e6c68d591d4c3dfef9ec88bf0d"},
+		{"train-labels-idx1-ubyte.gz", "25c81989df183df01b3e8a0aad5dffbe"},
+		{"t10k-images-idx3-ubyte.gz", "bef4ecab320f06d8554ea6380940ec79"},
+		{"t10k-labels-idx1-ubyte.gz", "bb300cfdad3c16e7a12a480ee83cd310"},
+	},
+}
+
+// Sources lists the datasets that can be downloaded, by name.
+var Sources = map[string]Source{
+	MNISTSource.Name:        MNISTSource,
+	FashionMNISTSource.Name: FashionMNISTSource,
+}
+
+// Download fetches every file of src into dir, creating it if necessary.
+// Files that are already there with the right checksum are skipped, and a
+// download with the wrong checksum is discarded. Progress is reported to
+// log.
+func Download(src Source, dir string, log io.Writer) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, file := range src.Files {
+		path := filepath.Join(dir, file.Name)
+		if sum, err := md5File(path); err == nil && sum == file.MD5 {
+			fmt.Fprintf(log, "%s is up to date\n", path)
+			continue
+		}
+
+		var err error
+		for _, mirror := range src.Mirrors {
+			fmt.Fprintf(log, "downloading %s%s\n", mirror, file.Name)
+			if err = download(mirror+file.Name, path, file.MD5); err == nil {
+				break
+			}
+			fmt.Fprintf(log, "  %v\n", err)
+		}
+		if err != nil {
+			return fmt.Errorf("dataset: could not download %s from any mirror", file.Name)
+		}
+	}
+	return nil
+}
+
+// download fetches url to path, checking its MD5 checksum before moving it
+// into place.
+func download(url, path, sum string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("%s: checksum is %s, want %s", url, got, sum)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// md5File returns the hex encoded MD5 checksum of the file at path.
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,127 @@
+package dataset
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// idxUbyte is the IDX type code for unsigned byte data, the only type used
+// by MNIST and the like.
+const idxUbyte = 0x08
+
+// idxFile is an open IDX file positioned at the start of its data.
+type idxFile struct {
+	f    *os.File
+	r    io.Reader
+	dims []int
+}
+
+// openIDX opens the IDX file at path, which may be gzip compressed, and
+// reads its header.
+func openIDX(path string) (*idxFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		r = bufio.NewReader(gz)
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: reading header: %w", path, err)
+	}
+	if header[0] != 0 || header[1] != 0 || header[2] != idxUbyte {
+		f.Close()
+		return nil, fmt.Errorf("%s: not an unsigned byte IDX file", path)
+	}
+	dims := make([]uint32, header[3])
+	if err := binary.Read(r, binary.BigEndian, dims); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: reading dimensions: %w", path, err)
+	}
+
+	idx := &idxFile{f: f, r: r, dims: make([]int, len(dims))}
+	for i, d := range dims {
+		idx.dims[i] = int(d)
+	}
+	return idx, nil
+}
+
+func (idx *idxFile) Close() error {
+	return idx.f.Close()
+}
+
+// IDX is an image dataset in the IDX format MNIST is originally distributed
+// in, as a pair of optionally gzipped files holding the images and the
+// labels. It is read from disk every time it is iterated over; use Load to
+// keep it in memory.
+type IDX struct {
+	Images  string
+	Labels  string
+	Classes int
+	// LabelOffset is subtracted from every label.
+	LabelOffset int
+	// Transpose is set if the images are stored column by column.
+	Transpose bool
+}
+
+func (d IDX) Each(fn func(Sample) error) error {
+	images, err := openIDX(d.Images)
+	if err != nil {
+		return err
+	}
+	defer images.Close()
+	labels, err := openIDX(d.Labels)
+	if err != nil {
+		return err
+	}
+	defer labels.Close()
+
+	if len(images.dims) != 3 || images.dims[1] != 28 || images.dims[2] != 28 {
+		return fmt.Errorf("%s: images have dimensions %v, want n x 28 x 28", d.Images, images.dims)
+	}
+	if len(labels.dims) != 1 || labels.dims[0] != images.dims[0] {
+		return fmt.Errorf("%s: labels have dimensions %v, want %d", d.Labels, labels.dims, images.dims[0])
+	}
+
+	pixels := make([]byte, ImagePixels)
+	raw := make([]byte, 1)
+	for n := 0; n < images.dims[0]; n++ {
+		if _, err := io.ReadFull(images.r, pixels); err != nil {
+			return fmt.Errorf("%s: reading image %d: %w", d.Images, n, err)
+		}
+		if _, err := io.ReadFull(labels.r, raw); err != nil {
+			return fmt.Errorf("%s: reading label %d: %w", d.Labels, n, err)
+		}
+		label := int(raw[0]) - d.LabelOffset
+		if label < 0 || label >= d.Classes {
+			return fmt.Errorf("%s: label %d is %d", d.Labels, n, raw[0])
+		}
+
+		inputs := make([]float64, ImagePixels)
+		for i, p := range pixels {
+			if d.Transpose {
+				i = i%28*28 + i/28
+			}
+			inputs[i] = PixelInput(float64(p))
+		}
+		s := Sample{Inputs: inputs, Targets: Targets(label, d.Classes), Label: label}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
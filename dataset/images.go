@@ -0,0 +1,264 @@
+package dataset
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ImagePixels is the number of pixels in the 28 x 28 greyscale images used
+// by MNIST and the datasets modelled on it.
+const ImagePixels = 28 * 28
+
+// PixelInput converts a pixel value in the range 0-255 to a network input
+// in the range 0.01-1.0.
+func PixelInput(x float64) float64 {
+	return (x / 255.0 * 0.99) + 0.01
+}
+
+// PixelValue converts a network input back to a pixel value, undoing
+// PixelInput. Inputs outside 0.01-1.0, as a network may output, are clamped
+// to the range 0-255.
+func PixelValue(x float64) float64 {
+	return math.Max(0, math.Min(255, (x-0.01)/0.99*255))
+}
+
+// Targets returns the one-hot target outputs for the given class label.
+func Targets(label, classes int) []float64 {
+	targets := make([]float64, classes)
+	targets[label] = 1
+	return targets
+}
+
+// ImageSet describes a dataset of 28 x 28 greyscale images in the same
+// format as MNIST.
+type ImageSet struct {
+	Name string
+	// Classes holds the human readable name of each class, indexed by
+	// label.
+	Classes []string
+	// Source is where the dataset can be downloaded from, or nil if it has
+	// to be fetched by hand.
+	Source *Source
+
+	// prefix is prepended to the standard IDX file names, and train and
+	// test name the two parts of the dataset within them.
+	prefix      string
+	train, test string
+	// labelOffset is subtracted from the labels in the files, for datasets
+	// whose labels do not start at zero.
+	labelOffset int
+	// transpose is set for datasets whose images are stored column by
+	// column rather than row by row.
+	transpose bool
+}
+
+// MNIST is the MNIST dataset of handwritten digits.
+var MNIST = ImageSet{
+	Name:    "mnist",
+	Classes: []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+	Source:  &MNISTSource,
+	train:   "train",
+	test:    "t10k",
+}
+
+// FashionMNIST is Zalando's Fashion-MNIST dataset of clothing images.
+var FashionMNIST = ImageSet{
+	Name: "fashion-mnist",
+	Classes: []string{
+		"T-shirt/top", "Trouser", "Pullover", "Dress", "Coat",
+		"Sandal", "Shirt", "Sneaker", "Bag", "Ankle boot",
+	},
+	Source: &FashionMNISTSource,
+	train:  "train",
+	test:   "t10k",
+}
+
+// EMNIST datasets come as several splits of the same handwritten characters.
+// They are published as one large archive, so the files need to be
+// extracted by hand into the data directory.
+var (
+	EMNISTDigits  = emnist("digits", digits)
+	EMNISTLetters = ImageSet{
+		Name:        "emnist-letters",
+		Classes:     splitChars(upper),
+		prefix:      "emnist-letters-",
+		train:       "train",
+		test:        "test",
+		labelOffset: 1,
+		transpose:   true,
+	}
+	EMNISTBalanced = emnist("balanced", digits+upper+"abdefghnqrt")
+	EMNISTByClass  = emnist("byclass", digits+upper+lower)
+)
+
+const (
+	digits = "0123456789"
+	upper  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lower  = "abcdefghijklmnopqrstuvwxyz"
+)
+
+func emnist(split string, classes string) ImageSet {
+	return ImageSet{
+		Name:      "emnist-" + split,
+		Classes:   splitChars(classes),
+		prefix:    "emnist-" + split + "-",
+		train:     "train",
+		test:      "test",
+		transpose: true,
+	}
+}
+
+// splitChars returns each character of s as a string.
+func splitChars(s string) []string {
+	chars := make([]string, len(s))
+	for i := range s {
+		chars[i] = s[i : i+1]
+	}
+	return chars
+}
+
+// ImageSets lists the known image datasets by name.
+var ImageSets = map[string]ImageSet{
+	MNIST.Name:          MNIST,
+	FashionMNIST.Name:   FashionMNIST,
+	EMNISTDigits.Name:   EMNISTDigits,
+	EMNISTLetters.Name:  EMNISTLetters,
+	EMNISTBalanced.Name: EMNISTBalanced,
+	EMNISTByClass.Name:  EMNISTByClass,
+}
+
+// Dir returns the part of the dataset found in dir. The IDX files are used
+// if they are there under their standard names, optionally gzipped,
+// otherwise a CSV file named after the dataset such as mnist_train.csv or
+// mnist_test.csv.
+func (s ImageSet) Dir(dir string, test bool) Dataset {
+	part := s.train
+	if test {
+		part = s.test
+	}
+	idx := s.IDX(
+		findFile(filepath.Join(dir, s.prefix+part+"-images-idx3-ubyte")),
+		findFile(filepath.Join(dir, s.prefix+part+"-labels-idx1-ubyte")),
+	)
+	if _, err := os.Stat(idx.Images); err == nil {
+		return idx
+	}
+	if test {
+		return s.CSV(filepath.Join(dir, s.Name+"_test.csv"))
+	}
+	return s.CSV(filepath.Join(dir, s.Name+"_train.csv"))
+}
+
+// IDX returns the dataset stored in the given pair of IDX files.
+func (s ImageSet) IDX(images, labels string) IDX {
+	return IDX{
+		Images:      images,
+		Labels:      labels,
+		Classes:     len(s.Classes),
+		LabelOffset: s.labelOffset,
+		Transpose:   s.transpose,
+	}
+}
+
+// CSV returns the dataset stored in the CSV file at path.
+func (s ImageSet) CSV(path string) ImageCSV {
+	return ImageCSV{Path: path, Classes: len(s.Classes), LabelOffset: s.labelOffset, Transpose: s.transpose}
+}
+
+// findFile returns the gzipped version of path if there is one.
+func findFile(path string) string {
+	if _, err := os.Stat(path + ".gz"); err == nil {
+		return path + ".gz"
+	}
+	return path
+}
+
+// ImageCSV is an image dataset in CSV format, with each row holding the
+// label followed by the pixels. A header row is skipped. It is read from
+// disk every time it is iterated over; use Load to keep it in memory.
+type ImageCSV struct {
+	Path        string
+	Classes     int
+	LabelOffset int
+	// Transpose is set if the images are stored column by column, as for
+	// IDX.
+	Transpose bool
+	// BadRow, if set, is called with the error of each row that cannot be
+	// read, and the row is skipped rather than ending the read, as for
+	// CSVOptions.
+	BadRow func(*RowError)
+}
+
+func (d ImageCSV) Each(fn func(Sample) error) error {
+	f, err := os.Open(d.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if pe, ok := err.(*csv.ParseError); ok {
+			err = &RowError{Path: d.Path, Line: pe.StartLine, Err: pe.Err}
+		} else if err != nil {
+			return err
+		}
+		var s Sample
+		if err == nil {
+			if _, labelErr := strconv.Atoi(record[0]); labelErr != nil && row == 1 {
+				// a header
+				continue
+			}
+			line, _ := r.FieldPos(0)
+			s, err = d.sample(line, record)
+		}
+		if err != nil {
+			if d.BadRow == nil {
+				return err
+			}
+			d.BadRow(err.(*RowError))
+			continue
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+}
+
+// sample parses the record on line, returning a *RowError if it is not a
+// label and the right number of pixels.
+func (d ImageCSV) sample(line int, record []string) (Sample, error) {
+	if len(record) != ImagePixels+1 {
+		return Sample{}, &RowError{Path: d.Path, Line: line, Err: fmt.Errorf("got %d columns, want %d", len(record), ImagePixels+1)}
+	}
+	label, err := strconv.Atoi(record[0])
+	if err == nil {
+		label -= d.LabelOffset
+	}
+	if err != nil || label < 0 || label >= d.Classes {
+		return Sample{}, &RowError{Path: d.Path, Line: line, Column: 1, Err: fmt.Errorf("invalid label %q", record[0])}
+	}
+	inputs := make([]float64, ImagePixels)
+	for j, field := range record[1:] {
+		x, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return Sample{}, &RowError{Path: d.Path, Line: line, Column: j + 2, Err: fmt.Errorf("invalid pixel %q", field)}
+		}
+		i := j
+		if d.Transpose {
+			i = j%28*28 + j/28
+		}
+		inputs[i] = PixelInput(x)
+	}
+	return Sample{Inputs: inputs, Targets: Targets(label, d.Classes), Label: label}, nil
+}
@@ -0,0 +1,53 @@
+package dataset
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Mixup returns a mini-batch of samples mixed in pairs, for mixup training
+// as described by Zhang et al. in "mixup: Beyond Empirical Risk
+// Minimization". Each sample is combined with another from the batch picked
+// at random, weighed by lambda and the other by 1-lambda, and so are their
+// targets, with lambda drawn once for the batch from a Beta(alpha, alpha)
+// distribution. The batch passed in is left as it is.
+func Mixup(inputs, targets [][]float64, alpha float64, rng *rand.Rand) (mixedInputs, mixedTargets [][]float64) {
+	x, y := gamma(alpha, rng), gamma(alpha, rng)
+	lambda := x / (x + y)
+	perm := rng.Perm(len(inputs))
+	mix := func(data [][]float64) [][]float64 {
+		mixed := make([][]float64, len(data))
+		for i, d := range data {
+			other := data[perm[i]]
+			mixed[i] = make([]float64, len(d))
+			for j, v := range d {
+				mixed[i][j] = lambda*v + (1-lambda)*other[j]
+			}
+		}
+		return mixed
+	}
+	return mix(inputs), mix(targets)
+}
+
+// gamma returns a number drawn from a Gamma(alpha, 1) distribution, by the
+// method of Marsaglia and Tsang.
+func gamma(alpha float64, rng *rand.Rand) float64 {
+	if alpha < 1 {
+		// boost alpha above 1 and scale back down
+		return gamma(alpha+1, rng) * math.Pow(rng.Float64(), 1/alpha)
+	}
+	d := alpha - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < x*x/2+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
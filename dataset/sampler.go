@@ -0,0 +1,119 @@
+package dataset
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Sampler picks the samples of an indexed dataset to train on in an epoch
+// and the order to train on them in, which may repeat some samples and leave
+// out others.
+type Sampler interface {
+	// Epoch returns the indexes of the samples for an epoch.
+	Epoch(rng *rand.Rand) []int
+}
+
+// Shuffled returns a sampler giving every sample of d once per epoch, in a
+// random order.
+func Shuffled(d Indexed) Sampler {
+	return shuffled{n: d.Len()}
+}
+
+type shuffled struct {
+	n int
+}
+
+func (s shuffled) Epoch(rng *rand.Rand) []int {
+	return rng.Perm(s.n)
+}
+
+// Oversampled returns a sampler that evens out the classes of d by drawing
+// samples of the smaller classes with replacement until every class has as
+// many as the largest, along with every sample of the largest, in a random
+// order.
+func Oversampled(d Indexed) Sampler {
+	return oversampled{byClass(d)}
+}
+
+type oversampled struct {
+	classes [][]int
+}
+
+func (s oversampled) Epoch(rng *rand.Rand) []int {
+	most := 0
+	for _, c := range s.classes {
+		if len(c) > most {
+			most = len(c)
+		}
+	}
+	var order []int
+	for _, c := range s.classes {
+		order = append(order, c...)
+		for i := len(c); i < most; i++ {
+			order = append(order, c[rng.Intn(len(c))])
+		}
+	}
+	rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	return order
+}
+
+// Undersampled returns a sampler that evens out the classes of d by drawing
+// as many samples of each class as the smallest has, without replacement, in
+// a random order. A different selection of the larger classes is drawn each
+// epoch.
+func Undersampled(d Indexed) Sampler {
+	return undersampled{byClass(d)}
+}
+
+type undersampled struct {
+	classes [][]int
+}
+
+func (s undersampled) Epoch(rng *rand.Rand) []int {
+	least := -1
+	for _, c := range s.classes {
+		if least < 0 || len(c) < least {
+			least = len(c)
+		}
+	}
+	var order []int
+	for _, c := range s.classes {
+		for _, i := range rng.Perm(len(c))[:least] {
+			order = append(order, c[i])
+		}
+	}
+	rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	return order
+}
+
+// SamplerByName returns the sampler of d with the given name: shuffle,
+// oversample or undersample.
+func SamplerByName(name string, d Indexed) (Sampler, error) {
+	switch name {
+	case "shuffle":
+		return Shuffled(d), nil
+	case "oversample":
+		return Oversampled(d), nil
+	case "undersample":
+		return Undersampled(d), nil
+	}
+	return nil, fmt.Errorf("dataset: unknown sampler %q, want shuffle, oversample or undersample", name)
+}
+
+// byClass returns the indexes of the samples of d in each class, in the
+// order the classes first turn up.
+func byClass(d Indexed) [][]int {
+	index := map[int]int{}
+	var classes [][]int
+	for i := 0; i < d.Len(); i++ {
+		label := d.At(i).Label
+		c, ok := index[label]
+		if !ok {
+			c = len(classes)
+			index[label] = c
+			classes = append(classes, nil)
+		}
+		classes[c] = append(classes[c], i)
+	}
+	return classes
+}
@@ -0,0 +1,121 @@
+package dataset
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Sequences is a dataset whose samples are sequences of a fixed number of
+// steps, each with the same number of features. The inputs of a sample hold
+// the features of each step in turn, as the recurrent layers of package nn
+// take them.
+type Sequences interface {
+	Indexed
+	Steps() int
+	Features() int
+}
+
+// Text is a dataset of the runs of characters in a text, each with the
+// character that follows it as its class, for training a network to predict
+// the next character. The characters of the text make up its alphabet, and
+// each is one-hot encoded over it.
+type Text struct {
+	// Alphabet holds each distinct character of the text, in the order of
+	// the class indexes they are given.
+	Alphabet []rune
+
+	chars []int
+	index map[rune]int
+	steps int
+}
+
+// NewText returns the runs of steps characters in text.
+func NewText(text string, steps int) (*Text, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("dataset: sequences of %d steps", steps)
+	}
+	t := &Text{index: map[rune]int{}, steps: steps}
+	for _, c := range text {
+		if _, ok := t.index[c]; !ok {
+			t.index[c] = 0
+			t.Alphabet = append(t.Alphabet, c)
+		}
+	}
+	sort.Slice(t.Alphabet, func(i, j int) bool { return t.Alphabet[i] < t.Alphabet[j] })
+	for i, c := range t.Alphabet {
+		t.index[c] = i
+	}
+	for _, c := range text {
+		t.chars = append(t.chars, t.index[c])
+	}
+	if len(t.chars) <= steps {
+		return nil, fmt.Errorf("dataset: text of %d characters is too short for sequences of %d", len(t.chars), steps)
+	}
+	return t, nil
+}
+
+// ReadText returns the runs of steps characters in the text file at path.
+func ReadText(path string, steps int) (*Text, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := NewText(string(b), steps)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Steps returns the number of characters in each sequence.
+func (t *Text) Steps() int {
+	return t.steps
+}
+
+// Features returns the number of values each character is encoded as, the
+// size of the alphabet.
+func (t *Text) Features() int {
+	return len(t.Alphabet)
+}
+
+// Len returns the number of sequences in the text.
+func (t *Text) Len() int {
+	return len(t.chars) - t.steps
+}
+
+// At returns the sequence starting at character i.
+func (t *Text) At(i int) Sample {
+	inputs := make([]float64, t.steps*len(t.Alphabet))
+	for s, c := range t.chars[i : i+t.steps] {
+		inputs[s*len(t.Alphabet)+c] = 1
+	}
+	next := t.chars[i+t.steps]
+	targets := make([]float64, len(t.Alphabet))
+	targets[next] = 1
+	return Sample{Inputs: inputs, Targets: targets, Label: next}
+}
+
+// Each calls fn for every sequence in order.
+func (t *Text) Each(fn func(Sample) error) error {
+	for i := 0; i < t.Len(); i++ {
+		if err := fn(t.At(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode returns the inputs for a sequence of characters, which must all be
+// in the alphabet.
+func (t *Text) Encode(s []rune) ([]float64, error) {
+	inputs := make([]float64, len(s)*len(t.Alphabet))
+	for i, c := range s {
+		j, ok := t.index[c]
+		if !ok {
+			return nil, fmt.Errorf("dataset: %q is not in the alphabet", c)
+		}
+		inputs[i*len(t.Alphabet)+j] = 1
+	}
+	return inputs, nil
+}
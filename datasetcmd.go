@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kheob/ml/dataset"
+)
+
+const datasetUsage = `Usage: ml dataset <command> [flags]
+
+Commands:
+  download <name>  download a dataset, one of: %s
+  split <file>     split a CSV file into training and test files
+`
+
+func datasetCmd(args []string) error {
+	names := make([]string, 0, len(dataset.Sources))
+	for name := range dataset.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	usage := fmt.Sprintf(datasetUsage, strings.Join(names, ", "))
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "download":
+		return downloadCmd(args[1:])
+	case "split":
+		return splitCmd(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "ml dataset: unknown command %q\n\n", args[0])
+	fmt.Fprint(os.Stderr, usage)
+	os.Exit(2)
+	return nil
+}
+
+func downloadCmd(args []string) error {
+	fs := flag.NewFlagSet("dataset download", flag.ExitOnError)
+	dir := fs.String("dir", "", "Directory to download the dataset into (default <name>_dataset)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ml dataset download [flags] <name>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	src, ok := dataset.Sources[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown dataset %q", fs.Arg(0))
+	}
+	if *dir == "" {
+		*dir = src.Name + "_dataset"
+	}
+	return dataset.Download(src, *dir, os.Stdout)
+}
+
+func splitCmd(args []string) error {
+	fs := flag.NewFlagSet("dataset split", flag.ExitOnError)
+	ratio := fs.Float64("ratio", 0.8, "Fraction of the rows to put in the training file")
+	stratify := fs.Bool("stratify", false, "Keep the proportion of each label the same in both files")
+	labelColumn := fs.Int("label-column", 0, "Index of the label column to stratify by, negative to count from the end")
+	header := fs.Bool("header", false, "Copy the first row to both files as a header rather than splitting it")
+	delimiter := fs.String("delimiter", ",", "Field delimiter, use \\t for tabs")
+	seed := fs.Int64("seed", 0, "Random seed for the split, 0 to pick one from the current time")
+	trainPath := fs.String("train", "", "File to write the training rows to (default <file>_train.csv)")
+	testPath := fs.String("test", "", "File to write the test rows to (default <file>_test.csv)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ml dataset split [flags] <file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *ratio <= 0 || *ratio >= 1 {
+		return fmt.Errorf("ratio must be between 0 and 1, got %g", *ratio)
+	}
+	delim := *delimiter
+	if delim == "\\t" {
+		delim = "\t"
+	}
+	if utf8.RuneCountInString(delim) != 1 {
+		return fmt.Errorf("delimiter must be a single character, got %q", *delimiter)
+	}
+	path := fs.Arg(0)
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if *trainPath == "" {
+		*trainPath = base + "_train.csv"
+	}
+	if *testPath == "" {
+		*testPath = base + "_test.csv"
+	}
+	if *seed == 0 {
+		*seed = time.Now().UTC().UnixNano()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r := csv.NewReader(f)
+	r.Comma, _ = utf8.DecodeRuneInString(delim)
+	rows, err := r.ReadAll()
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	var head []string
+	if *header && len(rows) > 0 {
+		head, rows = rows[0], rows[1:]
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("%s has no rows to split", path)
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	var train, test []int
+	if *stratify {
+		col := *labelColumn
+		if col < 0 {
+			col += len(rows[0])
+		}
+		if col < 0 || col >= len(rows[0]) {
+			return fmt.Errorf("label column %d out of range for %d columns", *labelColumn, len(rows[0]))
+		}
+		ids := map[string]int{}
+		labels := make([]int, len(rows))
+		for i, row := range rows {
+			id, ok := ids[row[col]]
+			if !ok {
+				id = len(ids)
+				ids[row[col]] = id
+			}
+			labels[i] = id
+		}
+		train, test = dataset.StratifiedIndexes(labels, 1-*ratio, rng)
+	} else {
+		train, test = dataset.SplitIndexes(len(rows), 1-*ratio, rng)
+	}
+
+	if err := writeRows(*trainPath, r.Comma, head, rows, train); err != nil {
+		return err
+	}
+	if err := writeRows(*testPath, r.Comma, head, rows, test); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d rows to %s and %d rows to %s with seed %d\n", len(train), *trainPath, len(test), *testPath, *seed)
+	return nil
+}
+
+// writeRows writes head, if not nil, and the rows at the given indexes to
+// the CSV file at path.
+func writeRows(path string, comma rune, head []string, rows [][]string, indexes []int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Comma = comma
+	if head != nil {
+		w.Write(head)
+	}
+	for _, i := range indexes {
+		w.Write(rows[i])
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"sync"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/eval"
+	"github.com/kheob/ml/nn"
+)
+
+// trainEnsemble trains the ensemble of networks described by cfg and saves
+// it as an ensemble file, along with the resolved config. Any validation
+// split is held back from every network, so the ensemble can be evaluated
+// on it as a whole.
+func trainEnsemble(cfg trainConfig) error {
+	var train func(trainConfig, trainingSet, dataset.Indexed, *dataset.Subset) ([]nn.Network, error)
+	switch cfg.Ensemble.Method {
+	case "bagging":
+		train = trainBagging
+	case "snapshot":
+		train = trainSnapshots
+	default:
+		return fmt.Errorf("unknown ensemble method %q, want bagging or snapshot", cfg.Ensemble.Method)
+	}
+	if cfg.Ensemble.Size < 2 {
+		return fmt.Errorf("an ensemble needs at least 2 networks, got %d", cfg.Ensemble.Size)
+	}
+	if cfg.Ensemble.Parallel < 1 {
+		return fmt.Errorf("parallel must be at least 1, got %d", cfg.Ensemble.Parallel)
+	}
+	combination, err := nn.CombinationByName(cfg.Ensemble.Combine)
+	if err != nil {
+		return err
+	}
+	regression := cfg.Dataset == "csv" && cfg.CSV.Regression
+	if regression && combination == nn.Vote {
+		return fmt.Errorf("regression has no classes to vote for, use -combine average")
+	}
+	if cfg.Task == "autoencoder" {
+		return fmt.Errorf("an autoencoder cannot be trained as an ensemble")
+	}
+	if cfg.Checkpoint.Epochs > 0 || cfg.Checkpoint.Minutes > 0 {
+		return fmt.Errorf("checkpoints cannot be saved while training an ensemble")
+	}
+	if _, err := networkOptions(cfg); err != nil {
+		return err
+	}
+	set, err := loadTrainingSet(cfg)
+	if err != nil {
+		return err
+	}
+	indexed, ok := set.data.(dataset.Indexed)
+	if !ok {
+		return fmt.Errorf("an ensemble needs the training data to fit in memory")
+	}
+	var val *dataset.Subset
+	if cfg.ValSplit > 0 {
+		indexed, val = dataset.Split(indexed, cfg.ValSplit, rand.New(rand.NewSource(cfg.Seed)))
+	}
+	if cfg.EarlyStop.Patience > 0 && val == nil {
+		return fmt.Errorf("early stopping needs a validation split, set one with -val-split")
+	}
+	nets, err := train(cfg, set, indexed, val)
+	if err != nil {
+		return err
+	}
+
+	e, err := nn.NewEnsemble(nets, combination)
+	if err != nil {
+		return err
+	}
+	if val != nil {
+		m, err := eval.Evaluate(e, val, eval.Options{Regression: regression})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("ensemble: val %s\n", foldSummary(m))
+	}
+	if err := keepVersions(cfg.Model, cfg.KeepVersions); err != nil {
+		return fmt.Errorf("keeping the earlier model: %w", err)
+	}
+	if err := e.Save(cfg.Model); err != nil {
+		return fmt.Errorf("saving ensemble: %w", err)
+	}
+	if err := cfg.save(cfg.Model + ".yaml"); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	return nil
+}
+
+// trainBagging trains the networks of a bagging ensemble on data, holding
+// back val for validation if it is not nil.
+func trainBagging(cfg trainConfig, set trainingSet, data dataset.Indexed, val *dataset.Subset) ([]nn.Network, error) {
+	if cfg.Log != "" {
+		return nil, fmt.Errorf("the networks of a bagging ensemble cannot share a training log")
+	}
+	// train each network from a seed of its own, on a resample of the data
+	// drawn with yet another
+	member := func(i int) (nn.Network, error) {
+		mcfg := cfg
+		mcfg.Seed = cfg.Seed + 10*int64(i+1)
+		mcfg.ValSplit, mcfg.EarlyStop.Patience = 0, 0
+		netOpts, err := networkOptions(mcfg)
+		if err != nil {
+			return nn.Network{}, err
+		}
+		bag := set
+		bag.data = dataset.Bootstrap(data, rand.New(rand.NewSource(mcfg.Seed+3)))
+		net, _, err := buildNetwork(mcfg, bag, netOpts, "")
+		if err != nil {
+			return net, err
+		}
+		fitData, opts, err := fitSetup(mcfg, bag)
+		if err != nil {
+			return net, err
+		}
+		if val != nil {
+			opts.validation = val
+		}
+		if cfg.EarlyStop.Patience > 0 {
+			if opts.earlyStop, err = newEarlyStop(cfg.EarlyStop.Patience, cfg.EarlyStop.Metric); err != nil {
+				return net, err
+			}
+		}
+		var res fitResult
+		opts.output, opts.result = io.Discard, &res
+		if err := fit(&net, fitData, opts); err != nil {
+			return net, fmt.Errorf("training: %w", err)
+		}
+		meta, err := trainedMetadata(mcfg, net, fitData, res)
+		if err != nil {
+			return net, err
+		}
+		net.SetMetadata(meta)
+		summary := fmt.Sprintf("trained for %d epochs", res.epochs)
+		if res.val != nil {
+			summary += ", val " + foldSummary(*res.val)
+		}
+		fmt.Printf("network %d/%d: %s\n", i+1, cfg.Ensemble.Size, summary)
+		return net, nil
+	}
+	nets := make([]nn.Network, cfg.Ensemble.Size)
+	errs := make([]error, len(nets))
+	running := make(chan struct{}, cfg.Ensemble.Parallel)
+	var wg sync.WaitGroup
+	for i := range nets {
+		wg.Add(1)
+		running <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			nets[i], errs[i] = member(i)
+			<-running
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("network %d: %w", i+1, err)
+		}
+	}
+	return nets, nil
+}
+
+// trainSnapshots trains a single network on data with a learning rate that
+// falls along a cosine and restarts once for each network of the ensemble,
+// taking a snapshot of it at the end of each cycle, when the rate is at its
+// lowest, as the networks of a snapshot ensemble. val is held back for
+// validation if it is not nil.
+func trainSnapshots(cfg trainConfig, set trainingSet, data dataset.Indexed, val *dataset.Subset) ([]nn.Network, error) {
+	n := cfg.Ensemble.Size
+	if cfg.Epochs%n != 0 {
+		return nil, fmt.Errorf("a snapshot ensemble needs the epochs, %d, to be a multiple of the %d networks", cfg.Epochs, n)
+	}
+	if cfg.EarlyStop.Patience > 0 {
+		return nil, fmt.Errorf("a snapshot ensemble needs every cycle, so cannot stop early")
+	}
+	if cfg.Schedule.Name != "constant" {
+		return nil, fmt.Errorf("a snapshot ensemble sets its own cyclic learning rate schedule, so cannot use %s", cfg.Schedule.Name)
+	}
+	cycle := cfg.Epochs / n
+	mcfg := cfg
+	mcfg.ValSplit = 0
+	netOpts, err := networkOptions(mcfg)
+	if err != nil {
+		return nil, err
+	}
+	netOpts = append(netOpts, nn.WithScheduler(nn.CyclicCosine{Cycle: cycle, Min: cfg.Schedule.Min}))
+	set.data = data
+	net, _, err := buildNetwork(mcfg, set, netOpts, "")
+	if err != nil {
+		return nil, err
+	}
+	fitData, opts, err := fitSetup(mcfg, set)
+	if err != nil {
+		return nil, err
+	}
+	if val != nil {
+		opts.validation = val
+	}
+	opts.snapshots = &snapshots{cycle: cycle}
+	if cfg.Log != "" {
+		if opts.log, err = openTrainingLog(cfg.Log, cfg); err != nil {
+			return nil, fmt.Errorf("opening training log: %w", err)
+		}
+		defer opts.log.Close()
+	}
+	if !cfg.Quiet {
+		opts.progress = newProgress(cfg.Epochs)
+	}
+	if err := fit(&net, fitData, opts); err != nil {
+		return nil, fmt.Errorf("training: %w", err)
+	}
+	s := opts.snapshots
+	for i, snapshot := range s.nets {
+		res := fitResult{epochs: s.epochs[i], val: s.val[i]}
+		meta, err := trainedMetadata(mcfg, snapshot, fitData, res)
+		if err != nil {
+			return nil, err
+		}
+		s.nets[i].SetMetadata(meta)
+		summary := fmt.Sprintf("after epoch %d", res.epochs)
+		if res.val != nil {
+			summary += ", val " + foldSummary(*res.val)
+		}
+		fmt.Printf("snapshot %d/%d: %s\n", i+1, n, summary)
+	}
+	return s.nets, nil
+}
+
+// loadEnsembleFile loads the ensemble file at path, or named path in the
+// model registry. It returns nil if path is a model file, or there is no
+// file there, to be left to loadModel.
+func loadEnsembleFile(path string) (*nn.Ensemble, error) {
+	file, err := modelPath(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := nn.LoadEnsemble(file)
+	if err == nn.ErrBadModel || errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading ensemble: %w", err)
+	}
+	return &e, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/eval"
+	"github.com/kheob/ml/nn"
+)
+
+func evalCmd(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	modelPath := fs.String("model", "data/mnist.model", "Path of the model or ensemble to evaluate")
+	name := fs.String("dataset", "mnist", "Dataset the model was trained on")
+	testData := fs.String("test-data", "", "Path of the test data, either a CSV file or a directory of IDX files (default <dataset>_dataset)")
+	trainData := fs.String("train-data", "", "csv: path of the training data, needed to normalize the test data the same way")
+	skipBadRows := fs.Bool("skip-bad-rows", false, "Skip the rows of CSV data that cannot be read, logging each and counting them, rather than stopping at the first")
+	var opts evalOptions
+	fs.IntVar(&opts.Workers, "workers", 0, "Number of goroutines to evaluate with (default one per CPU)")
+	fs.Var((*sizes)(&opts.TopK), "top-k", "Comma separated k to report the top-k accuracy for, e.g. 3,5")
+	var metrics metricNames
+	fs.Var(&metrics, "metrics", "Comma separated metrics to report beside the usual ones: accuracy, top-k for a k such as top-5, precision, recall, f1 or mse")
+	fs.BoolVar(&opts.confusion, "confusion", true, "Print the confusion matrix")
+	fs.StringVar(&opts.confusionOut, "confusion-out", "", "File to write the confusion matrix to, as CSV if it ends in .csv and JSON otherwise")
+	fs.StringVar(&opts.misclassified, "misclassified", "", "Directory to write every misclassified image to as a PNG, with a manifest.csv listing them")
+	cv := fs.Int("cv", 0, "Estimate how well the model's training does by k-fold cross-validation with this many folds, training a network for each, rather than evaluating the model")
+	configPath := fs.String("config", "", "Training config to cross-validate with -cv (default the config saved with the model)")
+	csvCfg := defaultCSVConfig()
+	csvCfg.register(fs)
+	fs.Parse(args)
+	var err error
+	if opts.Metrics, err = metrics.metrics(); err != nil {
+		return err
+	}
+
+	if *cv > 0 {
+		cfg := defaultTrainConfig()
+		path := *configPath
+		if path == "" {
+			path = *modelPath + ".yaml"
+		}
+		if err := cfg.load(path); err != nil {
+			return fmt.Errorf("loading training config: %w", err)
+		}
+		if *trainData != "" {
+			cfg.TrainData = *trainData
+		}
+		return crossValidate(cfg, *cv)
+	}
+
+	// an ensemble is checked by its first network, but evaluated as a whole
+	var net nn.Network
+	var model eval.Model
+	e, err := loadEnsembleFile(*modelPath)
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		net, model = e.Networks[0], *e
+	} else {
+		if net, err = loadModel(*modelPath); err != nil {
+			return err
+		}
+		model = net
+	}
+
+	if opts.misclassified != "" && (*name == "csv" || net.IsAutoencoder()) {
+		return fmt.Errorf("-misclassified needs a classifier of an image dataset")
+	}
+
+	var bad *badRows
+	if *skipBadRows {
+		bad = &badRows{}
+	}
+	defer bad.report(os.Stdout)
+	var data dataset.Dataset
+	if net.IsAutoencoder() {
+		// an autoencoder is scored on how well it reproduces its inputs
+		if data, err = evalData(*name, *testData, *trainData, csvCfg, bad); err != nil {
+			return err
+		}
+		if net.Outputs() != net.Inputs() {
+			return fmt.Errorf("autoencoder has %d inputs but %d outputs", net.Inputs(), net.Outputs())
+		}
+		opts.Regression = true
+		return evaluate(model, dataset.NewReconstruction(data), opts)
+	}
+	if *name == "csv" {
+		train, err := openCSV(csvCfg, *trainData, bad)
+		if err != nil {
+			return err
+		}
+		if *testData == "" {
+			return fmt.Errorf("the csv dataset needs -test-data")
+		}
+		if net.Outputs() != train.Outputs() {
+			return fmt.Errorf("model has %d outputs but the data has %d", net.Outputs(), train.Outputs())
+		}
+		data = train.Like(*testData)
+		opts.classes = train.Classes
+		opts.Regression = csvCfg.Regression
+	} else {
+		set, err := imageSet(*name)
+		if err != nil {
+			return err
+		}
+		if err := checkOutputs(net, set); err != nil {
+			return err
+		}
+		data = imageData(set, *testData, true, bad)
+		opts.classes = set.Classes
+	}
+	return evaluate(model, data, opts)
+}
+
+// evalData returns the test data of the named dataset, reading the training
+// data of the csv dataset too to normalize it the same way. Rows that cannot
+// be read are skipped into bad if it is not nil.
+func evalData(name, testData, trainData string, csvCfg csvConfig, bad *badRows) (dataset.Dataset, error) {
+	if name != "csv" {
+		set, err := imageSet(name)
+		if err != nil {
+			return nil, err
+		}
+		return imageData(set, testData, true, bad), nil
+	}
+	train, err := openCSV(csvCfg, trainData, bad)
+	if err != nil {
+		return nil, err
+	}
+	if testData == "" {
+		return nil, fmt.Errorf("the csv dataset needs -test-data")
+	}
+	return train.Like(testData), nil
+}
@@ -0,0 +1,122 @@
+// Package eval measures how well a classifier does on labelled data.
+package eval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Confusion is a confusion matrix. Row i counts the samples of class i by
+// the class they were predicted as.
+type Confusion [][]int
+
+// NewConfusion returns an empty confusion matrix for the given number of
+// classes.
+func NewConfusion(classes int) Confusion {
+	c := make(Confusion, classes)
+	for i := range c {
+		c[i] = make([]int, classes)
+	}
+	return c
+}
+
+// Add counts a sample of class actual predicted as class predicted.
+func (c Confusion) Add(actual, predicted int) {
+	c[actual][predicted]++
+}
+
+// Total returns the number of samples counted.
+func (c Confusion) Total() int {
+	n := 0
+	for _, row := range c {
+		for _, v := range row {
+			n += v
+		}
+	}
+	return n
+}
+
+// Correct returns the number of samples predicted as their actual class.
+func (c Confusion) Correct() int {
+	n := 0
+	for i := range c {
+		n += c[i][i]
+	}
+	return n
+}
+
+// Accuracy returns the fraction of samples predicted correctly.
+func (c Confusion) Accuracy() float64 {
+	total := c.Total()
+	if total == 0 {
+		return 0
+	}
+	return float64(c.Correct()) / float64(total)
+}
+
+// Print writes the matrix as a table to w, with actual classes down the
+// side and predicted classes across the top. names holds the name of each
+// class.
+func (c Confusion) Print(w io.Writer, names []string) error {
+	label, width := len("actual"), 1
+	for _, n := range names {
+		if len(n) > label {
+			label = len(n)
+		}
+		if len(n) > width {
+			width = len(n)
+		}
+	}
+	for _, row := range c {
+		for _, v := range row {
+			if l := len(strconv.Itoa(v)); l > width {
+				width = l
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%*s", label, "actual")
+	for _, n := range names {
+		fmt.Fprintf(&b, " %*s", width, n)
+	}
+	b.WriteString("\n")
+	for i, row := range c {
+		fmt.Fprintf(&b, "%*s", label, names[i])
+		for _, v := range row {
+			fmt.Fprintf(&b, " %*d", width, v)
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteCSV writes the matrix to w as CSV with a header row of the class
+// names and the actual class in the first column.
+func (c Confusion) WriteCSV(w io.Writer, names []string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"actual"}, names...))
+	for i, row := range c {
+		record := []string{names[i]}
+		for _, v := range row {
+			record = append(record, strconv.Itoa(v))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the matrix to w as a JSON object holding the class names
+// and the rows of the matrix.
+func (c Confusion) WriteJSON(w io.Writer, names []string) error {
+	return json.NewEncoder(w).Encode(struct {
+		Classes []string `json:"classes"`
+		Matrix  [][]int  `json:"matrix"`
+	}{names, c})
+}
@@ -0,0 +1,179 @@
+package eval
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Metric is a measure of a model that builds up a sample at a time, such
+// as for the validation data after every epoch of training. The outputs
+// and targets of a classifier give the class as an index of the highest
+// value, or, for a single output, whether it is over 0.5.
+type Metric interface {
+	// Name is what the metric is reported as.
+	Name() string
+	// Update adds the outputs of the model for a sample and its targets.
+	Update(outputs, targets []float64)
+	// Compute returns the metric over the samples added since the last
+	// Reset.
+	Compute() float64
+	// Reset forgets the samples added.
+	Reset()
+}
+
+// MetricByName returns a new metric called "accuracy", "top-k" for a k
+// such as "top-5", "precision", "recall", "f1" or "mse". Precision, recall
+// and F1 are averaged over the classes.
+func MetricByName(name string) (Metric, error) {
+	switch name {
+	case "accuracy":
+		return &Accuracy{}, nil
+	case "precision":
+		return &Precision{}, nil
+	case "recall":
+		return &Recall{}, nil
+	case "f1":
+		return &F1{}, nil
+	case "mse":
+		return &MSE{}, nil
+	}
+	if strings.HasPrefix(name, "top-") {
+		k, err := strconv.Atoi(strings.TrimPrefix(name, "top-"))
+		if err == nil && k > 0 {
+			return &TopK{K: k}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown metric %q", name)
+}
+
+// Accuracy is the fraction of samples classified correctly.
+type Accuracy struct {
+	correct, total int
+}
+
+func (a *Accuracy) Name() string { return "accuracy" }
+
+func (a *Accuracy) Update(outputs, targets []float64) {
+	if classOf(outputs) == classOf(targets) {
+		a.correct++
+	}
+	a.total++
+}
+
+func (a *Accuracy) Compute() float64 { return ratio(a.correct, a.total) }
+
+func (a *Accuracy) Reset() { *a = Accuracy{} }
+
+// TopK is the fraction of samples whose class is among the K highest
+// outputs.
+type TopK struct {
+	K int
+
+	hits, total int
+}
+
+func (t *TopK) Name() string { return "top-" + strconv.Itoa(t.K) }
+
+func (t *TopK) Update(outputs, targets []float64) {
+	if rank(outputs, classOf(targets)) < t.K {
+		t.hits++
+	}
+	t.total++
+}
+
+func (t *TopK) Compute() float64 { return ratio(t.hits, t.total) }
+
+func (t *TopK) Reset() { t.hits, t.total = 0, 0 }
+
+// confusion counts the samples of each class by the class predicted,
+// growing to however many classes the outputs have.
+type confusion struct {
+	c Confusion
+}
+
+func (c *confusion) update(outputs, targets []float64) {
+	if c.c == nil {
+		classes := len(outputs)
+		if classes == 1 {
+			classes = 2
+		}
+		c.c = NewConfusion(classes)
+	}
+	c.c.Add(classOf(targets), classOf(outputs))
+}
+
+func (c *confusion) Reset() { c.c = nil }
+
+// Precision is the fraction of the samples predicted as each class that
+// are of it, averaged over the classes.
+type Precision struct{ confusion }
+
+func (p *Precision) Name() string { return "precision" }
+
+func (p *Precision) Update(outputs, targets []float64) { p.update(outputs, targets) }
+
+func (p *Precision) Compute() float64 { return p.c.Metrics().Macro.Precision }
+
+// Recall is the fraction of the samples of each class predicted as it,
+// averaged over the classes.
+type Recall struct{ confusion }
+
+func (r *Recall) Name() string { return "recall" }
+
+func (r *Recall) Update(outputs, targets []float64) { r.update(outputs, targets) }
+
+func (r *Recall) Compute() float64 { return r.c.Metrics().Macro.Recall }
+
+// F1 is the harmonic mean of the precision and recall of each class,
+// averaged over the classes.
+type F1 struct{ confusion }
+
+func (f *F1) Name() string { return "f1" }
+
+func (f *F1) Update(outputs, targets []float64) { f.update(outputs, targets) }
+
+func (f *F1) Compute() float64 { return f.c.Metrics().Macro.F1 }
+
+// MSE is the mean squared difference between every output and its target.
+type MSE struct {
+	sum float64
+	n   int
+}
+
+func (m *MSE) Name() string { return "mse" }
+
+func (m *MSE) Update(outputs, targets []float64) {
+	for i, y := range outputs {
+		d := y - targets[i]
+		m.sum += d * d
+	}
+	m.n += len(outputs)
+}
+
+func (m *MSE) Compute() float64 {
+	if m.n == 0 {
+		return 0
+	}
+	return m.sum / float64(m.n)
+}
+
+func (m *MSE) Reset() { *m = MSE{} }
+
+// classOf returns the class given by outputs or targets, as
+// nn.Network.ClassOf does.
+func classOf(v []float64) int {
+	if len(v) == 1 {
+		if v[0] > 0.5 {
+			return 1
+		}
+		return 0
+	}
+	best := 0
+	for i := range v {
+		if v[i] > v[best] {
+			best = i
+		}
+	}
+	return best
+}
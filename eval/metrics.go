@@ -0,0 +1,431 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kheob/ml/dataset"
+)
+
+// ClassMetrics are the precision, recall and F1 score for a class, or an
+// average of them over every class.
+type ClassMetrics struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	// Support is the number of samples of the class.
+	Support int `json:"support"`
+}
+
+// Metrics describe how well a network classifies a dataset. For regression
+// only Loss, RMSE and MAE are set.
+type Metrics struct {
+	// Loss is the mean loss over the dataset.
+	Loss float64 `json:"loss"`
+	// Regression is set when the metrics are for regression.
+	Regression bool `json:"regression,omitempty"`
+	// RMSE and MAE are the root mean squared error and mean absolute error
+	// of every output against its target, for regression.
+	RMSE float64 `json:"rmse,omitempty"`
+	MAE  float64 `json:"mae,omitempty"`
+
+	Accuracy  float64   `json:"accuracy"`
+	Confusion Confusion `json:"confusion"`
+	// Classes holds the metrics for each class.
+	Classes []ClassMetrics `json:"classes"`
+	// Macro averages the metrics of the classes, treating every class the
+	// same, while Micro counts every sample the same. With exactly one
+	// label per sample the micro averages all equal the accuracy.
+	Macro ClassMetrics `json:"macro"`
+	Micro ClassMetrics `json:"micro"`
+	// TopK holds the fraction of samples whose actual class is among the k
+	// highest outputs of the network, for each k asked for.
+	TopK map[int]float64 `json:"top_k,omitempty"`
+	// Scores holds the value of each of Options.Metrics by name.
+	Scores map[string]float64 `json:"scores,omitempty"`
+	// Misclassified holds the samples the network got wrong in the order
+	// of the dataset, if Options.Misclassified asked for them.
+	Misclassified []Misclassified `json:"-"`
+}
+
+// Misclassified is a sample the network put in the wrong class.
+type Misclassified struct {
+	// Index is the position of the sample in the dataset, counting from
+	// zero.
+	Index            int
+	Label, Predicted int
+	Inputs           []float64
+}
+
+// Model is what Evaluate measures, such as an nn.Network or an nn.Ensemble.
+type Model interface {
+	PredictBatchErr(inputData [][]float64) ([][]float64, error)
+	OutputLoss(outputs [][]float64, targetData [][]float64) float64
+	ClassOf(outputs []float64) int
+	Classes() int
+	Outputs() int
+}
+
+// batchSize is the number of samples Evaluate runs through the network at
+// once.
+const batchSize = 256
+
+// Options control what Evaluate measures and how.
+type Options struct {
+	// TopK lists the k to measure the top-k accuracy for.
+	TopK []int
+	// Workers is the number of goroutines to run the network on, or zero
+	// for one per CPU.
+	Workers int
+	// Regression measures how far the outputs are from the targets rather
+	// than how well the samples are classified.
+	Regression bool
+	// Misclassified keeps the samples the network gets wrong.
+	Misclassified bool
+	// Metrics are reset and then updated with every sample, to report in
+	// Metrics.Scores.
+	Metrics []Metric
+}
+
+// batch is a set of samples to run through the network together, starting
+// at index start of the dataset.
+type batch struct {
+	start           int
+	labels          []int
+	inputs, targets [][]float64
+}
+
+// tally adds up the results of the batches run by one worker.
+type tally struct {
+	confusion Confusion
+	loss      float64
+	hits      []int
+	// samples counts the samples seen, and squared and absolute add up
+	// the errors of their outputs, for regression.
+	samples           int
+	squared, absolute float64
+	// misclassified holds the samples got wrong, if asked for.
+	misclassified []Misclassified
+	// mu guards Options.Metrics, which every worker updates.
+	mu *sync.Mutex
+	// err is the first error met, after which the worker skips the rest
+	// of its batches.
+	err error
+}
+
+func (t *tally) add(net Model, b batch, opts Options) error {
+	outputs, err := net.PredictBatchErr(b.inputs)
+	if err != nil {
+		return fmt.Errorf("samples %d-%d: %w", b.start+1, b.start+len(b.inputs), err)
+	}
+	t.loss += net.OutputLoss(outputs, b.targets) * float64(len(outputs))
+	t.samples += len(outputs)
+	if len(opts.Metrics) > 0 {
+		t.mu.Lock()
+		for _, m := range opts.Metrics {
+			for i, o := range outputs {
+				m.Update(o, b.targets[i])
+			}
+		}
+		t.mu.Unlock()
+	}
+	if opts.Regression {
+		for i, o := range outputs {
+			for j, y := range o {
+				d := y - b.targets[i][j]
+				t.squared += d * d
+				t.absolute += math.Abs(d)
+			}
+		}
+		return nil
+	}
+	for i, o := range outputs {
+		predicted := net.ClassOf(o)
+		t.confusion.Add(b.labels[i], predicted)
+		if opts.Misclassified && predicted != b.labels[i] {
+			t.misclassified = append(t.misclassified, Misclassified{Index: b.start + i, Label: b.labels[i], Predicted: predicted, Inputs: b.inputs[i]})
+		}
+		r := rank(o, b.labels[i])
+		for j, k := range opts.TopK {
+			if r < k {
+				t.hits[j]++
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate runs the network over data and measures how well it does. The
+// samples are split into batches shared between a pool of workers, which
+// is safe as running a network does not change it.
+func Evaluate(net Model, data dataset.Dataset, opts Options) (Metrics, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	classes := net.Classes()
+	for _, m := range opts.Metrics {
+		m.Reset()
+	}
+
+	batches := make(chan batch, workers)
+	tallies := make([]tally, workers)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for w := range tallies {
+		t := &tallies[w]
+		t.mu = &mu
+		t.confusion = NewConfusion(classes)
+		t.hits = make([]int, len(opts.TopK))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				if t.err == nil {
+					t.err = t.add(net, b, opts)
+				}
+			}
+		}()
+	}
+
+	var b batch
+	seen := 0
+	err := data.Each(func(s dataset.Sample) error {
+		if !opts.Regression && (s.Label < 0 || s.Label >= classes) {
+			return fmt.Errorf("sample label %d is out of range for %d classes", s.Label, classes)
+		}
+		b.labels = append(b.labels, s.Label)
+		b.inputs = append(b.inputs, s.Inputs)
+		b.targets = append(b.targets, s.Targets)
+		seen++
+		if len(b.inputs) == batchSize {
+			batches <- b
+			b = batch{start: seen}
+		}
+		return nil
+	})
+	if err == nil && len(b.inputs) > 0 {
+		batches <- b
+	}
+	close(batches)
+	wg.Wait()
+	for _, t := range tallies {
+		if err == nil {
+			err = t.err
+		}
+	}
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	if opts.Regression {
+		m := regressionMetrics(tallies, net.Outputs())
+		m.Scores = computeScores(opts.Metrics)
+		return m, nil
+	}
+
+	c := NewConfusion(classes)
+	loss := 0.0
+	hits := make([]int, len(opts.TopK))
+	var misclassified []Misclassified
+	for _, t := range tallies {
+		misclassified = append(misclassified, t.misclassified...)
+		for i, row := range t.confusion {
+			for j, n := range row {
+				c[i][j] += n
+			}
+		}
+		loss += t.loss
+		for i, n := range t.hits {
+			hits[i] += n
+		}
+	}
+
+	m := c.Metrics()
+	sort.Slice(misclassified, func(i, j int) bool { return misclassified[i].Index < misclassified[j].Index })
+	m.Misclassified = misclassified
+	m.Scores = computeScores(opts.Metrics)
+	total := c.Total()
+	if total > 0 {
+		m.Loss = loss / float64(total)
+	}
+	if len(opts.TopK) > 0 {
+		m.TopK = make(map[int]float64, len(opts.TopK))
+		for i, k := range opts.TopK {
+			m.TopK[k] = ratio(hits[i], total)
+		}
+	}
+	return m, nil
+}
+
+// computeScores computes each metric by name, or returns nil if there are none.
+func computeScores(metrics []Metric) map[string]float64 {
+	if len(metrics) == 0 {
+		return nil
+	}
+	s := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		s[m.Name()] = m.Compute()
+	}
+	return s
+}
+
+// regressionMetrics adds up the tallies of a regression run over a network
+// with the given number of outputs.
+func regressionMetrics(tallies []tally, outputs int) Metrics {
+	m := Metrics{Regression: true}
+	samples := 0
+	for _, t := range tallies {
+		samples += t.samples
+		m.Loss += t.loss
+		m.RMSE += t.squared
+		m.MAE += t.absolute
+	}
+	if samples > 0 {
+		m.Loss /= float64(samples)
+		n := float64(samples * outputs)
+		m.RMSE = math.Sqrt(m.RMSE / n)
+		m.MAE /= n
+	}
+	return m
+}
+
+// rank returns how many classes score higher in outputs than the actual
+// class, so zero when it is predicted correctly.
+func rank(outputs []float64, actual int) int {
+	if len(outputs) == 1 {
+		// with two classes the other one is either predicted or not
+		if (outputs[0] > 0.5) == (actual == 1) {
+			return 0
+		}
+		return 1
+	}
+	r := 0
+	for _, v := range outputs {
+		if v > outputs[actual] {
+			r++
+		}
+	}
+	return r
+}
+
+// Metrics works out the accuracy and the metrics of each class from the
+// confusion matrix. The loss is left at zero.
+func (c Confusion) Metrics() Metrics {
+	m := Metrics{
+		Accuracy:  c.Accuracy(),
+		Confusion: c,
+		Classes:   make([]ClassMetrics, len(c)),
+	}
+	for i := range c {
+		var predicted, actual int
+		for j := range c {
+			predicted += c[j][i]
+			actual += c[i][j]
+		}
+		cm := ClassMetrics{
+			Precision: ratio(c[i][i], predicted),
+			Recall:    ratio(c[i][i], actual),
+			Support:   actual,
+		}
+		cm.F1 = f1(cm.Precision, cm.Recall)
+		m.Classes[i] = cm
+
+		m.Macro.Precision += cm.Precision
+		m.Macro.Recall += cm.Recall
+		m.Macro.F1 += cm.F1
+	}
+	if n := float64(len(c)); n > 0 {
+		m.Macro.Precision /= n
+		m.Macro.Recall /= n
+		m.Macro.F1 /= n
+	}
+	total := c.Total()
+	m.Macro.Support = total
+
+	// every wrong prediction is both a false positive for the predicted
+	// class and a false negative for the actual one
+	m.Micro = ClassMetrics{
+		Precision: m.Accuracy,
+		Recall:    m.Accuracy,
+		F1:        m.Accuracy,
+		Support:   total,
+	}
+	return m
+}
+
+// Print writes a table of the metrics of each class and the averages to w.
+// names holds the name of each class. Regression metrics are written on a
+// single line.
+func (m Metrics) Print(w io.Writer, names []string) error {
+	if m.Regression {
+		_, err := fmt.Fprintf(w, "rmse %.4f, mae %.4f, loss %.4f%s\n", m.RMSE, m.MAE, m.Loss, m.scores())
+		return err
+	}
+
+	label := len("macro avg")
+	for _, n := range names {
+		if len(n) > label {
+			label = len(n)
+		}
+	}
+
+	var b strings.Builder
+	row := func(name string, cm ClassMetrics) {
+		fmt.Fprintf(&b, "%*s %9.4f %9.4f %9.4f %9d\n", label, name, cm.Precision, cm.Recall, cm.F1, cm.Support)
+	}
+	fmt.Fprintf(&b, "%*s %9s %9s %9s %9s\n", label, "class", "precision", "recall", "f1", "support")
+	for i, cm := range m.Classes {
+		row(names[i], cm)
+	}
+	b.WriteString("\n")
+	row("macro avg", m.Macro)
+	row("micro avg", m.Micro)
+	fmt.Fprintf(&b, "\naccuracy %.2f%%", 100*m.Accuracy)
+	ks := make([]int, 0, len(m.TopK))
+	for k := range m.TopK {
+		ks = append(ks, k)
+	}
+	sort.Ints(ks)
+	for _, k := range ks {
+		fmt.Fprintf(&b, ", top-%d %.2f%%", k, 100*m.TopK[k])
+	}
+	fmt.Fprintf(&b, ", loss %.4f%s\n", m.Loss, m.scores())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// scores lists the Scores in order of name, each after a comma, for Print.
+func (m Metrics) scores() string {
+	names := make([]string, 0, len(m.Scores))
+	for name := range m.Scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, ", %s %.4f", name, m.Scores[name])
+	}
+	return b.String()
+}
+
+// ratio returns n / d, or zero if d is zero.
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// f1 returns the harmonic mean of precision and recall.
+func f1(precision, recall float64) float64 {
+	if precision+recall == 0 {
+		return 0
+	}
+	return 2 * precision * recall / (precision + recall)
+}
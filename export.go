@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func exportCmd(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	modelPath := fs.String("model", "data/mnist.model", "Path of the model to export")
+	format := fs.String("format", "onnx", "Format to export the model in: onnx for an ONNX graph, npz for the weights as NumPy arrays, json for the architecture and weights as JSON, gocode for Go source with a Predict function, or c for a C header with a predict function")
+	out := fs.String("out", "", "Path of the file to write (default model.<format>, model_gen.go for gocode or model.h for c)")
+	pkg := fs.String("package", "model", "gocode: package of the Go source")
+	prefix := fs.String("prefix", "model", "c: prefix of the names in the C header")
+	fs.Parse(args)
+
+	if *out == "" {
+		*out = "model." + *format
+		switch *format {
+		case "gocode":
+			*out = "model_gen.go"
+		case "c":
+			*out = "model.h"
+		}
+	}
+	net, err := loadModel(*modelPath)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "onnx":
+		err = net.ExportONNX(*out)
+	case "npz":
+		err = net.SaveNumpyWeights(*out)
+	case "json":
+		err = writeJSON(*out, net)
+	case "gocode":
+		err = net.ExportGo(*out, *pkg)
+	case "c":
+		err = net.ExportC(*out, *prefix)
+	default:
+		return fmt.Errorf("unknown export format %q, want onnx, npz, json, gocode or c", *format)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %s to %s\n", *modelPath, *out)
+	return nil
+}
+
+// writeJSON writes v to path as indented JSON, one value per line, so that
+// models can be diffed.
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/nn"
+)
+
+func finetuneCmd(args []string) error {
+	cfg := defaultTrainConfig()
+	fs := flag.NewFlagSet("finetune", flag.ExitOnError)
+	base := fs.String("model", "data/mnist.model", "Path of the pretrained model to start from")
+	fs.StringVar(&cfg.Model, "out", "data/finetuned.model", "Path to save the fine-tuned model to")
+	var freeze sizes
+	fs.Var(&freeze, "freeze", "Comma separated layers to leave as they are, counting the layers with weights from 1 at the inputs, e.g. 1,2")
+	classes := fs.Int("classes", 0, "Replace the output layer with a new one for this many classes, 0 to keep it")
+	fs.StringVar(&cfg.Dataset, "dataset", cfg.Dataset, "Dataset to fine-tune on: mnist, fashion-mnist, emnist-{digits,letters,balanced,byclass} or csv for tabular data")
+	fs.StringVar(&cfg.TrainData, "train-data", cfg.TrainData, "Path of the training data, either a CSV file or a directory of IDX files (default <dataset>_dataset)")
+	fs.BoolVar(&cfg.SkipBadRows, "skip-bad-rows", cfg.SkipBadRows, "Skip the rows of CSV training data that cannot be read, logging each and counting them, rather than stopping at the first")
+	cfg.CSV.register(fs)
+	fs.IntVar(&cfg.Epochs, "epochs", cfg.Epochs, "Number of passes over the training data")
+	fs.BoolVar(&cfg.Shuffle, "shuffle", cfg.Shuffle, "Shuffle the training data between epochs")
+	fs.Float64Var(&cfg.ValSplit, "val-split", cfg.ValSplit, "Fraction of the training data to hold back for validation after each epoch")
+	fs.StringVar(&cfg.Init, "init", cfg.Init, "Initializer for the weights of a replaced output layer: uniform, xavier-uniform, xavier-normal, he, lecun or orthogonal")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "Random seed for a replaced output layer and shuffling, 0 to pick one from the current time")
+	fs.Float64Var(&cfg.LearningRate, "lr", 0.01, "Learning rate")
+	fs.IntVar(&cfg.BatchSize, "batch-size", cfg.BatchSize, "Number of samples per mini-batch")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "Number of goroutines to split each mini-batch between")
+	cfg.registerOptimizer(fs)
+	fs.StringVar(&cfg.Comment, "comment", cfg.Comment, "Comment to save in the metadata of the model")
+	fs.IntVar(&cfg.KeepVersions, "keep-versions", cfg.KeepVersions, "Number of earlier versions of the model to keep when saving over it, as <model>.1, <model>.2 and so on")
+	fs.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "Do not show training progress, for scripted runs")
+	fs.Parse(args)
+
+	initializer, err := nn.InitializerByName(cfg.Init)
+	if err != nil {
+		return err
+	}
+	opt, err := cfg.optimizer()
+	if err != nil {
+		return err
+	}
+	if cfg.ValSplit < 0 || cfg.ValSplit >= 1 {
+		return fmt.Errorf("validation split must be between 0 and 1, got %g", cfg.ValSplit)
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UTC().UnixNano()
+	}
+	var bad *badRows
+	if cfg.SkipBadRows {
+		bad = &badRows{}
+	}
+	data, inputs, outputs, err := trainingData(cfg, bad)
+	if err != nil {
+		return err
+	}
+
+	netOpts := append([]nn.Option{nn.WithLearningRate(cfg.LearningRate), nn.WithOptimizer(opt), nn.WithInitializer(initializer), nn.WithSeed(cfg.Seed), nn.WithWorkers(cfg.Workers)}, cfg.LayerRates.options()...)
+	net, err := loadModel(*base, netOpts...)
+	if err != nil {
+		return err
+	}
+	if net.Inputs() != inputs {
+		return fmt.Errorf("model takes %d inputs but the data has %d", net.Inputs(), inputs)
+	}
+	layers := make([]int, len(freeze))
+	for i, l := range freeze {
+		if weighted := len(net.Sizes()) - 1; l > weighted {
+			return fmt.Errorf("cannot freeze layer %d of a model with %d layers with weights", l, weighted)
+		}
+		layers[i] = l - 1
+	}
+	if err := cfg.LayerRates.check(len(net.Sizes()) - 1); err != nil {
+		return err
+	}
+	if err := net.Freeze(layers...); err != nil {
+		return err
+	}
+	if *classes > 0 {
+		if err := net.ReplaceOutputs(*classes); err != nil {
+			return err
+		}
+	}
+	if net.Outputs() != outputs {
+		return fmt.Errorf("model has %d outputs but the data has %d, replace the output layer with -classes %d", net.Outputs(), outputs, outputs)
+	}
+
+	data, err = dataset.Load(data, inputs, outputs, 0)
+	if err != nil {
+		return fmt.Errorf("loading training data: %w", err)
+	}
+	bad.report(os.Stdout)
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	opts := fitOptions{epochs: cfg.Epochs, batchSize: cfg.BatchSize, regression: cfg.Dataset == "csv" && cfg.CSV.Regression, stop: interrupts(), lbfgs: cfg.Optimizer == "lbfgs"}
+	if cfg.ValSplit > 0 {
+		var val *dataset.Subset
+		data, val = dataset.Split(data.(dataset.Indexed), cfg.ValSplit, rng)
+		opts.validation = val
+	}
+	if cfg.Shuffle {
+		opts.rng = rng
+	}
+	if !cfg.Quiet {
+		opts.progress = newProgress(cfg.Epochs)
+	}
+	var res fitResult
+	opts.result = &res
+	if err := fit(&net, data, opts); err != nil && err != errInterrupted {
+		return fmt.Errorf("training: %w", err)
+	}
+	meta, err := trainedMetadata(cfg, net, data, res)
+	if err != nil {
+		return err
+	}
+	net.SetMetadata(meta)
+	if err := keepVersions(cfg.Model, cfg.KeepVersions); err != nil {
+		return fmt.Errorf("keeping the earlier model: %w", err)
+	}
+	if err := net.Save(cfg.Model); err != nil {
+		return fmt.Errorf("saving model: %w", err)
+	}
+	fmt.Printf("saved the fine-tuned model to %s\n", cfg.Model)
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+
+	"github.com/kheob/ml/helpers"
+	"github.com/kheob/ml/nn"
+)
+
+func gradcheckCmd(args []string) error {
+	fs := flag.NewFlagSet("gradcheck", flag.ExitOnError)
+	hidden := sizes{5, 4}
+	fs.Var(&hidden, "hidden", "Comma separated sizes of the hidden layers of the networks to check")
+	inputs := fs.Int("inputs", 6, "Number of inputs of the networks to check")
+	outputs := fs.Int("outputs", 3, "Number of outputs of the networks to check")
+	samples := fs.Int("samples", 4, "Number of random samples to work out the gradients over")
+	epsilon := fs.Float64("epsilon", 1e-5, "Step to nudge each weight by for the finite differences")
+	tolerance := fs.Float64("tolerance", 1e-4, "Largest relative error to accept")
+	weightDecay := fs.Float64("weight-decay", 0, "L2 penalty on the weights to include")
+	l1 := fs.Float64("l1", 0, "L1 penalty on the weights to include")
+	seed := fs.Int64("seed", 1, "Random seed for the networks and samples")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ml gradcheck [flags]")
+		fmt.Fprintln(fs.Output(), "\nChecks the gradients from backpropagation against finite differences on small")
+		fmt.Fprintln(fs.Output(), "random networks with every activation and loss, and fails if any disagree.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *inputs <= 0 || *outputs <= 0 || *samples <= 0 {
+		return fmt.Errorf("inputs, outputs and samples must be positive")
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	inputData := make([][]float64, *samples)
+	targetData := make([][]float64, *samples)
+	for i := range inputData {
+		inputData[i] = make([]float64, *inputs)
+		for j := range inputData[i] {
+			inputData[i][j] = rng.Float64()*2 - 1
+		}
+		targetData[i] = make([]float64, *outputs)
+		targetData[i][rng.Intn(*outputs)] = 1
+	}
+
+	layers := append(append([]int{*inputs}, hidden...), *outputs)
+	hiddenActivations := []helpers.Activation{helpers.Sigmoid{}, helpers.Tanh{}, helpers.ReLU{}, helpers.LeakyReLU{}}
+	outputLayers := []struct {
+		activation helpers.Activation
+		loss       nn.Loss
+	}{
+		{helpers.Sigmoid{}, nn.MSE{}},
+		{helpers.Sigmoid{}, nn.BinaryCrossEntropy{}},
+		{helpers.Sigmoid{}, nn.Huber{Delta: 0.25}},
+		{helpers.Softmax{}, nn.CrossEntropy{}},
+		{helpers.Linear{}, nn.MSE{}},
+	}
+	fmt.Printf("%-10s %-8s %-20s %12s  %s\n", "hidden", "output", "loss", "max error", "at")
+	failed := 0
+	for _, h := range hiddenActivations {
+		for _, o := range outputLayers {
+			activations := make([]helpers.Activation, len(layers)-1)
+			for i := range activations {
+				activations[i] = h
+			}
+			activations[len(activations)-1] = o.activation
+			net := nn.CreateNetwork(layers, activations, 0, nn.WithSeed(rng.Int63()), nn.WithLoss(o.loss), nn.WithWeightDecay(*weightDecay), nn.WithL1(*l1))
+
+			res, err := net.GradCheck(inputData, targetData, *epsilon)
+			if err != nil {
+				return err
+			}
+			status := ""
+			if res.Kinks > 0 {
+				status = fmt.Sprintf(", %d at kinks skipped", res.Kinks)
+			}
+			if res.MaxError > *tolerance {
+				status += "  FAIL"
+				failed++
+			}
+			fmt.Printf("%-10s %-8s %-20s %12.3g  layer %d %s, %.6g vs %.6g%s\n", h.Name(), o.activation.Name(), o.loss.Name(), res.MaxError, res.Layer, res.Param, res.Analytic, res.Numeric, status)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d networks have gradients off by more than %g", failed, len(hiddenActivations)*len(outputLayers), *tolerance)
+	}
+	return nil
+}
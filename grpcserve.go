@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/proto/inferencepb"
+)
+
+// inferenceServer serves the model of ml serve over gRPC, sharing the
+// network being served, and any batching of predictions, with the JSON API.
+type inferenceServer struct {
+	inferencepb.UnimplementedInferenceServer
+	model   *servedModel
+	predict func(inputs []float64) ([]float64, error)
+}
+
+func (s *inferenceServer) Predict(ctx context.Context, req *inferencepb.PredictRequest) (*inferencepb.PredictResponse, error) {
+	if len(req.Pixels) != dataset.ImagePixels {
+		return nil, status.Errorf(codes.InvalidArgument, "got %d pixels, want %d", len(req.Pixels), dataset.ImagePixels)
+	}
+	inputs := make([]float64, len(req.Pixels))
+	for i, x := range req.Pixels {
+		inputs[i] = dataset.PixelInput(x)
+	}
+	outputs, err := s.predict(inputs)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	label := bestOutput(outputs)
+	s.model.metrics.predicted(label)
+	return &inferencepb.PredictResponse{Label: int32(label), Class: s.model.set.Classes[label], Outputs: outputs}, nil
+}
+
+func (s *inferenceServer) Info(ctx context.Context, req *inferencepb.InfoRequest) (*inferencepb.ModelInfo, error) {
+	net := s.model.get()
+	info := &inferencepb.ModelInfo{Dataset: s.model.set.Name, Classes: s.model.set.Classes}
+	for _, n := range net.Sizes() {
+		info.Sizes = append(info.Sizes, int32(n))
+	}
+	for _, l := range net.Layers() {
+		if a, ok := l.(interface{ Name() string }); ok {
+			info.Activations = append(info.Activations, a.Name())
+		}
+	}
+	return info, nil
+}
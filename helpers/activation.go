@@ -0,0 +1,230 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Activation is a neuron activation function. Apply and Derivative write
+// their results to dst, which must be empty or the same shape as m, so that
+// a network can reuse its matrices from one batch to the next. Derivative
+// is given the already activated outputs of a layer, as that is what is
+// kept around during backpropagation. Name identifies the activation,
+// including any settings, so that it can be recreated with
+// ActivationByName.
+type Activation interface {
+	Apply(dst *mat.Dense, m mat.Matrix)
+	Derivative(dst *mat.Dense, m mat.Matrix)
+	Name() string
+}
+
+// ActivationByName returns the activation with the given name, as returned
+// by its Name method.
+func ActivationByName(name string) (Activation, error) {
+	switch name {
+	case "sigmoid":
+		return Sigmoid{}, nil
+	case "tanh":
+		return Tanh{}, nil
+	case "relu":
+		return ReLU{}, nil
+	case "leakyrelu":
+		return LeakyReLU{}, nil
+	case "softmax":
+		return Softmax{}, nil
+	case "linear":
+		return Linear{}, nil
+	}
+	if strings.HasPrefix(name, "leakyrelu:") {
+		alpha, err := strconv.ParseFloat(strings.TrimPrefix(name, "leakyrelu:"), 64)
+		if err == nil {
+			return LeakyReLU{Alpha: alpha}, nil
+		}
+	}
+	return nil, fmt.Errorf("helpers: unknown activation %q", name)
+}
+
+// Sigmoid is the logistic activation 1 / (1 + e^-z).
+type Sigmoid struct{}
+
+func (Sigmoid) Apply(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(sigmoid, m)
+}
+
+func (Sigmoid) Derivative(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(sigmoidPrime, m)
+}
+
+func (Sigmoid) Name() string {
+	return "sigmoid"
+}
+
+// Tanh is the hyperbolic tangent activation.
+type Tanh struct{}
+
+func (Tanh) Apply(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(func(_, _ int, z float64) float64 {
+		return math.Tanh(z)
+	}, m)
+}
+
+func (Tanh) Derivative(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(func(_, _ int, a float64) float64 {
+		return 1 - a*a
+	}, m)
+}
+
+func (Tanh) Name() string {
+	return "tanh"
+}
+
+// ReLU is the rectified linear unit max(0, z).
+type ReLU struct{}
+
+func (ReLU) Apply(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(func(_, _ int, z float64) float64 {
+		return math.Max(0, z)
+	}, m)
+}
+
+func (ReLU) Derivative(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(func(_, _ int, a float64) float64 {
+		if a > 0 {
+			return 1
+		}
+		return 0
+	}, m)
+}
+
+func (ReLU) Name() string {
+	return "relu"
+}
+
+// LeakyReLU is like ReLU but lets a small gradient through for negative
+// inputs. Alpha defaults to 0.01 when left as zero.
+type LeakyReLU struct {
+	Alpha float64
+}
+
+func (l LeakyReLU) alpha() float64 {
+	if l.Alpha == 0 {
+		return 0.01
+	}
+	return l.Alpha
+}
+
+func (l LeakyReLU) Apply(dst *mat.Dense, m mat.Matrix) {
+	alpha := l.alpha()
+	dst.Apply(func(_, _ int, z float64) float64 {
+		if z > 0 {
+			return z
+		}
+		return alpha * z
+	}, m)
+}
+
+func (l LeakyReLU) Derivative(dst *mat.Dense, m mat.Matrix) {
+	alpha := l.alpha()
+	dst.Apply(func(_, _ int, a float64) float64 {
+		if a > 0 {
+			return 1
+		}
+		return alpha
+	}, m)
+}
+
+func (l LeakyReLU) Name() string {
+	if l.Alpha == 0 {
+		return "leakyrelu"
+	}
+	return "leakyrelu:" + strconv.FormatFloat(l.Alpha, 'g', -1, 64)
+}
+
+// Linear passes its input through unchanged. It is meant for the output
+// layer of a network predicting continuous values.
+type Linear struct{}
+
+func (Linear) Apply(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(func(_, _ int, z float64) float64 {
+		return z
+	}, m)
+}
+
+func (Linear) Derivative(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(func(_, _ int, _ float64) float64 {
+		return 1
+	}, m)
+}
+
+func (Linear) Name() string {
+	return "linear"
+}
+
+// Softmax turns each column into a probability distribution. It is only
+// meant for the output layer, where it is paired with cross-entropy loss:
+// the gradient of the two combined is simply target - output, so Derivative
+// returns ones and leaves the output errors untouched.
+type Softmax struct{}
+
+func (Softmax) Apply(dst *mat.Dense, m mat.Matrix) {
+	r, c := m.Dims()
+	if err := checkDst(dst, r, c); err != nil {
+		panic(err)
+	}
+	if dst.IsEmpty() {
+		dst.ReuseAs(r, c)
+	}
+	col := make([]float64, r)
+	for j := 0; j < c; j++ {
+		mat.Col(col, j, m)
+		lse := LogSumExp(col)
+		for i, z := range col {
+			dst.Set(i, j, math.Exp(z-lse))
+		}
+	}
+}
+
+func (Softmax) Derivative(dst *mat.Dense, m mat.Matrix) {
+	dst.Apply(func(_, _ int, _ float64) float64 {
+		return 1
+	}, m)
+}
+
+func (Softmax) Name() string {
+	return "softmax"
+}
+
+// LogSumExp returns log(sum(e^v)) without overflowing for large values.
+func LogSumExp(v []float64) float64 {
+	max := math.Inf(-1)
+	for _, x := range v {
+		max = math.Max(max, x)
+	}
+	if math.IsInf(max, 0) {
+		return max
+	}
+	sum := 0.0
+	for _, x := range v {
+		sum += math.Exp(x - max)
+	}
+	return max + math.Log(sum)
+}
+
+// CrossEntropy returns the mean cross-entropy between predicted
+// probabilities and targets, with one sample per column.
+func CrossEntropy(outputs, targets mat.Matrix) float64 {
+	r, c := outputs.Dims()
+	loss := 0.0
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			// clamp to avoid log(0)
+			p := math.Max(outputs.At(i, j), 1e-15)
+			loss -= targets.At(i, j) * math.Log(p)
+		}
+	}
+	return loss / float64(c)
+}
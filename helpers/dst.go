@@ -0,0 +1,121 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrShape is returned, wrapped with the dimensions involved, when the
+// matrices given to one of the To helpers do not fit together.
+var ErrShape = errors.New("helpers: dimension mismatch")
+
+// The To helpers write their result to dst rather than allocating a new
+// matrix. dst must be empty, in which case it is sized to fit, or already
+// the shape of the result.
+
+// DotTo sets dst to the matrix product of m and n.
+func DotTo(dst *mat.Dense, m, n mat.Matrix) error {
+	mr, mc := m.Dims()
+	nr, nc := n.Dims()
+	if mc != nr {
+		return fmt.Errorf("%w: cannot multiply %dx%d by %dx%d", ErrShape, mr, mc, nr, nc)
+	}
+	if err := checkDst(dst, mr, nc); err != nil {
+		return err
+	}
+	dst.Mul(m, n)
+	return nil
+}
+
+// ApplyTo sets each element of dst to fn applied to the element of m.
+func ApplyTo(dst *mat.Dense, fn func(i, j int, v float64) float64, m mat.Matrix) error {
+	r, c := m.Dims()
+	if err := checkDst(dst, r, c); err != nil {
+		return err
+	}
+	dst.Apply(fn, m)
+	return nil
+}
+
+// ScaleTo sets dst to s * m.
+func ScaleTo(dst *mat.Dense, s float64, m mat.Matrix) error {
+	r, c := m.Dims()
+	if err := checkDst(dst, r, c); err != nil {
+		return err
+	}
+	dst.Scale(s, m)
+	return nil
+}
+
+// MultiplyTo sets dst to the element-wise product of m and n.
+func MultiplyTo(dst *mat.Dense, m, n mat.Matrix) error {
+	if err := checkSameShape(dst, "multiply", m, n); err != nil {
+		return err
+	}
+	dst.MulElem(m, n)
+	return nil
+}
+
+// AddTo sets dst to m + n.
+func AddTo(dst *mat.Dense, m, n mat.Matrix) error {
+	if err := checkSameShape(dst, "add", m, n); err != nil {
+		return err
+	}
+	dst.Add(m, n)
+	return nil
+}
+
+// SubtractTo sets dst to m - n.
+func SubtractTo(dst *mat.Dense, m, n mat.Matrix) error {
+	if err := checkSameShape(dst, "subtract", m, n); err != nil {
+		return err
+	}
+	dst.Sub(m, n)
+	return nil
+}
+
+// AddColumnTo sets dst to m with the column vector v added to every
+// column. dst may be m.
+func AddColumnTo(dst *mat.Dense, m, v mat.Matrix) error {
+	r, c := m.Dims()
+	if vr, vc := v.Dims(); vr != r || vc != 1 {
+		return fmt.Errorf("%w: cannot add a %dx%d column to %dx%d", ErrShape, vr, vc, r, c)
+	}
+	if err := checkDst(dst, r, c); err != nil {
+		return err
+	}
+	if dst.IsEmpty() {
+		dst.ReuseAs(r, c)
+	}
+	for i := 0; i < r; i++ {
+		b := v.At(i, 0)
+		for j := 0; j < c; j++ {
+			dst.Set(i, j, m.At(i, j)+b)
+		}
+	}
+	return nil
+}
+
+// checkSameShape checks that m and n are the same shape, to carry out the
+// element-wise operation op on, and dst can hold a result of that shape.
+func checkSameShape(dst *mat.Dense, op string, m, n mat.Matrix) error {
+	mr, mc := m.Dims()
+	nr, nc := n.Dims()
+	if mr != nr || mc != nc {
+		return fmt.Errorf("%w: cannot %s %dx%d and %dx%d", ErrShape, op, mr, mc, nr, nc)
+	}
+	return checkDst(dst, mr, mc)
+}
+
+// checkDst checks that dst is empty or r x c.
+func checkDst(dst *mat.Dense, r, c int) error {
+	if dst.IsEmpty() {
+		return nil
+	}
+	if dr, dc := dst.Dims(); dr != r || dc != c {
+		return fmt.Errorf("%w: cannot write a %dx%d result to %dx%d", ErrShape, r, c, dr, dc)
+	}
+	return nil
+}
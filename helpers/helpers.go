@@ -2,87 +2,108 @@ package helpers
 
 import (
 	"math"
+	"math/rand"
 
 	"gonum.org/v1/gonum/mat"
-	"gonum.org/v1/gonum/stat/distuv"
 )
 
+// The helpers below allocate their result, and panic with the error of
+// the To helper they wrap if the matrices do not fit together.
+
+// Dot returns the matrix product of m and n.
 func Dot(m, n mat.Matrix) mat.Matrix {
-	r, _ := m.Dims()
-	_, c := n.Dims()
-	o := mat.NewDense(r, c, nil)
-	o.Product(m, n)
-	return o
+	var o mat.Dense
+	must(DotTo(&o, m, n))
+	return &o
 }
 
+// Apply returns fn applied to each element of m.
 func Apply(fn func(i, j int, v float64) float64, m mat.Matrix) mat.Matrix {
-	r, c := m.Dims()
-	o := mat.NewDense(r, c, nil)
-	o.Apply(fn, m)
-	return o
+	var o mat.Dense
+	must(ApplyTo(&o, fn, m))
+	return &o
 }
 
+// Scale returns s * m.
 func Scale(s float64, m mat.Matrix) mat.Matrix {
-	r, c := m.Dims()
-	o := mat.NewDense(r, c, nil)
-	o.Scale(s, m)
-	return o
+	var o mat.Dense
+	must(ScaleTo(&o, s, m))
+	return &o
 }
 
+// Multiply returns the element-wise product of m and n.
 func Multiply(m, n mat.Matrix) mat.Matrix {
-	r, c := m.Dims()
-	o := mat.NewDense(r, c, nil)
-	o.MulElem(m, n)
-	return o
+	var o mat.Dense
+	must(MultiplyTo(&o, m, n))
+	return &o
 }
 
+// Add returns m + n.
 func Add(m, n mat.Matrix) mat.Matrix {
-	r, c := m.Dims()
-	o := mat.NewDense(r, c, nil)
-	o.Add(m, n)
-	return o
+	var o mat.Dense
+	must(AddTo(&o, m, n))
+	return &o
 }
 
+// Subtract returns m - n.
 func Subtract(m, n mat.Matrix) mat.Matrix {
-	r, c := m.Dims()
-	o := mat.NewDense(r, c, nil)
-	o.Sub(m, n)
-	return o
+	var o mat.Dense
+	must(SubtractTo(&o, m, n))
+	return &o
 }
 
+// AddScalar returns m with i added to each element.
 func AddScalar(i float64, m mat.Matrix) mat.Matrix {
-	r, c := m.Dims()
-	a := make([]float64, r*c)
-	for x := 0; x < r*c; x++ {
-		a[x] = i
-	}
-	n := mat.NewDense(r, c, a)
-	return Add(m, n)
+	return Apply(func(_, _ int, v float64) float64 { return v + i }, m)
 }
 
-func RandomArray(size int, v float64) (data []float64) {
-	dist := distuv.Uniform{
-		Min: -1 / math.Sqrt(v),
-		Max: 1 / math.Sqrt(v),
+// must panics with err if it is not nil.
+func must(err error) {
+	if err != nil {
+		panic(err)
 	}
+}
 
+// RandomArray returns size numbers drawn from rng uniformly between
+// -1/sqrt(v) and 1/sqrt(v).
+func RandomArray(rng *rand.Rand, size int, v float64) (data []float64) {
+	bound := 1 / math.Sqrt(v)
 	data = make([]float64, size)
 	for i := 0; i < size; i++ {
-		data[i] = dist.Rand()
+		data[i] = bound * (2*rng.Float64() - 1)
 	}
 	return
 }
 
-func Sigmoid(r, c int, z float64) float64 {
+func sigmoid(r, c int, z float64) float64 {
 	return 1.0 / (1 + math.Exp(-1*z))
 }
 
+func sigmoidPrime(r, c int, v float64) float64 {
+	return v * (1 - v)
+}
+
 func SigmoidPrime(m mat.Matrix) mat.Matrix {
-	rows, _ := m.Dims()
-	o := make([]float64, rows)
-	for i := range o {
-		o[i] = 1
+	return Apply(sigmoidPrime, m) // m * (1 - m)
+}
+
+// AddColumn adds the column vector v to every column of m.
+func AddColumn(m, v mat.Matrix) mat.Matrix {
+	var o mat.Dense
+	must(AddColumnTo(&o, m, v))
+	return &o
+}
+
+// SumRows returns a column vector holding the sum of each row of m.
+func SumRows(m mat.Matrix) mat.Matrix {
+	r, c := m.Dims()
+	o := mat.NewDense(r, 1, nil)
+	for i := 0; i < r; i++ {
+		sum := 0.0
+		for j := 0; j < c; j++ {
+			sum += m.At(i, j)
+		}
+		o.Set(i, 0, sum)
 	}
-	ones := mat.NewDense(rows, 1, o)
-	return Multiply(m, Subtract(ones, m)) // m * (1 - m)
+	return o
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+
+	"github.com/kheob/ml/dataset"
+)
+
+// imageSide is the width and height of the images in the image datasets.
+const imageSide = 28
+
+// pixelImage returns the greyscale image given as network inputs.
+func pixelImage(inputs []float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, imageSide, imageSide))
+	for p, v := range inputs {
+		img.SetGray(p%imageSide, p/imageSide, color.Gray{Y: uint8(dataset.PixelValue(v) + 0.5)})
+	}
+	return img
+}
+
+// imageGrid lays out rows of images, each given as network inputs, in a
+// single greyscale image with a pixel between each.
+func imageGrid(rows [][][]float64) *image.Gray {
+	const gap = 1
+	cols := 0
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	img := image.NewGray(image.Rect(0, 0, cols*(imageSide+gap)+gap, len(rows)*(imageSide+gap)+gap))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	for r, row := range rows {
+		for c, inputs := range row {
+			at := image.Pt(gap+c*(imageSide+gap), gap+r*(imageSide+gap))
+			draw.Draw(img, image.Rectangle{Min: at, Max: at.Add(image.Pt(imageSide, imageSide))}, pixelImage(inputs), image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// writePNG writes img to the PNG file at path.
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
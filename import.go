@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/kheob/ml/helpers"
+	"github.com/kheob/ml/nn"
+)
+
+func importCmd(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "keras", "Format to import the model from: keras for the weights of a Sequential model of Dense layers saved with numpy.savez(path, *model.get_weights())")
+	weights := fs.String("weights", "", "Path of the weights to import")
+	acts := fs.String("activations", "", "Comma separated activation of each dense layer, e.g. relu,relu,softmax")
+	modelPath := fs.String("model", "data/imported.model", "Path to save the model to")
+	precision := fs.String("precision", "float64", "Precision to keep the weights in: float64 or float32")
+	fs.Parse(args)
+
+	if *format != "keras" {
+		return fmt.Errorf("unknown import format %q, want keras", *format)
+	}
+	if *weights == "" || *acts == "" {
+		return fmt.Errorf("import needs -weights and -activations")
+	}
+	var activations []helpers.Activation
+	for _, name := range strings.Split(*acts, ",") {
+		a, err := helpers.ActivationByName(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		activations = append(activations, a)
+	}
+	p, err := nn.PrecisionByName(*precision)
+	if err != nil {
+		return err
+	}
+	net, err := nn.ReadKerasNetwork(*weights, activations, nn.WithPrecision(p))
+	if err != nil {
+		return err
+	}
+	if err := net.Save(*modelPath); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s to %s\n\n", *weights, *modelPath)
+	fmt.Print(net.Summary())
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/nn"
+)
+
+func lrfindCmd(args []string) error {
+	cfg := defaultTrainConfig()
+	fs := flag.NewFlagSet("lrfind", flag.ExitOnError)
+	configPath := fs.String("config", "", "YAML file to read the training configuration from, as for ml train; other flags override it")
+	minRate := fs.Float64("min-lr", 1e-7, "Learning rate to start the sweep from")
+	maxRate := fs.Float64("max-lr", 10, "Learning rate to end the sweep at, unless the loss blows up first")
+	steps := fs.Int("steps", 100, "Number of mini-batches to train, raising the learning rate by the same factor for each")
+	out := fs.String("out", "lrfind.csv", "CSV file to write the loss at each learning rate to, empty for none")
+	plot := fs.String("plot", "lrfind.png", "PNG file to plot the smoothed loss against the log of the learning rate to, with the suggested rate marked in red, empty for none")
+	fs.StringVar(&cfg.Dataset, "dataset", cfg.Dataset, "Dataset to train on: mnist, fashion-mnist, emnist-{digits,letters,balanced,byclass} or csv for tabular data")
+	fs.StringVar(&cfg.Task, "task", cfg.Task, "What to train the network to do: classify, or autoencoder to reproduce its inputs through the last of the hidden layers")
+	fs.StringVar(&cfg.TrainData, "train-data", cfg.TrainData, "Path of the training data, either a CSV file or a directory of IDX files (default <dataset>_dataset)")
+	cfg.CSV.register(fs)
+	fs.BoolVar(&cfg.Softmax, "softmax", cfg.Softmax, "Use a softmax output layer trained with cross-entropy loss")
+	fs.StringVar(&cfg.Loss, "loss", cfg.Loss, "Loss to train with: mse, cross-entropy, binary-cross-entropy or huber (default cross-entropy with -softmax, mse otherwise)")
+	fs.Var(&cfg.Conv, "conv", "Comma separated convolutional layers to put before the hidden layers of an image dataset, as for ml train")
+	fs.Var(&cfg.Hidden, "hidden", "Comma separated sizes of the hidden layers, e.g. 512,256")
+	fs.Var(&cfg.Dropout, "dropout", "Dropout rate of the hidden layers while training, either one for all of them or a comma separated rate for each")
+	fs.StringVar(&cfg.Init, "init", cfg.Init, "Initializer for the starting weights: uniform, xavier-uniform, xavier-normal, he, lecun or orthogonal")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "Random seed for the initial weights and shuffling, 0 to pick one from the current time")
+	fs.Float64Var(&cfg.WeightDecay, "weight-decay", cfg.WeightDecay, "L2 penalty on the weights, added to the training loss, or the decoupled weight decay of the adamw optimizer")
+	fs.IntVar(&cfg.BatchSize, "batch-size", cfg.BatchSize, "Number of samples per mini-batch")
+	fs.StringVar(&cfg.Precision, "precision", cfg.Precision, "Precision to keep the weights in and work out the matrix products in: float64 or float32")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "Number of goroutines to split each mini-batch between")
+	cfg.registerOptimizer(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ml lrfind [flags]")
+		fmt.Fprintln(fs.Output(), "\nTrains a fresh network for a few mini-batches with the learning rate rising from")
+		fmt.Fprintln(fs.Output(), "-min-lr to -max-lr, and suggests the rate at which the loss fell fastest as one to")
+		fmt.Fprintln(fs.Output(), "train with.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *configPath != "" {
+		if err := cfg.load(*configPath); err != nil {
+			return err
+		}
+		// parse again so that flags given explicitly win over the file
+		fs.Parse(args)
+	}
+
+	if *minRate <= 0 || *maxRate <= *minRate {
+		return fmt.Errorf("the learning rates must rise from above 0, got %g to %g", *minRate, *maxRate)
+	}
+	if *steps < 2 {
+		return fmt.Errorf("the sweep needs at least 2 steps, got %d", *steps)
+	}
+	if cfg.Optimizer == "lbfgs" {
+		return fmt.Errorf("L-BFGS sizes its own steps, so has no learning rate to find")
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UTC().UnixNano()
+	}
+	// the sweep is the schedule, each mini-batch taken as an epoch
+	cfg.LearningRate, cfg.Schedule.Name, cfg.Schedule.WarmupSteps = *minRate, "constant", 0
+	netOpts, err := networkOptions(cfg)
+	if err != nil {
+		return err
+	}
+	growth := math.Pow(*maxRate / *minRate, 1/float64(*steps-1))
+	netOpts = append(netOpts, nn.WithScheduler(nn.ExponentialDecay{Gamma: growth}))
+	set, err := loadTrainingSet(cfg)
+	if err != nil {
+		return err
+	}
+	net, _, err := buildNetwork(cfg, set, netOpts, "")
+	if err != nil {
+		return err
+	}
+
+	points, err := sweepRates(&net, set.data, cfg.BatchSize, *steps, rand.New(rand.NewSource(cfg.Seed)))
+	if err != nil {
+		return err
+	}
+	best := suggestRate(points)
+	if best < 0 {
+		return fmt.Errorf("the loss never fell, try a wider range of learning rates")
+	}
+	fmt.Printf("swept %d learning rates from %.3g to %.3g\n", len(points), points[0].rate, points[len(points)-1].rate)
+	fmt.Printf("the loss fell fastest at %.3g, a good learning rate to start from\n", points[best].rate)
+	if *out != "" {
+		if err := writeSweep(*out, points); err != nil {
+			return err
+		}
+		fmt.Printf("wrote the losses to %s\n", *out)
+	}
+	if *plot != "" {
+		if err := writePNG(*plot, plotSweep(points, best)); err != nil {
+			return err
+		}
+		fmt.Printf("plotted the losses to %s\n", *plot)
+	}
+	return nil
+}
+
+// sweepPoint is the loss of one mini-batch of a learning rate sweep.
+type sweepPoint struct {
+	rate, loss float64
+	// smoothed is the exponential moving average of the losses so far,
+	// corrected for starting from zero.
+	smoothed float64
+}
+
+// errSweepDone stops a pass over the data once the sweep is over.
+var errSweepDone = errors.New("sweep done")
+
+// sweepRates trains net on up to steps mini-batches of data, passing over
+// it as many times as it takes, with the rate its schedule gives for each
+// mini-batch in turn, and returns the loss at each. The data is shuffled
+// with rng if it can be indexed. The sweep stops early once the loss blows
+// up to four times the lowest so far.
+func sweepRates(net *nn.Network, data dataset.Dataset, batchSize, steps int, rng *rand.Rand) ([]sweepPoint, error) {
+	// smooth over about a twentieth of the sweep, so the average does not
+	// lag far behind the loss
+	beta := math.Min(math.Max(1-20/float64(steps), 0.8), 0.98)
+	var points []sweepPoint
+	var inputs, targets [][]float64
+	avg, lowest := 0.0, math.Inf(1)
+	add := func(s dataset.Sample) error {
+		inputs, targets = append(inputs, s.Inputs), append(targets, s.Targets)
+		if len(inputs) < batchSize {
+			return nil
+		}
+		step := len(points)
+		net.SetEpoch(step)
+		loss, err := net.TrainBatch(inputs, targets)
+		if err != nil {
+			return err
+		}
+		inputs, targets = inputs[:0], targets[:0]
+		avg = beta*avg + (1-beta)*loss
+		p := sweepPoint{rate: net.LearningRate(), loss: loss, smoothed: avg / (1 - math.Pow(beta, float64(step+1)))}
+		points = append(points, p)
+		lowest = math.Min(lowest, p.smoothed)
+		if len(points) == steps || math.IsNaN(p.smoothed) || p.smoothed > 4*lowest {
+			return errSweepDone
+		}
+		return nil
+	}
+	indexed, canShuffle := data.(dataset.Indexed)
+	for {
+		var err error
+		if canShuffle {
+			for _, i := range rng.Perm(indexed.Len()) {
+				if err = add(indexed.At(i)); err != nil {
+					break
+				}
+			}
+		} else {
+			err = data.Each(add)
+		}
+		if errors.Is(err, errSweepDone) {
+			return points, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(points) == 0 {
+			return nil, fmt.Errorf("the training data has fewer samples than a mini-batch")
+		}
+	}
+}
+
+// suggestRate returns the index of the point where the smoothed loss falls
+// fastest against the log of the learning rate, before it reaches its
+// lowest, or -1 if it never falls. The first tenth of the sweep is left
+// out, as the average of the first few losses is still noisy.
+func suggestRate(points []sweepPoint) int {
+	skip := len(points) / 10
+	if skip < 1 {
+		skip = 1
+	}
+	lowest := skip
+	for i := skip; i < len(points); i++ {
+		if points[i].smoothed < points[lowest].smoothed {
+			lowest = i
+		}
+	}
+	best, steepest := -1, 0.0
+	for i := skip; i < lowest; i++ {
+		slope := (points[i+1].smoothed - points[i-1].smoothed) / math.Log(points[i+1].rate/points[i-1].rate)
+		if slope < steepest {
+			best, steepest = i, slope
+		}
+	}
+	return best
+}
+
+// writeSweep writes the points of a sweep to the CSV file at path.
+func writeSweep(path string, points []sweepPoint) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"learning_rate", "loss", "smoothed_loss"})
+	for _, p := range points {
+		w.Write([]string{
+			strconv.FormatFloat(p.rate, 'g', -1, 64),
+			strconv.FormatFloat(p.loss, 'g', -1, 64),
+			strconv.FormatFloat(p.smoothed, 'g', -1, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// plotSweep draws the smoothed loss of each point of a sweep in blue
+// against the log of its learning rate, with the raw losses as grey dots
+// and a red line at the point suggested. The loss axis spans the smoothed
+// losses, so raw losses beyond them fall outside the plot.
+func plotSweep(points []sweepPoint, suggested int) *image.RGBA {
+	const width, height, margin = 640, 400, 20
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	axis := color.RGBA{160, 160, 160, 255}
+	for x := margin; x < width-margin; x++ {
+		img.Set(x, height-margin, axis)
+	}
+	for y := margin; y <= height-margin; y++ {
+		img.Set(margin, y, axis)
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		lo, hi = math.Min(lo, p.smoothed), math.Max(hi, p.smoothed)
+	}
+	if hi <= lo {
+		hi = lo + 1
+	}
+	first, last := math.Log(points[0].rate), math.Log(points[len(points)-1].rate)
+	if last <= first {
+		last = first + 1
+	}
+	at := func(rate, loss float64) (int, int) {
+		x := margin + (math.Log(rate)-first)/(last-first)*(width-2*margin)
+		y := height - margin - (loss-lo)/(hi-lo)*(height-2*margin)
+		return int(math.Round(x)), int(math.Round(y))
+	}
+	if suggested >= 0 {
+		x, _ := at(points[suggested].rate, lo)
+		for y := margin; y < height-margin; y++ {
+			img.Set(x, y, color.RGBA{220, 40, 40, 255})
+		}
+	}
+	grey := color.RGBA{180, 180, 180, 255}
+	for _, p := range points {
+		x, y := at(p.rate, p.loss)
+		img.Set(x, y, grey)
+	}
+	blue := color.RGBA{30, 90, 200, 255}
+	for i := 1; i < len(points); i++ {
+		x0, y0 := at(points[i-1].rate, points[i-1].smoothed)
+		x1, y1 := at(points[i].rate, points[i].smoothed)
+		drawLine(img, x0, y0, x1, y1, blue)
+	}
+	return img
+}
+
+// drawLine draws a line from (x0, y0) to (x1, y1) on img in c, leaving out
+// whatever falls outside img.
+func drawLine(img draw.Image, x0, y0, x1, y1 int, c color.Color) {
+	n := int(math.Max(math.Abs(float64(x1-x0)), math.Abs(float64(y1-y0))))
+	if n == 0 {
+		img.Set(x0, y0, c)
+		return
+	}
+	for i := 0; i <= n; i++ {
+		t := float64(i) / float64(n)
+		img.Set(int(math.Round(float64(x0)+t*float64(x1-x0))), int(math.Round(float64(y0)+t*float64(y1-y0))), c)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/eval"
+	"github.com/kheob/ml/nn"
+)
+
+func inspectCmd(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ml inspect <model>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	net, err := loadModel(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s weights, %d inputs, %d outputs\n\n", path, net.Precision(), net.Inputs(), net.Outputs())
+	m := net.Metadata()
+	if m.IsZero() {
+		fmt.Println("No metadata saved with the model.")
+		return nil
+	}
+	row := func(name, value string, known bool) {
+		if known {
+			fmt.Printf("%-17s%s\n", name+":", value)
+		}
+	}
+	row("Created", m.Created.Local().Format(time.RFC1123), !m.Created.IsZero())
+	row("Dataset", m.Dataset, m.Dataset != "")
+	row("Dataset SHA-256", m.DatasetHash, m.DatasetHash != "")
+	row("Epochs", fmt.Sprint(m.Epochs), m.Epochs != 0)
+	row("Train accuracy", fmt.Sprintf("%.2f%%", 100*m.TrainAccuracy), m.TrainAccuracy != 0)
+	row("Val accuracy", fmt.Sprintf("%.2f%%", 100*m.ValAccuracy), m.ValAccuracy != 0)
+	row("Commit", m.Commit, m.Commit != "")
+	row("Comment", m.Comment, m.Comment != "")
+	return nil
+}
+
+// trainedMetadata returns the metadata to save with a network trained as
+// described by cfg on data, going by the result of fit. The training
+// accuracy is measured afresh on data, for classifiers.
+func trainedMetadata(cfg trainConfig, net nn.Network, data dataset.Dataset, res fitResult) (nn.Metadata, error) {
+	m := nn.Metadata{
+		Created: time.Now().UTC(),
+		Dataset: cfg.Dataset,
+		Epochs:  res.epochs,
+		Commit:  buildCommit(),
+		Comment: cfg.Comment,
+	}
+	path := cfg.TrainData
+	if path == "" && cfg.Dataset != "csv" {
+		path = cfg.Dataset + "_dataset"
+	}
+	var err error
+	if m.DatasetHash, err = hashFiles(path); err != nil {
+		return m, fmt.Errorf("hashing the training data: %w", err)
+	}
+	if cfg.Task == "autoencoder" || cfg.Dataset == "csv" && cfg.CSV.Regression {
+		return m, nil
+	}
+	if res.val != nil {
+		m.ValAccuracy = res.val.Accuracy
+	}
+	train, err := eval.Evaluate(net, data, eval.Options{Workers: cfg.Workers})
+	if err != nil {
+		return m, fmt.Errorf("measuring the training accuracy: %w", err)
+	}
+	m.TrainAccuracy = train.Accuracy
+	return m, nil
+}
+
+// hashFiles returns the SHA-256 hash of the file at path in hex, or for a
+// directory, of the names and contents of the files in it, in order.
+func hashFiles(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	files := []string{path}
+	if fi.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return "", err
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, e.Name())
+			}
+		}
+		sort.Strings(files)
+	}
+	h := sha256.New()
+	for _, name := range files {
+		file := name
+		if fi.IsDir() {
+			file = filepath.Join(path, name)
+			fmt.Fprintf(h, "%s\n", name)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildCommit returns the version control revision this program was built
+// from, marked dirty if it had uncommitted changes, or an empty string if
+// it is not known.
+func buildCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
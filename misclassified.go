@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kheob/ml/eval"
+)
+
+// writeMisclassified writes each misclassified image to dir as a PNG named
+// after its index in the test data and its actual and predicted classes,
+// such as 42_true3_pred8.png, along with a manifest.csv listing them.
+func writeMisclassified(dir string, misclassified []eval.Misclassified, names []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, "manifest.csv"))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"index", "true", "predicted", "file"})
+	for _, m := range misclassified {
+		file := fmt.Sprintf("%d_true%s_pred%s.png", m.Index, fileSafe(names[m.Label]), fileSafe(names[m.Predicted]))
+		if err := writePNG(filepath.Join(dir, file), pixelImage(m.Inputs)); err != nil {
+			f.Close()
+			return err
+		}
+		w.Write([]string{strconv.Itoa(m.Index), names[m.Label], names[m.Predicted], file})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d misclassified images to %s\n", len(misclassified), dir)
+	return nil
+}
+
+// fileSafe replaces the characters of a class name that do not belong in a
+// file name, such as the slash of T-shirt/top.
+func fileSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '-'
+	}, name)
+}
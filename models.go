@@ -0,0 +1,398 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const modelsUsage = `Usage: ml models <command> [flags]
+
+Commands:
+  list [name]                    list the models in the registry
+  save <model> <name>            save a model file as the next version of name
+  load <name[@version]> <model>  copy a version of name out to a model file
+  delete <name[@version]>        delete a version of name, or all of them
+  promote <name[@version]>       make a version the one name refers to
+
+The registry is kept in ~/.ml/models, or $ML_MODELS if set, with each
+version of a model in <name>/<version>/. A name can be given instead of a
+model file to the other commands, such as ml serve -model mnist, and refers
+to the promoted version of the model, or the latest if none was promoted.
+name@version refers to a version in particular.
+`
+
+// modelFile is the name of the model file in the directory of each version
+// in the registry, and promotedFile that of the file naming the promoted
+// version of a model.
+const (
+	modelFile    = "model"
+	promotedFile = "promoted"
+)
+
+func modelsCmd(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, modelsUsage)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "list":
+		return modelsListCmd(args[1:])
+	case "save":
+		return modelsSaveCmd(args[1:])
+	case "load":
+		return modelsLoadCmd(args[1:])
+	case "delete":
+		return modelsDeleteCmd(args[1:])
+	case "promote":
+		return modelsPromoteCmd(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "ml models: unknown command %q\n\n", args[0])
+	fmt.Fprint(os.Stderr, modelsUsage)
+	os.Exit(2)
+	return nil
+}
+
+// modelsFlags parses the arguments of a models command, which takes n of
+// them and no flags.
+func modelsFlags(name, usage string, n int, args []string) []string {
+	fs := flag.NewFlagSet("models "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ml models %s %s\n", name, usage)
+	}
+	fs.Parse(args)
+	if fs.NArg() != n {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+func modelsListCmd(args []string) error {
+	fs := flag.NewFlagSet("models list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ml models list [name]")
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	reg, err := openRegistry()
+	if err != nil {
+		return err
+	}
+	names := fs.Args()
+	if len(names) == 0 {
+		if names, err = reg.names(); err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name\tVersion\tCreated\tDataset\tEpochs\tTrain accuracy\tVal accuracy\tComment\n")
+	for _, name := range names {
+		versions, err := reg.versions(name)
+		if err != nil {
+			return err
+		}
+		promoted, err := reg.promoted(name)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			net, err := loadModel(reg.path(name, v))
+			if err != nil {
+				return err
+			}
+			m := net.Metadata()
+			version := strconv.Itoa(v)
+			if v == promoted {
+				version += " (promoted)"
+			}
+			created, data, epochs, train, val := "-", "-", "-", "-", "-"
+			if !m.Created.IsZero() {
+				created = m.Created.Local().Format(time.RFC3339)
+			}
+			if m.Dataset != "" {
+				data = m.Dataset
+			}
+			if m.Epochs != 0 {
+				epochs = strconv.Itoa(m.Epochs)
+			}
+			if m.TrainAccuracy != 0 {
+				train = fmt.Sprintf("%.2f%%", 100*m.TrainAccuracy)
+			}
+			if m.ValAccuracy != 0 {
+				val = fmt.Sprintf("%.2f%%", 100*m.ValAccuracy)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, version, created, data, epochs, train, val, m.Comment)
+		}
+	}
+	return tw.Flush()
+}
+
+func modelsSaveCmd(args []string) error {
+	args = modelsFlags("save", "<model> <name>", 2, args)
+	path, name := args[0], args[1]
+	if !modelName(name) {
+		return fmt.Errorf("invalid model name %q, use letters, digits, - and _", name)
+	}
+	// make sure it is a model before filing it
+	if _, err := loadModel(path); err != nil {
+		return err
+	}
+	reg, err := openRegistry()
+	if err != nil {
+		return err
+	}
+	versions, err := reg.versions(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	v := 1
+	if len(versions) > 0 {
+		v = versions[len(versions)-1] + 1
+	}
+	dst := reg.path(name, v)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := copyModel(path, dst); err != nil {
+		os.RemoveAll(filepath.Dir(dst))
+		return err
+	}
+	fmt.Printf("saved %s as %s@%d\n", path, name, v)
+	return nil
+}
+
+func modelsLoadCmd(args []string) error {
+	args = modelsFlags("load", "<name[@version]> <model>", 2, args)
+	reg, err := openRegistry()
+	if err != nil {
+		return err
+	}
+	name, v, err := reg.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	if err := copyModel(reg.path(name, v), args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("copied %s@%d to %s\n", name, v, args[1])
+	return nil
+}
+
+func modelsDeleteCmd(args []string) error {
+	args = modelsFlags("delete", "<name[@version]>", 1, args)
+	reg, err := openRegistry()
+	if err != nil {
+		return err
+	}
+	name, v, err := reg.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(args[0], "@") {
+		if err := os.RemoveAll(filepath.Join(reg.dir, name)); err != nil {
+			return err
+		}
+		fmt.Printf("deleted every version of %s\n", name)
+		return nil
+	}
+	if promoted, err := reg.promoted(name); err != nil {
+		return err
+	} else if promoted == v {
+		if err := os.Remove(filepath.Join(reg.dir, name, promotedFile)); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(filepath.Dir(reg.path(name, v))); err != nil {
+		return err
+	}
+	if versions, err := reg.versions(name); err == nil && len(versions) == 0 {
+		os.Remove(filepath.Join(reg.dir, name))
+	}
+	fmt.Printf("deleted %s@%d\n", name, v)
+	return nil
+}
+
+func modelsPromoteCmd(args []string) error {
+	args = modelsFlags("promote", "<name[@version]>", 1, args)
+	reg, err := openRegistry()
+	if err != nil {
+		return err
+	}
+	name, v, err := reg.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(args[0], "@") {
+		// a bare name would resolve to the version already promoted
+		versions, _ := reg.versions(name)
+		v = versions[len(versions)-1]
+	}
+	if err := os.WriteFile(filepath.Join(reg.dir, name, promotedFile), []byte(fmt.Sprintln(v)), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("promoted %s@%d\n", name, v)
+	return nil
+}
+
+// registry is a directory of named models, each with numbered versions.
+type registry struct {
+	dir string
+}
+
+// openRegistry returns the registry in $ML_MODELS, or ~/.ml/models if that
+// is not set. The directory need not exist yet.
+func openRegistry() (registry, error) {
+	if dir := os.Getenv("ML_MODELS"); dir != "" {
+		return registry{dir: dir}, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return registry{}, fmt.Errorf("finding the model registry: %w", err)
+	}
+	return registry{dir: filepath.Join(home, ".ml", "models")}, nil
+}
+
+// path returns the path of the model file of version v of name.
+func (reg registry) path(name string, v int) string {
+	return filepath.Join(reg.dir, name, strconv.Itoa(v), modelFile)
+}
+
+// names returns the names of the models in the registry, in order.
+func (reg registry) names() ([]string, error) {
+	entries, err := os.ReadDir(reg.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && modelName(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// versions returns the versions of name in the registry, in order.
+func (reg registry) versions(name string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(reg.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var versions []int
+	for _, e := range entries {
+		if v, err := strconv.Atoi(e.Name()); err == nil && v > 0 && e.IsDir() {
+			versions = append(versions, v)
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// promoted returns the promoted version of name, or 0 if none was.
+func (reg registry) promoted(name string) (int, error) {
+	b, err := os.ReadFile(filepath.Join(reg.dir, name, promotedFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("%s has an invalid promoted version %q", name, b)
+	}
+	return v, nil
+}
+
+// resolve returns the name and version a reference such as "mnist" or
+// "mnist@3" refers to. A bare name refers to its promoted version, or else
+// its latest.
+func (reg registry) resolve(ref string) (string, int, error) {
+	name, version, pinned := strings.Cut(ref, "@")
+	if !modelName(name) {
+		return "", 0, fmt.Errorf("invalid model name %q", name)
+	}
+	versions, err := reg.versions(name)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && len(versions) == 0 {
+		return "", 0, fmt.Errorf("no model named %s in %s", name, reg.dir)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	v := versions[len(versions)-1]
+	if pinned {
+		if v, err = strconv.Atoi(version); err != nil {
+			return "", 0, fmt.Errorf("invalid version %q of %s", version, name)
+		}
+	} else if promoted, err := reg.promoted(name); err != nil {
+		return "", 0, err
+	} else if promoted != 0 {
+		v = promoted
+	}
+	for _, have := range versions {
+		if have == v {
+			return name, v, nil
+		}
+	}
+	return "", 0, fmt.Errorf("%s has no version %d", name, v)
+}
+
+// modelPath returns the path of the model file ref refers to. That is ref
+// itself if there is a file there, or if it is not a name such as
+// "mnist@3", and otherwise the file of that model in the registry.
+func modelPath(ref string) (string, error) {
+	name, _, _ := strings.Cut(ref, "@")
+	if !modelName(name) {
+		return ref, nil
+	}
+	if _, err := os.Stat(ref); err == nil {
+		return ref, nil
+	}
+	reg, err := openRegistry()
+	if err != nil {
+		return "", err
+	}
+	name, v, err := reg.resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	return reg.path(name, v), nil
+}
+
+// modelName reports whether s can be used as the name of a model in the
+// registry.
+func modelName(s string) bool {
+	for _, r := range s {
+		if r != '-' && r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// copyModel copies the model file at src to dst, along with its config at
+// src.yaml if there is one.
+func copyModel(src, dst string) error {
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	if err := copyFile(src+".yaml", dst+".yaml"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package nn
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// activation is a layer applying an activation function to each of its
+// inputs.
+type activation struct {
+	helpers.Activation
+}
+
+// Activation returns a layer applying a to each of its inputs.
+func Activation(a helpers.Activation) Layer {
+	return activation{a}
+}
+
+// ReLU, LeakyReLU, Sigmoid, Tanh, Softmax and Linear return layers applying
+// the activations of the same names in the helpers package.
+func ReLU() Layer {
+	return Activation(helpers.ReLU{})
+}
+
+func LeakyReLU(alpha float64) Layer {
+	return Activation(helpers.LeakyReLU{Alpha: alpha})
+}
+
+func Sigmoid() Layer {
+	return Activation(helpers.Sigmoid{})
+}
+
+func Tanh() Layer {
+	return Activation(helpers.Tanh{})
+}
+
+func Softmax() Layer {
+	return Activation(helpers.Softmax{})
+}
+
+func Linear() Layer {
+	return Activation(helpers.Linear{})
+}
+
+func (a activation) Outputs(inputs int) (int, error) {
+	return inputs, nil
+}
+
+func (a activation) Forward(s *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	r, n := inputs.Dims()
+	out := s.Matrix(0, r, n)
+	a.Apply(out, inputs)
+	return out, nil
+}
+
+func (a activation) Backward(s *Scratch, grad *mat.Dense, _ []*mat.Dense) (*mat.Dense, error) {
+	r, n := grad.Dims()
+	delta := s.Matrix(1, r, n)
+	a.Derivative(delta, s.Outputs())
+	return delta, helpers.MultiplyTo(delta, delta, grad)
+}
+
+func (a activation) Params() []*mat.Dense {
+	return nil
+}
+
+func (a activation) spec() string {
+	return a.Name()
+}
+
+// dropout is a layer dropping out each of its inputs at random while
+// training.
+type dropout struct {
+	rate float64
+}
+
+// Dropout returns a layer that drops out each of its inputs with the given
+// probability while training, and scales up the rest to make up for them.
+// Prediction passes every input through untouched.
+func Dropout(rate float64) Layer {
+	if rate < 0 || rate >= 1 {
+		panic(fmt.Sprintf("nn: dropout rate %g is not between 0 and 1", rate))
+	}
+	return &dropout{rate: rate}
+}
+
+func (d *dropout) Outputs(inputs int) (int, error) {
+	return inputs, nil
+}
+
+func (d *dropout) Forward(s *Scratch, inputs *mat.Dense, training bool) (*mat.Dense, error) {
+	s.Value = training && d.rate > 0
+	if !training || d.rate == 0 {
+		return inputs, nil
+	}
+	r, n := inputs.Dims()
+	mask := s.Matrix(0, r, n)
+	keep := 1 - d.rate
+	raw := mask.RawMatrix()
+	rng := s.Rand()
+	for i := 0; i < raw.Rows; i++ {
+		for j := range raw.Data[i*raw.Stride : i*raw.Stride+raw.Cols] {
+			// inverted dropout: scale up the inputs kept so that
+			// prediction needs no scaling
+			v := 0.0
+			if rng.Float64() < keep {
+				v = 1 / keep
+			}
+			raw.Data[i*raw.Stride+j] = v
+		}
+	}
+	dropped := s.Matrix(1, r, n)
+	return dropped, helpers.MultiplyTo(dropped, inputs, mask)
+}
+
+func (d *dropout) Backward(s *Scratch, grad *mat.Dense, _ []*mat.Dense) (*mat.Dense, error) {
+	if masked, _ := s.Value.(bool); !masked {
+		return grad, nil
+	}
+	// dropped inputs passed nothing on, so get no error back
+	r, n := grad.Dims()
+	errors := s.Matrix(2, r, n)
+	return errors, helpers.MultiplyTo(errors, grad, s.Matrix(0, r, n))
+}
+
+func (d *dropout) Params() []*mat.Dense {
+	return nil
+}
+
+func (d *dropout) clone() Layer {
+	return &dropout{rate: d.rate}
+}
+
+func (d *dropout) spec() string {
+	return "dropout:" + strconv.FormatFloat(d.rate, 'g', -1, 64)
+}
+
+// flatten is a layer passing its inputs through unchanged.
+type flatten struct{}
+
+// Flatten returns a layer turning images into a flat column of values for
+// the dense layers that follow, as after convolutional layers. Images are
+// always kept as a single column for each sample, so it has nothing to do,
+// but makes the architecture read more clearly.
+func Flatten() Layer {
+	return flatten{}
+}
+
+func (flatten) Outputs(inputs int) (int, error) {
+	return inputs, nil
+}
+
+func (flatten) Forward(_ *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	return inputs, nil
+}
+
+func (flatten) Backward(_ *Scratch, grad *mat.Dense, _ []*mat.Dense) (*mat.Dense, error) {
+	return grad, nil
+}
+
+func (flatten) Params() []*mat.Dense {
+	return nil
+}
+
+func (flatten) spec() string {
+	return "flatten"
+}
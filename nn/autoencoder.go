@@ -0,0 +1,94 @@
+package nn
+
+import (
+	"errors"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// code is a layer passing its inputs through unchanged, which marks where
+// the encoder of an autoencoder ends and its decoder begins.
+type code struct{}
+
+// Autoencoder returns the layers of a network trained to reproduce its
+// inputs, the encoder squeezing them down to a smaller code and the decoder
+// building them back up from it, such as
+//
+//	nn.Autoencoder(
+//		nn.NewSequential(nn.Dense(784, 32), nn.Sigmoid()),
+//		nn.NewSequential(nn.Dense(32, 784), nn.Sigmoid()),
+//	)
+//
+// The split between the two is kept by the network, and saved with it, so
+// that Encode can run the encoder alone.
+func Autoencoder(encoder, decoder Sequential) Sequential {
+	layers := append(NewSequential(encoder...), code{})
+	return append(layers, decoder...)
+}
+
+func (code) Outputs(inputs int) (int, error) {
+	return inputs, nil
+}
+
+func (code) Forward(_ *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	return inputs, nil
+}
+
+func (code) Backward(_ *Scratch, grad *mat.Dense, _ []*mat.Dense) (*mat.Dense, error) {
+	return grad, nil
+}
+
+func (code) Params() []*mat.Dense {
+	return nil
+}
+
+func (code) spec() string {
+	return "code"
+}
+
+// ErrNotAutoencoder is returned by Encode for a network that was not made
+// by Autoencoder.
+var ErrNotAutoencoder = errors.New("nn: network is not an autoencoder")
+
+// IsAutoencoder reports whether the network was made by Autoencoder.
+func (net Network) IsAutoencoder() bool {
+	return net.encoder() >= 0
+}
+
+// encoder returns the index of the layer ending the encoder, or -1 if there
+// is none.
+func (net Network) encoder() int {
+	for i, l := range net.layers {
+		if _, ok := l.(code); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// Encode runs the samples through the encoder of an autoencoder and returns
+// the code for each.
+func (net Network) Encode(inputData [][]float64) ([][]float64, error) {
+	n := net.encoder()
+	if n < 0 {
+		return nil, ErrNotAutoencoder
+	}
+	if len(inputData) == 0 {
+		return nil, nil
+	}
+	ws := net.workspaces.Get().(*workspace)
+	defer net.workspaces.Put(ws)
+	inputs, err := setColumns(&ws.inputs, inputData, "inputs", net.Inputs())
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := net.forwardTo(ws, inputs, n, false)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([][]float64, len(inputData))
+	for j := range codes {
+		codes[j] = mat.Col(nil, j, outputs)
+	}
+	return codes, nil
+}
@@ -0,0 +1,201 @@
+package nn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kheob/ml/helpers"
+)
+
+// ExportC writes the network to path as a C header, with names starting
+// with prefix, as WriteC does.
+func (net Network) ExportC(path, prefix string) error {
+	return writeFile(path, func(w io.Writer) error {
+		return net.WriteC(w, prefix)
+	})
+}
+
+// WriteC writes the network to w as a self-contained C header, with the
+// weights as static float arrays and a reference prefix_predict function
+// running the network, for microcontrollers and the like that cannot run
+// Go. It needs nothing but a C89 compiler and math.h, which has only the
+// double versions of exp and tanh, and no memory beyond two static buffers
+// the size of the widest layer. Only networks that can
+// be exported to ONNX can be written as C.
+func (net Network) WriteC(w io.Writer, prefix string) error {
+	if !cIdentifier(prefix) {
+		return fmt.Errorf("nn: %q is not a C identifier", prefix)
+	}
+	layers, err := net.exportLayers("C")
+	if err != nil {
+		return err
+	}
+	upper := strings.ToUpper(prefix)
+	var b, arrays, steps bytes.Buffer
+	used := map[string]bool{}
+	// the output of each dense layer goes in whichever buffer its input
+	// is not in, and activations work in place
+	x, widest, width := "inputs", 0, net.Inputs()
+	for i, l := range layers {
+		var call string
+		switch a := l.activation.(type) {
+		case nil:
+			rows := l.dense.rows()
+			fmt.Fprintf(&arrays, "static const float %s_weights%d[%d][%d] = {\n", prefix, i, len(rows), width)
+			for _, row := range rows {
+				fmt.Fprintf(&arrays, "\t{%s},\n", cFloats(row))
+			}
+			fmt.Fprintf(&arrays, "};\nstatic const float %s_biases%d[%d] = {%s};\n\n", prefix, i, len(rows), cFloats(l.dense.biasValues()))
+			y := "a"
+			if x == "a" {
+				y = "b"
+			}
+			call = fmt.Sprintf("dense(&%s_weights%d[0][0], %s_biases%d, %d, %d, %s, %s)", prefix, i, prefix, i, len(rows), width, x, y)
+			x, width = y, len(rows)
+			if width > widest {
+				widest = width
+			}
+		case helpers.ReLU:
+			call = fmt.Sprintf("leaky_relu(%s, %d, 0.0f)", x, width)
+		case helpers.LeakyReLU:
+			call = fmt.Sprintf("leaky_relu(%s, %d, %s)", x, width, cFloat(leakyAlpha(a)))
+		case helpers.Sigmoid:
+			call = fmt.Sprintf("sigmoid(%s, %d)", x, width)
+		case helpers.Tanh:
+			call = fmt.Sprintf("tanh(%s, %d)", x, width)
+		case helpers.Softmax:
+			call = fmt.Sprintf("softmax(%s, %d)", x, width)
+		}
+		name := call[:strings.Index(call, "(")]
+		if x == "inputs" && name != "dense" {
+			// the network starts with an activation, which cannot work
+			// on the inputs in place
+			fmt.Fprintf(&steps, "\tfor (i = 0; i < %s_INPUTS; i++)\n\t\ta[i] = inputs[i];\n", upper)
+			x, call = "a", strings.Replace(call, "(inputs,", "(a,", 1)
+			if width > widest {
+				widest = width
+			}
+		}
+		fmt.Fprintf(&steps, "\t%s_%s;\n", prefix, call)
+		used[name] = true
+	}
+
+	fmt.Fprintf(&b, "/* Code generated by ml export; DO NOT EDIT. */\n\n")
+	fmt.Fprintf(&b, "#ifndef %s_H\n#define %s_H\n\n", upper, upper)
+	if used["sigmoid"] || used["tanh"] || used["softmax"] {
+		fmt.Fprintf(&b, "#include <math.h>\n\n")
+	}
+	fmt.Fprintf(&b, "#define %s_INPUTS %d\n#define %s_OUTPUTS %d\n\n", upper, net.Inputs(), upper, net.Outputs())
+	b.Write(arrays.Bytes())
+	for _, name := range []string{"dense", "leaky_relu", "sigmoid", "tanh", "softmax"} {
+		if used[name] {
+			b.WriteString(strings.ReplaceAll(cHelpers[name], "PREFIX", prefix))
+		}
+	}
+	fmt.Fprintf(&b, "/* %s_predict sets outputs, which hold %s_OUTPUTS values, to the outputs\n", prefix, upper)
+	fmt.Fprintf(&b, " * of the network for inputs, which hold %s_INPUTS values. It is not\n * reentrant. */\n", upper)
+	fmt.Fprintf(&b, "static void %s_predict(const float *inputs, float *outputs)\n{\n", prefix)
+	fmt.Fprintf(&b, "\tstatic float a[%d], b[%d];\n\tint i;\n\n", widest, widest)
+	b.Write(steps.Bytes())
+	fmt.Fprintf(&b, "\tfor (i = 0; i < %s_OUTPUTS; i++)\n\t\toutputs[i] = %s[i];\n}\n\n", upper, x)
+	fmt.Fprintf(&b, "#endif /* %s_H */\n", upper)
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// cHelpers holds the functions the predict function of a generated C
+// header calls, with PREFIX standing for the prefix of their names.
+var cHelpers = map[string]string{
+	"dense": `/* PREFIX_dense sets out to the rows x cols weights times in plus the biases. */
+static void PREFIX_dense(const float *weights, const float *biases, int rows, int cols, const float *in, float *out)
+{
+	int i, j;
+
+	for (i = 0; i < rows; i++) {
+		float sum = biases[i];
+		for (j = 0; j < cols; j++)
+			sum += weights[i * cols + j] * in[j];
+		out[i] = sum;
+	}
+}
+
+`,
+	"leaky_relu": `/* PREFIX_leaky_relu scales the negative values of x by alpha. */
+static void PREFIX_leaky_relu(float *x, int n, float alpha)
+{
+	int i;
+
+	for (i = 0; i < n; i++)
+		if (x[i] < 0.0f)
+			x[i] *= alpha;
+}
+
+`,
+	"sigmoid": `static void PREFIX_sigmoid(float *x, int n)
+{
+	int i;
+
+	for (i = 0; i < n; i++)
+		x[i] = (float)(1.0 / (1.0 + exp(-x[i])));
+}
+
+`,
+	"tanh": `static void PREFIX_tanh(float *x, int n)
+{
+	int i;
+
+	for (i = 0; i < n; i++)
+		x[i] = (float)tanh(x[i]);
+}
+
+`,
+	"softmax": `static void PREFIX_softmax(float *x, int n)
+{
+	float max = x[0], sum = 0.0f;
+	int i;
+
+	for (i = 1; i < n; i++)
+		if (x[i] > max)
+			max = x[i];
+	for (i = 0; i < n; i++) {
+		x[i] = (float)exp(x[i] - max);
+		sum += x[i];
+	}
+	for (i = 0; i < n; i++)
+		x[i] /= sum;
+}
+
+`,
+}
+
+// cFloats returns values as the elements of a C array initializer.
+func cFloats(values []float64) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = cFloat(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// cFloat returns v rounded to a float as a C literal.
+func cFloat(v float64) string {
+	s := strconv.FormatFloat(float64(float32(v)), 'g', -1, 32)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s + "f"
+}
+
+// cIdentifier reports whether s can be used as a C identifier.
+func cIdentifier(s string) bool {
+	for i, r := range s {
+		letter := r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
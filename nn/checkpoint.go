@@ -0,0 +1,169 @@
+package nn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// A checkpoint file is a model file followed by the state needed to carry
+// on training where it left off, so it can be used anywhere a model file
+// can:
+//
+//	model       a model file as written by SaveTo
+//	magic       [4]byte  "MLCK"
+//	epoch       uint32
+//	samples     uint32   samples of the epoch already trained on
+//	optimizer   string   Go type of the optimizer
+//	steps       uint64   steps taken by the optimizer
+//	states      uint32   number of optimizer state matrices
+//	optimizer state matrices
+const checkpointMagic = "MLCK"
+
+// Checkpoint records how far through training a network is.
+type Checkpoint struct {
+	// Epoch is the epoch in progress, counting from zero, and Samples the
+	// number of samples of it that have already been trained on.
+	Epoch   int
+	Samples int
+}
+
+// SaveCheckpoint writes the network and the state of its optimizer to the
+// checkpoint file at path. As with Save, a crash part way through never
+// leaves a broken checkpoint behind.
+func (net Network) SaveCheckpoint(path string, cp Checkpoint) error {
+	return writeFile(path, func(w io.Writer) error {
+		return net.WriteCheckpoint(w, cp)
+	})
+}
+
+// WriteCheckpoint writes the network and the state of its optimizer in the
+// checkpoint file format to w.
+func (net Network) WriteCheckpoint(w io.Writer, cp Checkpoint) error {
+	if err := net.SaveTo(w); err != nil {
+		return err
+	}
+
+	var steps int
+	var state []*mat.Dense
+	if s, ok := net.optimizer.(stateful); ok {
+		steps, state = s.state()
+	}
+	if _, err := io.WriteString(w, checkpointMagic); err != nil {
+		return err
+	}
+	for _, v := range []interface{}{uint32(cp.Epoch), uint32(cp.Samples)} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if err := writeString(w, fmt.Sprintf("%T", net.optimizer)); err != nil {
+		return err
+	}
+	for _, v := range []interface{}{uint64(steps), uint32(len(state))} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	for _, m := range state {
+		if _, err := m.MarshalBinaryTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadCheckpoint reads the checkpoint file at path into a new network, as
+// for LoadNetwork, and restores the state of its optimizer. The optimizer
+// must be set with a WithOptimizer option to the same kind that wrote the
+// checkpoint.
+func LoadCheckpoint(path string, opts ...Option) (Network, Checkpoint, error) {
+	f, r, err := openModel(path)
+	if err != nil {
+		return Network{}, Checkpoint{}, err
+	}
+	defer f.Close()
+	return ReadCheckpoint(r, opts...)
+}
+
+// ReadCheckpoint reads a network and its optimizer state in the checkpoint
+// file format from r.
+func ReadCheckpoint(r io.Reader, opts ...Option) (Network, Checkpoint, error) {
+	var cp Checkpoint
+	net, h, err := readNetwork(r, opts)
+	if err != nil {
+		return Network{}, cp, err
+	}
+
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != checkpointMagic {
+		return Network{}, cp, fmt.Errorf("nn: model has no checkpoint state")
+	}
+	var epoch, samples uint32
+	if err := binary.Read(r, binary.LittleEndian, &epoch); err != nil {
+		return Network{}, cp, ErrBadModel
+	}
+	if err := binary.Read(r, binary.LittleEndian, &samples); err != nil {
+		return Network{}, cp, ErrBadModel
+	}
+	cp.Epoch, cp.Samples = int(epoch), int(samples)
+
+	name, err := readString(r)
+	if err != nil {
+		return Network{}, cp, ErrBadModel
+	}
+	if want := fmt.Sprintf("%T", net.optimizer); name != want {
+		return Network{}, cp, fmt.Errorf("nn: checkpoint was saved with optimizer %s, network uses %s", name, want)
+	}
+	var steps uint64
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &steps); err != nil {
+		return Network{}, cp, ErrBadModel
+	}
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil || n > 1<<12 {
+		return Network{}, cp, ErrBadModel
+	}
+
+	params, _ := net.trainable(nil)
+	state := make([]*mat.Dense, n)
+	for i := range state {
+		state[i] = &mat.Dense{}
+		if _, err := state[i].UnmarshalBinaryFrom(r); err != nil {
+			return Network{}, cp, fmt.Errorf("nn: reading optimizer state: %w", err)
+		}
+	}
+	if h.version < 6 && len(state)%len(params) == 0 {
+		state = layerOrder(state, len(params))
+	}
+	for i, m := range state {
+		sr, sc := m.Dims()
+		pr, pc := params[i%len(params)].Dims()
+		if sr != pr || sc != pc {
+			return Network{}, cp, fmt.Errorf("nn: optimizer state %d has the wrong shape", i)
+		}
+	}
+	if s, ok := net.optimizer.(stateful); ok {
+		if err := s.setState(int(steps), state); err != nil {
+			return Network{}, cp, err
+		}
+	} else if n > 0 {
+		return Network{}, cp, fmt.Errorf("nn: optimizer %s keeps no state, but the checkpoint has some", name)
+	}
+	return net, cp, nil
+}
+
+// layerOrder reorders optimizer state saved before version 6, which kept the
+// state of the weights of every layer before that of the biases, to go
+// layer by layer like the parameters of the network. The state is in runs
+// of one matrix for each of the params.
+func layerOrder(state []*mat.Dense, params int) []*mat.Dense {
+	layers := params / 2
+	ordered := make([]*mat.Dense, len(state))
+	for i, m := range state {
+		run, j := i/params*params, i%params
+		ordered[run+j%layers*2+j/layers] = m
+	}
+	return ordered
+}
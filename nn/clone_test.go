@@ -0,0 +1,117 @@
+package nn
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// samples returns n random samples with the given numbers of inputs and
+// one-hot targets.
+func samples(rng *rand.Rand, n, inputs, outputs int) (inputData, targetData [][]float64) {
+	for i := 0; i < n; i++ {
+		in := make([]float64, inputs)
+		for j := range in {
+			in[j] = rng.Float64()
+		}
+		target := make([]float64, outputs)
+		target[rng.Intn(outputs)] = 1
+		inputData, targetData = append(inputData, in), append(targetData, target)
+	}
+	return inputData, targetData
+}
+
+func TestCloneKeepsDropoutAndFrozenLayers(t *testing.T) {
+	opts := []Option{WithLayers(4, 8, 8, 3), WithDropout(0.5), WithFrozen(0), WithOptimizer(&Adam{}), WithSeed(1)}
+	net := New(opts...)
+	twin := New(opts...)
+	clone, err := net.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := net.rng.Int63(), twin.rng.Int63(); got != want {
+		t.Errorf("Clone drew on the random numbers of the original")
+	}
+
+	layers := clone.Layers()
+	if len(layers) != len(net.layers) {
+		t.Fatalf("clone has %d layers, want %d", len(layers), len(net.layers))
+	}
+	for i, l := range layers {
+		if got, want := layerName(l), layerName(net.layers[i]); got != want {
+			t.Errorf("layer %d of the clone is %s, want %s", i+1, got, want)
+		}
+		if clone.Frozen(i) != net.Frozen(i) {
+			t.Errorf("layer %d of the clone is frozen %v, want %v", i+1, clone.Frozen(i), net.Frozen(i))
+		}
+	}
+
+	before := copyAll(net.params())
+	inputs, targets := samples(rand.New(rand.NewSource(2)), 16, 4, 3)
+	if _, err := clone.TrainBatch(inputs, targets); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range net.params() {
+		if !mat.Equal(p, before[i]) {
+			t.Errorf("training the clone changed parameter %d of the original", i)
+		}
+	}
+	for i, l := range clone.layers {
+		changed := false
+		for j, p := range l.Params() {
+			changed = changed || !mat.Equal(p, net.layers[i].Params()[j])
+		}
+		if frozen := clone.Frozen(i); frozen && changed {
+			t.Errorf("training the clone changed frozen layer %d", i+1)
+		} else if !frozen && len(l.Params()) > 0 && !changed {
+			t.Errorf("training the clone left layer %d as it was", i+1)
+		}
+	}
+}
+
+// TestConcurrentPredict predicts with a network from many goroutines while
+// clones of it are trained, for go test -race to check.
+func TestConcurrentPredict(t *testing.T) {
+	net := New(WithLayers(6, 16, 4), WithDropout(0.2), WithWorkers(3), WithSeed(3))
+	inputs, targets := samples(rand.New(rand.NewSource(4)), 24, 6, 4)
+	want := net.PredictBatch(inputs)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for g := 0; g < 4; g++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for i, in := range inputs {
+				if got := mat.Col(nil, 0, net.Predict(in)); !equal(got, want[i]) {
+					t.Errorf("Predict of sample %d = %v, want %v", i, got, want[i])
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i, got := range net.PredictBatch(inputs) {
+				if !equal(got, want[i]) {
+					t.Errorf("PredictBatch of sample %d = %v, want %v", i, got, want[i])
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			clone, err := net.Clone()
+			if err == nil {
+				_, err = clone.TrainBatch(inputs, targets)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Shape is the shape of the images a convolutional layer takes or produces.
+// An image is kept as a column of Channels x Height x Width values, channel
+// by channel and row by row within each channel.
+type Shape struct {
+	Channels, Height, Width int
+}
+
+// Size returns the number of values in an image of the shape.
+func (s Shape) Size() int {
+	return s.Channels * s.Height * s.Width
+}
+
+func (s Shape) String() string {
+	return fmt.Sprintf("%dx%dx%d", s.Channels, s.Height, s.Width)
+}
+
+// Conv2D describes a convolutional layer, which slides Filters square
+// kernels Kernel values across over every channel of its input image,
+// Stride values at a time, after padding the image with Padding zeros on
+// every side. Each filter produces one channel of the output. Pool is only
+// used by WithConv2D, to pool the output after the activation function.
+// Stride defaults to 1 when left as zero.
+type Conv2D struct {
+	Filters, Kernel, Stride, Padding int
+	Pool                             Pool2D
+}
+
+func (c Conv2D) stride() int {
+	if c.Stride == 0 {
+		return 1
+	}
+	return c.Stride
+}
+
+// Output returns the shape of the image the layer produces from an input
+// image of the given shape, after any pooling.
+func (c Conv2D) Output(in Shape) Shape {
+	return c.Pool.output(c.convolved(in))
+}
+
+// convolved returns the shape of the image the filters produce from an
+// input image of the given shape, before any pooling.
+func (c Conv2D) convolved(in Shape) Shape {
+	s := c.stride()
+	return Shape{
+		Channels: c.Filters,
+		Height:   fits(in.Height+2*c.Padding, c.Kernel, s),
+		Width:    fits(in.Width+2*c.Padding, c.Kernel, s),
+	}
+}
+
+// fits returns the number of times a kernel fits across size values,
+// stride values apart.
+func fits(size, kernel, stride int) int {
+	if size < kernel || stride <= 0 {
+		return 0
+	}
+	return (size-kernel)/stride + 1
+}
+
+// valid reports whether the settings make sense, once the defaults are
+// filled in.
+func (c Conv2D) valid() bool {
+	return c.Filters > 0 && c.Kernel > 0 && c.Stride >= 0 && c.Padding >= 0
+}
+
+// parseShape parses a shape written as by its String method.
+func parseShape(s string) (Shape, error) {
+	var shape Shape
+	if _, err := fmt.Sscanf(s, "%dx%dx%d", &shape.Channels, &shape.Height, &shape.Width); err != nil || shape.Size() <= 0 || shape.String() != s {
+		return Shape{}, fmt.Errorf("nn: invalid shape %q", s)
+	}
+	return shape, nil
+}
+
+// WithConv2D makes the first layers of a network made by CreateNetwork
+// convolutional, one for each of layers, taking input images of the given
+// shape. The sizes given to CreateNetwork must start with the number of
+// values in an input image followed by the number of outputs of each
+// convolutional layer, as returned by ConvSizes. Each is followed by its
+// activation and then any pooling, and the outputs of the last are
+// flattened for the fully connected layers that follow.
+func WithConv2D(input Shape, layers ...Conv2D) Option {
+	return func(net *Network) {
+		net.convInput = input
+		net.convs = append([]Conv2D(nil), layers...)
+	}
+}
+
+// ConvSizes returns the layer sizes of a network whose first layers are the
+// given convolutional layers taking images of the given shape, for the
+// start of the sizes given to CreateNetwork.
+func ConvSizes(input Shape, layers ...Conv2D) []int {
+	sizes := []int{input.Size()}
+	for _, c := range layers {
+		input = c.Output(input)
+		sizes = append(sizes, input.Size())
+	}
+	return sizes
+}
+
+// checkConv panics if convolutional layer number layer of a network made by
+// CreateNetwork, with its defaults filled in, does not fit the layer sizes
+// either side of it. in is the shape of its input images.
+func checkConv(layer int, in Shape, c Conv2D, inputs, outputs int) {
+	if !c.valid() || c.Stride <= 0 {
+		panic(fmt.Sprintf("nn: convolutional layer %d has filters %d, kernel %d, stride %d and padding %d", layer, c.Filters, c.Kernel, c.Stride, c.Padding))
+	}
+	if c.Pool.Type != NoPooling && (c.Pool.Kernel <= 0 || c.Pool.Stride <= 0) {
+		panic(fmt.Sprintf("nn: convolutional layer %d has pooling kernel %d and stride %d", layer, c.Pool.Kernel, c.Pool.Stride))
+	}
+	conv, out := c.convolved(in), c.Output(in)
+	if in.Channels <= 0 || conv.Height <= 0 || conv.Width <= 0 || out.Height <= 0 || out.Width <= 0 {
+		panic(fmt.Sprintf("nn: convolutional layer %d does not fit its %s input", layer, in))
+	}
+	if inputs != in.Size() || outputs != out.Size() {
+		panic(fmt.Sprintf("nn: convolutional layer %d takes %s images to %s, so needs sizes %d and %d, not %d and %d",
+			layer, in, out, in.Size(), out.Size(), inputs, outputs))
+	}
+}
+
+// conv is a convolutional layer along with the shapes of its input and
+// output images. Its weights have a row for each filter and a column for
+// each channel and kernel position, and its biases a row for each filter.
+type conv struct {
+	Conv2D
+	in, out Shape
+	w       weightMatrix
+	biases  *mat.Dense
+}
+
+// Conv returns a convolutional layer taking images of the given shape.
+// Pooling goes in a layer of its own after the activation, so c.Pool must
+// not be set.
+func Conv(input Shape, c Conv2D) Layer {
+	if c.Pool.Type != NoPooling {
+		panic("nn: pooling goes in a Pool layer after the activation")
+	}
+	if !c.valid() {
+		panic(fmt.Sprintf("nn: convolutional layer with filters %d, kernel %d, stride %d and padding %d", c.Filters, c.Kernel, c.Stride, c.Padding))
+	}
+	c.Stride = c.stride()
+	out := c.convolved(input)
+	if input.Channels <= 0 || out.Height <= 0 || out.Width <= 0 {
+		panic(fmt.Sprintf("nn: convolutional layer does not fit its %s input", input))
+	}
+	return &conv{
+		Conv2D: c,
+		in:     input,
+		out:    out,
+		w:      weightMatrix{Dense: mat.NewDense(c.Filters, input.Channels*c.Kernel*c.Kernel, nil)},
+		biases: mat.NewDense(c.Filters, 1, nil),
+	}
+}
+
+func (c *conv) Outputs(inputs int) (int, error) {
+	if inputs != c.in.Size() {
+		return 0, fmt.Errorf("convolutional layer takes %s images of %d values, not %d", c.in, c.in.Size(), inputs)
+	}
+	return c.out.Size(), nil
+}
+
+func (c *conv) Forward(s *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	r, n := inputs.Dims()
+	if r != c.in.Size() {
+		return nil, fmt.Errorf("%w: %d inputs for %s images", helpers.ErrShape, r, c.in)
+	}
+	cols := s.Matrix(0, c.patch(), n*c.positions())
+	c.im2col(cols, inputs)
+	filtered := s.Matrix(1, c.Filters, n*c.positions())
+	if err := c.w.product(s, filtered, false, cols); err != nil {
+		return nil, err
+	}
+	if err := helpers.AddColumnTo(filtered, filtered, c.biases); err != nil {
+		return nil, err
+	}
+	z := s.Matrix(2, c.out.Size(), n)
+	c.perSample(z, filtered)
+	return z, nil
+}
+
+func (c *conv) Backward(s *Scratch, grad *mat.Dense, grads []*mat.Dense) (*mat.Dense, error) {
+	_, n := grad.Dims()
+	weightGrad, biasGrad := grads[0], grads[1]
+	filtered := s.Matrix(1, c.Filters, n*c.positions())
+	c.perFilter(filtered, grad)
+	if err := outerProduct(s, c.w.precision, weightGrad, filtered, s.Matrix(0, c.patch(), n*c.positions())); err != nil {
+		return nil, err
+	}
+	if err := helpers.ScaleTo(weightGrad, 1/float64(n), weightGrad); err != nil {
+		return nil, err
+	}
+	// the bias of a filter is shared by every position, so its gradient
+	// sums over the positions and averages over the batch
+	ones := s.Matrix(3, n*c.positions(), 1)
+	for j := 0; j < n*c.positions(); j++ {
+		ones.Set(j, 0, 1/float64(n))
+	}
+	if err := helpers.DotTo(biasGrad, filtered, ones); err != nil {
+		return nil, err
+	}
+	if !s.InputGradient() {
+		return nil, nil
+	}
+	patches := s.Matrix(4, c.patch(), n*c.positions())
+	if err := c.w.product(s, patches, true, filtered); err != nil {
+		return nil, err
+	}
+	errors := s.Matrix(5, c.in.Size(), n)
+	c.col2im(errors, patches)
+	return errors, nil
+}
+
+func (c *conv) Params() []*mat.Dense {
+	return []*mat.Dense{c.w.Dense, c.biases}
+}
+
+func (c *conv) clone() Layer {
+	copied := *c
+	copied.w, copied.biases = c.w.clone(), mat.DenseCopyOf(c.biases)
+	return &copied
+}
+
+func (c *conv) init(i Initializer, rng *rand.Rand) {
+	i.Init(c.w.Dense, rng)
+}
+
+func (c *conv) weights() []int {
+	return []int{0}
+}
+
+func (c *conv) sync(p Precision) {
+	c.w.sync(p, c.biases)
+}
+
+func (c *conv) spec() string {
+	return convSpec(c.in, c.Conv2D)
+}
+
+// convSpec returns the spec of a convolutional layer taking images of the
+// given shape, which c must be valid for.
+func convSpec(input Shape, c Conv2D) string {
+	return fmt.Sprintf("conv2d:%s:%d:%d:%d:%d", input, c.Filters, c.Kernel, c.stride(), c.Padding)
+}
+
+func (c *conv) paramNames() []string {
+	return []string{"weights", "biases"}
+}
+
+func (c *conv) inputs() int {
+	return c.in.Size()
+}
+
+// patch returns the number of values under a kernel at one position.
+func (c *conv) patch() int {
+	return c.in.Channels * c.Kernel * c.Kernel
+}
+
+// positions returns the number of positions the kernels are applied at.
+func (c *conv) positions() int {
+	return c.out.Height * c.out.Width
+}
+
+// im2col sets dst to the input patch under the kernel at every position of
+// every sample in m, one column for each, so that applying every filter is
+// a single matrix product with the weights. The columns of a sample's
+// positions are together, row by row.
+func (c *conv) im2col(dst *mat.Dense, m *mat.Dense) {
+	in, d := m.RawMatrix(), dst.RawMatrix()
+	k, s := c.Kernel, c.stride()
+	positions := c.positions()
+	for j := 0; j < in.Cols; j++ {
+		for oy := 0; oy < c.out.Height; oy++ {
+			for ox := 0; ox < c.out.Width; ox++ {
+				col := j*positions + oy*c.out.Width + ox
+				row := 0
+				for ch := 0; ch < c.in.Channels; ch++ {
+					for ky := 0; ky < k; ky++ {
+						y := oy*s + ky - c.Padding
+						for kx := 0; kx < k; kx++ {
+							x := ox*s + kx - c.Padding
+							v := 0.0
+							if y >= 0 && y < c.in.Height && x >= 0 && x < c.in.Width {
+								v = in.Data[((ch*c.in.Height+y)*c.in.Width+x)*in.Stride+j]
+							}
+							d.Data[row*d.Stride+col] = v
+							row++
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// col2im is the reverse of im2col, setting dst to the sum for each input
+// value of the entries of m for the patches it appears in.
+func (c *conv) col2im(dst *mat.Dense, m *mat.Dense) {
+	dst.Zero()
+	out, src := dst.RawMatrix(), m.RawMatrix()
+	k, s := c.Kernel, c.stride()
+	positions := c.positions()
+	for j := 0; j < out.Cols; j++ {
+		for oy := 0; oy < c.out.Height; oy++ {
+			for ox := 0; ox < c.out.Width; ox++ {
+				col := j*positions + oy*c.out.Width + ox
+				row := 0
+				for ch := 0; ch < c.in.Channels; ch++ {
+					for ky := 0; ky < k; ky++ {
+						y := oy*s + ky - c.Padding
+						for kx := 0; kx < k; kx++ {
+							x := ox*s + kx - c.Padding
+							if y >= 0 && y < c.in.Height && x >= 0 && x < c.in.Width {
+								out.Data[((ch*c.in.Height+y)*c.in.Width+x)*out.Stride+j] += src.Data[row*src.Stride+col]
+							}
+							row++
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// perSample sets dst, with a column for each sample, to m, which has a row
+// for each filter and a column for each position of each sample.
+func (c *conv) perSample(dst, m *mat.Dense) {
+	d, src := dst.RawMatrix(), m.RawMatrix()
+	positions := c.positions()
+	for f := 0; f < c.Filters; f++ {
+		for j := 0; j < d.Cols; j++ {
+			for p, v := range src.Data[f*src.Stride+j*positions : f*src.Stride+(j+1)*positions] {
+				d.Data[(f*positions+p)*d.Stride+j] = v
+			}
+		}
+	}
+}
+
+// perFilter is the reverse of perSample.
+func (c *conv) perFilter(dst, m *mat.Dense) {
+	d, src := dst.RawMatrix(), m.RawMatrix()
+	positions := c.positions()
+	for f := 0; f < c.Filters; f++ {
+		for j := 0; j < src.Cols; j++ {
+			row := d.Data[f*d.Stride+j*positions : f*d.Stride+(j+1)*positions]
+			for p := range row {
+				row[p] = src.Data[(f*positions+p)*src.Stride+j]
+			}
+		}
+	}
+}
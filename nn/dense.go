@@ -0,0 +1,160 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/blas/blas32"
+	"gonum.org/v1/gonum/mat"
+)
+
+// dense is a fully connected layer, with a row of weights for each output
+// and a column for each input, and a bias for each output.
+type dense struct {
+	w      weightMatrix
+	biases *mat.Dense
+}
+
+// Dense returns a fully connected layer with the given number of inputs
+// and outputs. Its weights are set by the network it is added to.
+func Dense(inputs, outputs int) Layer {
+	return &dense{
+		w:      weightMatrix{Dense: mat.NewDense(outputs, inputs, nil)},
+		biases: mat.NewDense(outputs, 1, nil),
+	}
+}
+
+func (d *dense) Outputs(inputs int) (int, error) {
+	r, c := d.w.Dims()
+	if inputs != c {
+		return 0, fmt.Errorf("dense layer takes %d inputs, not %d", c, inputs)
+	}
+	return r, nil
+}
+
+func (d *dense) Forward(s *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	r, _ := d.w.Dims()
+	_, n := inputs.Dims()
+	z := s.Matrix(0, r, n)
+	if err := d.w.product(s, z, false, inputs); err != nil {
+		return nil, err
+	}
+	return z, helpers.AddColumnTo(z, z, d.biases)
+}
+
+func (d *dense) Backward(s *Scratch, grad *mat.Dense, grads []*mat.Dense) (*mat.Dense, error) {
+	_, c := d.w.Dims()
+	_, n := grad.Dims()
+	weightGrad, biasGrad := grads[0], grads[1]
+	if err := outerProduct(s, d.w.precision, weightGrad, grad, s.Inputs()); err != nil {
+		return nil, err
+	}
+	if err := helpers.ScaleTo(weightGrad, 1/float64(n), weightGrad); err != nil {
+		return nil, err
+	}
+	// multiplying by a column of 1/n averages the deltas over the batch
+	if err := helpers.DotTo(biasGrad, grad, s.ones(n)); err != nil {
+		return nil, err
+	}
+	if !s.InputGradient() {
+		return nil, nil
+	}
+	errors := s.Matrix(1, c, n)
+	return errors, d.w.product(s, errors, true, grad)
+}
+
+func (d *dense) Params() []*mat.Dense {
+	return []*mat.Dense{d.w.Dense, d.biases}
+}
+
+func (d *dense) clone() Layer {
+	return &dense{w: d.w.clone(), biases: mat.DenseCopyOf(d.biases)}
+}
+
+func (d *dense) init(i Initializer, rng *rand.Rand) {
+	i.Init(d.w.Dense, rng)
+}
+
+func (d *dense) weights() []int {
+	return []int{0}
+}
+
+func (d *dense) sync(p Precision) {
+	d.w.sync(p, d.biases)
+}
+
+func (d *dense) spec() string {
+	r, c := d.w.Dims()
+	return denseSpec(c, r)
+}
+
+// denseSpec returns the spec of a dense layer with the given numbers of
+// inputs and outputs.
+func denseSpec(inputs, outputs int) string {
+	return fmt.Sprintf("dense:%d:%d", inputs, outputs)
+}
+
+func (d *dense) paramNames() []string {
+	return []string{"weights", "biases"}
+}
+
+func (d *dense) inputs() int {
+	_, c := d.w.Dims()
+	return c
+}
+
+// weightMatrix is the weights of a layer, along with a float32 copy for
+// the matrix products of a float32 network.
+type weightMatrix struct {
+	*mat.Dense
+	precision Precision
+	w32       blas32.General
+}
+
+// sync rounds the weights, and the biases along with them, to precision p,
+// and copies the weights to the float32 matrix if it is Float32. It must
+// be called whenever the weights change.
+func (w *weightMatrix) sync(p Precision, biases *mat.Dense) {
+	w.precision = p
+	if p != Float32 {
+		w.w32 = blas32.General{}
+		return
+	}
+	raw := w.RawMatrix()
+	if len(w.w32.Data) != raw.Rows*raw.Cols {
+		w.w32 = blas32.General{Rows: raw.Rows, Cols: raw.Cols, Stride: raw.Cols, Data: make([]float32, raw.Rows*raw.Cols)}
+	}
+	for r := 0; r < raw.Rows; r++ {
+		row := raw.Data[r*raw.Stride : r*raw.Stride+raw.Cols]
+		for c, v := range row {
+			f := float32(v)
+			row[c] = float64(f)
+			w.w32.Data[r*w.w32.Stride+c] = f
+		}
+	}
+	b := biases.RawMatrix()
+	for r := 0; r < b.Rows; r++ {
+		for c := range b.Data[r*b.Stride : r*b.Stride+b.Cols] {
+			b.Data[r*b.Stride+c] = float64(float32(b.Data[r*b.Stride+c]))
+		}
+	}
+}
+
+// clone returns a copy of the weights. The float32 copy of them is made by
+// the next sync.
+func (w weightMatrix) clone() weightMatrix {
+	return weightMatrix{Dense: mat.DenseCopyOf(w.Dense)}
+}
+
+// product sets dst to the weights, transposed if trans is set, times m.
+func (w *weightMatrix) product(s *Scratch, dst *mat.Dense, trans bool, m mat.Matrix) error {
+	if w.precision != Float32 {
+		var wm mat.Matrix = w.Dense
+		if trans {
+			wm = wm.T()
+		}
+		return helpers.DotTo(dst, wm, m)
+	}
+	return s.ws.gemm32(dst, w.w32, trans, float32s(&s.ws.b32, m), false)
+}
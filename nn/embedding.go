@@ -0,0 +1,167 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// embedding is a layer looking up a learned vector for each of its
+// categorical inputs. Each has a matrix with a column for each category,
+// which is the same as a dense layer without biases taking the category
+// one-hot encoded.
+type embedding struct {
+	in, dims   int
+	embeddings []*mat.Dense
+}
+
+// Embedding returns a layer taking inputs values for each sample, the first
+// of which are the IDs of categorical features, one feature for each entry
+// of categories giving its number of categories. An ID is a whole number
+// from zero up to but not including the number of categories, and is
+// replaced by the dims values learned for that category. The numeric
+// inputs after the IDs are passed through unchanged, following the
+// embedded ones.
+func Embedding(inputs int, categories []int, dims int) Layer {
+	if len(categories) == 0 || len(categories) > inputs || dims <= 0 {
+		panic(fmt.Sprintf("nn: embedding of %d categorical features of %d inputs in %d values", len(categories), inputs, dims))
+	}
+	e := &embedding{in: inputs, dims: dims}
+	for _, n := range categories {
+		if n <= 0 {
+			panic(fmt.Sprintf("nn: embedding of a feature with %d categories", n))
+		}
+		e.embeddings = append(e.embeddings, mat.NewDense(dims, n, nil))
+	}
+	return e
+}
+
+func (e *embedding) Outputs(inputs int) (int, error) {
+	if inputs != e.in {
+		return 0, fmt.Errorf("embedding layer takes %d inputs, not %d", e.in, inputs)
+	}
+	return e.outputs(), nil
+}
+
+// outputs returns the number of outputs for each sample.
+func (e *embedding) outputs() int {
+	return len(e.embeddings)*e.dims + e.in - len(e.embeddings)
+}
+
+func (e *embedding) Forward(s *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	if rows, _ := inputs.Dims(); rows != e.in {
+		return nil, fmt.Errorf("%w: %d inputs for an embedding of %d", helpers.ErrShape, rows, e.in)
+	}
+	_, n := inputs.Dims()
+	out := s.Matrix(0, e.outputs(), n)
+	for f, m := range e.embeddings {
+		_, categories := m.Dims()
+		for j := 0; j < n; j++ {
+			id := inputs.At(f, j)
+			if id < 0 || id >= float64(categories) || id != math.Trunc(id) {
+				return nil, fmt.Errorf("categorical input %d of sample %d is %g, not an ID below %d", f+1, j+1, id, categories)
+			}
+			for k := 0; k < e.dims; k++ {
+				out.Set(f*e.dims+k, j, m.At(k, int(id)))
+			}
+		}
+	}
+	numeric := len(e.embeddings) * e.dims
+	for i := len(e.embeddings); i < e.in; i++ {
+		for j := 0; j < n; j++ {
+			out.Set(numeric+i-len(e.embeddings), j, inputs.At(i, j))
+		}
+	}
+	return out, nil
+}
+
+// Backward adds the gradient of each embedded value to the column of the
+// category it was looked up for. The IDs have no gradient, so the errors
+// for them are zero.
+func (e *embedding) Backward(s *Scratch, grad *mat.Dense, grads []*mat.Dense) (*mat.Dense, error) {
+	_, n := grad.Dims()
+	inputs := s.Inputs()
+	for f, g := range grads {
+		g.Zero()
+		for j := 0; j < n; j++ {
+			id := int(inputs.At(f, j))
+			for k := 0; k < e.dims; k++ {
+				g.Set(k, id, g.At(k, id)+grad.At(f*e.dims+k, j)/float64(n))
+			}
+		}
+	}
+	if !s.InputGradient() {
+		return nil, nil
+	}
+	errors := s.Matrix(1, e.in, n)
+	errors.Zero()
+	numeric := len(e.embeddings) * e.dims
+	for i := len(e.embeddings); i < e.in; i++ {
+		for j := 0; j < n; j++ {
+			errors.Set(i, j, grad.At(numeric+i-len(e.embeddings), j))
+		}
+	}
+	return errors, nil
+}
+
+func (e *embedding) Params() []*mat.Dense {
+	return append([]*mat.Dense(nil), e.embeddings...)
+}
+
+func (e *embedding) clone() Layer {
+	return &embedding{in: e.in, dims: e.dims, embeddings: copyAll(e.embeddings)}
+}
+
+func (e *embedding) init(i Initializer, rng *rand.Rand) {
+	for _, m := range e.embeddings {
+		i.Init(m, rng)
+	}
+}
+
+func (e *embedding) weights() []int {
+	indexes := make([]int, len(e.embeddings))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
+
+func (e *embedding) sync(p Precision) {
+	if p != Float32 {
+		return
+	}
+	for _, m := range e.embeddings {
+		raw := m.RawMatrix()
+		for r := 0; r < raw.Rows; r++ {
+			for c := range raw.Data[r*raw.Stride : r*raw.Stride+raw.Cols] {
+				raw.Data[r*raw.Stride+c] = float64(float32(raw.Data[r*raw.Stride+c]))
+			}
+		}
+	}
+}
+
+func (e *embedding) spec() string {
+	categories := make([]string, len(e.embeddings))
+	for i, m := range e.embeddings {
+		_, c := m.Dims()
+		categories[i] = strconv.Itoa(c)
+	}
+	return fmt.Sprintf("embedding:%d:%s:%d", e.in, strings.Join(categories, ","), e.dims)
+}
+
+func (e *embedding) paramNames() []string {
+	names := make([]string, len(e.embeddings))
+	for i := range names {
+		names[i] = fmt.Sprintf("embeddings of input %d", i+1)
+	}
+	return names
+}
+
+func (e *embedding) inputs() int {
+	return e.in
+}
@@ -0,0 +1,263 @@
+package nn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// An ensemble file bundles the model files of the networks of an ensemble:
+//
+//	magic       [4]byte  "MLEN"
+//	version     uint32
+//	combination string   "average" or "vote"
+//	networks    uint32   number of networks
+//	a model file as written by SaveTo for each network
+const (
+	ensembleMagic   = "MLEN"
+	ensembleVersion = 1
+)
+
+// Combination is how an ensemble combines the outputs of its networks.
+type Combination int
+
+const (
+	// Average takes the mean of the outputs of the networks.
+	Average Combination = iota
+	// Vote has each network vote for the class it predicts, and outputs
+	// the share of the votes each class got. With a single output, that is
+	// the share of votes for class 1.
+	Vote
+)
+
+// CombinationByName returns the combination called "average" or "vote".
+func CombinationByName(name string) (Combination, error) {
+	switch name {
+	case "average":
+		return Average, nil
+	case "vote":
+		return Vote, nil
+	}
+	return 0, fmt.Errorf("nn: unknown combination %q", name)
+}
+
+func (c Combination) String() string {
+	if c == Vote {
+		return "vote"
+	}
+	return "average"
+}
+
+// Ensemble predicts with several networks at once and combines their
+// outputs, which is usually more accurate than any one of them, for
+// example with networks trained from different seeds. It can be used much
+// like a Network that is not being trained.
+type Ensemble struct {
+	Networks    []Network
+	Combination Combination
+}
+
+// NewEnsemble returns an ensemble of the networks, which must all have the
+// same number of inputs and outputs.
+func NewEnsemble(nets []Network, c Combination) (Ensemble, error) {
+	if len(nets) == 0 {
+		return Ensemble{}, fmt.Errorf("nn: ensemble has no networks")
+	}
+	for i, net := range nets[1:] {
+		if net.Inputs() != nets[0].Inputs() || net.Outputs() != nets[0].Outputs() {
+			return Ensemble{}, fmt.Errorf("nn: ensemble network %d has %d inputs and %d outputs, network 1 has %d and %d",
+				i+2, net.Inputs(), net.Outputs(), nets[0].Inputs(), nets[0].Outputs())
+		}
+	}
+	return Ensemble{Networks: nets, Combination: c}, nil
+}
+
+// Inputs returns the number of inputs of the networks.
+func (e Ensemble) Inputs() int {
+	return e.Networks[0].Inputs()
+}
+
+// Outputs returns the number of outputs of the networks.
+func (e Ensemble) Outputs() int {
+	return e.Networks[0].Outputs()
+}
+
+// Classes returns the number of classes the networks tell apart.
+func (e Ensemble) Classes() int {
+	return e.Networks[0].Classes()
+}
+
+// Predict runs inputData through every network and returns their combined
+// outputs as a column vector. It panics as Network.Predict does.
+func (e Ensemble) Predict(inputData []float64) mat.Matrix {
+	outputs, err := e.PredictErr(inputData)
+	if err != nil {
+		panic(err)
+	}
+	return outputs
+}
+
+// PredictErr is Predict, returning an error rather than panicking if
+// inputData does not fit the networks.
+func (e Ensemble) PredictErr(inputData []float64) (mat.Matrix, error) {
+	outputs := make([][]float64, len(e.Networks))
+	for i, net := range e.Networks {
+		o, err := net.PredictErr(inputData)
+		if err != nil {
+			return nil, err
+		}
+		outputs[i] = mat.Col(nil, 0, o)
+	}
+	return mat.NewVecDense(e.Outputs(), e.combine(outputs)), nil
+}
+
+// PredictBatch runs many samples through every network, a batch at a time,
+// and returns the combined outputs for each sample. It panics as
+// Network.PredictBatch does.
+func (e Ensemble) PredictBatch(inputData [][]float64) [][]float64 {
+	results, err := e.PredictBatchErr(inputData)
+	if err != nil {
+		panic(err)
+	}
+	return results
+}
+
+// PredictBatchErr is PredictBatch, returning an error rather than panicking
+// if any sample does not fit the networks.
+func (e Ensemble) PredictBatchErr(inputData [][]float64) ([][]float64, error) {
+	if len(inputData) == 0 {
+		return nil, nil
+	}
+	batches := make([][][]float64, len(e.Networks))
+	for i, net := range e.Networks {
+		var err error
+		if batches[i], err = net.PredictBatchErr(inputData); err != nil {
+			return nil, err
+		}
+	}
+	results := make([][]float64, len(inputData))
+	outputs := make([][]float64, len(e.Networks))
+	for j := range results {
+		for i := range outputs {
+			outputs[i] = batches[i][j]
+		}
+		results[j] = e.combine(outputs)
+	}
+	return results, nil
+}
+
+// Classify returns the class the ensemble predicts for inputData.
+func (e Ensemble) Classify(inputData []float64) int {
+	return e.ClassOf(mat.Col(nil, 0, e.Predict(inputData)))
+}
+
+// ClassOf returns the class predicted by a set of combined outputs, as for
+// a Network. Ties go to the lowest class.
+func (e Ensemble) ClassOf(outputs []float64) int {
+	return e.Networks[0].ClassOf(outputs)
+}
+
+// OutputLoss returns the loss of the first network for the combined
+// outputs of a batch of samples against their targets.
+func (e Ensemble) OutputLoss(outputs [][]float64, targetData [][]float64) float64 {
+	return e.Networks[0].OutputLoss(outputs, targetData)
+}
+
+// combine returns the combination of the outputs of each network for one
+// sample.
+func (e Ensemble) combine(outputs [][]float64) []float64 {
+	combined := make([]float64, len(outputs[0]))
+	for i, o := range outputs {
+		if e.Combination == Vote {
+			class := e.Networks[i].ClassOf(o)
+			if len(combined) == 1 {
+				combined[0] += float64(class)
+			} else {
+				combined[class]++
+			}
+			continue
+		}
+		for j, v := range o {
+			combined[j] += v
+		}
+	}
+	for j := range combined {
+		combined[j] /= float64(len(outputs))
+	}
+	return combined
+}
+
+// Save writes the ensemble to the ensemble file at path, as safely as
+// Network.Save writes a model file.
+func (e Ensemble) Save(path string) error {
+	return writeFile(path, e.SaveTo)
+}
+
+// SaveTo writes the ensemble in the ensemble file format to w.
+func (e Ensemble) SaveTo(w io.Writer) error {
+	if _, err := io.WriteString(w, ensembleMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(ensembleVersion)); err != nil {
+		return err
+	}
+	if err := writeString(w, e.Combination.String()); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(e.Networks))); err != nil {
+		return err
+	}
+	for _, net := range e.Networks {
+		if err := net.SaveTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadEnsemble reads the ensemble file at path. Options are applied to each
+// network as for CreateNetwork. It returns ErrBadModel itself if the file
+// is not an ensemble file.
+func LoadEnsemble(path string, opts ...Option) (Ensemble, error) {
+	f, r, err := openModel(path)
+	if err != nil {
+		return Ensemble{}, err
+	}
+	defer f.Close()
+	return ReadEnsemble(r, opts...)
+}
+
+// ReadEnsemble reads an ensemble in the ensemble file format from r.
+func ReadEnsemble(r io.Reader, opts ...Option) (Ensemble, error) {
+	magic := make([]byte, len(ensembleMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != ensembleMagic {
+		return Ensemble{}, ErrBadModel
+	}
+	var version, n uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return Ensemble{}, ErrCorruptModel
+	}
+	if version != ensembleVersion {
+		return Ensemble{}, fmt.Errorf("nn: unsupported ensemble version %d", version)
+	}
+	name, err := readString(r)
+	if err != nil {
+		return Ensemble{}, ErrCorruptModel
+	}
+	c, err := CombinationByName(name)
+	if err != nil {
+		return Ensemble{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil || n == 0 || n > 1<<10 {
+		return Ensemble{}, ErrCorruptModel
+	}
+	nets := make([]Network, n)
+	for i := range nets {
+		if nets[i], err = ReadNetwork(r, opts...); err != nil {
+			return Ensemble{}, fmt.Errorf("nn: ensemble network %d: %w", i+1, err)
+		}
+	}
+	return NewEnsemble(nets, c)
+}
@@ -0,0 +1,68 @@
+package nn
+
+import (
+	"fmt"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// exportLayer is a step of a network as exported to another format: either
+// a dense layer or an activation.
+type exportLayer struct {
+	dense      *dense
+	activation helpers.Activation
+}
+
+// exportLayers returns the layers of the network that matter for
+// prediction, for exporting to the named format. Dropout and the like are
+// left out, as they only matter while training, and so are linear
+// activations. Other layers, such as convolutional ones, cannot be exported
+// yet.
+func (net Network) exportLayers(format string) ([]exportLayer, error) {
+	var layers []exportLayer
+	for _, l := range net.layers {
+		switch l := l.(type) {
+		case *dense:
+			layers = append(layers, exportLayer{dense: l})
+		case activation:
+			switch l.Activation.(type) {
+			case helpers.Linear:
+			case helpers.ReLU, helpers.LeakyReLU, helpers.Sigmoid, helpers.Tanh, helpers.Softmax:
+				layers = append(layers, exportLayer{activation: l.Activation})
+			default:
+				return nil, fmt.Errorf("nn: cannot export %s activation to %s", l.Name(), format)
+			}
+		case *dropout, flatten, code:
+		default:
+			return nil, fmt.Errorf("nn: cannot export %s layer to %s", layerKind(l), format)
+		}
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("nn: no layers to export to %s", format)
+	}
+	return layers, nil
+}
+
+// leakyAlpha returns the slope of a leaky ReLU for negative inputs.
+func leakyAlpha(a helpers.LeakyReLU) float64 {
+	if a.Alpha == 0 {
+		return 0.01
+	}
+	return a.Alpha
+}
+
+// rows returns the elements of the weights of d row by row.
+func (d *dense) rows() [][]float64 {
+	r, _ := d.w.Dims()
+	rows := make([][]float64, r)
+	for i := range rows {
+		rows[i] = d.w.RawRowView(i)
+	}
+	return rows
+}
+
+// biasValues returns the biases of d.
+func (d *dense) biasValues() []float64 {
+	return mat.Col(nil, 0, d.biases)
+}
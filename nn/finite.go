@@ -0,0 +1,68 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// NonFiniteError is returned by TrainBatch when a network created
+// WithFiniteCheck comes across a NaN or infinity, usually because the
+// learning rate is too high.
+type NonFiniteError struct {
+	// Layer counts from 1 for the first layer of the network, and is 0 for
+	// the loss.
+	Layer int
+	// What is "outputs", "loss", or a parameter such as "weights" or its
+	// gradients, such as "gradients of the weights".
+	What string
+	// Value is the first NaN or infinity found.
+	Value float64
+}
+
+func (e *NonFiniteError) Error() string {
+	if e.Layer == 0 {
+		return fmt.Sprintf("nn: %s is %g", e.What, e.Value)
+	}
+	return fmt.Sprintf("nn: %g in the %s of layer %d", e.Value, e.What, e.Layer)
+}
+
+// WithFiniteCheck scans the outputs of every layer, the loss and the
+// gradients of every mini-batch for NaNs and infinities, and the parameters
+// after every update, so that TrainBatch fails with a NonFiniteError rather
+// than carrying on with garbage. It costs a pass over each matrix.
+func WithFiniteCheck() Option {
+	return func(net *Network) {
+		net.finiteCheck = true
+	}
+}
+
+// checkFinite returns a NonFiniteError for the layer counting from 1 if m
+// holds a NaN or infinity.
+func checkFinite(m *mat.Dense, layer int, what string) error {
+	raw := m.RawMatrix()
+	for r := 0; r < raw.Rows; r++ {
+		for _, v := range raw.Data[r*raw.Stride : r*raw.Stride+raw.Cols] {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return &NonFiniteError{Layer: layer, What: what, Value: v}
+			}
+		}
+	}
+	return nil
+}
+
+// checkGradients checks the gradients of the parameters of every layer in
+// grads.
+func (net Network) checkGradients(grads []*mat.Dense) error {
+	k := 0
+	for i, l := range net.layers {
+		for j := range l.Params() {
+			if err := checkFinite(grads[k], i+1, "gradients of the "+paramName(l, j)); err != nil {
+				return err
+			}
+			k++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package nn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// Fit holds the settings of Network.Fit.
+type Fit struct {
+	// Epochs is the number of passes over the data, 1 if it is 0.
+	Epochs int
+	// BatchSize is the number of samples in each mini-batch, 32 if it is 0.
+	BatchSize int
+	// Rand shuffles the samples before every epoch if it is not nil.
+	Rand *rand.Rand
+	// Callbacks are told how training goes, in order, and may change the
+	// network between mini-batches.
+	Callbacks []Callback
+	// Start is where to carry on training from, as from the Checkpoint of
+	// an InterruptedError, with the same Rand seeded as before so the
+	// samples come in the same order.
+	Start Checkpoint
+}
+
+// Callback is told how training goes by Network.Fit, to log, save, change
+// or stop training without a loop of its own. Training ends with the first
+// error a method returns, or quietly if it is ErrStop.
+type Callback interface {
+	// OnTrainBegin is called before the first epoch.
+	OnTrainBegin(net *Network) error
+	// OnBatchEnd is called after training on each mini-batch.
+	OnBatchEnd(net *Network, b BatchResult) error
+	// OnEpochEnd is called after each pass over the data.
+	OnEpochEnd(net *Network, e EpochResult) error
+	// OnTrainEnd is called once training is over, unless it failed.
+	OnTrainEnd(net *Network) error
+}
+
+// ErrStop is returned by a Callback to end training early, as for early
+// stopping. Network.Fit still calls OnTrainEnd and returns nil.
+var ErrStop = errors.New("nn: stop training")
+
+// InterruptedError is returned by TrainContext when its context is done
+// before training is over. The network is left as far as it got, which
+// Checkpoint records, for SaveCheckpoint or to carry on from with
+// Fit.Start.
+type InterruptedError struct {
+	Checkpoint Checkpoint
+	// Err is the error of the context.
+	Err error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("nn: training interrupted in epoch %d after %d samples: %v", e.Checkpoint.Epoch+1, e.Checkpoint.Samples, e.Err)
+}
+
+func (e *InterruptedError) Unwrap() error { return e.Err }
+
+// BatchResult is how training on a mini-batch went.
+type BatchResult struct {
+	// Epoch and Batch count the epochs, and the mini-batches within the
+	// epoch, from 0.
+	Epoch, Batch int
+	// Size is the number of samples in the mini-batch, which is smaller
+	// than the batch size for the last of an epoch if it does not divide
+	// the data.
+	Size int
+	// Loss is the mean loss over the mini-batch.
+	Loss float64
+}
+
+// EpochResult is how an epoch of training went.
+type EpochResult struct {
+	// Epoch counts the epochs from 0.
+	Epoch int
+	// Loss is the mean training loss over the epoch.
+	Loss float64
+}
+
+// CallbackFuncs is a Callback made from functions, any of which may be nil.
+type CallbackFuncs struct {
+	TrainBegin func(net *Network) error
+	BatchEnd   func(net *Network, b BatchResult) error
+	EpochEnd   func(net *Network, e EpochResult) error
+	TrainEnd   func(net *Network) error
+}
+
+func (c CallbackFuncs) OnTrainBegin(net *Network) error {
+	if c.TrainBegin == nil {
+		return nil
+	}
+	return c.TrainBegin(net)
+}
+
+func (c CallbackFuncs) OnBatchEnd(net *Network, b BatchResult) error {
+	if c.BatchEnd == nil {
+		return nil
+	}
+	return c.BatchEnd(net, b)
+}
+
+func (c CallbackFuncs) OnEpochEnd(net *Network, e EpochResult) error {
+	if c.EpochEnd == nil {
+		return nil
+	}
+	return c.EpochEnd(net, e)
+}
+
+func (c CallbackFuncs) OnTrainEnd(net *Network) error {
+	if c.TrainEnd == nil {
+		return nil
+	}
+	return c.TrainEnd(net)
+}
+
+// Fit trains the network on the data in mini-batches for a number of
+// epochs, telling the callbacks of o how it goes. It sets the epoch of the
+// network before each, for any learning rate schedule. For data that does
+// not fit in memory, call TrainBatch in a loop of your own.
+func (net *Network) Fit(inputData, targetData [][]float64, o Fit) error {
+	return net.TrainContext(context.Background(), inputData, targetData, o)
+}
+
+// TrainContext is Fit stopping between mini-batches once ctx is done, to
+// cancel training or limit how long it takes, with an *InterruptedError.
+// OnTrainEnd is not called then.
+func (net *Network) TrainContext(ctx context.Context, inputData, targetData [][]float64, o Fit) error {
+	if len(inputData) == 0 || len(inputData) != len(targetData) {
+		return errors.New("nn: Fit needs as many targets as inputs")
+	}
+	err := net.fit(ctx, inputData, targetData, o)
+	if err != nil && err != ErrStop {
+		return err
+	}
+	for _, c := range o.Callbacks {
+		if err := c.OnTrainEnd(net); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fit runs the epochs of TrainContext, up to the first error from
+// training or a callback, or until ctx is done.
+func (net *Network) fit(ctx context.Context, inputData, targetData [][]float64, o Fit) error {
+	epochs, size := o.Epochs, o.BatchSize
+	if epochs == 0 {
+		epochs = 1
+	}
+	if size == 0 {
+		size = 32
+	}
+	for _, c := range o.Callbacks {
+		if err := c.OnTrainBegin(net); err != nil {
+			return err
+		}
+	}
+	order := make([]int, len(inputData))
+	for i := range order {
+		order[i] = i
+	}
+	if o.Start.Epoch > 0 || o.Start.Samples > 0 {
+		// carry on any warm-up from the mini-batches already trained on
+		perEpoch := (len(order) + size - 1) / size
+		net.SetStep(o.Start.Epoch*perEpoch + (o.Start.Samples+size-1)/size)
+	}
+	inputs, targets := make([][]float64, 0, size), make([][]float64, 0, size)
+	for epoch := 0; epoch < epochs; epoch++ {
+		if o.Rand != nil {
+			o.Rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		}
+		if epoch < o.Start.Epoch {
+			// shuffled all the same, to keep the order of later epochs
+			continue
+		}
+		net.SetEpoch(epoch)
+		total, first := 0.0, 0
+		if epoch == o.Start.Epoch {
+			first = o.Start.Samples
+		}
+		for batch, start := first/size, first; start < len(order); batch, start = batch+1, start+size {
+			if err := ctx.Err(); err != nil {
+				return &InterruptedError{Checkpoint: Checkpoint{Epoch: epoch, Samples: start}, Err: err}
+			}
+			end := start + size
+			if end > len(order) {
+				end = len(order)
+			}
+			inputs, targets = inputs[:0], targets[:0]
+			for _, i := range order[start:end] {
+				inputs, targets = append(inputs, inputData[i]), append(targets, targetData[i])
+			}
+			loss, err := net.TrainBatch(inputs, targets)
+			if err != nil {
+				return fmt.Errorf("epoch %d, batch %d: %w", epoch+1, batch+1, err)
+			}
+			total += loss * float64(end-start)
+			b := BatchResult{Epoch: epoch, Batch: batch, Size: end - start, Loss: loss}
+			for _, c := range o.Callbacks {
+				if err := c.OnBatchEnd(net, b); err != nil {
+					return err
+				}
+			}
+		}
+		e := EpochResult{Epoch: epoch, Loss: total / float64(len(order)-first)}
+		for _, c := range o.Callbacks {
+			if err := c.OnEpochEnd(net, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Online trains a network on samples as they arrive, such as from a stream
+// with no end, a mini-batch at a time. The network changes as it learns, so
+// it may follow data that drifts over time.
+type Online struct {
+	Net *Network
+	// BatchSize is the number of samples to train on at once, 1 if it is 0.
+	BatchSize int
+
+	inputs, targets [][]float64
+}
+
+// PartialFit adds a sample to the mini-batch, training on it once full. It
+// reports whether it trained, with the mean loss of the mini-batch. The
+// slices must not be changed until it has.
+func (o *Online) PartialFit(inputData, targetData []float64) (bool, float64, error) {
+	o.inputs, o.targets = append(o.inputs, inputData), append(o.targets, targetData)
+	if len(o.inputs) < o.BatchSize {
+		return false, 0, nil
+	}
+	return o.Flush()
+}
+
+// Flush trains on the samples added since the last mini-batch, if any, as
+// PartialFit does.
+func (o *Online) Flush() (bool, float64, error) {
+	if len(o.inputs) == 0 {
+		return false, 0, nil
+	}
+	loss, err := o.Net.TrainBatch(o.inputs, o.targets)
+	o.inputs, o.targets = o.inputs[:0], o.targets[:0]
+	return err == nil, loss, err
+}
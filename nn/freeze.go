@@ -0,0 +1,126 @@
+package nn
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// WithFrozen freezes the given layers, so that training leaves their
+// parameters as they are, as when fine-tuning a network trained on other
+// data. Only the layers with parameters are counted, from zero, so layer i
+// takes Sizes()[i] inputs, and creating the network panics if there is no
+// such layer. Training does not backpropagate any further than the first
+// layer that is not frozen.
+//
+// The optimizer only keeps state for the layers being trained, so a
+// checkpoint of a network with frozen layers must be loaded with the same
+// WithFrozen option.
+func WithFrozen(layers ...int) Option {
+	return func(net *Network) {
+		net.freeze = append([]int(nil), layers...)
+	}
+}
+
+// Freeze freezes the given layers, counted as for WithFrozen, in place of
+// any frozen before. It must be called before training the network, as the
+// optimizer keeps state only for the layers it trains.
+func (net *Network) Freeze(layers ...int) error {
+	if err := net.freezeLayers(layers); err != nil {
+		return err
+	}
+	net.freeze = append([]int(nil), layers...)
+	return nil
+}
+
+// freezeLayers marks the given layers as frozen, or returns an error if
+// there is no such layer.
+func (net *Network) freezeLayers(layers []int) error {
+	frozen := make([]bool, len(net.layers))
+	var weighted []int
+	for i, l := range net.layers {
+		if len(l.Params()) > 0 {
+			weighted = append(weighted, i)
+		}
+	}
+	for _, i := range layers {
+		if i < 0 || i >= len(weighted) {
+			return fmt.Errorf("nn: cannot freeze layer %d of a network with %d layers with parameters", i, len(weighted))
+		}
+		frozen[weighted[i]] = true
+	}
+	net.frozen = frozen
+	return nil
+}
+
+// Frozen reports whether layer i of Layers is frozen.
+func (net Network) Frozen(i int) bool {
+	return net.frozen != nil && net.frozen[i]
+}
+
+// backpropagated returns the index of the first layer whose parameters
+// are trained, before which there is no need to backpropagate.
+func (net Network) backpropagated() int {
+	for i, l := range net.layers {
+		if !net.Frozen(i) && len(l.Params()) > 0 {
+			return i
+		}
+	}
+	return len(net.layers)
+}
+
+// trainable returns the parameters of the layers that are not frozen, in
+// the order the optimizer takes them, along with their gradients from
+// grads, which holds those of every layer, if it is not nil.
+func (net Network) trainable(grads []*mat.Dense) ([]*mat.Dense, []*mat.Dense) {
+	if len(net.freeze) == 0 {
+		return net.params(), grads
+	}
+	var params, trained []*mat.Dense
+	k := 0
+	for i, l := range net.layers {
+		p := l.Params()
+		if !net.Frozen(i) {
+			params = append(params, p...)
+			if grads != nil {
+				trained = append(trained, grads[k:k+len(p)]...)
+			}
+		}
+		k += len(p)
+	}
+	return params, trained
+}
+
+// ReplaceOutputs swaps the dense layer nearest the outputs of the network
+// for a new one with the given number of outputs, with its weights set by
+// the initializer, keeping whatever follows it such as a softmax. This is
+// how a network trained on one set of classes is readied for fine-tuning
+// on another. It must be called before training the network, as the
+// optimizer keeps no state for the new layer.
+func (net *Network) ReplaceOutputs(outputs int) error {
+	if outputs <= 0 {
+		return fmt.Errorf("nn: cannot replace the output layer with one of %d outputs", outputs)
+	}
+	last := len(net.layers) - 1
+	for last >= 0 && len(net.layers[last].Params()) == 0 {
+		last--
+	}
+	if last < 0 {
+		return fmt.Errorf("nn: network has no output layer to replace")
+	}
+	d, ok := net.layers[last].(*dense)
+	if !ok {
+		return fmt.Errorf("nn: can only replace a dense output layer, not %s", layerName(net.layers[last]))
+	}
+	layers := append([]Layer(nil), net.layers...)
+	layers[last] = Dense(d.inputs(), outputs)
+	sizes, err := layerSizes(layers)
+	if err != nil {
+		return fmt.Errorf("nn: %w", err)
+	}
+	layers[last].(initialized).init(net.initializer, net.rng)
+	net.layers, net.sizes = layers, sizes
+	net.syncParams()
+	net.workspaces = newWorkspacePool(net.layers)
+	return nil
+}
@@ -0,0 +1,173 @@
+package nn
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kheob/ml/helpers"
+)
+
+// ExportGo writes the network to path as Go source in the named package,
+// as WriteGo does.
+func (net Network) ExportGo(path, pkg string) error {
+	return writeFile(path, func(w io.Writer) error {
+		return net.WriteGo(w, pkg)
+	})
+}
+
+// WriteGo writes the network to w as a self-contained Go file in the named
+// package, with the weights as literals and a Predict function that runs
+// the network using only the standard library. This lets a model be built
+// into another program without gonum or a model file. Only networks that
+// can be exported to ONNX can be written as Go.
+func (net Network) WriteGo(w io.Writer, pkg string) error {
+	layers, err := net.exportLayers("Go")
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by ml export; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "// Package %s runs a neural network exported by ml export.\n", pkg)
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+
+	// the steps of Predict, and the helpers they need
+	var steps []string
+	used := map[string]bool{}
+	var vars bytes.Buffer
+	for i, l := range layers {
+		var call string
+		switch a := l.activation.(type) {
+		case nil:
+			fmt.Fprintf(&vars, "\tweights%d = [][]float64{\n", i)
+			for _, row := range l.dense.rows() {
+				fmt.Fprintf(&vars, "\t\t{%s},\n", goFloats(row))
+			}
+			fmt.Fprintf(&vars, "\t}\n\tbiases%d = []float64{%s}\n", i, goFloats(l.dense.biasValues()))
+			call = fmt.Sprintf("dense(x, weights%d, biases%d)", i, i)
+		case helpers.ReLU:
+			call = "leakyReLU(x, 0)"
+		case helpers.LeakyReLU:
+			call = fmt.Sprintf("leakyReLU(x, %s)", goFloat(leakyAlpha(a)))
+		case helpers.Sigmoid:
+			call = "sigmoid(x)"
+		case helpers.Tanh:
+			call = "tanh(x)"
+		case helpers.Softmax:
+			call = "softmax(x)"
+		}
+		steps = append(steps, call)
+		used[call[:strings.Index(call, "(")]] = true
+	}
+	if used["sigmoid"] || used["tanh"] || used["softmax"] {
+		fmt.Fprintf(&b, "import \"math\"\n\n")
+	}
+	fmt.Fprintf(&b, "// Inputs and Outputs are the number of inputs Predict takes and outputs it gives.\n")
+	fmt.Fprintf(&b, "const (\n\tInputs = %d\n\tOutputs = %d\n)\n\n", net.Inputs(), net.Outputs())
+	fmt.Fprintf(&b, "// Predict returns the outputs of the network for inputs, which must hold\n// Inputs values.\n")
+	fmt.Fprintf(&b, "func Predict(inputs []float64) []float64 {\n")
+	fmt.Fprintf(&b, "\tif len(inputs) != Inputs {\n\t\tpanic(\"%s: wrong number of inputs\")\n\t}\n", pkg)
+	fmt.Fprintf(&b, "\tx := inputs\n")
+	for _, s := range steps {
+		fmt.Fprintf(&b, "\tx = %s\n", s)
+	}
+	fmt.Fprintf(&b, "\treturn x\n}\n\n")
+	for _, name := range []string{"dense", "leakyReLU", "sigmoid", "tanh", "softmax"} {
+		if used[name] {
+			b.WriteString(goHelpers[name])
+		}
+	}
+	fmt.Fprintf(&b, "var (\n%s)\n", vars.Bytes())
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("nn: formatting Go source: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// goHelpers holds the functions Predict calls in a generated Go file.
+var goHelpers = map[string]string{
+	"dense": `// dense returns the weights times x plus the biases.
+func dense(x []float64, weights [][]float64, biases []float64) []float64 {
+	out := make([]float64, len(biases))
+	for i, row := range weights {
+		sum := biases[i]
+		for j, w := range row {
+			sum += w * x[j]
+		}
+		out[i] = sum
+	}
+	return out
+}
+
+`,
+	"leakyReLU": `// leakyReLU passes on the positive values of x, and the negative ones
+// scaled by alpha.
+func leakyReLU(x []float64, alpha float64) []float64 {
+	out := make([]float64, len(x))
+	for i, v := range x {
+		if v < 0 {
+			v *= alpha
+		}
+		out[i] = v
+	}
+	return out
+}
+
+`,
+	"sigmoid": `func sigmoid(x []float64) []float64 {
+	out := make([]float64, len(x))
+	for i, v := range x {
+		out[i] = 1 / (1 + math.Exp(-v))
+	}
+	return out
+}
+
+`,
+	"tanh": `func tanh(x []float64) []float64 {
+	out := make([]float64, len(x))
+	for i, v := range x {
+		out[i] = math.Tanh(v)
+	}
+	return out
+}
+
+`,
+	"softmax": `func softmax(x []float64) []float64 {
+	max := x[0]
+	for _, v := range x {
+		max = math.Max(max, v)
+	}
+	out := make([]float64, len(x))
+	sum := 0.0
+	for i, v := range x {
+		out[i] = math.Exp(v - max)
+		sum += out[i]
+	}
+	for i := range out {
+		out[i] /= sum
+	}
+	return out
+}
+
+`,
+}
+
+// goFloats returns values as the elements of a Go slice literal.
+func goFloats(values []float64) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = goFloat(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// goFloat returns v as a Go literal that reads back as exactly v.
+func goFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
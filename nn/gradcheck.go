@@ -0,0 +1,130 @@
+package nn
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// GradCheckResult describes the worst disagreement GradCheck found between
+// the gradients from backpropagation and the finite difference estimates.
+type GradCheckResult struct {
+	// MaxError is the largest relative error over every weight and bias.
+	// Correct gradients usually agree to 1e-6 or better, while a bug
+	// tends to show up as an error of 1e-2 or more.
+	MaxError float64
+	// Layer, counting from 1, and Param, such as "weights" or "biases", say
+	// where the largest error was, and Analytic and Numeric give the gradients
+	// compared there.
+	Layer             int
+	Param             string
+	Analytic, Numeric float64
+	// Kinks counts the weights and biases left out because the loss has a
+	// kink within epsilon of them, such as where the input of a ReLU
+	// crosses zero, so that the finite differences cannot be trusted.
+	Kinks int
+}
+
+// GradCheck compares the gradients backpropagation works out for a batch of
+// samples against estimates from central finite differences, nudging each
+// weight and bias by epsilon in turn. It is slow, so meant for small
+// networks when changing the maths of backpropagation. The network is left
+// as it was, and must be float64 without dropout so that the loss does not
+// change between passes.
+//
+// Near a kink the finite differences are meaningless. With a smooth loss
+// halving epsilon halves the difference between the slopes either side of
+// a weight and barely changes the central difference, so a weight is taken
+// to be near a kink when either does not hold.
+func (net Network) GradCheck(inputData, targetData [][]float64, epsilon float64) (GradCheckResult, error) {
+	if net.precision != Float64 {
+		return GradCheckResult{}, errors.New("nn: gradient checking needs a float64 network")
+	}
+	if net.hasDropout() {
+		return GradCheckResult{}, errors.New("nn: gradient checking needs a network without dropout")
+	}
+	if len(inputData) == 0 || len(inputData) != len(targetData) {
+		return GradCheckResult{}, errors.New("nn: gradient checking needs as many targets as inputs")
+	}
+
+	ws := net.workspaces.Get().(*workspace)
+	defer net.workspaces.Put(ws)
+	loss := func() (float64, []*mat.Dense, error) {
+		loss, grads, err := net.shardGradients(ws, inputData, targetData)
+		if err != nil {
+			return 0, nil, err
+		}
+		return loss + net.regularize(grads), grads, nil
+	}
+	base, grads, err := loss()
+	if err != nil {
+		return GradCheckResult{}, err
+	}
+	// nudged returns the losses with the weight at data[j] nudged up and
+	// down by step.
+	nudged := func(data []float64, j int, step float64) (plus, minus float64, err error) {
+		v := data[j]
+		data[j] = v + step
+		plus, _, err = loss()
+		if err == nil {
+			data[j] = v - step
+			minus, _, err = loss()
+		}
+		data[j] = v
+		return plus, minus, err
+	}
+	analytic := make([]*mat.Dense, len(grads))
+	for i, g := range grads {
+		analytic[i] = mat.DenseCopyOf(g)
+	}
+
+	var worst GradCheckResult
+	// layers and names give the layer and name of each parameter, and
+	// frozen marks those training leaves alone
+	var layers []int
+	var frozen []bool
+	var names []string
+	for i, l := range net.layers {
+		for j := range l.Params() {
+			layers = append(layers, i+1)
+			names = append(names, paramName(l, j))
+			frozen = append(frozen, net.Frozen(i))
+		}
+	}
+	for i, p := range net.params() {
+		if frozen[i] {
+			continue
+		}
+		data := p.RawMatrix().Data
+		for j := range data {
+			plus, minus, err := nudged(data, j, epsilon)
+			if err != nil {
+				return GradCheckResult{}, err
+			}
+			halfPlus, halfMinus, err := nudged(data, j, epsilon/2)
+			if err != nil {
+				return GradCheckResult{}, err
+			}
+			// the forward slope less the backward one
+			gap := (plus - 2*base + minus) / epsilon
+			halfGap := (halfPlus - 2*base + halfMinus) / (epsilon / 2)
+			numeric := (plus - minus) / (2 * epsilon)
+			halfNumeric := (halfPlus - halfMinus) / epsilon
+			if math.Abs(gap) > 1e-8 && math.Abs(gap-2*halfGap) > math.Abs(gap)/2 ||
+				math.Abs(numeric-halfNumeric) > 1e-8+(math.Abs(numeric)+math.Abs(halfNumeric))/100 {
+				worst.Kinks++
+				continue
+			}
+
+			a := analytic[i].RawMatrix().Data[j]
+			// the rounding of the loss swamps gradients much smaller than
+			// 1e-6, so they are compared absolutely
+			rel := math.Abs(a-numeric) / math.Max(math.Abs(a)+math.Abs(numeric), 1e-6)
+			if rel > worst.MaxError || worst.Param == "" {
+				worst = GradCheckResult{MaxError: rel, Layer: layers[i], Param: names[i], Analytic: a, Numeric: numeric, Kinks: worst.Kinks}
+			}
+		}
+	}
+	return worst, nil
+}
@@ -0,0 +1,146 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Initializer sets the starting weights of a layer. w has a row for each
+// neuron of the layer and a column for each of its inputs, or for a
+// convolutional layer a row for each filter and a column for each value
+// under it.
+type Initializer interface {
+	Init(w *mat.Dense, rng *rand.Rand)
+}
+
+// InitializerByName returns the initializer called "uniform",
+// "xavier-uniform", "xavier-normal", "he", "lecun" or "orthogonal".
+func InitializerByName(name string) (Initializer, error) {
+	switch name {
+	case "uniform":
+		return Uniform{}, nil
+	case "xavier-uniform":
+		return XavierUniform{}, nil
+	case "xavier-normal":
+		return XavierNormal{}, nil
+	case "he":
+		return He{}, nil
+	case "lecun":
+		return LeCun{}, nil
+	case "orthogonal":
+		return Orthogonal{}, nil
+	}
+	return nil, fmt.Errorf("nn: unknown initializer %q", name)
+}
+
+// Uniform draws weights uniformly between ±1/sqrt(inputs). It is the
+// default.
+type Uniform struct{}
+
+func (Uniform) Init(w *mat.Dense, rng *rand.Rand) {
+	_, in := w.Dims()
+	limit := 1 / math.Sqrt(float64(in))
+	fill(w, func() float64 {
+		return limit * (2*rng.Float64() - 1)
+	})
+}
+
+// XavierUniform is Glorot uniform initialization, drawing weights uniformly
+// between ±sqrt(6/(inputs+outputs)). It suits sigmoid and tanh layers.
+type XavierUniform struct{}
+
+func (XavierUniform) Init(w *mat.Dense, rng *rand.Rand) {
+	out, in := w.Dims()
+	limit := math.Sqrt(6 / float64(in+out))
+	fill(w, func() float64 {
+		return limit * (2*rng.Float64() - 1)
+	})
+}
+
+// XavierNormal is Glorot normal initialization, drawing weights from a
+// normal distribution with standard deviation sqrt(2/(inputs+outputs)).
+type XavierNormal struct{}
+
+func (XavierNormal) Init(w *mat.Dense, rng *rand.Rand) {
+	out, in := w.Dims()
+	normal(w, rng, math.Sqrt(2/float64(in+out)))
+}
+
+// He draws weights from a normal distribution with standard deviation
+// sqrt(2/inputs), which keeps the variance of ReLU layers steady.
+type He struct{}
+
+func (He) Init(w *mat.Dense, rng *rand.Rand) {
+	_, in := w.Dims()
+	normal(w, rng, math.Sqrt(2/float64(in)))
+}
+
+// LeCun draws weights from a normal distribution with standard deviation
+// sqrt(1/inputs).
+type LeCun struct{}
+
+func (LeCun) Init(w *mat.Dense, rng *rand.Rand) {
+	_, in := w.Dims()
+	normal(w, rng, math.Sqrt(1/float64(in)))
+}
+
+// Orthogonal sets the weights to a random orthogonal matrix, or as close to
+// one as the shape allows, scaled by Gain. Gain defaults to 1 when left as
+// zero.
+type Orthogonal struct {
+	Gain float64
+}
+
+func (o Orthogonal) Init(w *mat.Dense, rng *rand.Rand) {
+	out, in := w.Dims()
+	// the QR decomposition of a random tall matrix gives orthonormal
+	// columns, which are transposed into rows if the layer is wide
+	r, c := out, in
+	if out < in {
+		r, c = in, out
+	}
+	a := mat.NewDense(r, c, nil)
+	normal(a, rng, 1)
+	var qr mat.QR
+	qr.Factorize(a)
+	var q, rr mat.Dense
+	qr.QTo(&q)
+	qr.RTo(&rr)
+
+	gain := orDefault(o.Gain, 1)
+	for j := 0; j < c; j++ {
+		// fix the signs so that every orthogonal matrix is as likely
+		s := gain
+		if rr.At(j, j) < 0 {
+			s = -gain
+		}
+		for i := 0; i < r; i++ {
+			if out >= in {
+				w.Set(i, j, s*q.At(i, j))
+			} else {
+				w.Set(j, i, s*q.At(i, j))
+			}
+		}
+	}
+}
+
+// normal fills m from a normal distribution with mean zero and standard
+// deviation sd.
+func normal(m *mat.Dense, rng *rand.Rand, sd float64) {
+	fill(m, func() float64 {
+		return sd * rng.NormFloat64()
+	})
+}
+
+// fill sets every element of m, row by row, to the next value from next.
+func fill(m *mat.Dense, next func() float64) {
+	r, c := m.Dims()
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			m.Set(i, j, next())
+		}
+	}
+}
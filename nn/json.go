@@ -0,0 +1,135 @@
+package nn
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// jsonNetwork is a network as JSON, with the spec of each layer as in a
+// model file and its parameters row by row, for example
+//
+//	{
+//	  "precision": "float64",
+//	  "loss": "cross-entropy",
+//	  "layers": [
+//	    {"spec": "dense:2:1", "params": [
+//	      {"name": "weights", "values": [[0.5, -0.25]]},
+//	      {"name": "biases", "values": [[0.1]]}
+//	    ]},
+//	    {"spec": "softmax"}
+//	  ],
+//	  "metadata": {"epochs": "10"}
+//	}
+type jsonNetwork struct {
+	Precision string      `json:"precision"`
+	Loss      string      `json:"loss"`
+	Layers    []jsonLayer `json:"layers"`
+	// Metadata holds the known fields of the metadata, as in a model file.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type jsonLayer struct {
+	Spec   string      `json:"spec"`
+	Params []jsonParam `json:"params,omitempty"`
+}
+
+type jsonParam struct {
+	Name   string      `json:"name"`
+	Values [][]float64 `json:"values"`
+}
+
+// MarshalJSON returns the architecture and parameters of the network as
+// JSON, which can be read back by UnmarshalJSON. Dropout is left out, as in
+// a model file. It fails if the network has a layer from outside this
+// package, or a parameter that is not a finite number.
+func (net Network) MarshalJSON() ([]byte, error) {
+	n := jsonNetwork{Precision: net.precision.String(), Loss: net.loss.Name()}
+	for i, l := range net.layers {
+		if _, ok := l.(*dropout); ok {
+			continue
+		}
+		if _, ok := l.(specified); !ok {
+			return nil, fmt.Errorf("nn: layer %d of type %T cannot be saved", i+1, l)
+		}
+		layer := jsonLayer{Spec: layerName(l)}
+		for p, m := range l.Params() {
+			r, c := m.Dims()
+			values := make([][]float64, r)
+			for i := range values {
+				values[i] = append(make([]float64, 0, c), m.RawRowView(i)...)
+			}
+			layer.Params = append(layer.Params, jsonParam{Name: paramName(l, p), Values: values})
+		}
+		n.Layers = append(n.Layers, layer)
+	}
+	for _, f := range net.metadata.fields() {
+		if n.Metadata == nil {
+			n.Metadata = map[string]string{}
+		}
+		n.Metadata[f[0]] = f[1]
+	}
+	return json.Marshal(n)
+}
+
+// UnmarshalJSON sets the network to the one described by JSON from
+// MarshalJSON, with the architecture, precision, loss and parameters given
+// there, much as ReadNetwork reads a model file. Every parameter must be
+// given. The network is left unchanged if an error is returned.
+func (net *Network) UnmarshalJSON(data []byte) error {
+	var n jsonNetwork
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	if len(n.Layers) == 0 {
+		return fmt.Errorf("nn: model has no layers")
+	}
+	h := header{loss: n.Loss}
+	var err error
+	if h.precision, err = PrecisionByName(n.Precision); err != nil {
+		return err
+	}
+	for _, l := range n.Layers {
+		h.layers = append(h.layers, l.Spec)
+	}
+	built, err := h.network(nil)
+	if err != nil {
+		return err
+	}
+	for i, l := range built.layers {
+		params := l.Params()
+		if len(n.Layers[i].Params) != len(params) {
+			return fmt.Errorf("nn: model layer %d has %d parameters, want %d", i+1, len(n.Layers[i].Params), len(params))
+		}
+		for p, m := range params {
+			if err := setRows(m, n.Layers[i].Params[p].Values); err != nil {
+				return fmt.Errorf("nn: model layer %d %s: %w", i+1, paramName(l, p), err)
+			}
+		}
+	}
+	built.syncParams()
+	for key, value := range n.Metadata {
+		if err := built.metadata.setField(key, value); err != nil {
+			return fmt.Errorf("nn: invalid metadata %s %q", key, value)
+		}
+	}
+	*net = built
+	return nil
+}
+
+// setRows copies values, row by row, into m, which they must be the shape
+// of.
+func setRows(m *mat.Dense, values [][]float64) error {
+	r, c := m.Dims()
+	if len(values) != r {
+		return fmt.Errorf("%d rows, want %d", len(values), r)
+	}
+	for i, row := range values {
+		if len(row) != c {
+			return fmt.Errorf("row %d has %d values, want %d", i+1, len(row), c)
+		}
+		m.SetRow(i, row)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package nn
+
+import (
+	"fmt"
+
+	"github.com/kheob/ml/helpers"
+)
+
+// Keras keeps the weights of a Sequential model of Dense layers as a kernel
+// for each layer, with a row for each input and a column for each output,
+// followed by its biases unless the layer was made with use_bias=False.
+// model.get_weights() returns them in that order, and
+//
+//	numpy.savez("weights.npz", *model.get_weights())
+//
+// saves them as arr_0, arr_1 and so on, which is the form read here. HDF5
+// files saved by model.save() cannot be read, as there is no HDF5 reader
+// in Go's standard library.
+
+// LoadKerasWeights reads the weights of a Keras Sequential model of Dense
+// layers, saved to an .npz file as above, into the dense layers of the
+// network in turn. The network is left unchanged if an error is returned.
+func (net *Network) LoadKerasWeights(path string) error {
+	layers, err := readKerasLayers(path)
+	if err != nil {
+		return err
+	}
+	var targets []*dense
+	for _, l := range net.layers {
+		if d, ok := l.(*dense); ok {
+			targets = append(targets, d)
+		}
+	}
+	if len(layers) != len(targets) {
+		return fmt.Errorf("nn: %s has %d dense layers, network has %d", path, len(layers), len(targets))
+	}
+	for i, k := range layers {
+		r, c := targets[i].w.Dims()
+		if k.inputs != c || k.outputs != r {
+			return fmt.Errorf("nn: %s dense layer %d is %d x %d, network's is %d x %d", path, i+1, k.inputs, k.outputs, c, r)
+		}
+	}
+	for i, k := range layers {
+		k.setTo(targets[i])
+	}
+	net.syncParams()
+	return nil
+}
+
+// ReadKerasNetwork returns a network of the dense layers of a Keras
+// Sequential model, saved to an .npz file as for LoadKerasWeights, each
+// followed by the activation of the same index. Options are applied as for
+// CreateNetwork.
+func ReadKerasNetwork(path string, activations []helpers.Activation, opts ...Option) (Network, error) {
+	layers, err := readKerasLayers(path)
+	if err != nil {
+		return Network{}, err
+	}
+	if len(activations) != len(layers) {
+		return Network{}, fmt.Errorf("nn: %s has %d dense layers, but %d activations were given", path, len(layers), len(activations))
+	}
+	var seq Sequential
+	for i, k := range layers {
+		if i > 0 && k.inputs != layers[i-1].outputs {
+			return Network{}, fmt.Errorf("nn: %s dense layer %d takes %d inputs, not the %d outputs of the layer before", path, i+1, k.inputs, layers[i-1].outputs)
+		}
+		if _, ok := activations[i].(helpers.Softmax); ok && i < len(layers)-1 {
+			return Network{}, fmt.Errorf("nn: softmax can only be used for the output layer")
+		}
+		seq = append(seq, Dense(k.inputs, k.outputs), Activation(activations[i]))
+	}
+	net := seq.Network(0, opts...)
+	i := 0
+	for _, l := range net.layers {
+		if d, ok := l.(*dense); ok {
+			layers[i].setTo(d)
+			i++
+		}
+	}
+	net.syncParams()
+	return net, nil
+}
+
+// kerasDense is a Dense layer of a Keras model, with its kernel row by row.
+type kerasDense struct {
+	inputs, outputs int
+	kernel, biases  []float64
+}
+
+// setTo sets the weights and biases of d to those of k, transposing the
+// kernel.
+func (k kerasDense) setTo(d *dense) {
+	for i := 0; i < k.outputs; i++ {
+		for j := 0; j < k.inputs; j++ {
+			d.w.Set(i, j, k.kernel[j*k.outputs+i])
+		}
+		b := 0.0
+		if k.biases != nil {
+			b = k.biases[i]
+		}
+		d.biases.Set(i, 0, b)
+	}
+}
+
+// readKerasLayers reads the dense layers of a Keras model from the .npz file
+// at path.
+func readKerasLayers(path string) ([]kerasDense, error) {
+	arrays, err := readNpz(path)
+	if err != nil {
+		return nil, err
+	}
+	var layers []kerasDense
+	for i := 0; i < len(arrays); i++ {
+		a := arrays[i]
+		if len(a.shape) != 2 {
+			return nil, fmt.Errorf("nn: %s in %s is %v, want the kernel of a dense layer", a.name, path, a.shape)
+		}
+		k := kerasDense{inputs: a.shape[0], outputs: a.shape[1], kernel: a.data}
+		if k.inputs == 0 || k.outputs == 0 {
+			return nil, fmt.Errorf("nn: %s in %s is empty", a.name, path)
+		}
+		if i+1 < len(arrays) && len(arrays[i+1].shape) == 1 {
+			if b := arrays[i+1]; b.shape[0] != k.outputs {
+				return nil, fmt.Errorf("nn: %s in %s has %d biases, want %d", b.name, path, b.shape[0], k.outputs)
+			}
+			i++
+			k.biases = arrays[i].data
+		}
+		layers = append(layers, k)
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("nn: no weights in %s", path)
+	}
+	return layers, nil
+}
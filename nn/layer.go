@@ -0,0 +1,295 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Layer is one step of a network, turning the outputs of the layer before
+// into its own, with one sample per column.
+//
+// Outputs returns the number of outputs the layer gives for each sample of
+// the given number of inputs, or an error if it cannot take that many.
+// Forward works out the outputs for a batch of inputs. Backward is given
+// the gradient of the loss with respect to the outputs of the last Forward
+// and returns the gradient with respect to its inputs, after setting grads,
+// which are shaped like the matrices returned by Params, to the gradients
+// of the parameters averaged over the batch. Anything a layer needs to keep
+// from Forward for Backward goes in s, which belongs to a single pass, so
+// that a layer can be used for many batches at once.
+type Layer interface {
+	Outputs(inputs int) (int, error)
+	Forward(s *Scratch, inputs *mat.Dense, training bool) (*mat.Dense, error)
+	Backward(s *Scratch, grad *mat.Dense, grads []*mat.Dense) (*mat.Dense, error)
+	Params() []*mat.Dense
+}
+
+// Scratch holds what a layer works with during one pass over a batch, and
+// keeps it from one batch to the next so that its matrices can be reused.
+type Scratch struct {
+	matrices []*mat.Dense
+	// Value is for a layer to keep anything other than matrices between
+	// Forward and Backward.
+	Value interface{}
+
+	ws              *workspace
+	inputs, outputs *mat.Dense
+	inputGrad       bool
+	// argmax holds the rows kept by max pooling.
+	argmax []int
+}
+
+// Matrix returns the i'th matrix of s resized to r x c. It keeps what it
+// holds from one call to the next unless its shape changes, when it is
+// zeroed.
+func (s *Scratch) Matrix(i, r, c int) *mat.Dense {
+	for len(s.matrices) <= i {
+		s.matrices = append(s.matrices, &mat.Dense{})
+	}
+	return resize(s.matrices[i], r, c)
+}
+
+// Inputs and Outputs return the inputs and outputs of the last Forward.
+func (s *Scratch) Inputs() *mat.Dense {
+	return s.inputs
+}
+
+func (s *Scratch) Outputs() *mat.Dense {
+	return s.outputs
+}
+
+// InputGradient reports whether Backward needs to work out the gradient
+// with respect to the inputs. It is false for the first layer of a
+// network, whose Backward may then return nil.
+func (s *Scratch) InputGradient() bool {
+	return s.inputGrad
+}
+
+// Rand returns the random numbers for the pass, which are seeded from the
+// network so that training is repeatable with WithSeed.
+func (s *Scratch) Rand() *rand.Rand {
+	return s.ws.rng
+}
+
+// ones returns a column of n copies of 1/n, for averaging over a batch of
+// n samples.
+func (s *Scratch) ones(n int) *mat.Dense {
+	ones := resize(&s.ws.ones, n, 1)
+	for j := 0; j < n; j++ {
+		ones.Set(j, 0, 1/float64(n))
+	}
+	return ones
+}
+
+// Sequential is a stack of layers for a network to run one after the other.
+type Sequential []Layer
+
+// NewSequential returns a stack of the given layers, such as
+//
+//	nn.NewSequential(nn.Dense(784, 200), nn.ReLU(), nn.Dense(200, 10), nn.Softmax())
+//
+// for a network with one hidden layer of 200 neurons.
+func NewSequential(layers ...Layer) Sequential {
+	return append(Sequential(nil), layers...)
+}
+
+// Network returns a network running the layers, with the weights set by
+// the initializer. Options are applied as for CreateNetwork, and a softmax
+// activation may only come last. It panics if the layers do not fit
+// together.
+func (s Sequential) Network(rate float64, opts ...Option) Network {
+	net := newNetwork(rate, opts)
+	net.build(s)
+	return net
+}
+
+// Layers returns the layers of the network, from the inputs to the outputs.
+// They are shared with the network, so changing their parameters changes
+// the network.
+func (net Network) Layers() []Layer {
+	return append([]Layer(nil), net.layers...)
+}
+
+// The layers in this package implement some extra interfaces, which custom
+// layers may leave out.
+type (
+	// initialized layers set their starting parameters with an
+	// initializer.
+	initialized interface {
+		init(i Initializer, rng *rand.Rand)
+	}
+	// regularized layers return the indexes in Params of the weights that
+	// the L1 and L2 penalties apply to.
+	regularized interface {
+		weights() []int
+	}
+	// synced layers keep their parameters in the given precision, and
+	// are told whenever they change.
+	synced interface {
+		sync(p Precision)
+	}
+	// specified layers can be saved to a model file, and recreated from
+	// their spec with layerBySpec.
+	specified interface {
+		spec() string
+	}
+	// named layers have names for their parameters, such as "weights".
+	named interface {
+		paramNames() []string
+	}
+	// sized layers know how many inputs they take, which gives the number
+	// of inputs of a network starting with them.
+	sized interface {
+		inputs() int
+	}
+)
+
+// layerBySpec returns a new layer from its spec, as returned by its spec
+// method.
+func layerBySpec(spec string) (Layer, error) {
+	kind, args := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, args = spec[:i], spec[i+1:]
+	}
+	var n []int
+	number := func(s string) bool {
+		v, err := strconv.Atoi(s)
+		n = append(n, v)
+		return err == nil && v >= 0 && v <= 1<<24
+	}
+	numbers := func(fields []string) bool {
+		for _, f := range fields {
+			if !number(f) {
+				return false
+			}
+		}
+		return true
+	}
+	fields := strings.Split(args, ":")
+	switch kind {
+	case "dense":
+		if len(fields) == 2 && numbers(fields) && n[0] > 0 && n[1] > 0 {
+			return Dense(n[0], n[1]), nil
+		}
+	case "conv2d":
+		if len(fields) == 5 && numbers(fields[1:]) {
+			in, err := parseShape(fields[0])
+			c := Conv2D{Filters: n[0], Kernel: n[1], Stride: n[2], Padding: n[3]}
+			if out := c.convolved(in); err == nil && c.valid() && out.Height > 0 && out.Width > 0 {
+				return Conv(in, c), nil
+			}
+		}
+	case "maxpool", "avgpool":
+		if len(fields) == 3 && numbers(fields[1:]) {
+			in, err := parseShape(fields[0])
+			p := MaxPool2D(n[0], n[1])
+			if kind == "avgpool" {
+				p = AvgPool2D(n[0], n[1])
+			}
+			if out := p.output(in); err == nil && p.Kernel > 0 && p.Stride > 0 && out.Height > 0 && out.Width > 0 {
+				return Pool(in, p), nil
+			}
+		}
+	case "rnn", "lstm":
+		sequences := len(fields) == 4 && fields[3] == "sequences"
+		if (len(fields) == 3 || sequences) && numbers(fields[:3]) && n[0] > 0 && n[1] > 0 && n[2] > 0 {
+			if kind == "lstm" {
+				return LSTM(n[0], n[1], n[2], sequences), nil
+			}
+			return RNN(n[0], n[1], n[2], sequences), nil
+		}
+	case "embedding":
+		if len(fields) == 3 && number(fields[0]) && number(fields[2]) && n[0] > 0 && n[1] > 0 {
+			var categories []int
+			for _, c := range strings.Split(fields[1], ",") {
+				if !number(c) || n[len(n)-1] == 0 {
+					return nil, fmt.Errorf("nn: unknown layer %q", spec)
+				}
+				categories = append(categories, n[len(n)-1])
+			}
+			if len(categories) <= n[0] {
+				return Embedding(n[0], categories, n[1]), nil
+			}
+		}
+	case "dropout":
+		rate, err := strconv.ParseFloat(args, 64)
+		if err == nil && rate >= 0 && rate < 1 {
+			return Dropout(rate), nil
+		}
+	case "flatten":
+		return Flatten(), nil
+	case "code":
+		return code{}, nil
+	default:
+		if a, err := helpers.ActivationByName(spec); err == nil {
+			return Activation(a), nil
+		}
+	}
+	return nil, fmt.Errorf("nn: unknown layer %q", spec)
+}
+
+// specParams returns the number of parameters of the layer a spec
+// describes without making it, so that the sizes in a model file can be
+// checked before anything is allocated for them. It is a float64 so that
+// damaged sizes cannot overflow it, and zero for a layer without
+// parameters or a spec layerBySpec rejects.
+func specParams(spec string) float64 {
+	kind, args := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, args = spec[:i], spec[i+1:]
+	}
+	fields := strings.Split(args, ":")
+	n := func(s string) float64 {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return 0
+		}
+		return float64(v)
+	}
+	switch {
+	case kind == "dense" && len(fields) == 2:
+		return (n(fields[0]) + 1) * n(fields[1])
+	case kind == "conv2d" && len(fields) == 5:
+		in, err := parseShape(fields[0])
+		if err != nil {
+			return 0
+		}
+		filters, kernel := n(fields[1]), n(fields[2])
+		return filters * (float64(in.Channels)*kernel*kernel + 1)
+	case (kind == "rnn" || kind == "lstm") && len(fields) >= 3:
+		features, hidden := n(fields[0]), n(fields[1])
+		params := hidden * (features + hidden + 1)
+		if kind == "lstm" {
+			params *= 4
+		}
+		return params
+	case kind == "embedding" && len(fields) == 3:
+		categories := 0.0
+		for _, c := range strings.Split(fields[1], ",") {
+			categories += n(c)
+		}
+		return categories * n(fields[2])
+	}
+	return 0
+}
+
+// layerName returns the spec of a layer, or its Go type if it has none.
+func layerName(l Layer) string {
+	if s, ok := l.(specified); ok {
+		return s.spec()
+	}
+	return fmt.Sprintf("%T", l)
+}
+
+// paramName returns the name of parameter i of a layer.
+func paramName(l Layer, i int) string {
+	if n, ok := l.(named); ok {
+		return n.paramNames()[i]
+	}
+	return fmt.Sprintf("parameter %d", i+1)
+}
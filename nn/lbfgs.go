@@ -0,0 +1,183 @@
+package nn
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// LBFGS holds the settings of TrainLBFGS.
+type LBFGS struct {
+	// Iterations is the most iterations to run, 100 if it is 0. Each takes
+	// a step along the direction L-BFGS works out, with a line search to
+	// size it, so may pass over the data several times.
+	Iterations int
+	// Memory is how many of the latest steps approximate the curvature of
+	// the loss, 10 if it is 0.
+	Memory int
+	// Progress, if set, is called after each iteration with its number,
+	// counting from 1, and the loss. Training stops with the error it
+	// returns, if any.
+	Progress func(iteration int, loss float64) error
+}
+
+// errProgress wraps an error from LBFGS.Progress, to tell it apart from the
+// line search giving up.
+type errProgress struct{ err error }
+
+func (e errProgress) Error() string { return e.err.Error() }
+
+// TrainLBFGS trains the network on the whole of the data at once with the
+// L-BFGS method, which estimates the curvature of the loss to take far
+// fewer, better steps than gradient descent. That suits small networks on
+// data that fits in memory, such as most tabular data. The optimizer and
+// learning rate of the network are not used, and it must not have dropout,
+// as the line search needs the loss not to change between passes.
+//
+// Training stops early once the loss stops falling. The network is left
+// with the parameters of the lowest loss found, which is returned along
+// with any error from a pass through the network or from Progress.
+func (net *Network) TrainLBFGS(inputData, targetData [][]float64, o LBFGS) (float64, error) {
+	if net.hasDropout() {
+		return 0, errors.New("nn: L-BFGS needs a network without dropout")
+	}
+	if len(inputData) == 0 || len(inputData) != len(targetData) {
+		return 0, errors.New("nn: L-BFGS needs as many targets as inputs")
+	}
+
+	ws := net.workspaces.Get().(*workspace)
+	defer net.workspaces.Put(ws)
+	params, _ := net.trainable(nil)
+	// set copies x into the parameters, which it runs through in order
+	set := func(x []float64) {
+		for _, p := range params {
+			data := p.RawMatrix().Data
+			x = x[copy(data, x):]
+		}
+		net.syncParams()
+	}
+	x := appendParams(nil, params)
+
+	// the line search asks for the loss and gradient at the same point in
+	// turn, so the last point worked out is kept
+	var last []float64
+	var loss float64
+	var grad []float64
+	var evalErr error
+	evaluate := func(x []float64) {
+		if last != nil && equal(last, x) {
+			return
+		}
+		last = append(last[:0], x...)
+		set(x)
+		var grads []*mat.Dense
+		loss, grads, evalErr = net.gradients(ws, inputData, targetData)
+		if evalErr != nil {
+			return
+		}
+		loss += net.regularize(grads)
+		_, grads = net.trainable(grads)
+		grad = appendParams(grad[:0], grads)
+	}
+	problem := optimize.Problem{
+		Func: func(x []float64) float64 {
+			evaluate(x)
+			return loss
+		},
+		Grad: func(dst, x []float64) {
+			evaluate(x)
+			copy(dst, grad)
+		},
+		Status: func() (optimize.Status, error) {
+			if evalErr != nil {
+				return optimize.Failure, evalErr
+			}
+			return optimize.NotTerminated, nil
+		},
+	}
+	iterations, memory := o.Iterations, o.Memory
+	if iterations == 0 {
+		iterations = 100
+	}
+	if memory == 0 {
+		memory = 10
+	}
+	// the iterations are counted, and convergence checked, here rather
+	// than by Minimize, which leaves the last iteration unrecorded
+	var losses []float64
+	// errDone stops Minimize, with the best location found
+	errDone := errors.New("nn: L-BFGS done")
+	settings := &optimize.Settings{
+		Converger: optimize.NeverTerminate{},
+		Recorder: recorder(func(loc *optimize.Location, op optimize.Operation, stats *optimize.Stats) error {
+			if op&optimize.MajorIteration == 0 {
+				return nil
+			}
+			if o.Progress != nil {
+				// the line search may have tried points past the one it took
+				set(loc.X)
+				if err := o.Progress(stats.MajorIterations, loc.F); err != nil {
+					return errProgress{err}
+				}
+			}
+			// converged once the loss barely falls over 5 iterations
+			losses = append(losses, loc.F)
+			if n := len(losses); n > 5 && losses[n-6]-loc.F <= 1e-10*(1+math.Abs(loc.F)) {
+				return errDone
+			}
+			if stats.MajorIterations >= iterations {
+				return errDone
+			}
+			return nil
+		}),
+	}
+	result, err := optimize.Minimize(problem, x, settings, &optimize.LBFGS{Store: memory})
+	var progressErr errProgress
+	switch {
+	case evalErr != nil:
+		err = evalErr
+	case errors.As(err, &progressErr):
+		err = progressErr.err
+	default:
+		// errDone, or the line search failing to find a lower loss, which
+		// means there is none to be had nearby, as good as converging
+		err = nil
+	}
+	if result == nil {
+		return 0, err
+	}
+	set(result.X)
+	return result.F, err
+}
+
+// recorder is an optimize.Recorder made from a function.
+type recorder func(*optimize.Location, optimize.Operation, *optimize.Stats) error
+
+func (recorder) Init() error { return nil }
+
+func (r recorder) Record(loc *optimize.Location, op optimize.Operation, stats *optimize.Stats) error {
+	return r(loc, op, stats)
+}
+
+// appendParams appends the elements of each matrix in ms to dst, in order.
+func appendParams(dst []float64, ms []*mat.Dense) []float64 {
+	for _, m := range ms {
+		dst = append(dst, m.RawMatrix().Data...)
+	}
+	return dst
+}
+
+// equal reports whether a and b hold the same values.
+func equal(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Loss measures how far the outputs of a network are from the targets, with
+// one sample per column. Value returns the mean loss over the samples, and
+// Gradient writes the gradient of each sample's loss with respect to its
+// outputs to dst, which is the same shape as outputs. Name identifies the
+// loss, including any settings, so that it can be recreated with
+// LossByName.
+type Loss interface {
+	Value(outputs, targets mat.Matrix) float64
+	Gradient(dst *mat.Dense, outputs, targets mat.Matrix)
+	Name() string
+}
+
+// LossByName returns the loss called "mse", "cross-entropy",
+// "binary-cross-entropy" or "huber", which may be given a delta as in
+// "huber:0.5".
+func LossByName(name string) (Loss, error) {
+	switch name {
+	case "mse":
+		return MSE{}, nil
+	case "cross-entropy":
+		return CrossEntropy{}, nil
+	case "binary-cross-entropy":
+		return BinaryCrossEntropy{}, nil
+	case "huber":
+		return Huber{}, nil
+	}
+	if strings.HasPrefix(name, "huber:") {
+		delta, err := strconv.ParseFloat(strings.TrimPrefix(name, "huber:"), 64)
+		if err == nil && delta > 0 {
+			return Huber{Delta: delta}, nil
+		}
+	}
+	return nil, fmt.Errorf("nn: unknown loss %q", name)
+}
+
+// WithLoss sets the loss the network is trained to minimise. The default
+// is CrossEntropy for a softmax output layer and MSE for any other.
+func WithLoss(l Loss) Option {
+	return func(net *Network) {
+		net.loss = l
+	}
+}
+
+// WithLabelSmoothing trains the network towards targets moved epsilon of
+// the way from one-hot to an even spread over the classes, so that for k
+// classes the true class has a target of 1-epsilon+epsilon/k and every other
+// class epsilon/k. This keeps the network from growing overconfident. Only
+// the training loss is smoothed, and the targets must be one-hot, or a
+// single 0 or 1 for two classes. Epsilon must be from 0 up to but not
+// including 1.
+func WithLabelSmoothing(epsilon float64) Option {
+	return func(net *Network) {
+		net.smoothing = epsilon
+	}
+}
+
+// smoothLabels applies label smoothing of epsilon over the given number of
+// classes to the targets in place.
+func smoothLabels(targets *mat.Dense, epsilon float64, classes int) {
+	raw := targets.RawMatrix()
+	for i := 0; i < raw.Rows; i++ {
+		row := raw.Data[i*raw.Stride : i*raw.Stride+raw.Cols]
+		for j, t := range row {
+			row[j] = t*(1-epsilon) + epsilon/float64(classes)
+		}
+	}
+}
+
+// MSE is half the squared error, summed over the outputs of each sample.
+type MSE struct{}
+
+func (MSE) Value(outputs, targets mat.Matrix) float64 {
+	return mean(outputs, targets, func(y, t float64) float64 {
+		return (y - t) * (y - t) / 2
+	})
+}
+
+func (MSE) Gradient(dst *mat.Dense, outputs, targets mat.Matrix) {
+	elementwise(dst, outputs, targets, func(y, t float64) float64 {
+		return y - t
+	})
+}
+
+func (MSE) Name() string {
+	return "mse"
+}
+
+// CrossEntropy is the categorical cross-entropy between predicted class
+// probabilities and the targets. It is meant for a softmax output layer.
+type CrossEntropy struct{}
+
+func (CrossEntropy) Value(outputs, targets mat.Matrix) float64 {
+	return helpers.CrossEntropy(outputs, targets)
+}
+
+func (CrossEntropy) Gradient(dst *mat.Dense, outputs, targets mat.Matrix) {
+	elementwise(dst, outputs, targets, func(y, t float64) float64 {
+		return -t / math.Max(y, 1e-15)
+	})
+}
+
+func (CrossEntropy) Name() string {
+	return "cross-entropy"
+}
+
+// BinaryCrossEntropy treats each output as the probability of a separate
+// yes or no answer, as for multi-label classification. It is meant for a
+// sigmoid output layer.
+type BinaryCrossEntropy struct{}
+
+func (BinaryCrossEntropy) Value(outputs, targets mat.Matrix) float64 {
+	return mean(outputs, targets, func(y, t float64) float64 {
+		// clamp to avoid log(0)
+		y = math.Min(math.Max(y, 1e-15), 1-1e-15)
+		return -t*math.Log(y) - (1-t)*math.Log(1-y)
+	})
+}
+
+func (BinaryCrossEntropy) Gradient(dst *mat.Dense, outputs, targets mat.Matrix) {
+	elementwise(dst, outputs, targets, func(y, t float64) float64 {
+		y = math.Min(math.Max(y, 1e-15), 1-1e-15)
+		return (y - t) / (y * (1 - y))
+	})
+}
+
+func (BinaryCrossEntropy) Name() string {
+	return "binary-cross-entropy"
+}
+
+// Huber is squared error for differences up to Delta and absolute error
+// beyond, so that outliers pull on the network less than with MSE. Delta
+// defaults to 1 when left as zero.
+type Huber struct {
+	Delta float64
+}
+
+func (h Huber) Value(outputs, targets mat.Matrix) float64 {
+	delta := orDefault(h.Delta, 1)
+	return mean(outputs, targets, func(y, t float64) float64 {
+		d := math.Abs(y - t)
+		if d <= delta {
+			return d * d / 2
+		}
+		return delta * (d - delta/2)
+	})
+}
+
+func (h Huber) Gradient(dst *mat.Dense, outputs, targets mat.Matrix) {
+	delta := orDefault(h.Delta, 1)
+	elementwise(dst, outputs, targets, func(y, t float64) float64 {
+		return math.Max(-delta, math.Min(y-t, delta))
+	})
+}
+
+func (h Huber) Name() string {
+	if h.Delta == 0 || h.Delta == 1 {
+		return "huber"
+	}
+	return "huber:" + strconv.FormatFloat(h.Delta, 'g', -1, 64)
+}
+
+// fused reports whether the output activation and loss go together such
+// that the error of the output layer with respect to its weighted inputs
+// is simply outputs - targets. Working it out that way avoids dividing by
+// outputs that have saturated, and is what Softmax relies on.
+func fused(a helpers.Activation, l Loss) bool {
+	switch a.(type) {
+	case helpers.Softmax:
+		_, ok := l.(CrossEntropy)
+		return ok
+	case helpers.Sigmoid:
+		_, ok := l.(BinaryCrossEntropy)
+		return ok
+	}
+	return false
+}
+
+// mean returns the sum of f over every output and its target, divided by
+// the number of samples.
+func mean(outputs, targets mat.Matrix, f func(y, t float64) float64) float64 {
+	r, n := targets.Dims()
+	sum := 0.0
+	for i := 0; i < r; i++ {
+		for j := 0; j < n; j++ {
+			sum += f(outputs.At(i, j), targets.At(i, j))
+		}
+	}
+	return sum / float64(n)
+}
+
+// elementwise sets each element of dst to f of the output and target there.
+func elementwise(dst *mat.Dense, outputs, targets mat.Matrix, f func(y, t float64) float64) {
+	dst.Apply(func(i, j int, y float64) float64 {
+		return f(y, targets.At(i, j))
+	}, outputs)
+}
@@ -0,0 +1,231 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/blas/blas64"
+	"gonum.org/v1/gonum/mat"
+)
+
+// lstm is a long short-term memory layer. Its weights and biases have a
+// block of rows for each of its four gates, in the order input, forget,
+// candidate and output, with a row in each block for each hidden value.
+type lstm struct {
+	recurrent
+	wx, wh weightMatrix
+	biases *mat.Dense
+}
+
+// LSTM returns a long short-term memory layer taking sequences of steps
+// steps with features values each, laid out as for RNN. Alongside its state
+// of hidden values it keeps a cell of the same size, which at every step
+// the forget gate scales down and the input gate adds a candidate to, and
+// which the output gate lets through to the state. The gates let it carry
+// what it has seen across many more steps than a simple RNN. Like RNN, it
+// outputs the state after the last step, or after every step if sequences
+// is set.
+func LSTM(features, hidden, steps int, sequences bool) Layer {
+	return &lstm{
+		recurrent: newRecurrent(features, hidden, steps, sequences),
+		wx:        weightMatrix{Dense: mat.NewDense(4*hidden, features, nil)},
+		wh:        weightMatrix{Dense: mat.NewDense(4*hidden, hidden, nil)},
+		biases:    mat.NewDense(4*hidden, 1, nil),
+	}
+}
+
+func (l *lstm) Forward(s *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	if err := l.check(inputs); err != nil {
+		return nil, err
+	}
+	_, n := inputs.Dims()
+	// gates holds the gates of every step, c the cell and tc its tanh, and
+	// h the state
+	gates := s.Matrix(0, l.steps*4*l.hidden, n)
+	c := s.Matrix(1, l.steps*l.hidden, n)
+	tc := s.Matrix(2, l.steps*l.hidden, n)
+	h := s.Matrix(3, l.steps*l.hidden, n)
+	weighted := s.Matrix(4, 4*l.hidden, n)
+	for t := 0; t < l.steps; t++ {
+		a := step(gates, t, 4*l.hidden)
+		if err := l.wx.product(s, a, false, step(inputs, t, l.features)); err != nil {
+			return nil, err
+		}
+		if t > 0 {
+			if err := l.wh.product(s, weighted, false, step(h, t-1, l.hidden)); err != nil {
+				return nil, err
+			}
+			if err := helpers.AddTo(a, a, weighted); err != nil {
+				return nil, err
+			}
+		}
+		if err := helpers.AddColumnTo(a, a, l.biases); err != nil {
+			return nil, err
+		}
+
+		g, ct, tct, ht := a.RawMatrix(), step(c, t, l.hidden).RawMatrix(), step(tc, t, l.hidden).RawMatrix(), step(h, t, l.hidden).RawMatrix()
+		var prev blas64.General
+		if t > 0 {
+			prev = step(c, t-1, l.hidden).RawMatrix()
+		}
+		for k := 0; k < l.hidden; k++ {
+			for j := 0; j < n; j++ {
+				in := &g.Data[k*g.Stride+j]
+				forget := &g.Data[(l.hidden+k)*g.Stride+j]
+				candidate := &g.Data[(2*l.hidden+k)*g.Stride+j]
+				out := &g.Data[(3*l.hidden+k)*g.Stride+j]
+				*in, *forget, *candidate, *out = sigmoid(*in), sigmoid(*forget), math.Tanh(*candidate), sigmoid(*out)
+				cell := *in * *candidate
+				if t > 0 {
+					cell += *forget * prev.Data[k*prev.Stride+j]
+				}
+				ct.Data[k*ct.Stride+j] = cell
+				tct.Data[k*tct.Stride+j] = math.Tanh(cell)
+				ht.Data[k*ht.Stride+j] = *out * tct.Data[k*tct.Stride+j]
+			}
+		}
+	}
+	return l.output(h), nil
+}
+
+// Backward works back from the last step to the first, passing the
+// gradients of each state and cell on to the step before.
+func (l *lstm) Backward(s *Scratch, grad *mat.Dense, grads []*mat.Dense) (*mat.Dense, error) {
+	_, n := grad.Dims()
+	wxGrad, whGrad, biasGrad := grads[0], grads[1], grads[2]
+	wxGrad.Zero()
+	whGrad.Zero()
+	biasGrad.Zero()
+	inputs := s.Inputs()
+	gates := s.Matrix(0, l.steps*4*l.hidden, n)
+	c := s.Matrix(1, l.steps*l.hidden, n)
+	tc := s.Matrix(2, l.steps*l.hidden, n)
+	h := s.Matrix(3, l.steps*l.hidden, n)
+	// dh and dc hold the gradients of the state and cell of the step being
+	// worked out, and da those of the weighted inputs of its gates
+	dh := s.Matrix(5, l.hidden, n)
+	dc := s.Matrix(6, l.hidden, n)
+	da := s.Matrix(7, 4*l.hidden, n)
+	wxStep := s.Matrix(8, 4*l.hidden, l.features)
+	whStep := s.Matrix(9, 4*l.hidden, l.hidden)
+	biasStep := s.Matrix(10, 4*l.hidden, 1)
+	var errors *mat.Dense
+	if s.InputGradient() {
+		errors = s.Matrix(11, l.steps*l.features, n)
+	}
+
+	dh.Zero()
+	dc.Zero()
+	for t := l.steps - 1; t >= 0; t-- {
+		if l.sequences {
+			if err := helpers.AddTo(dh, dh, step(grad, t, l.hidden)); err != nil {
+				return nil, err
+			}
+		} else if t == l.steps-1 {
+			dh.Copy(grad)
+		}
+
+		g, tct, d := step(gates, t, 4*l.hidden).RawMatrix(), step(tc, t, l.hidden).RawMatrix(), da.RawMatrix()
+		dhr, dcr := dh.RawMatrix(), dc.RawMatrix()
+		var prev blas64.General
+		if t > 0 {
+			prev = step(c, t-1, l.hidden).RawMatrix()
+		}
+		for k := 0; k < l.hidden; k++ {
+			for j := 0; j < n; j++ {
+				in := g.Data[k*g.Stride+j]
+				forget := g.Data[(l.hidden+k)*g.Stride+j]
+				candidate := g.Data[(2*l.hidden+k)*g.Stride+j]
+				out := g.Data[(3*l.hidden+k)*g.Stride+j]
+				tanhCell := tct.Data[k*tct.Stride+j]
+				dState := dhr.Data[k*dhr.Stride+j]
+				dCell := dcr.Data[k*dcr.Stride+j] + dState*out*(1-tanhCell*tanhCell)
+				prevCell := 0.0
+				if t > 0 {
+					prevCell = prev.Data[k*prev.Stride+j]
+				}
+				d.Data[k*d.Stride+j] = dCell * candidate * in * (1 - in)
+				d.Data[(l.hidden+k)*d.Stride+j] = dCell * prevCell * forget * (1 - forget)
+				d.Data[(2*l.hidden+k)*d.Stride+j] = dCell * in * (1 - candidate*candidate)
+				d.Data[(3*l.hidden+k)*d.Stride+j] = dState * tanhCell * out * (1 - out)
+				// the forget gate carries the cell over to the step before
+				dcr.Data[k*dcr.Stride+j] = dCell * forget
+			}
+		}
+
+		if err := outerProduct(s, l.wx.precision, wxStep, da, step(inputs, t, l.features)); err != nil {
+			return nil, err
+		}
+		if err := helpers.AddTo(wxGrad, wxGrad, wxStep); err != nil {
+			return nil, err
+		}
+		// multiplying by a column of 1/n averages the deltas over the batch
+		if err := helpers.DotTo(biasStep, da, s.ones(n)); err != nil {
+			return nil, err
+		}
+		if err := helpers.AddTo(biasGrad, biasGrad, biasStep); err != nil {
+			return nil, err
+		}
+		if errors != nil {
+			if err := l.wx.product(s, step(errors, t, l.features), true, da); err != nil {
+				return nil, err
+			}
+		}
+		if t == 0 {
+			break
+		}
+		if err := outerProduct(s, l.wh.precision, whStep, da, step(h, t-1, l.hidden)); err != nil {
+			return nil, err
+		}
+		if err := helpers.AddTo(whGrad, whGrad, whStep); err != nil {
+			return nil, err
+		}
+		if err := l.wh.product(s, dh, true, da); err != nil {
+			return nil, err
+		}
+	}
+	if err := helpers.ScaleTo(wxGrad, 1/float64(n), wxGrad); err != nil {
+		return nil, err
+	}
+	return errors, helpers.ScaleTo(whGrad, 1/float64(n), whGrad)
+}
+
+func (l *lstm) Params() []*mat.Dense {
+	return []*mat.Dense{l.wx.Dense, l.wh.Dense, l.biases}
+}
+
+func (l *lstm) clone() Layer {
+	return &lstm{recurrent: l.recurrent, wx: l.wx.clone(), wh: l.wh.clone(), biases: mat.DenseCopyOf(l.biases)}
+}
+
+// init starts the forget gate biases at 1, so that the cell is carried
+// over from step to step until training learns otherwise.
+func (l *lstm) init(i Initializer, rng *rand.Rand) {
+	i.Init(l.wx.Dense, rng)
+	i.Init(l.wh.Dense, rng)
+	for k := l.hidden; k < 2*l.hidden; k++ {
+		l.biases.Set(k, 0, 1)
+	}
+}
+
+func (l *lstm) weights() []int {
+	return []int{0, 1}
+}
+
+func (l *lstm) sync(p Precision) {
+	l.wx.sync(p, l.biases)
+	l.wh.sync(p, l.biases)
+}
+
+func (l *lstm) spec() string {
+	return l.recurrent.spec("lstm")
+}
+
+func (l *lstm) paramNames() []string {
+	return []string{"input weights", "recurrent weights", "biases"}
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
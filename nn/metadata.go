@@ -0,0 +1,136 @@
+package nn
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Metadata describes how a model was made. It is saved in the model file
+// along with the network, but has no effect on it. Fields left as their
+// zero value are not known.
+type Metadata struct {
+	// Created is when the model was saved.
+	Created time.Time
+	// Dataset is the name of the dataset the model was trained on, and
+	// DatasetHash a SHA-256 hash of its files, in hex.
+	Dataset     string
+	DatasetHash string
+	// Epochs is the number of epochs the model was trained for.
+	Epochs int
+	// TrainAccuracy and ValAccuracy are the final accuracies on the
+	// training and validation data, between 0 and 1.
+	TrainAccuracy float64
+	ValAccuracy   float64
+	// Commit is the version control revision of the program that trained
+	// the model.
+	Commit string
+	// Comment is anything else worth noting about the model.
+	Comment string
+}
+
+// Metadata returns the metadata of the network, as loaded from its model
+// file or set by SetMetadata.
+func (net Network) Metadata() Metadata {
+	return net.metadata
+}
+
+// SetMetadata sets the metadata saved with the network.
+func (net *Network) SetMetadata(m Metadata) {
+	net.metadata = m
+}
+
+// IsZero reports whether nothing is known about the model.
+func (m Metadata) IsZero() bool {
+	return m == Metadata{}
+}
+
+// fields returns the fields of m that are known, as pairs of keys and
+// values, in the order they are saved in.
+func (m Metadata) fields() [][2]string {
+	var fields [][2]string
+	add := func(key, value string, known bool) {
+		if known {
+			fields = append(fields, [2]string{key, value})
+		}
+	}
+	add("created", m.Created.UTC().Format(time.RFC3339), !m.Created.IsZero())
+	add("dataset", m.Dataset, m.Dataset != "")
+	add("dataset_hash", m.DatasetHash, m.DatasetHash != "")
+	add("epochs", strconv.Itoa(m.Epochs), m.Epochs != 0)
+	add("train_accuracy", strconv.FormatFloat(m.TrainAccuracy, 'g', -1, 64), m.TrainAccuracy != 0)
+	add("val_accuracy", strconv.FormatFloat(m.ValAccuracy, 'g', -1, 64), m.ValAccuracy != 0)
+	add("commit", m.Commit, m.Commit != "")
+	add("comment", m.Comment, m.Comment != "")
+	return fields
+}
+
+// setField sets the field of m with the given key, as saved by fields.
+// Keys it does not know, from newer versions, are skipped.
+func (m *Metadata) setField(key, value string) error {
+	var err error
+	switch key {
+	case "created":
+		m.Created, err = time.Parse(time.RFC3339, value)
+	case "dataset":
+		m.Dataset = value
+	case "dataset_hash":
+		m.DatasetHash = value
+	case "epochs":
+		m.Epochs, err = strconv.Atoi(value)
+	case "train_accuracy":
+		m.TrainAccuracy, err = strconv.ParseFloat(value, 64)
+	case "val_accuracy":
+		m.ValAccuracy, err = strconv.ParseFloat(value, 64)
+	case "commit":
+		m.Commit = value
+	case "comment":
+		m.Comment = value
+	}
+	if err != nil {
+		return ErrBadModel
+	}
+	return nil
+}
+
+// writeMetadata writes m to a model file as the number of fields known
+// followed by the key and value of each.
+func writeMetadata(w io.Writer, m Metadata) error {
+	fields := m.fields()
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(fields))); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := writeString(w, f[0]); err != nil {
+			return err
+		}
+		if err := writeString(w, f[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMetadata reads metadata written by writeMetadata.
+func readMetadata(r io.Reader) (Metadata, error) {
+	var m Metadata
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil || n > 1<<10 {
+		return m, ErrBadModel
+	}
+	for i := uint32(0); i < n; i++ {
+		key, err := readString(r)
+		if err != nil {
+			return m, ErrBadModel
+		}
+		value, err := readString(r)
+		if err != nil {
+			return m, ErrBadModel
+		}
+		if err := m.setField(key, value); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
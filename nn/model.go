@@ -0,0 +1,732 @@
+package nn
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// A model file starts with a header describing the architecture of the
+// network, followed by the parameters of each layer:
+//
+//	magic       [4]byte  "MLNN"
+//	version     uint32
+//	precision   uint32   bits per weight, 64 or 32, from version 2
+//	layers      uint32   number of layers
+//	specs       [layers]string, each a uint32 length then the bytes
+//	loss        string
+//	metadata    uint32 number of fields, then the key and value strings of
+//	            each, such as "epochs" and "10", from version 7
+//	parameters of each layer, such as its weights and then its biases
+//	checksum    [32]byte SHA-256 hash of everything before it, from version 8
+//
+// A layer is described by its spec, such as "dense:784:200" or "relu".
+// Dropout is left out, as it only matters while training. Before version 6
+// the header described a network made by CreateNetwork instead:
+//
+//	layers      uint32   number of layer sizes
+//	sizes       [layers]uint32
+//	activations [layers-1]string
+//	loss        string, from version 3
+//	convs       uint32   number of convolutional layers, from version 4
+//	input       [3]uint32 channels, height and width of the input images,
+//	                     if there are convolutional layers
+//	conv        [convs][4]uint32 filters, kernel, stride and padding, then
+//	                     from version 5 the pooling, 0 for none, 1 for max
+//	                     and 2 for average, and its kernel and stride
+//
+// float64 parameters are in the gonum binary matrix format, and float32
+// ones are the number of rows and columns as uint32s followed by the
+// elements row by row. All numbers are little endian.
+const (
+	modelMagic   = "MLNN"
+	modelVersion = 8
+)
+
+// ErrBadModel is returned when a model file is not in the expected format.
+var ErrBadModel = errors.New("nn: not a model file")
+
+// ErrCorruptModel is returned when a model file ends early, does not match
+// its checksum, has sizes in its header too big for the file, or has a
+// checksum but cannot be read.
+var ErrCorruptModel = errors.New("nn: model file is truncated or corrupted")
+
+// Save writes the network to the model file at path. The file is written in
+// full and synced to disk before replacing any existing one, so neither
+// anything reading the model nor a crash part way through ever leaves it
+// half written.
+func (net Network) Save(path string) error {
+	return writeFile(path, net.SaveTo)
+}
+
+// writeFile calls write to write a file at path, through a temporary file
+// that is synced to disk and renamed into place once it is complete.
+func writeFile(path string, write func(w io.Writer) error) error {
+	// a temporary file of its own, so saves of the same path at once do not
+	// write over each other before the rename
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// CreateTemp leaves the file readable by its owner alone
+	err = f.Chmod(0644)
+	w := bufio.NewWriter(f)
+	if err == nil {
+		err = write(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		// without this the rename can reach the disk before the data, and
+		// a crash leave an empty or partial file behind
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir syncs the directory at path to disk, so that a file renamed into
+// it stays renamed after a crash. This is as far as it goes: some systems
+// and file systems cannot sync a directory, so errors are ignored.
+func syncDir(path string) {
+	if d, err := os.Open(path); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Load reads the model file at path into the network. It returns an error
+// if the architecture stored in the file does not match the network.
+func (net *Network) Load(path string) error {
+	f, r, err := openModel(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return net.LoadFrom(r)
+}
+
+// SaveTo writes the network in the model file format to w. It fails if the
+// network has a layer from outside this package, which cannot be recreated
+// from a model file.
+func (net Network) SaveTo(w io.Writer) error {
+	sum := sha256.New()
+	if err := net.writeModel(io.MultiWriter(w, sum)); err != nil {
+		return err
+	}
+	_, err := w.Write(sum.Sum(nil))
+	return err
+}
+
+// writeModel writes the network in the model file format to w, but for the
+// checksum.
+func (net Network) writeModel(w io.Writer) error {
+	specs := net.specs()
+	for i, l := range net.layers {
+		if _, ok := l.(specified); !ok {
+			return fmt.Errorf("nn: layer %d of type %T cannot be saved", i+1, l)
+		}
+	}
+	header := []interface{}{
+		[]byte(modelMagic),
+		uint32(modelVersion),
+		net.precision.bits(),
+		uint32(len(specs)),
+	}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	for _, spec := range append(specs, net.loss.Name()) {
+		if err := writeString(w, spec); err != nil {
+			return err
+		}
+	}
+	if err := writeMetadata(w, net.metadata); err != nil {
+		return err
+	}
+
+	for _, m := range net.params() {
+		var err error
+		if net.precision == Float32 {
+			err = writeMatrix32(w, m)
+		} else {
+			_, err = m.MarshalBinaryTo(w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// specs returns the spec of each layer of the network, leaving out dropout.
+func (net Network) specs() []string {
+	var specs []string
+	for _, l := range net.layers {
+		if _, ok := l.(*dropout); !ok {
+			specs = append(specs, layerName(l))
+		}
+	}
+	return specs
+}
+
+// LoadFrom reads a network in the model file format from r. The weights are
+// converted to the precision of the network if the model was saved in
+// another, and the network keeps its own loss and dropout but takes the
+// metadata of the model. The network is left unchanged if an error is
+// returned.
+func (net *Network) LoadFrom(r io.Reader) error {
+	cr := newChecksumReader(r)
+	h, err := readHeader(cr)
+	if err != nil {
+		return h.corrupt(err)
+	}
+	specs := net.specs()
+	if len(h.layers) != len(specs) {
+		return fmt.Errorf("nn: model has %d layers, network has %d", len(h.layers), len(specs))
+	}
+	for i, spec := range specs {
+		if h.layers[i] != spec {
+			return fmt.Errorf("nn: model layer %d is %s, network layer is %s", i+1, h.layers[i], spec)
+		}
+	}
+
+	params, err := readParams(cr, net.layers, h.precision)
+	if err != nil {
+		return h.corrupt(err)
+	}
+	if err := cr.verify(h.version); err != nil {
+		return err
+	}
+	for i, p := range net.params() {
+		p.Copy(params[i])
+	}
+	net.syncParams()
+	net.metadata = h.metadata
+	return nil
+}
+
+// LoadNetwork reads the model file at path into a new network with the
+// architecture stored in the file. Options are applied as for CreateNetwork.
+func LoadNetwork(path string, opts ...Option) (Network, error) {
+	f, r, err := openModel(path)
+	if err != nil {
+		return Network{}, err
+	}
+	defer f.Close()
+	return ReadNetwork(r, opts...)
+}
+
+// ReadNetwork reads a network in the model file format from r, creating it
+// with the architecture, precision and loss stored in the header. The
+// learning rate is left at zero, so a WithLearningRate option is needed to
+// carry on training.
+func ReadNetwork(r io.Reader, opts ...Option) (Network, error) {
+	net, _, err := readNetwork(r, opts)
+	return net, err
+}
+
+// cloner is a layer that Clone can copy, sharing nothing with the copy that
+// changes. Layers without parameters that are not clonable are shared.
+type cloner interface {
+	clone() Layer
+}
+
+// Clone returns a copy of the network that shares none of its parameters,
+// optimizer state or working matrices, such as to train while the original
+// carries on serving predictions. The copy keeps every other setting, and
+// is seeded afresh without drawing on the random numbers of the original,
+// so it is safe to call while the original is in use. A network with
+// layers from outside the package that have parameters cannot be cloned.
+func (net Network) Clone() (Network, error) {
+	clone := net
+	clone.layers = make([]Layer, len(net.layers))
+	for i, l := range net.layers {
+		if c, ok := l.(cloner); ok {
+			clone.layers[i] = c.clone()
+			continue
+		}
+		if len(l.Params()) > 0 {
+			return Network{}, fmt.Errorf("nn: cannot clone layer %d (%s)", i+1, layerName(l))
+		}
+		clone.layers[i] = l
+	}
+	clone.sizes = append([]int(nil), net.sizes...)
+	clone.freeze = append([]int(nil), net.freeze...)
+	if err := clone.freezeLayers(clone.freeze); err != nil {
+		return Network{}, err
+	}
+	clone.optimizer = cloneOptimizer(net.optimizer)
+	clone.rng = rand.New(rand.NewSource(rand.Int63()))
+	clone.syncParams()
+	clone.workspaces = newWorkspacePool(clone.layers)
+	return clone, nil
+}
+
+// readNetwork reads a network as ReadNetwork does, along with the header of
+// its model file.
+func readNetwork(r io.Reader, opts []Option) (Network, header, error) {
+	cr := newChecksumReader(r)
+	h, err := readHeader(cr)
+	if err != nil {
+		return Network{}, h, h.corrupt(err)
+	}
+	if err := h.fits(remaining(r)); err != nil {
+		return Network{}, h, err
+	}
+	net, err := h.network(opts)
+	if err != nil {
+		return Network{}, h, h.corrupt(err)
+	}
+	params, err := readParams(cr, net.layers, h.precision)
+	if err != nil {
+		return Network{}, h, h.corrupt(err)
+	}
+	if err := cr.verify(h.version); err != nil {
+		return Network{}, h, err
+	}
+	for i, p := range net.params() {
+		p.Copy(params[i])
+	}
+	net.syncParams()
+	net.metadata = h.metadata
+	return net, h, nil
+}
+
+// header is the description of a network at the start of a model file.
+type header struct {
+	version   int
+	precision Precision
+	// layers holds the spec of each layer.
+	layers []string
+	// loss is empty for models from before version 3, which were trained
+	// with the default loss.
+	loss string
+	// metadata is empty for models from before version 7.
+	metadata Metadata
+}
+
+// network returns a new network with the architecture, precision and loss
+// described by the header, and its parameters left for the caller to set.
+func (h header) network(opts []Option) (Network, error) {
+	layers := make([]Layer, len(h.layers))
+	for i, spec := range h.layers {
+		var err error
+		if layers[i], err = layerBySpec(spec); err != nil {
+			return Network{}, fmt.Errorf("nn: model layer %d: %w", i+1, err)
+		}
+	}
+	if _, err := layerSizes(layers); err != nil {
+		return Network{}, fmt.Errorf("nn: model: %w", err)
+	}
+	fileOpts := []Option{WithPrecision(h.precision)}
+	if h.loss != "" {
+		loss, err := LossByName(h.loss)
+		if err != nil {
+			return Network{}, err
+		}
+		fileOpts = append(fileOpts, WithLoss(loss))
+	}
+	net := newNetwork(0, append(fileOpts, opts...))
+	net.build(layers)
+	return net, nil
+}
+
+// corrupt returns err, from reading the model file the header starts, as
+// ErrCorruptModel if the file has a checksum, as from version 8. Such a file
+// was written whole, so anything in it that cannot be read means it has
+// since been cut short or damaged.
+func (h header) corrupt(err error) error {
+	if h.version < 8 || errors.Is(err, ErrCorruptModel) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrCorruptModel, err)
+}
+
+// fits returns ErrCorruptModel if the parameters of the layers in the
+// header take more than the left bytes still to read of the file, so that
+// sizes in a damaged header are caught before anything is allocated for
+// them. A negative left means the length of the file is not known.
+func (h header) fits(left int64) error {
+	if left < 0 {
+		return nil
+	}
+	params := 0.0
+	for _, spec := range h.layers {
+		params += specParams(spec)
+	}
+	size := params * 8
+	if h.precision == Float32 {
+		size = params * 4
+	}
+	if size > float64(left) {
+		return fmt.Errorf("%w: its layers take %.0f bytes but %d are left", ErrCorruptModel, size, left)
+	}
+	return nil
+}
+
+// remaining returns how many bytes are left to read from r, or -1 if that
+// is not known, as for a reader that is not from openModel.
+func remaining(r io.Reader) int64 {
+	switch r := r.(type) {
+	case *io.LimitedReader:
+		return r.N
+	case interface{ Len() int }:
+		return int64(r.Len())
+	}
+	return -1
+}
+
+// openModel opens the model file at path. The reader returned keeps count
+// of how much of the file is left, to check the header against, if it is
+// a regular file.
+func openModel(path string) (*os.File, io.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return f, bufio.NewReader(f), nil
+	}
+	return f, &io.LimitedReader{R: bufio.NewReader(f), N: fi.Size()}, nil
+}
+
+// readHeader reads the header of a model file.
+func readHeader(r io.Reader) (header, error) {
+	var h header
+	magic := make([]byte, len(modelMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != modelMagic {
+		return h, ErrBadModel
+	}
+	var version, layers uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return h, ErrBadModel
+	}
+	if version < 1 || version > modelVersion {
+		return h, fmt.Errorf("nn: unsupported model version %d", version)
+	}
+	h.version = int(version)
+	h.precision = Float64
+	if version >= 2 {
+		var bits uint32
+		if err := binary.Read(r, binary.LittleEndian, &bits); err != nil {
+			return h, ErrBadModel
+		}
+		switch bits {
+		case 64:
+		case 32:
+			h.precision = Float32
+		default:
+			return h, fmt.Errorf("nn: unsupported model precision of %d bits", bits)
+		}
+	}
+	if version < 6 {
+		return readLegacyHeader(r, h)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &layers); err != nil || layers < 1 || layers > 1<<12 {
+		return h, ErrBadModel
+	}
+	h.layers = make([]string, layers)
+	for i := range h.layers {
+		var err error
+		if h.layers[i], err = readString(r); err != nil {
+			return h, ErrBadModel
+		}
+	}
+	var err error
+	if h.loss, err = readString(r); err != nil {
+		return h, ErrBadModel
+	}
+	if version >= 7 {
+		if h.metadata, err = readMetadata(r); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// readLegacyHeader reads the rest of the header of a model file from before
+// version 6, working out the layers of the network made by CreateNetwork
+// that it describes.
+func readLegacyHeader(r io.Reader, h header) (header, error) {
+	var layers uint32
+	if err := binary.Read(r, binary.LittleEndian, &layers); err != nil || layers < 2 || layers > 1<<10 {
+		return h, ErrBadModel
+	}
+	raw := make([]uint32, layers)
+	if err := binary.Read(r, binary.LittleEndian, raw); err != nil {
+		return h, ErrBadModel
+	}
+	sizes := make([]int, layers)
+	for i, s := range raw {
+		if s == 0 {
+			return h, ErrBadModel
+		}
+		sizes[i] = int(s)
+	}
+	activations := make([]string, layers-1)
+	for i := range activations {
+		var err error
+		if activations[i], err = readString(r); err != nil {
+			return h, ErrBadModel
+		}
+	}
+	if h.version >= 3 {
+		var err error
+		if h.loss, err = readString(r); err != nil {
+			return h, ErrBadModel
+		}
+	}
+	var input Shape
+	var convs []Conv2D
+	if h.version >= 4 {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil || n >= layers {
+			return h, ErrBadModel
+		}
+		if n > 0 {
+			var in [3]uint32
+			if err := binary.Read(r, binary.LittleEndian, &in); err != nil {
+				return h, ErrBadModel
+			}
+			input = Shape{Channels: int(in[0]), Height: int(in[1]), Width: int(in[2])}
+			fields := 4
+			if h.version >= 5 {
+				fields = 7
+			}
+			raw := make([]uint32, int(n)*fields)
+			if err := binary.Read(r, binary.LittleEndian, raw); err != nil {
+				return h, ErrBadModel
+			}
+			convs = make([]Conv2D, n)
+			for i := range convs {
+				c := make([]uint32, 7)
+				copy(c, raw[i*fields:(i+1)*fields])
+				for _, v := range c {
+					if v > 1<<16 {
+						return h, ErrBadModel
+					}
+				}
+				if c[0] == 0 || c[1] == 0 || c[2] == 0 || c[4] > uint32(AvgPooling) || c[4] != 0 && (c[5] == 0 || c[6] == 0) {
+					return h, ErrBadModel
+				}
+				convs[i] = Conv2D{
+					Filters: int(c[0]), Kernel: int(c[1]), Stride: int(c[2]), Padding: int(c[3]),
+					Pool: Pool2D{Type: Pooling(c[4]), Kernel: int(c[5]), Stride: int(c[6])},
+				}
+			}
+			if input.Channels == 0 || input.Size() != sizes[0] {
+				return h, ErrBadModel
+			}
+			shape := input
+			for i, c := range convs {
+				if conv := c.convolved(shape); conv.Height <= 0 || conv.Width <= 0 {
+					return h, ErrBadModel
+				}
+				if shape = c.Output(shape); shape.Height <= 0 || shape.Width <= 0 || shape.Size() != sizes[i+1] {
+					return h, ErrBadModel
+				}
+			}
+		}
+	}
+
+	shape := input
+	for i, a := range activations {
+		if i >= len(convs) {
+			h.layers = append(h.layers, denseSpec(sizes[i], sizes[i+1]), a)
+			continue
+		}
+		c := convs[i]
+		pool := c.Pool
+		c.Pool = Pool2D{}
+		h.layers = append(h.layers, convSpec(shape, c), a)
+		shape = c.convolved(shape)
+		if pool.Type != NoPooling {
+			h.layers = append(h.layers, layerName(Pool(shape, pool)))
+			shape = pool.output(shape)
+		}
+	}
+	return h, nil
+}
+
+// readParams reads the parameters of each of the layers in the given
+// precision, checking they have the shapes the layers need before
+// allocating anything for them.
+func readParams(r io.Reader, layers []Layer, precision Precision) ([]*mat.Dense, error) {
+	var params []*mat.Dense
+	for i, l := range layers {
+		for j, p := range l.Params() {
+			m := &mat.Dense{}
+			pr, pc := p.Dims()
+			var err error
+			if precision == Float32 {
+				err = readMatrix32(r, m, pr, pc)
+			} else {
+				err = readMatrix64(r, m, pr, pc)
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("%w: %s of layer %d cut short", ErrCorruptModel, paramName(l, j), i+1)
+			}
+			if err == errWrongShape {
+				return nil, fmt.Errorf("nn: model layer %d has the wrong shape", i+1)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("nn: reading %s of layer %d: %w", paramName(l, j), i+1, err)
+			}
+			params = append(params, m)
+		}
+	}
+	return params, nil
+}
+
+// errWrongShape is returned by readMatrix32 and readMatrix64 for a matrix
+// that is not the shape asked for.
+var errWrongShape = errors.New("nn: wrong shape")
+
+// readMatrix64 reads a matrix in the gonum binary matrix format into the
+// empty matrix m. It returns errWrongShape, before reading the elements, if
+// the matrix is not rows by cols.
+func readMatrix64(r io.Reader, m *mat.Dense, rows, cols int) error {
+	// the header gonum writes before the elements
+	var h struct {
+		Version             uint32
+		Form, Packing, Uplo byte
+		Unit                bool
+		Rows, Cols, KU, KL  int64
+	}
+	n := binary.Size(h)
+	buf := make([]byte, n+rows*cols*8)
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return err
+	}
+	binary.Read(bytes.NewReader(buf[:n]), binary.LittleEndian, &h)
+	if h.Rows != int64(rows) || h.Cols != int64(cols) {
+		return errWrongShape
+	}
+	if _, err := io.ReadFull(r, buf[n:]); err != nil {
+		return err
+	}
+	return m.UnmarshalBinary(buf)
+}
+
+// checksumReader hashes everything read through it, to check against the
+// checksum at the end of a model file.
+type checksumReader struct {
+	r   io.Reader
+	sum hash.Hash
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{r: r, sum: sha256.New()}
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.sum.Write(p[:n])
+	return n, err
+}
+
+// verify reads the checksum at the end of a model file of the given version
+// and checks it against the hash of what has been read. Models from before
+// version 8 have no checksum.
+func (cr *checksumReader) verify(version int) error {
+	if version < 8 {
+		return nil
+	}
+	want := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(cr.r, want); err != nil || !bytes.Equal(cr.sum.Sum(nil), want) {
+		return ErrCorruptModel
+	}
+	return nil
+}
+
+// writeMatrix32 writes m with its elements rounded to float32.
+func writeMatrix32(w io.Writer, m *mat.Dense) error {
+	r, c := m.Dims()
+	if err := binary.Write(w, binary.LittleEndian, [2]uint32{uint32(r), uint32(c)}); err != nil {
+		return err
+	}
+	data := make([]float32, 0, r*c)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			data = append(data, float32(m.At(i, j)))
+		}
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
+
+// readMatrix32 reads a matrix written by writeMatrix32 into the empty
+// matrix m. It returns errWrongShape, before reading the elements, if the
+// matrix is not rows by cols.
+func readMatrix32(r io.Reader, m *mat.Dense, rows, cols int) error {
+	var dims [2]uint32
+	if err := binary.Read(r, binary.LittleEndian, &dims); err != nil {
+		return err
+	}
+	if int(dims[0]) != rows || int(dims[1]) != cols {
+		return errWrongShape
+	}
+	data := make([]float32, rows*cols)
+	if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+		return err
+	}
+	m.ReuseAs(rows, cols)
+	raw := m.RawMatrix()
+	for i, v := range data {
+		raw.Data[i] = float64(v)
+	}
+	return nil
+}
+
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	if n > 1<<16 {
+		return "", ErrBadModel
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
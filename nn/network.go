@@ -0,0 +1,1033 @@
+// Package nn implements a simple feed-forward neural network trained with
+// backpropagation.
+package nn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Network is a neural network made of a stack of layers, such as fully
+// connected layers each followed by an activation function, the first of
+// which may be convolutional.
+//
+// Running a network never changes it, so Predict, PredictBatch, Classify
+// and Loss may be called from many goroutines at once, each working in
+// matrices of its own. Training does change it, so must not run at the
+// same time as anything else; train a Clone to keep serving the original.
+type Network struct {
+	layers []Layer
+	// sizes holds the number of inputs followed by the number of outputs
+	// of each layer with parameters.
+	sizes        []int
+	loss         Loss
+	optimizer    Optimizer
+	scheduler    Scheduler
+	learningRate float64
+	rate         float64
+	workers      int
+	precision    Precision
+	rng          *rand.Rand
+	initializer  Initializer
+	// warmup is the number of mini-batches to ramp the learning rate up
+	// over, and steps the number trained on so far.
+	warmup, steps int
+	// layerRates scales the learning rate of the layers given by
+	// WithLayerRates.
+	layerRates map[int]float64
+	// dropout holds the dropout rate of each hidden layer, or nil for none.
+	dropout []float64
+	// l1 and l2 weigh the penalties on the weights added to the loss.
+	l1, l2 float64
+	// smoothing is the label smoothing applied to the training targets.
+	smoothing float64
+	// convInput and convs hold the convolutional layers CreateNetwork
+	// starts the network with.
+	convInput Shape
+	convs     []Conv2D
+	// layout, hidden and output hold the layer sizes and activations New
+	// makes the network with.
+	layout         []int
+	hidden, output helpers.Activation
+	// metadata describes how the network was made.
+	metadata Metadata
+	// finiteCheck looks for NaNs and infinities while training.
+	finiteCheck bool
+	// freeze holds the layers given by WithFrozen, and frozen marks each
+	// of them among the layers.
+	freeze []int
+	frozen []bool
+	// workspaces holds the matrices for passes over a mini-batch, shared
+	// by every copy of the network.
+	workspaces *sync.Pool
+}
+
+// Option configures optional behaviour of a network at creation time.
+type Option func(*Network)
+
+// WithOptimizer sets the optimizer used to update the weights and biases
+// during training. The default is plain SGD.
+func WithOptimizer(o Optimizer) Option {
+	return func(net *Network) {
+		net.optimizer = o
+	}
+}
+
+// WithLearningRate sets the base learning rate, overriding the one given to
+// CreateNetwork or the DefaultLearningRate of New.
+func WithLearningRate(rate float64) Option {
+	return func(net *Network) {
+		net.learningRate = rate
+		net.rate = rate
+	}
+}
+
+// WithScheduler sets the learning rate schedule consulted by SetEpoch. The
+// default keeps the learning rate constant.
+func WithScheduler(s Scheduler) Option {
+	return func(net *Network) {
+		net.scheduler = s
+	}
+}
+
+// WithWarmup ramps the learning rate up linearly over the first steps
+// mini-batches of training, from a share of the rate given by the
+// schedule to all of it, which steadies the first steps of training with
+// large batches or Adam. The default is no warm-up.
+func WithWarmup(steps int) Option {
+	return func(net *Network) {
+		net.warmup = steps
+	}
+}
+
+// WithLayerRates scales the learning rate of some of the layers, each by
+// the factor given for it, such as a low rate for the pretrained layers of
+// a network being fine-tuned and a high one for its new output layer. The
+// layers are counted as for WithFrozen, and the rest keep the rate as it
+// is. Training fails if there is no such layer, or if the optimizer is not
+// a ScaledOptimizer.
+func WithLayerRates(rates map[int]float64) Option {
+	return func(net *Network) {
+		net.layerRates = rates
+	}
+}
+
+// WithWorkers splits each mini-batch between n goroutines during training.
+// Each works out the gradients for its share of the batch, and they are
+// averaged for a single optimizer step, so the result is the same as
+// training on one goroutine. PredictBatch splits its samples between them
+// too. The default is 1.
+func WithWorkers(n int) Option {
+	return func(net *Network) {
+		net.workers = n
+	}
+}
+
+// WithSeed seeds the random numbers the network is initialised with, so
+// that networks created with the same seed are identical. Without it the
+// seed is picked at random.
+func WithSeed(seed int64) Option {
+	return func(net *Network) {
+		net.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithInitializer sets how the starting weights are chosen. The default is
+// Uniform.
+func WithInitializer(i Initializer) Option {
+	return func(net *Network) {
+		net.initializer = i
+	}
+}
+
+// WithDropout randomly drops out neurons of the hidden layers while
+// training, each with the rate given for its layer, and scales up the rest
+// to make up for them. rates holds a rate for each hidden layer, or a
+// single rate for all of them. Prediction always uses every neuron.
+func WithDropout(rates ...float64) Option {
+	return func(net *Network) {
+		net.dropout = append([]float64(nil), rates...)
+	}
+}
+
+// WithWeightDecay adds an L2 penalty of lambda/2 times the sum of the
+// squared weights to the loss, which shrinks the weights by lambda times
+// their value on every step. Biases are left alone.
+func WithWeightDecay(lambda float64) Option {
+	return func(net *Network) {
+		net.l2 = lambda
+	}
+}
+
+// WithL1 adds an L1 penalty of lambda times the sum of the absolute weights
+// to the loss, which pushes small weights to zero. Biases are left alone.
+func WithL1(lambda float64) Option {
+	return func(net *Network) {
+		net.l1 = lambda
+	}
+}
+
+// CreateNetwork returns a network with the given layer sizes, from the input
+// layer through any hidden layers to the output layer, with weights set by
+// the initializer and biases set to zero. For example []int{784, 200, 10}
+// creates a network with 784 inputs, a single hidden layer of 200 neurons
+// and 10 outputs. Each layer is a Dense layer followed by its Activation, so
+// it is the same as the network NewSequential makes from them.
+//
+// activations holds the activation function of each layer after the input
+// layer, so it must have one less entry than sizes. If it is nil every layer
+// uses a sigmoid. A helpers.Softmax may only be used for the output layer,
+// in which case the network must be trained with cross-entropy loss.
+func CreateNetwork(sizes []int, activations []helpers.Activation, rate float64, opts ...Option) Network {
+	if len(sizes) < 2 {
+		panic("nn: a network needs at least an input and an output layer")
+	}
+	if activations == nil {
+		activations = make([]helpers.Activation, len(sizes)-1)
+		for i := range activations {
+			activations[i] = helpers.Sigmoid{}
+		}
+	}
+	if len(activations) != len(sizes)-1 {
+		panic(fmt.Sprintf("nn: got %d activations for %d layers", len(activations), len(sizes)-1))
+	}
+
+	net := newNetwork(rate, opts)
+	net.create(sizes, activations)
+	return net
+}
+
+// WithLayers sets the sizes of the layers of a network made by New, from
+// the input layer through any hidden layers to the output layer, as
+// CreateNetwork takes them.
+func WithLayers(sizes ...int) Option {
+	return func(net *Network) {
+		net.layout = append([]int(nil), sizes...)
+	}
+}
+
+// WithActivation sets the activation function of the hidden layers of a
+// network made by New. The default is a sigmoid.
+func WithActivation(a helpers.Activation) Option {
+	return func(net *Network) {
+		net.hidden = a
+	}
+}
+
+// WithOutputActivation sets the activation function of the output layer of
+// a network made by New, such as helpers.Softmax for cross-entropy loss.
+// The default is a sigmoid.
+func WithOutputActivation(a helpers.Activation) Option {
+	return func(net *Network) {
+		net.output = a
+	}
+}
+
+// DefaultLearningRate is the learning rate of a network made by New without
+// WithLearningRate, the default of ml train.
+const DefaultLearningRate = 0.1
+
+// New returns a network made from options alone, so that it can take new
+// settings without breaking its callers. WithLayers must be among them, and
+// the rest are as for CreateNetwork, with a learning rate of
+// DefaultLearningRate unless set WithLearningRate, so
+//
+//	nn.New(nn.WithLayers(784, 200, 10), nn.WithActivation(helpers.ReLU{}), nn.WithSeed(42))
+//
+// creates the same network as CreateNetwork with a rate of 0.1, those
+// sizes, a ReLU for the hidden layer and a sigmoid for the output layer. It
+// panics as CreateNetwork does.
+func New(opts ...Option) Network {
+	net := newNetwork(DefaultLearningRate, opts)
+	if len(net.layout) < 2 {
+		panic("nn: New needs WithLayers with at least an input and an output layer")
+	}
+	activations := make([]helpers.Activation, len(net.layout)-1)
+	for i := range activations {
+		activations[i] = net.hidden
+		if i == len(activations)-1 {
+			activations[i] = net.output
+		}
+		if activations[i] == nil {
+			activations[i] = helpers.Sigmoid{}
+		}
+	}
+	net.create(net.layout, activations)
+	return net
+}
+
+// create gives the network Dense layers of the given sizes, after any
+// convolutional layers, with their activations, as for CreateNetwork.
+func (net *Network) create(sizes []int, activations []helpers.Activation) {
+	if len(net.convs) > len(activations) {
+		panic(fmt.Sprintf("nn: got %d convolutional layers for %d layers", len(net.convs), len(activations)))
+	}
+	var layers []Layer
+	shape := net.convInput
+	for i, a := range activations {
+		if i >= len(net.convs) {
+			layers = append(layers, Dense(sizes[i], sizes[i+1]), Activation(a))
+			continue
+		}
+		c := net.convs[i]
+		c.Stride, c.Pool.Stride = c.stride(), c.Pool.stride()
+		checkConv(i+1, shape, c, sizes[i], sizes[i+1])
+		pool := c.Pool
+		c.Pool = Pool2D{}
+		layers = append(layers, Conv(shape, c), Activation(a))
+		shape = c.convolved(shape)
+		if pool.Type != NoPooling {
+			if i == len(activations)-1 {
+				panic("nn: the output layer cannot be pooled")
+			}
+			layers = append(layers, Pool(shape, pool))
+			shape = pool.output(shape)
+		}
+	}
+	net.build(layers)
+}
+
+// newNetwork returns a network with the given learning rate and options
+// applied, ready for build to give it its layers.
+func newNetwork(rate float64, opts []Option) Network {
+	net := Network{
+		optimizer:    SGD{},
+		scheduler:    ConstantRate{},
+		initializer:  Uniform{},
+		learningRate: rate,
+		rate:         rate,
+	}
+	for _, opt := range opts {
+		opt(&net)
+	}
+	if net.rng == nil {
+		net.rng = rand.New(rand.NewSource(rand.Int63()))
+	}
+	return net
+}
+
+// build gives the network its layers, adding dropout to them, and sets
+// their starting parameters. It panics if they do not fit together.
+func (net *Network) build(layers []Layer) {
+	if len(layers) == 0 {
+		panic("nn: a network needs at least one layer")
+	}
+	net.layers = append([]Layer(nil), layers...)
+	last := len(net.layers) - 1
+	for _, l := range net.layers[:last] {
+		if softmax(l) {
+			panic("nn: softmax can only be used for the output layer")
+		}
+	}
+	if net.loss == nil {
+		net.loss = MSE{}
+		if softmax(net.layers[last]) {
+			net.loss = CrossEntropy{}
+		}
+	}
+	if _, ok := net.loss.(CrossEntropy); softmax(net.layers[last]) && !ok {
+		panic(fmt.Sprintf("nn: a softmax output layer needs cross-entropy loss, not %s", net.loss.Name()))
+	}
+	if len(net.dropout) > 0 {
+		net.applyDropout()
+	}
+	sizes, err := layerSizes(net.layers)
+	if err != nil {
+		panic("nn: " + err.Error())
+	}
+	net.sizes = sizes
+	if err := net.freezeLayers(net.freeze); err != nil {
+		panic(err.Error())
+	}
+
+	for _, l := range net.layers {
+		if i, ok := l.(initialized); ok {
+			i.init(net.initializer, net.rng)
+		}
+	}
+	net.syncParams()
+	net.workspaces = newWorkspacePool(net.layers)
+}
+
+// softmax reports whether l is a softmax activation.
+func softmax(l Layer) bool {
+	a, ok := l.(activation)
+	if !ok {
+		return false
+	}
+	_, ok = a.Activation.(helpers.Softmax)
+	return ok
+}
+
+// applyDropout puts a dropout layer with the rate given by WithDropout after
+// each hidden layer, which ends with an activation and any pooling, or sets
+// the rate of the dropout layer already there.
+func (net *Network) applyDropout() {
+	// at holds the index of the layer after each hidden layer
+	var at []int
+	for i, l := range net.layers {
+		if _, ok := l.(activation); !ok {
+			continue
+		}
+		j := i + 1
+		for j < len(net.layers) {
+			if _, ok := net.layers[j].(*pool); !ok {
+				break
+			}
+			j++
+		}
+		if j < len(net.layers) {
+			at = append(at, j)
+		}
+	}
+	rates := net.dropout
+	if len(rates) == 1 && len(at) != 1 {
+		rates = make([]float64, len(at))
+		for i := range rates {
+			rates[i] = net.dropout[0]
+		}
+	} else if len(rates) != len(at) {
+		panic(fmt.Sprintf("nn: got %d dropout rates for %d hidden layers", len(rates), len(at)))
+	}
+	for i := len(at) - 1; i >= 0; i-- {
+		d := Dropout(rates[i])
+		if existing, ok := net.layers[at[i]].(*dropout); ok {
+			existing.rate = rates[i]
+			continue
+		}
+		net.layers = append(net.layers[:at[i]], append([]Layer{d}, net.layers[at[i]:]...)...)
+	}
+}
+
+// layerSizes returns the number of inputs of a network with the given
+// layers followed by the number of outputs of each layer with parameters,
+// taking in those of the layers after it without any. It returns an error
+// if a layer cannot take the outputs of the one before.
+func layerSizes(layers []Layer) ([]int, error) {
+	inputs := 0
+	for _, l := range layers {
+		if s, ok := l.(sized); ok {
+			inputs = s.inputs()
+			break
+		}
+	}
+	if inputs <= 0 {
+		return nil, errors.New("none of the layers says how many inputs the network takes")
+	}
+	sizes := []int{inputs}
+	n := inputs
+	for i, l := range layers {
+		out, err := l.Outputs(n)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i+1, err)
+		}
+		if len(l.Params()) > 0 || len(sizes) == 1 && out != n {
+			sizes = append(sizes, out)
+		} else if len(sizes) > 1 {
+			sizes[len(sizes)-1] = out
+		}
+		n = out
+	}
+	return sizes, nil
+}
+
+// params returns the parameters of every layer, layer by layer, in the
+// order the optimizer takes them.
+func (net Network) params() []*mat.Dense {
+	var params []*mat.Dense
+	for _, l := range net.layers {
+		params = append(params, l.Params()...)
+	}
+	return params
+}
+
+// Inputs returns the number of input neurons.
+func (net Network) Inputs() int {
+	return net.sizes[0]
+}
+
+// Outputs returns the number of output neurons.
+func (net Network) Outputs() int {
+	return net.sizes[len(net.sizes)-1]
+}
+
+// Sizes returns the number of neurons in each layer, from input to output.
+func (net Network) Sizes() []int {
+	return append([]int(nil), net.sizes...)
+}
+
+// SetStep tells the network how many mini-batches it has already been
+// trained on, when carrying on training it, so that any warm-up picks up
+// where it left off.
+func (net *Network) SetStep(step int) {
+	net.steps = step
+}
+
+// LearningRate returns the learning rate currently used for training,
+// before any warm-up.
+func (net Network) LearningRate() float64 {
+	return net.rate
+}
+
+// SetEpoch tells the network which zero-based epoch of training is about to
+// start, so that the learning rate, and the momentum for a
+// MomentumScheduler, can be updated from its schedule.
+func (net *Network) SetEpoch(epoch int) {
+	net.rate = net.scheduler.Rate(net.learningRate, epoch)
+	if s, ok := net.scheduler.(MomentumScheduler); ok {
+		setMomentum(net.optimizer, s.Momentum(epoch))
+	}
+}
+
+// forward propagates inputs through every layer and returns the outputs of
+// the last. The outputs of each layer are written to its scratch in ws, so
+// only last until it is next used.
+func (net Network) forward(ws *workspace, inputs *mat.Dense, training bool) (*mat.Dense, error) {
+	return net.forwardTo(ws, inputs, len(net.layers), training)
+}
+
+// forwardTo propagates inputs through the first n layers and returns the
+// outputs of the last of them.
+func (net Network) forwardTo(ws *workspace, inputs *mat.Dense, n int, training bool) (*mat.Dense, error) {
+	if r, _ := inputs.Dims(); r != net.sizes[0] {
+		return nil, fmt.Errorf("nn: %w: got %d inputs, want %d", helpers.ErrShape, r, net.sizes[0])
+	}
+	out := inputs
+	for i, l := range net.layers[:n] {
+		s := ws.scratch[i]
+		s.inputs = out
+		var err error
+		if out, err = l.Forward(s, out, training); err != nil {
+			return nil, fmt.Errorf("nn: layer %d (%s) forward: %w", i+1, layerName(l), err)
+		}
+		s.outputs = out
+	}
+	return out, nil
+}
+
+// backward backpropagates the difference between the outputs left in ws by
+// forward and the targets, returning the gradients of the loss with respect
+// to the parameters of every layer, averaged over the samples in the batch.
+// The gradients are written to ws.
+func (net Network) backward(ws *workspace, targets *mat.Dense) ([]*mat.Dense, error) {
+	_, n := targets.Dims()
+	last := len(net.layers) - 1
+	outputs := ws.scratch[last].outputs
+	rows, _ := outputs.Dims()
+	grad := resize(&ws.errors, rows, n)
+	start := last
+	if a, ok := net.layers[last].(activation); ok && fused(a.Activation, net.loss) {
+		// the derivative of the activation cancels out against the loss,
+		// leaving the error of its inputs as the outputs less the targets
+		if err := helpers.SubtractTo(grad, outputs, targets); err != nil {
+			return nil, fmt.Errorf("nn: targets: %w", err)
+		}
+		start--
+	} else {
+		if r, c := targets.Dims(); r != rows || c != outputs.RawMatrix().Cols {
+			return nil, fmt.Errorf("nn: targets: %w", helpers.ErrShape)
+		}
+		net.loss.Gradient(grad, outputs, targets)
+	}
+
+	// work from the output layer back to the first that is trained, leaving
+	// the gradients of the frozen layers before it at zero
+	first := net.backpropagated()
+	for i := start; i >= 0; i-- {
+		grads := ws.layerGrads[i]
+		for j, p := range net.layers[i].Params() {
+			r, c := p.Dims()
+			resize(grads[j], r, c)
+			if i < first {
+				grads[j].Zero()
+			}
+		}
+		if i < first {
+			continue
+		}
+		s := ws.scratch[i]
+		s.inputGrad = i > first
+		var err error
+		if grad, err = net.layers[i].Backward(s, grad, grads); err != nil {
+			return nil, fmt.Errorf("nn: layer %d (%s) backward: %w", i+1, layerName(net.layers[i]), err)
+		}
+	}
+	return ws.grads, nil
+}
+
+// setTargets stacks targetData as the columns of the targets of ws. It
+// returns an error unless there are targets for each of n samples, each the
+// size of the output layer.
+func (net Network) setTargets(ws *workspace, targetData [][]float64, n int) (*mat.Dense, error) {
+	if len(targetData) != n {
+		return nil, fmt.Errorf("nn: got %d inputs and %d targets", n, len(targetData))
+	}
+	return setColumns(&ws.targets, targetData, "targets", net.Outputs())
+}
+
+// checkSamples returns an error unless every sample has inputs the size of
+// the input layer and targets the size of the output layer.
+func (net Network) checkSamples(inputData, targetData [][]float64) error {
+	if len(targetData) != len(inputData) {
+		return fmt.Errorf("nn: got %d inputs and %d targets", len(inputData), len(targetData))
+	}
+	if err := checkColumns(inputData, "inputs", net.Inputs()); err != nil {
+		return err
+	}
+	return checkColumns(targetData, "targets", net.Outputs())
+}
+
+// Predict runs inputData through the network and returns the output layer as
+// a column vector. It panics if inputData is not the size of the input
+// layer; PredictErr returns the error instead. It is safe to call from many
+// goroutines at once.
+func (net Network) Predict(inputData []float64) mat.Matrix {
+	outputs, err := net.PredictErr(inputData)
+	if err != nil {
+		panic(err)
+	}
+	return outputs
+}
+
+// PredictErr is Predict, returning an error rather than panicking if
+// inputData is not the size of the input layer.
+func (net Network) PredictErr(inputData []float64) (mat.Matrix, error) {
+	if len(inputData) != net.Inputs() {
+		return nil, fmt.Errorf("nn: %w: got %d inputs, want %d", helpers.ErrShape, len(inputData), net.Inputs())
+	}
+	ws := net.workspaces.Get().(*workspace)
+	defer net.workspaces.Put(ws)
+	// forward propogation
+	inputs := mat.NewDense(len(inputData), 1, inputData)
+	outputs, err := net.forward(ws, inputs, false)
+	if err != nil {
+		return nil, err
+	}
+	return mat.DenseCopyOf(outputs), nil
+}
+
+// PredictBatch runs many samples through the network at once, stacking
+// them into one matrix so that each layer takes a single matrix
+// multiplication, and returns the outputs for each sample. The samples are
+// split between the workers set by WithWorkers. It panics as Predict does;
+// PredictBatchErr returns the error instead.
+func (net Network) PredictBatch(inputData [][]float64) [][]float64 {
+	results, err := net.PredictBatchErr(inputData)
+	if err != nil {
+		panic(err)
+	}
+	return results
+}
+
+// PredictBatchErr is PredictBatch, returning an error rather than panicking
+// if any sample is not the size of the input layer.
+func (net Network) PredictBatchErr(inputData [][]float64) ([][]float64, error) {
+	n := len(inputData)
+	if n == 0 {
+		return nil, nil
+	}
+	results := make([][]float64, n)
+	shards := net.workers
+	if shards > n {
+		shards = n
+	}
+	if shards <= 1 {
+		if err := net.predictShard(results, inputData); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+	// check the whole batch here so that errors count samples from its start
+	if err := checkColumns(inputData, "inputs", net.Inputs()); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, shards)
+	var wg sync.WaitGroup
+	for s := range errs {
+		lo, hi := s*n/shards, (s+1)*n/shards
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			errs[s] = net.predictShard(results[lo:hi], inputData[lo:hi])
+		}(s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// predictShard runs a batch of samples, or a share of one, through the
+// network on the calling goroutine, setting results to their outputs.
+func (net Network) predictShard(results, inputData [][]float64) error {
+	ws := net.workspaces.Get().(*workspace)
+	defer net.workspaces.Put(ws)
+	inputs, err := setColumns(&ws.inputs, inputData, "inputs", net.Inputs())
+	if err != nil {
+		return err
+	}
+	outputs, err := net.forward(ws, inputs, false)
+	if err != nil {
+		return err
+	}
+	for j := range results {
+		results[j] = mat.Col(nil, j, outputs)
+	}
+	return nil
+}
+
+// Classify returns the class the network predicts for inputData.
+func (net Network) Classify(inputData []float64) int {
+	return net.ClassOf(mat.Col(nil, 0, net.Predict(inputData)))
+}
+
+// ClassOf returns the class predicted by a set of network outputs, the
+// index of the highest output. A network with a single output tells two
+// classes apart, predicting 1 when the output is over 0.5.
+func (net Network) ClassOf(outputs []float64) int {
+	if len(outputs) == 1 {
+		if outputs[0] > 0.5 {
+			return 1
+		}
+		return 0
+	}
+	best := 0
+	highest := math.Inf(-1)
+	for i, v := range outputs {
+		if v > highest {
+			best = i
+			highest = v
+		}
+	}
+	return best
+}
+
+// Classes returns the number of classes the network tells apart, which is
+// two for a network with a single output.
+func (net Network) Classes() int {
+	if net.Outputs() == 1 {
+		return 2
+	}
+	return net.Outputs()
+}
+
+// Train performs a single step of backpropagation for one sample, updating
+// the weights in place. It returns the loss for the sample from before the
+// update, and any error as TrainBatch does.
+func (net *Network) Train(inputData []float64, targetData []float64) (float64, error) {
+	return net.TrainBatch([][]float64{inputData}, [][]float64{targetData})
+}
+
+// TrainBatch performs a single step of backpropagation for a mini-batch of
+// samples. The samples are stacked as the columns of one matrix so that the
+// whole batch goes through the network in a single pass, and the gradients
+// are averaged over the batch before being handed to the optimizer. It
+// returns the mean loss over the batch from before the update. It returns
+// an error if there are not as many targets as inputs or the samples do not
+// fit the input and output layers, naming the sample or layer that does
+// not, or a NonFiniteError if
+// the network was created WithFiniteCheck and the batch ran into a NaN or
+// infinity.
+func (net *Network) TrainBatch(inputData [][]float64, targetData [][]float64) (float64, error) {
+	if len(inputData) != len(targetData) {
+		return 0, fmt.Errorf("nn: got %d inputs and %d targets", len(inputData), len(targetData))
+	}
+	if len(inputData) == 0 {
+		return 0, nil
+	}
+
+	ws := net.workspaces.Get().(*workspace)
+	defer net.workspaces.Put(ws)
+	loss, grads, err := net.gradients(ws, inputData, targetData)
+	if err != nil {
+		return 0, err
+	}
+	loss += net.regularize(grads)
+	params, grads := net.trainable(grads)
+	rate := net.rate
+	if net.steps < net.warmup {
+		rate *= float64(net.steps+1) / float64(net.warmup)
+	}
+	net.steps++
+	if net.layerRates == nil {
+		net.optimizer.Step(params, grads, rate)
+	} else if err := net.stepScaled(params, grads, rate); err != nil {
+		return loss, err
+	}
+	net.syncParams()
+	if net.finiteCheck {
+		for i, l := range net.layers {
+			for j, p := range l.Params() {
+				if err := checkFinite(p, i+1, paramName(l, j)); err != nil {
+					return loss, err
+				}
+			}
+		}
+	}
+	return loss, nil
+}
+
+// stepScaled has the optimizer take a step with the learning rate of each
+// parameter scaled as WithLayerRates gives for its layer.
+func (net *Network) stepScaled(params, grads []*mat.Dense, rate float64) error {
+	opt, ok := net.optimizer.(ScaledOptimizer)
+	if l, wraps := net.optimizer.(*Lookahead); wraps {
+		_, ok = l.Inner.(ScaledOptimizer)
+	}
+	if !ok {
+		return fmt.Errorf("nn: optimizer %T cannot scale the learning rate of each layer", net.optimizer)
+	}
+	var rates []float64
+	weighted := 0
+	for i, l := range net.layers {
+		n := len(l.Params())
+		if n == 0 {
+			continue
+		}
+		scale, ok := net.layerRates[weighted]
+		if !ok {
+			scale = 1
+		}
+		if !net.Frozen(i) {
+			for j := 0; j < n; j++ {
+				rates = append(rates, rate*scale)
+			}
+		}
+		weighted++
+	}
+	for layer := range net.layerRates {
+		if layer < 0 || layer >= weighted {
+			return fmt.Errorf("nn: cannot set the learning rate of layer %d of a network with %d layers with parameters", layer, weighted)
+		}
+	}
+	opt.StepScaled(params, grads, rates)
+	return nil
+}
+
+// gradients returns the mean loss over a mini-batch and the gradients of the
+// parameters, in the order the optimizer takes them, written to ws.
+// The batch is split between the workers, and the gradients of each share
+// weighted by its size.
+func (net Network) gradients(ws *workspace, inputData [][]float64, targetData [][]float64) (float64, []*mat.Dense, error) {
+	n := len(inputData)
+	shards := net.workers
+	if shards > n {
+		shards = n
+	}
+	if shards <= 1 {
+		net.seedDropout(ws)
+		return net.shardGradients(ws, inputData, targetData)
+	}
+	// check the whole batch here so that errors count samples from its start
+	if err := net.checkSamples(inputData, targetData); err != nil {
+		return 0, nil, err
+	}
+
+	losses := make([]float64, shards)
+	grads := make([][]*mat.Dense, shards)
+	errs := make([]error, shards)
+	workspaces := make([]*workspace, shards)
+	workspaces[0] = ws
+	for s := 1; s < shards; s++ {
+		workspaces[s] = net.workspaces.Get().(*workspace)
+		defer net.workspaces.Put(workspaces[s])
+	}
+	for _, w := range workspaces {
+		net.seedDropout(w)
+	}
+	var wg sync.WaitGroup
+	for s := range grads {
+		lo, hi := s*n/shards, (s+1)*n/shards
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			loss, g, err := net.shardGradients(workspaces[s], inputData[lo:hi], targetData[lo:hi])
+			if err != nil {
+				errs[s] = err
+				return
+			}
+			share := float64(hi-lo) / float64(n)
+			for _, m := range g {
+				if err := helpers.ScaleTo(m, share, m); err != nil {
+					errs[s] = err
+					return
+				}
+			}
+			losses[s], grads[s] = loss*share, g
+		}(s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	loss, total := losses[0], grads[0]
+	for s := 1; s < shards; s++ {
+		loss += losses[s]
+		for i, m := range grads[s] {
+			if err := helpers.AddTo(total[i], total[i], m); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	return loss, total, nil
+}
+
+// shardGradients works out the loss and gradients for a mini-batch, or a
+// share of one, on the calling goroutine.
+func (net Network) shardGradients(ws *workspace, inputData [][]float64, targetData [][]float64) (float64, []*mat.Dense, error) {
+	inputs, err := setColumns(&ws.inputs, inputData, "inputs", net.Inputs())
+	if err != nil {
+		return 0, nil, err
+	}
+	outputs, err := net.forward(ws, inputs, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	targets, err := net.setTargets(ws, targetData, len(inputData))
+	if err != nil {
+		return 0, nil, err
+	}
+	if net.smoothing > 0 {
+		smoothLabels(targets, net.smoothing, net.Classes())
+	}
+	loss := net.loss.Value(outputs, targets)
+	if net.finiteCheck {
+		for i, s := range ws.scratch {
+			if err := checkFinite(s.outputs, i+1, "outputs"); err != nil {
+				return 0, nil, err
+			}
+		}
+		if math.IsNaN(loss) || math.IsInf(loss, 0) {
+			return 0, nil, &NonFiniteError{What: "loss", Value: loss}
+		}
+	}
+	grads, err := net.backward(ws, targets)
+	if err == nil && net.finiteCheck {
+		err = net.checkGradients(grads)
+	}
+	return loss, grads, err
+}
+
+// regularize adds the gradients of the L1 and L2 penalties to the weight
+// gradients in grads, and returns the penalties.
+func (net Network) regularize(grads []*mat.Dense) float64 {
+	if net.l1 == 0 && net.l2 == 0 {
+		return 0
+	}
+	penalty := 0.0
+	k := 0
+	for _, l := range net.layers {
+		params := l.Params()
+		r, ok := l.(regularized)
+		if !ok {
+			k += len(params)
+			continue
+		}
+		for _, i := range r.weights() {
+			wd, gd := params[i].RawMatrix().Data, grads[k+i].RawMatrix().Data
+			for j, v := range wd {
+				penalty += net.l2/2*v*v + net.l1*math.Abs(v)
+				gd[j] += net.l2 * v
+				if v > 0 {
+					gd[j] += net.l1
+				} else if v < 0 {
+					gd[j] -= net.l1
+				}
+			}
+		}
+		k += len(params)
+	}
+	return penalty
+}
+
+// seedDropout seeds the random numbers ws draws dropout masks from, taking
+// the seed from the network so that training is repeatable with WithSeed.
+func (net Network) seedDropout(ws *workspace) {
+	if !net.hasDropout() {
+		return
+	}
+	seed := net.rng.Int63()
+	if ws.rng == nil {
+		ws.rng = rand.New(rand.NewSource(seed))
+	} else {
+		ws.rng.Seed(seed)
+	}
+}
+
+// hasDropout reports whether any layer drops out its inputs while training.
+func (net Network) hasDropout() bool {
+	for _, l := range net.layers {
+		if d, ok := l.(*dropout); ok && d.rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Loss returns the mean loss of the network over the given samples. It
+// panics if the samples do not fit the network; LossErr returns the error
+// instead.
+func (net Network) Loss(inputData [][]float64, targetData [][]float64) float64 {
+	loss, err := net.LossErr(inputData, targetData)
+	if err != nil {
+		panic(err)
+	}
+	return loss
+}
+
+// LossErr is Loss, returning an error rather than panicking if the samples
+// do not fit the network.
+func (net Network) LossErr(inputData [][]float64, targetData [][]float64) (float64, error) {
+	if err := net.checkSamples(inputData, targetData); err != nil {
+		return 0, err
+	}
+	if len(inputData) == 0 {
+		return 0, nil
+	}
+	ws := net.workspaces.Get().(*workspace)
+	defer net.workspaces.Put(ws)
+	inputs, err := setColumns(&ws.inputs, inputData, "inputs", net.Inputs())
+	if err != nil {
+		return 0, err
+	}
+	outputs, err := net.forward(ws, inputs, false)
+	if err != nil {
+		return 0, err
+	}
+	targets, err := setColumns(&ws.targets, targetData, "targets", net.Outputs())
+	if err != nil {
+		return 0, err
+	}
+	return net.loss.Value(outputs, targets), nil
+}
+
+// OutputLoss returns the mean loss of outputs the network has already
+// produced, such as by PredictBatch, against the targets.
+func (net Network) OutputLoss(outputs [][]float64, targetData [][]float64) float64 {
+	if len(outputs) == 0 {
+		return 0
+	}
+	return net.loss.Value(columns(outputs), columns(targetData))
+}
+
+// columns stacks samples as the columns of a matrix.
+func columns(data [][]float64) *mat.Dense {
+	m := mat.NewDense(len(data[0]), len(data), nil)
+	for j, d := range data {
+		m.SetCol(j, d)
+	}
+	return m
+}
@@ -0,0 +1,293 @@
+package nn
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// An .npy file holds a single array:
+//
+//	magic   "\x93NUMPY"
+//	version [2]byte  major and minor version
+//	length  uint16, or uint32 from version 2, of the header
+//	header  a Python dict literal such as
+//	        {'descr': '<f8', 'fortran_order': False, 'shape': (3, 4), }
+//	        padded with spaces and a newline
+//	data    the elements, row by row unless fortran_order is set
+//
+// and an .npz file is a zip archive of .npy files, named after the arrays
+// in them, as written by numpy.savez.
+const npyMagic = "\x93NUMPY"
+
+// WriteNpy writes an array of the given shape, holding data row by row, to
+// w in NumPy's .npy format as float64s.
+func WriteNpy(w io.Writer, shape []int, data []float64) error {
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = strconv.Itoa(d)
+	}
+	tuple := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		tuple += ","
+	}
+	header := fmt.Sprintf("{'descr': '<f8', 'fortran_order': False, 'shape': (%s), }", tuple)
+	// the data starts at a multiple of 64 bytes, after a newline
+	pad := 64 - (len(npyMagic)+4+len(header)+1)%64
+	header += strings.Repeat(" ", pad%64) + "\n"
+
+	b := append([]byte(npyMagic), 1, 0, 0, 0)
+	binary.LittleEndian.PutUint16(b[len(npyMagic)+2:], uint16(len(header)))
+	b = append(b, header...)
+	values := make([]byte, 8*len(data))
+	for i, v := range data {
+		binary.LittleEndian.PutUint64(values[8*i:], math.Float64bits(v))
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	_, err := w.Write(values)
+	return err
+}
+
+// ReadNpy reads an array of float32s or float64s in NumPy's .npy format
+// from r, returning its shape and its elements row by row.
+func ReadNpy(r io.Reader) (shape []int, data []float64, err error) {
+	errBad := errors.New("nn: not an .npy file")
+	prefix := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix[:len(npyMagic)]) != npyMagic {
+		return nil, nil, errBad
+	}
+	var length uint32
+	switch prefix[len(npyMagic)] {
+	case 1:
+		var n uint16
+		err = binary.Read(r, binary.LittleEndian, &n)
+		length = uint32(n)
+	case 2, 3:
+		err = binary.Read(r, binary.LittleEndian, &length)
+	default:
+		return nil, nil, fmt.Errorf("nn: unsupported .npy version %d", prefix[len(npyMagic)])
+	}
+	if err != nil || length > 1<<16 {
+		return nil, nil, errBad
+	}
+	raw := make([]byte, length)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, nil, errBad
+	}
+	header := string(raw)
+
+	descr, ok := npyField(header, "descr")
+	if !ok || len(descr) != 5 || descr[0] != '\'' || descr[4] != '\'' {
+		return nil, nil, errBad
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	switch descr[1] {
+	case '<', '|', '=':
+	case '>':
+		order = binary.BigEndian
+	default:
+		return nil, nil, errBad
+	}
+	size := 0
+	switch descr[2:4] {
+	case "f8":
+		size = 8
+	case "f4":
+		size = 4
+	default:
+		return nil, nil, fmt.Errorf("nn: unsupported .npy element type %s, want float32 or float64", descr)
+	}
+	fortran, ok := npyField(header, "fortran_order")
+	if !ok || (fortran != "True" && fortran != "False") {
+		return nil, nil, errBad
+	}
+	tuple, ok := npyField(header, "shape")
+	if !ok || !strings.HasPrefix(tuple, "(") || !strings.HasSuffix(tuple, ")") {
+		return nil, nil, errBad
+	}
+	n := 1
+	for _, d := range strings.Split(tuple[1:len(tuple)-1], ",") {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+		v, err := strconv.Atoi(d)
+		if err != nil || v < 0 || v > 1<<30 || n*v > 1<<30 {
+			return nil, nil, errBad
+		}
+		shape = append(shape, v)
+		n *= v
+	}
+
+	b := make([]byte, n*size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, nil, fmt.Errorf("nn: reading .npy data: %w", err)
+	}
+	data = make([]float64, n)
+	for i := range data {
+		if size == 8 {
+			data[i] = math.Float64frombits(order.Uint64(b[8*i:]))
+		} else {
+			data[i] = float64(math.Float32frombits(order.Uint32(b[4*i:])))
+		}
+	}
+	if fortran == "True" && len(shape) == 2 {
+		// column by column, so transpose it
+		rows, cols := shape[0], shape[1]
+		t := make([]float64, n)
+		for i, v := range data {
+			t[(i%rows)*cols+i/rows] = v
+		}
+		data = t
+	}
+	return shape, data, nil
+}
+
+// npyField returns the value of key in an .npy header, which is simple
+// enough to pick apart by hand.
+func npyField(header, key string) (string, bool) {
+	i := strings.Index(header, "'"+key+"':")
+	if i < 0 {
+		return "", false
+	}
+	rest := strings.TrimSpace(header[i+len(key)+3:])
+	end := strings.IndexAny(rest, ",}")
+	if strings.HasPrefix(rest, "(") {
+		end = strings.Index(rest, ")") + 1
+	}
+	if end <= 0 {
+		return "", false
+	}
+	return strings.TrimSpace(rest[:end]), true
+}
+
+// SaveNumpyWeights writes the parameters of the network to path as an .npz
+// file, which numpy.load reads as a dict of arrays.
+func (net Network) SaveNumpyWeights(path string) error {
+	return writeFile(path, net.WriteNumpyWeights)
+}
+
+// WriteNumpyWeights writes the parameters of the network to w in NumPy's
+// .npz format. Each is an array named after its layer, counting from 1 and
+// leaving out dropout as in a model file, and its own name, such as
+// "layer1.weights" and "layer1.biases" for a dense layer. Weights have a row
+// for each output and a column for each input, and biases are a column.
+func (net Network) WriteNumpyWeights(w io.Writer) error {
+	z := zip.NewWriter(w)
+	err := net.eachNumpyParam(func(name string, p int, l Layer) error {
+		m := l.Params()[p]
+		f, err := z.CreateHeader(&zip.FileHeader{Name: name + ".npy", Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		r, c := m.Dims()
+		data := make([]float64, 0, r*c)
+		for i := 0; i < r; i++ {
+			data = append(data, m.RawRowView(i)...)
+		}
+		return WriteNpy(f, []int{r, c}, data)
+	})
+	if err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// LoadNumpyWeights reads parameters from the .npz file at path, named as by
+// WriteNumpyWeights, into the network. Parameters with no array in the file
+// are left as they are, so weights for just some layers can be loaded, but
+// an array with no parameter of its name, or of the wrong shape, is an
+// error. A column, such as the biases, may be given as a one dimensional
+// array. The network is left unchanged if an error is returned.
+func (net *Network) LoadNumpyWeights(path string) error {
+	arrays, err := readNpz(path)
+	if err != nil {
+		return err
+	}
+	params := map[string]*mat.Dense{}
+	net.eachNumpyParam(func(name string, p int, l Layer) error {
+		params[name] = l.Params()[p]
+		return nil
+	})
+	for _, a := range arrays {
+		m, ok := params[a.name]
+		if !ok {
+			return fmt.Errorf("nn: no parameter %s for the array in %s", a.name, path)
+		}
+		r, c := m.Dims()
+		column := len(a.shape) == 1 && c == 1 && a.shape[0] == r
+		if !column && (len(a.shape) != 2 || a.shape[0] != r || a.shape[1] != c) {
+			return fmt.Errorf("nn: %s is %v, want (%d, %d)", a.name, a.shape, r, c)
+		}
+	}
+	for _, a := range arrays {
+		m := params[a.name]
+		_, c := m.Dims()
+		for i := 0; i*c < len(a.data); i++ {
+			copy(m.RawRowView(i), a.data[i*c:(i+1)*c])
+		}
+	}
+	net.syncParams()
+	return nil
+}
+
+// npyArray is an array read from an .npy file, with its elements row by
+// row.
+type npyArray struct {
+	name  string
+	shape []int
+	data  []float64
+}
+
+// readNpz reads every array of the .npz file at path, in the order they
+// were written.
+func readNpz(path string) ([]npyArray, error) {
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	var arrays []npyArray
+	for _, f := range z.File {
+		a := npyArray{name: strings.TrimSuffix(f.Name, ".npy")}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		a.shape, a.data, err = ReadNpy(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("nn: %s: %w", a.name, err)
+		}
+		arrays = append(arrays, a)
+	}
+	return arrays, nil
+}
+
+// eachNumpyParam calls fn with the name of each parameter of the network as
+// an array of an .npz file, along with its index in the parameters of its
+// layer.
+func (net Network) eachNumpyParam(fn func(name string, p int, l Layer) error) error {
+	i := 0
+	for _, l := range net.layers {
+		if _, ok := l.(*dropout); ok {
+			continue
+		}
+		i++
+		for p := range l.Params() {
+			name := fmt.Sprintf("layer%d.%s", i, strings.ReplaceAll(paramName(l, p), " ", "_"))
+			if err := fn(name, p, l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
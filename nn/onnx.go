@@ -0,0 +1,241 @@
+package nn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/kheob/ml/helpers"
+)
+
+// An ONNX model is a protocol buffer message, written out here by hand with
+// just the fields the exported graph needs, from onnx.proto:
+//
+//	ModelProto        ir_version 1, producer_name 2, graph 7, opset_import 8
+//	OperatorSetIdProto version 2
+//	GraphProto        node 1, name 2, initializer 5, input 11, output 12
+//	NodeProto         input 1, output 2, name 3, op_type 4, attribute 5
+//	AttributeProto    name 1, f 2, i 3, type 20
+//	TensorProto       dims 1, data_type 2, name 8, raw_data 9
+//	ValueInfoProto    name 1, type 2
+//	TypeProto         tensor_type 1, with elem_type 1 and shape 2
+//	TensorShapeProto  dim 1, with dim_value 1 or dim_param 2
+const (
+	onnxIRVersion = 8
+	onnxOpset     = 13
+	onnxFloat     = 1 // TensorProto.FLOAT
+	onnxAttrFloat = 1 // AttributeProto.FLOAT
+	onnxAttrInt   = 2 // AttributeProto.INT
+)
+
+// ExportONNX writes the network to path as an ONNX model, so that it can be
+// served by onnxruntime or imported into other frameworks.
+func (net Network) ExportONNX(path string) error {
+	return writeFile(path, net.WriteONNX)
+}
+
+// WriteONNX writes the network to w as an ONNX model with float32 weights.
+// The graph takes a batch of samples, one per row, as its input named
+// "input", and gives their outputs, one per row, as "output". Dense layers
+// become Gemm nodes and activations the nodes of the same name, and dropout
+// is left out. Networks with other layers, such as convolutional ones,
+// cannot be exported yet.
+func (net Network) WriteONNX(w io.Writer) error {
+	// each step becomes a node taking the output of the one before, along
+	// with the initializers named in params
+	type step struct {
+		op     string
+		params []string
+		attrs  []protoMessage
+	}
+	layers, err := net.exportLayers("ONNX")
+	if err != nil {
+		return err
+	}
+	var steps []step
+	var initializers []protoMessage
+	for _, l := range layers {
+		if d := l.dense; d != nil {
+			weights, biases := fmt.Sprintf("weights_%d", len(steps)), fmt.Sprintf("biases_%d", len(steps))
+			r, c := d.w.Dims()
+			var data []float64
+			for _, row := range d.rows() {
+				data = append(data, row...)
+			}
+			initializers = append(initializers,
+				onnxTensor(weights, []int{r, c}, data),
+				onnxTensor(biases, []int{r}, d.biasValues()))
+			// the weights have a row for each output, so are transposed to
+			// multiply a row of inputs
+			steps = append(steps, step{"Gemm", []string{weights, biases}, []protoMessage{onnxIntAttribute("transB", 1)}})
+			continue
+		}
+		switch a := l.activation.(type) {
+		case helpers.ReLU:
+			steps = append(steps, step{op: "Relu"})
+		case helpers.LeakyReLU:
+			steps = append(steps, step{"LeakyRelu", nil, []protoMessage{onnxFloatAttribute("alpha", leakyAlpha(a))}})
+		case helpers.Sigmoid:
+			steps = append(steps, step{op: "Sigmoid"})
+		case helpers.Tanh:
+			steps = append(steps, step{op: "Tanh"})
+		case helpers.Softmax:
+			steps = append(steps, step{"Softmax", nil, []protoMessage{onnxIntAttribute("axis", 1)}})
+		}
+	}
+
+	var graph protoMessage
+	value := "input"
+	for i, s := range steps {
+		out := fmt.Sprintf("%s_%d", s.op, i)
+		if i == len(steps)-1 {
+			out = "output"
+		}
+		graph.addMessage(1, onnxNode(fmt.Sprintf("%s_%d", s.op, i), s.op, append([]string{value}, s.params...), out, s.attrs...))
+		value = out
+	}
+	graph.addString(2, "ml")
+	for _, t := range initializers {
+		graph.addMessage(5, t)
+	}
+	graph.addMessage(11, onnxValueInfo("input", net.Inputs()))
+	graph.addMessage(12, onnxValueInfo("output", net.Outputs()))
+
+	var opset protoMessage
+	opset.addInt(2, onnxOpset)
+	var model protoMessage
+	model.addInt(1, onnxIRVersion)
+	model.addString(2, "github.com/kheob/ml")
+	model.addMessage(7, graph)
+	model.addMessage(8, opset)
+	_, err = w.Write(model.encode())
+	return err
+}
+
+// onnxNode returns a node applying op to inputs, giving out.
+func onnxNode(name, op string, inputs []string, out string, attrs ...protoMessage) protoMessage {
+	var n protoMessage
+	for _, in := range inputs {
+		n.addString(1, in)
+	}
+	n.addString(2, out)
+	n.addString(3, name)
+	n.addString(4, op)
+	for _, a := range attrs {
+		n.addMessage(5, a)
+	}
+	return n
+}
+
+func onnxIntAttribute(name string, v int) protoMessage {
+	var a protoMessage
+	a.addString(1, name)
+	a.addInt(3, v)
+	a.addInt(20, onnxAttrInt)
+	return a
+}
+
+func onnxFloatAttribute(name string, v float64) protoMessage {
+	var a protoMessage
+	a.addString(1, name)
+	a.addFixed32(2, math.Float32bits(float32(v)))
+	a.addInt(20, onnxAttrFloat)
+	return a
+}
+
+// onnxTensor returns a float32 tensor of the given dimensions holding data
+// in row major order.
+func onnxTensor(name string, dims []int, data []float64) protoMessage {
+	var t protoMessage
+	for _, d := range dims {
+		t.addInt(1, d)
+	}
+	t.addInt(2, onnxFloat)
+	t.addString(8, name)
+	raw := make([]byte, 4*len(data))
+	for i, v := range data {
+		binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(float32(v)))
+	}
+	t.addBytes(9, raw)
+	return t
+}
+
+// onnxValueInfo describes a float32 batch of rows of the given size, with
+// any number of rows.
+func onnxValueInfo(name string, size int) protoMessage {
+	var batch, features, shape, tensor, typ, info protoMessage
+	batch.addString(2, "batch")
+	features.addInt(1, size)
+	shape.addMessage(1, batch)
+	shape.addMessage(1, features)
+	tensor.addInt(1, onnxFloat)
+	tensor.addMessage(2, shape)
+	typ.addMessage(1, tensor)
+	info.addString(1, name)
+	info.addMessage(2, typ)
+	return info
+}
+
+// protoMessage is a protocol buffer message being built up field by field.
+type protoMessage struct {
+	fields []protoField
+}
+
+// protoField is a field of a message, holding an integer, fixed32, bytes
+// or a nested message.
+type protoField struct {
+	number   int
+	wireType int
+	n        uint64
+	bytes    []byte
+	message  *protoMessage
+}
+
+func (m *protoMessage) addInt(number, v int) {
+	m.fields = append(m.fields, protoField{number: number, wireType: 0, n: uint64(v)})
+}
+
+func (m *protoMessage) addFixed32(number int, v uint32) {
+	m.fields = append(m.fields, protoField{number: number, wireType: 5, n: uint64(v)})
+}
+
+func (m *protoMessage) addBytes(number int, b []byte) {
+	m.fields = append(m.fields, protoField{number: number, wireType: 2, bytes: b})
+}
+
+func (m *protoMessage) addString(number int, s string) {
+	m.addBytes(number, []byte(s))
+}
+
+func (m *protoMessage) addMessage(number int, msg protoMessage) {
+	m.fields = append(m.fields, protoField{number: number, wireType: 2, message: &msg})
+}
+
+// encode returns the message in the protocol buffer wire format.
+func (m protoMessage) encode() []byte {
+	var b []byte
+	varint := func(v uint64) {
+		var buf [binary.MaxVarintLen64]byte
+		b = append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+	}
+	for _, f := range m.fields {
+		varint(uint64(f.number<<3 | f.wireType))
+		switch f.wireType {
+		case 0:
+			varint(f.n)
+		case 5:
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], uint32(f.n))
+			b = append(b, buf[:]...)
+		case 2:
+			data := f.bytes
+			if f.message != nil {
+				data = f.message.encode()
+			}
+			varint(uint64(len(data)))
+			b = append(b, data...)
+		}
+	}
+	return b
+}
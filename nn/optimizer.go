@@ -0,0 +1,488 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Optimizer updates the parameters of a network from the gradients of the
+// loss. params and grads line up index for index, and a network always
+// passes its parameters in the same order, so stateful optimizers can keep
+// per-parameter state by index.
+type Optimizer interface {
+	Step(params, grads []*mat.Dense, rate float64)
+}
+
+// ScaledOptimizer is an Optimizer that can also step each parameter with a
+// learning rate of its own, given by rates, as WithLayerRates needs. Every
+// optimizer in this package is one, a Lookahead so long as the optimizer
+// it wraps is.
+type ScaledOptimizer interface {
+	Optimizer
+	StepScaled(params, grads []*mat.Dense, rates []float64)
+}
+
+// stateful is implemented by optimizers that keep state between steps, so
+// that it can be saved in checkpoints. steps counts the steps taken, for
+// optimizers that need it.
+type stateful interface {
+	state() (steps int, state []*mat.Dense)
+	setState(steps int, state []*mat.Dense) error
+}
+
+// OptimizerByName returns a new optimizer with default settings for one of
+// "sgd", "momentum", "nesterov", "adagrad", "adadelta", "rmsprop", "adam"
+// or "adamw", or one of those wrapped in a Lookahead, such as
+// "lookahead(adam)".
+func OptimizerByName(name string) (Optimizer, error) {
+	if strings.HasPrefix(name, "lookahead(") && strings.HasSuffix(name, ")") {
+		inner := strings.TrimSuffix(strings.TrimPrefix(name, "lookahead("), ")")
+		if strings.HasPrefix(inner, "lookahead(") {
+			return nil, fmt.Errorf("nn: lookahead cannot wrap another lookahead")
+		}
+		opt, err := OptimizerByName(inner)
+		if err != nil {
+			return nil, err
+		}
+		return &Lookahead{Inner: opt}, nil
+	}
+	switch name {
+	case "sgd":
+		return SGD{}, nil
+	case "momentum":
+		return &Momentum{}, nil
+	case "nesterov":
+		return &Momentum{Nesterov: true}, nil
+	case "adagrad":
+		return &AdaGrad{}, nil
+	case "adadelta":
+		return &AdaDelta{}, nil
+	case "rmsprop":
+		return &RMSProp{}, nil
+	case "adam":
+		return &Adam{}, nil
+	case "adamw":
+		return &AdamW{}, nil
+	}
+	return nil, fmt.Errorf("nn: unknown optimizer %q", name)
+}
+
+// SGD is plain stochastic gradient descent.
+type SGD struct{}
+
+func (o SGD) Step(params, grads []*mat.Dense, rate float64) {
+	o.StepScaled(params, grads, sameRates(rate, len(params)))
+}
+
+func (SGD) StepScaled(params, grads []*mat.Dense, rates []float64) {
+	for i, p := range params {
+		rate := rates[i]
+		pd, gd := p.RawMatrix().Data, grads[i].RawMatrix().Data
+		for j := range pd {
+			pd[j] -= rate * gd[j]
+		}
+	}
+}
+
+// Momentum is SGD with classical momentum, or with Nesterov momentum, which
+// takes each step from where the momentum is about to carry the parameters
+// and so corrects course sooner. Momentum defaults to 0.9 when left as
+// zero.
+type Momentum struct {
+	Momentum float64
+	Nesterov bool
+
+	velocity []*mat.Dense
+}
+
+func (o *Momentum) Step(params, grads []*mat.Dense, rate float64) {
+	o.StepScaled(params, grads, sameRates(rate, len(params)))
+}
+
+func (o *Momentum) StepScaled(params, grads []*mat.Dense, rates []float64) {
+	mu := orDefault(o.Momentum, 0.9)
+	o.velocity = zerosLike(o.velocity, params)
+	for i, p := range params {
+		rate := rates[i]
+		v := o.velocity[i].RawMatrix().Data
+		pd, gd := p.RawMatrix().Data, grads[i].RawMatrix().Data
+		for j := range pd {
+			v[j] = mu*v[j] - rate*gd[j]
+			if o.Nesterov {
+				pd[j] += mu*v[j] - rate*gd[j]
+			} else {
+				pd[j] += v[j]
+			}
+		}
+	}
+}
+
+func (o *Momentum) state() (int, []*mat.Dense) {
+	return 0, o.velocity
+}
+
+func (o *Momentum) setState(_ int, state []*mat.Dense) error {
+	o.velocity = state
+	return nil
+}
+
+// AdaGrad scales each parameter's step by the square root of the sum of its
+// squared gradients so far, so parameters that are seldom updated, such as
+// the weights of rare sparse features, keep taking larger steps. Epsilon
+// defaults to 1e-8 when left as zero.
+type AdaGrad struct {
+	Epsilon float64
+
+	sums []*mat.Dense
+}
+
+func (o *AdaGrad) Step(params, grads []*mat.Dense, rate float64) {
+	o.StepScaled(params, grads, sameRates(rate, len(params)))
+}
+
+func (o *AdaGrad) StepScaled(params, grads []*mat.Dense, rates []float64) {
+	eps := orDefault(o.Epsilon, 1e-8)
+	o.sums = zerosLike(o.sums, params)
+	for i, p := range params {
+		rate := rates[i]
+		s := o.sums[i].RawMatrix().Data
+		pd, gd := p.RawMatrix().Data, grads[i].RawMatrix().Data
+		for j := range pd {
+			s[j] += gd[j] * gd[j]
+			pd[j] -= rate * gd[j] / (math.Sqrt(s[j]) + eps)
+		}
+	}
+}
+
+func (o *AdaGrad) state() (int, []*mat.Dense) {
+	return 0, o.sums
+}
+
+func (o *AdaGrad) setState(_ int, state []*mat.Dense) error {
+	o.sums = state
+	return nil
+}
+
+// AdaDelta scales each parameter's step by the ratio of running averages of
+// its past steps and its squared gradients, so the steps keep to the scale
+// of the parameter and no learning rate is needed beyond a multiplier,
+// usually 1. Rho defaults to 0.95 and Epsilon to 1e-6 when left as zero.
+type AdaDelta struct {
+	Rho     float64
+	Epsilon float64
+
+	grads, steps []*mat.Dense
+}
+
+func (o *AdaDelta) Step(params, grads []*mat.Dense, rate float64) {
+	o.StepScaled(params, grads, sameRates(rate, len(params)))
+}
+
+func (o *AdaDelta) StepScaled(params, grads []*mat.Dense, rates []float64) {
+	rho, eps := orDefault(o.Rho, 0.95), orDefault(o.Epsilon, 1e-6)
+	o.grads = zerosLike(o.grads, params)
+	o.steps = zerosLike(o.steps, params)
+	for i, p := range params {
+		rate := rates[i]
+		g2, d2 := o.grads[i].RawMatrix().Data, o.steps[i].RawMatrix().Data
+		pd, gd := p.RawMatrix().Data, grads[i].RawMatrix().Data
+		for j := range pd {
+			g2[j] = rho*g2[j] + (1-rho)*gd[j]*gd[j]
+			d := -math.Sqrt(d2[j]+eps) / math.Sqrt(g2[j]+eps) * gd[j]
+			d2[j] = rho*d2[j] + (1-rho)*d*d
+			pd[j] += rate * d
+		}
+	}
+}
+
+func (o *AdaDelta) state() (int, []*mat.Dense) {
+	return 0, append(append([]*mat.Dense(nil), o.grads...), o.steps...)
+}
+
+func (o *AdaDelta) setState(_ int, state []*mat.Dense) error {
+	if len(state)%2 != 0 {
+		return fmt.Errorf("nn: adadelta state has %d matrices, want an even number", len(state))
+	}
+	half := len(state) / 2
+	o.grads, o.steps = state[:half], state[half:]
+	return nil
+}
+
+// RMSProp scales each parameter's step by a running average of its squared
+// gradients. Decay defaults to 0.9 and Epsilon to 1e-8 when left as zero.
+type RMSProp struct {
+	Decay   float64
+	Epsilon float64
+
+	cache []*mat.Dense
+}
+
+func (o *RMSProp) Step(params, grads []*mat.Dense, rate float64) {
+	o.StepScaled(params, grads, sameRates(rate, len(params)))
+}
+
+func (o *RMSProp) StepScaled(params, grads []*mat.Dense, rates []float64) {
+	decay, eps := orDefault(o.Decay, 0.9), orDefault(o.Epsilon, 1e-8)
+	o.cache = zerosLike(o.cache, params)
+	for i, p := range params {
+		rate := rates[i]
+		s := o.cache[i].RawMatrix().Data
+		pd, gd := p.RawMatrix().Data, grads[i].RawMatrix().Data
+		for j := range pd {
+			s[j] = decay*s[j] + (1-decay)*gd[j]*gd[j]
+			pd[j] -= rate * gd[j] / (math.Sqrt(s[j]) + eps)
+		}
+	}
+}
+
+func (o *RMSProp) state() (int, []*mat.Dense) {
+	return 0, o.cache
+}
+
+func (o *RMSProp) setState(_ int, state []*mat.Dense) error {
+	o.cache = state
+	return nil
+}
+
+// Adam keeps bias-corrected running averages of both the gradients and the
+// squared gradients. Beta1 defaults to 0.9, Beta2 to 0.999 and Epsilon to
+// 1e-8 when left as zero.
+type Adam struct {
+	Beta1   float64
+	Beta2   float64
+	Epsilon float64
+
+	t    int
+	m, v []*mat.Dense
+}
+
+func (o *Adam) Step(params, grads []*mat.Dense, rate float64) {
+	o.StepScaled(params, grads, sameRates(rate, len(params)))
+}
+
+func (o *Adam) StepScaled(params, grads []*mat.Dense, rates []float64) {
+	b1, b2 := orDefault(o.Beta1, 0.9), orDefault(o.Beta2, 0.999)
+	eps := orDefault(o.Epsilon, 1e-8)
+	o.m = zerosLike(o.m, params)
+	o.v = zerosLike(o.v, params)
+	o.t++
+	c1 := 1 - math.Pow(b1, float64(o.t))
+	c2 := 1 - math.Pow(b2, float64(o.t))
+	for i, p := range params {
+		rate := rates[i]
+		m, v := o.m[i].RawMatrix().Data, o.v[i].RawMatrix().Data
+		pd, gd := p.RawMatrix().Data, grads[i].RawMatrix().Data
+		for j := range pd {
+			m[j] = b1*m[j] + (1-b1)*gd[j]
+			v[j] = b2*v[j] + (1-b2)*gd[j]*gd[j]
+			pd[j] -= rate * (m[j] / c1) / (math.Sqrt(v[j]/c2) + eps)
+		}
+	}
+}
+
+func (o *Adam) state() (int, []*mat.Dense) {
+	return o.t, append(append([]*mat.Dense(nil), o.m...), o.v...)
+}
+
+func (o *Adam) setState(steps int, state []*mat.Dense) error {
+	if len(state)%2 != 0 {
+		return fmt.Errorf("nn: adam state has %d matrices, want an even number", len(state))
+	}
+	half := len(state) / 2
+	o.t, o.m, o.v = steps, state[:half], state[half:]
+	return nil
+}
+
+// AdamW is Adam with decoupled weight decay: rather than adding a penalty
+// to the gradients, where Adam's scaling would weaken it for parameters with
+// large gradients, it shrinks every parameter by rate times WeightDecay of
+// itself at each step. WeightDecay defaults to 0.01 when left as zero.
+type AdamW struct {
+	Adam
+	WeightDecay float64
+}
+
+func (o *AdamW) Step(params, grads []*mat.Dense, rate float64) {
+	o.StepScaled(params, grads, sameRates(rate, len(params)))
+}
+
+func (o *AdamW) StepScaled(params, grads []*mat.Dense, rates []float64) {
+	decay := orDefault(o.WeightDecay, 0.01)
+	for i, p := range params {
+		pd := p.RawMatrix().Data
+		for j := range pd {
+			pd[j] -= rates[i] * decay * pd[j]
+		}
+	}
+	o.Adam.StepScaled(params, grads, rates)
+}
+
+// Lookahead wraps another optimizer, letting it take K steps with a set of
+// fast weights before moving the slow weights Alpha of the way towards
+// them and starting the fast weights again from there. Looking ahead like
+// this steadies the inner optimizer and makes it less sensitive to its
+// learning rate. K defaults to 5 and Alpha to 0.5 when left as zero.
+type Lookahead struct {
+	Inner Optimizer
+	K     int
+	Alpha float64
+
+	steps int
+	slow  []*mat.Dense
+	// pending holds the state set from a checkpoint until the first step
+	// shows how much of it is the slow weights
+	pending []*mat.Dense
+}
+
+func (o *Lookahead) Step(params, grads []*mat.Dense, rate float64) {
+	o.step(params, func() { o.Inner.Step(params, grads, rate) })
+}
+
+// StepScaled panics if the inner optimizer is not a ScaledOptimizer.
+func (o *Lookahead) StepScaled(params, grads []*mat.Dense, rates []float64) {
+	inner, ok := o.Inner.(ScaledOptimizer)
+	if !ok {
+		panic(fmt.Sprintf("nn: lookahead optimizer %T cannot scale the learning rate of each parameter", o.Inner))
+	}
+	o.step(params, func() { inner.StepScaled(params, grads, rates) })
+}
+
+// step has the inner optimizer take a step with the fast weights in params,
+// and then moves the slow weights towards them every K steps.
+func (o *Lookahead) step(params []*mat.Dense, inner func()) {
+	if o.pending != nil {
+		o.restore(len(params))
+	}
+	if len(o.slow) != len(params) {
+		o.slow = make([]*mat.Dense, len(params))
+		for i, p := range params {
+			o.slow[i] = mat.DenseCopyOf(p)
+		}
+	}
+	inner()
+	o.steps++
+	k := o.K
+	if k == 0 {
+		k = 5
+	}
+	if o.steps%k != 0 {
+		return
+	}
+	alpha := orDefault(o.Alpha, 0.5)
+	for i, p := range params {
+		s, pd := o.slow[i].RawMatrix().Data, p.RawMatrix().Data
+		for j := range pd {
+			s[j] += alpha * (pd[j] - s[j])
+			pd[j] = s[j]
+		}
+	}
+}
+
+// restore hands the pending state, less the n slow weights at its end, to
+// the inner optimizer.
+func (o *Lookahead) restore(n int) {
+	state := o.pending
+	o.pending = nil
+	if len(state) < n {
+		panic(fmt.Sprintf("nn: lookahead state has %d matrices for %d parameters", len(state), n))
+	}
+	o.slow = state[len(state)-n:]
+	if s, ok := o.Inner.(stateful); ok {
+		if err := s.setState(o.steps, state[:len(state)-n]); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (o *Lookahead) state() (int, []*mat.Dense) {
+	if o.pending != nil {
+		return o.steps, o.pending
+	}
+	var state []*mat.Dense
+	if s, ok := o.Inner.(stateful); ok {
+		_, state = s.state()
+	}
+	return o.steps, append(append([]*mat.Dense(nil), state...), o.slow...)
+}
+
+// setState keeps the state until the next step, as it cannot tell the
+// state of the inner optimizer from the slow weights without the
+// parameters. The inner optimizer takes steps as its own, having taken
+// every step.
+func (o *Lookahead) setState(steps int, state []*mat.Dense) error {
+	o.steps = steps
+	if len(state) > 0 {
+		o.pending = state
+	}
+	return nil
+}
+
+// cloneOptimizer returns a copy of o with its own copy of any state, or o
+// itself if it keeps none.
+func cloneOptimizer(o Optimizer) Optimizer {
+	if l, ok := o.(*Lookahead); ok {
+		c := *l
+		c.Inner = cloneOptimizer(l.Inner)
+		c.slow, c.pending = copyAll(l.slow), copyAll(l.pending)
+		return &c
+	}
+	s, ok := o.(stateful)
+	v := reflect.ValueOf(o)
+	if !ok || v.Kind() != reflect.Ptr {
+		return o
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	clone := c.Interface().(Optimizer)
+	steps, state := s.state()
+	// every optimizer here takes any state it is given
+	_ = clone.(stateful).setState(steps, copyAll(state))
+	return clone
+}
+
+// copyAll returns a copy of each matrix in ms, or nil if there are none.
+func copyAll(ms []*mat.Dense) []*mat.Dense {
+	if ms == nil {
+		return nil
+	}
+	copies := make([]*mat.Dense, len(ms))
+	for i, m := range ms {
+		copies[i] = mat.DenseCopyOf(m)
+	}
+	return copies
+}
+
+// sameRates returns n copies of rate.
+func sameRates(rate float64, n int) []float64 {
+	rates := make([]float64, n)
+	for i := range rates {
+		rates[i] = rate
+	}
+	return rates
+}
+
+// orDefault returns v, or def if v is zero.
+func orDefault(v, def float64) float64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// zerosLike returns state unchanged if it already has an entry for every
+// parameter, otherwise a fresh set of zero matrices shaped like params.
+func zerosLike(state, params []*mat.Dense) []*mat.Dense {
+	if len(state) == len(params) {
+		return state
+	}
+	state = make([]*mat.Dense, len(params))
+	for i, p := range params {
+		r, c := p.Dims()
+		state[i] = mat.NewDense(r, c, nil)
+	}
+	return state
+}
@@ -0,0 +1,205 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kheob/ml/helpers"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Pooling is a way of shrinking the output image of a convolutional layer
+// by summing up each window of it in a single value.
+type Pooling int
+
+const (
+	NoPooling Pooling = iota
+	// MaxPooling keeps the highest value in each window.
+	MaxPooling
+	// AvgPooling averages the values in each window.
+	AvgPooling
+)
+
+// PoolingByName returns the pooling called "none", "max" or "avg".
+func PoolingByName(name string) (Pooling, error) {
+	switch name {
+	case "none":
+		return NoPooling, nil
+	case "max":
+		return MaxPooling, nil
+	case "avg":
+		return AvgPooling, nil
+	}
+	return 0, fmt.Errorf("nn: unknown pooling %q", name)
+}
+
+func (p Pooling) String() string {
+	switch p {
+	case MaxPooling:
+		return "max"
+	case AvgPooling:
+		return "avg"
+	}
+	return "none"
+}
+
+// Pool2D describes the pooling of each channel of an image over square
+// windows Kernel values across, Stride values apart. Stride defaults to
+// Kernel when left as zero, so that the windows do not overlap.
+type Pool2D struct {
+	Type           Pooling
+	Kernel, Stride int
+}
+
+// MaxPool2D returns max pooling over kernel x kernel windows, stride apart.
+func MaxPool2D(kernel, stride int) Pool2D {
+	return Pool2D{Type: MaxPooling, Kernel: kernel, Stride: stride}
+}
+
+// AvgPool2D returns average pooling over kernel x kernel windows, stride
+// apart.
+func AvgPool2D(kernel, stride int) Pool2D {
+	return Pool2D{Type: AvgPooling, Kernel: kernel, Stride: stride}
+}
+
+func (p Pool2D) stride() int {
+	if p.Stride == 0 {
+		return p.Kernel
+	}
+	return p.Stride
+}
+
+// output returns the shape of the pooled image for an image of the given
+// shape.
+func (p Pool2D) output(in Shape) Shape {
+	if p.Type == NoPooling {
+		return in
+	}
+	s := p.stride()
+	return Shape{
+		Channels: in.Channels,
+		Height:   fits(in.Height, p.Kernel, s),
+		Width:    fits(in.Width, p.Kernel, s),
+	}
+}
+
+// pool is a layer pooling each channel of its input images.
+type pool struct {
+	Pool2D
+	in, out Shape
+}
+
+// Pool returns a layer pooling images of the given shape, as usually comes
+// after the activation of a convolutional layer.
+func Pool(input Shape, p Pool2D) Layer {
+	if p.Type != MaxPooling && p.Type != AvgPooling {
+		panic(fmt.Sprintf("nn: unknown pooling %d", p.Type))
+	}
+	p.Stride = p.stride()
+	out := p.output(input)
+	if p.Kernel <= 0 || p.Stride <= 0 || input.Channels <= 0 || out.Height <= 0 || out.Width <= 0 {
+		panic(fmt.Sprintf("nn: %s pooling over %d windows does not fit its %s input", p.Type, p.Kernel, input))
+	}
+	return &pool{Pool2D: p, in: input, out: out}
+}
+
+func (p *pool) Outputs(inputs int) (int, error) {
+	if inputs != p.in.Size() {
+		return 0, fmt.Errorf("pooling layer takes %s images of %d values, not %d", p.in, p.in.Size(), inputs)
+	}
+	return p.out.Size(), nil
+}
+
+// Forward sets the pooled images, with one sample per column. For max
+// pooling the row of the inputs each value kept came from is recorded in
+// the scratch for Backward.
+func (p *pool) Forward(s *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	in := inputs.RawMatrix()
+	if in.Rows != p.in.Size() {
+		return nil, fmt.Errorf("%w: %d inputs for %s images", helpers.ErrShape, in.Rows, p.in)
+	}
+	dst := s.Matrix(0, p.out.Size(), in.Cols)
+	out := dst.RawMatrix()
+	k, st := p.Kernel, p.Stride
+	if p.Type == MaxPooling {
+		if cap(s.argmax) < out.Rows*out.Cols {
+			s.argmax = make([]int, out.Rows*out.Cols)
+		}
+		s.argmax = s.argmax[:out.Rows*out.Cols]
+	}
+	for ch := 0; ch < p.out.Channels; ch++ {
+		for oy := 0; oy < p.out.Height; oy++ {
+			for ox := 0; ox < p.out.Width; ox++ {
+				row := (ch*p.out.Height+oy)*p.out.Width + ox
+				for j := 0; j < out.Cols; j++ {
+					best, at, sum := math.Inf(-1), 0, 0.0
+					for ky := 0; ky < k; ky++ {
+						for kx := 0; kx < k; kx++ {
+							r := (ch*p.in.Height+oy*st+ky)*p.in.Width + ox*st + kx
+							v := in.Data[r*in.Stride+j]
+							sum += v
+							if v > best {
+								best, at = v, r
+							}
+						}
+					}
+					if p.Type == MaxPooling {
+						out.Data[row*out.Stride+j] = best
+						s.argmax[row*out.Cols+j] = at
+					} else {
+						out.Data[row*out.Stride+j] = sum / float64(k*k)
+					}
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
+// Backward passes the gradient of each pooled value back to the values it
+// was pooled from.
+func (p *pool) Backward(s *Scratch, grad *mat.Dense, _ []*mat.Dense) (*mat.Dense, error) {
+	in := grad.RawMatrix()
+	dst := s.Matrix(1, p.in.Size(), in.Cols)
+	dst.Zero()
+	out := dst.RawMatrix()
+	if p.Type == MaxPooling {
+		for r := 0; r < in.Rows; r++ {
+			for j, v := range in.Data[r*in.Stride : r*in.Stride+in.Cols] {
+				out.Data[s.argmax[r*in.Cols+j]*out.Stride+j] += v
+			}
+		}
+		return dst, nil
+	}
+	k, st := p.Kernel, p.Stride
+	scale := 1 / float64(k*k)
+	for ch := 0; ch < p.out.Channels; ch++ {
+		for oy := 0; oy < p.out.Height; oy++ {
+			for ox := 0; ox < p.out.Width; ox++ {
+				row := (ch*p.out.Height+oy)*p.out.Width + ox
+				for j := 0; j < in.Cols; j++ {
+					v := in.Data[row*in.Stride+j] * scale
+					for ky := 0; ky < k; ky++ {
+						for kx := 0; kx < k; kx++ {
+							out.Data[((ch*p.in.Height+oy*st+ky)*p.in.Width+ox*st+kx)*out.Stride+j] += v
+						}
+					}
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
+func (p *pool) Params() []*mat.Dense {
+	return nil
+}
+
+func (p *pool) spec() string {
+	return fmt.Sprintf("%spool:%s:%d:%d", p.Type, p.in, p.Kernel, p.Stride)
+}
+
+func (p *pool) inputs() int {
+	return p.in.Size()
+}
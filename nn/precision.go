@@ -0,0 +1,146 @@
+package nn
+
+import (
+	"fmt"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/blas"
+	"gonum.org/v1/gonum/blas/blas32"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Precision is the floating point precision a network keeps its weights in
+// and works out its matrix products in.
+type Precision int
+
+const (
+	Float64 Precision = iota
+	// Float32 rounds the weights and biases to float32 and works out the
+	// matrix products of training and prediction in float32, which halves
+	// the memory they read. The other steps still run in float64.
+	Float32
+)
+
+// PrecisionByName returns the precision called "float64" or "float32".
+func PrecisionByName(name string) (Precision, error) {
+	switch name {
+	case "float64":
+		return Float64, nil
+	case "float32":
+		return Float32, nil
+	}
+	return 0, fmt.Errorf("nn: unknown precision %q", name)
+}
+
+func (p Precision) String() string {
+	if p == Float32 {
+		return "float32"
+	}
+	return "float64"
+}
+
+// bits returns the number of bits in a float of the precision, as stored in
+// model files.
+func (p Precision) bits() uint32 {
+	if p == Float32 {
+		return 32
+	}
+	return 64
+}
+
+// WithPrecision sets the precision of the network. The default is Float64.
+func WithPrecision(p Precision) Option {
+	return func(net *Network) {
+		net.precision = p
+	}
+}
+
+// Precision returns the precision of the network.
+func (net Network) Precision() Precision {
+	return net.precision
+}
+
+// syncParams rounds the parameters of a float32 network to float32 and
+// copies the weights to the float32 matrices its products read. It must be
+// called whenever the parameters change.
+func (net *Network) syncParams() {
+	for _, l := range net.layers {
+		if s, ok := l.(synced); ok {
+			s.sync(net.precision)
+		}
+	}
+}
+
+// outerProduct sets dst to a times the transpose of b, in precision p.
+func outerProduct(s *Scratch, p Precision, dst *mat.Dense, a, b mat.Matrix) error {
+	if p != Float32 {
+		return helpers.DotTo(dst, a, b.T())
+	}
+	return s.ws.gemm32(dst, float32s(&s.ws.a32, a), false, float32s(&s.ws.b32, b), true)
+}
+
+// gemm32 sets dst to the product of a and b, either transposed, worked out
+// in float32.
+func (ws *workspace) gemm32(dst *mat.Dense, a blas32.General, ta bool, b blas32.General, tb bool) error {
+	ar, ac := a.Rows, a.Cols
+	if ta {
+		ar, ac = ac, ar
+	}
+	br, bc := b.Rows, b.Cols
+	if tb {
+		br, bc = bc, br
+	}
+	if ac != br {
+		return fmt.Errorf("%w: cannot multiply %dx%d by %dx%d", helpers.ErrShape, ar, ac, br, bc)
+	}
+	if dr, dc := dst.Dims(); dr != ar || dc != bc {
+		return fmt.Errorf("%w: cannot write a %dx%d result to %dx%d", helpers.ErrShape, ar, bc, dr, dc)
+	}
+
+	if cap(ws.c32) < ar*bc {
+		ws.c32 = make([]float32, ar*bc)
+	}
+	c := blas32.General{Rows: ar, Cols: bc, Stride: bc, Data: ws.c32[:ar*bc]}
+	blas32.Gemm(transpose(ta), transpose(tb), 1, a, b, 0, c)
+
+	raw := dst.RawMatrix()
+	for r := 0; r < ar; r++ {
+		row := raw.Data[r*raw.Stride : r*raw.Stride+bc]
+		for j, v := range c.Data[r*bc : (r+1)*bc] {
+			row[j] = float64(v)
+		}
+	}
+	return nil
+}
+
+// float32s copies m to a float32 matrix backed by buf, growing it if need
+// be.
+func float32s(buf *[]float32, m mat.Matrix) blas32.General {
+	r, c := m.Dims()
+	if cap(*buf) < r*c {
+		*buf = make([]float32, r*c)
+	}
+	g := blas32.General{Rows: r, Cols: c, Stride: c, Data: (*buf)[:r*c]}
+	if d, ok := m.(*mat.Dense); ok {
+		raw := d.RawMatrix()
+		for i := 0; i < r; i++ {
+			for j, v := range raw.Data[i*raw.Stride : i*raw.Stride+c] {
+				g.Data[i*c+j] = float32(v)
+			}
+		}
+		return g
+	}
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			g.Data[i*c+j] = float32(m.At(i, j))
+		}
+	}
+	return g
+}
+
+func transpose(t bool) blas.Transpose {
+	if t {
+		return blas.Trans
+	}
+	return blas.NoTrans
+}
@@ -0,0 +1,226 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// rnn is a simple recurrent layer, an Elman network. Its input weights have
+// a row for each hidden value and a column for each feature of a step, and
+// its recurrent weights a row and a column for each hidden value.
+type rnn struct {
+	recurrent
+	wx, wh weightMatrix
+	biases *mat.Dense
+}
+
+// recurrent holds the sizes of a recurrent layer, which takes sequences of
+// steps steps with features values each and keeps a state of hidden values.
+type recurrent struct {
+	features, hidden, steps int
+	sequences               bool
+}
+
+// RNN returns a simple recurrent layer taking sequences of steps steps, with
+// features values for each. Each column of its inputs holds one sequence,
+// step after step. Its state of hidden values starts at zero, and at every
+// step is the tanh of the weighted step and weighted state of the step
+// before. It outputs the state after the last step or, if sequences is set,
+// after every step in turn, as another recurrent layer takes them. Training
+// backpropagates through every step.
+func RNN(features, hidden, steps int, sequences bool) Layer {
+	return &rnn{
+		recurrent: newRecurrent(features, hidden, steps, sequences),
+		wx:        weightMatrix{Dense: mat.NewDense(hidden, features, nil)},
+		wh:        weightMatrix{Dense: mat.NewDense(hidden, hidden, nil)},
+		biases:    mat.NewDense(hidden, 1, nil),
+	}
+}
+
+// newRecurrent returns the sizes of a recurrent layer, panicking if they
+// are not all positive.
+func newRecurrent(features, hidden, steps int, sequences bool) recurrent {
+	if features <= 0 || hidden <= 0 || steps <= 0 {
+		panic(fmt.Sprintf("nn: recurrent layer with %d features, %d hidden values and %d steps", features, hidden, steps))
+	}
+	return recurrent{features: features, hidden: hidden, steps: steps, sequences: sequences}
+}
+
+func (r recurrent) Outputs(inputs int) (int, error) {
+	if inputs != r.inputs() {
+		return 0, fmt.Errorf("recurrent layer takes %d steps of %d features, not %d inputs", r.steps, r.features, inputs)
+	}
+	if r.sequences {
+		return r.steps * r.hidden, nil
+	}
+	return r.hidden, nil
+}
+
+func (r recurrent) inputs() int {
+	return r.steps * r.features
+}
+
+// check returns an error if m does not hold sequences of the right size.
+func (r recurrent) check(m *mat.Dense) error {
+	if rows, _ := m.Dims(); rows != r.inputs() {
+		return fmt.Errorf("%w: %d inputs for %d steps of %d features", helpers.ErrShape, rows, r.steps, r.features)
+	}
+	return nil
+}
+
+// output returns the outputs of the layer from the states h of every step.
+func (r recurrent) output(h *mat.Dense) *mat.Dense {
+	if r.sequences {
+		return h
+	}
+	return step(h, r.steps-1, r.hidden)
+}
+
+// spec returns the spec of a recurrent layer of the given kind.
+func (r recurrent) spec(kind string) string {
+	spec := fmt.Sprintf("%s:%d:%d:%d", kind, r.features, r.hidden, r.steps)
+	if r.sequences {
+		spec += ":sequences"
+	}
+	return spec
+}
+
+func (r *rnn) Forward(s *Scratch, inputs *mat.Dense, _ bool) (*mat.Dense, error) {
+	if err := r.check(inputs); err != nil {
+		return nil, err
+	}
+	_, n := inputs.Dims()
+	h := s.Matrix(0, r.steps*r.hidden, n)
+	weighted := s.Matrix(1, r.hidden, n)
+	for t := 0; t < r.steps; t++ {
+		ht := step(h, t, r.hidden)
+		if err := r.wx.product(s, ht, false, step(inputs, t, r.features)); err != nil {
+			return nil, err
+		}
+		if t > 0 {
+			if err := r.wh.product(s, weighted, false, step(h, t-1, r.hidden)); err != nil {
+				return nil, err
+			}
+			if err := helpers.AddTo(ht, ht, weighted); err != nil {
+				return nil, err
+			}
+		}
+		if err := helpers.AddColumnTo(ht, ht, r.biases); err != nil {
+			return nil, err
+		}
+		helpers.Tanh{}.Apply(ht, ht)
+	}
+	return r.output(h), nil
+}
+
+// Backward works back from the last step to the first, passing the
+// gradient of each state on to the step before through the recurrent
+// weights.
+func (r *rnn) Backward(s *Scratch, grad *mat.Dense, grads []*mat.Dense) (*mat.Dense, error) {
+	_, n := grad.Dims()
+	wxGrad, whGrad, biasGrad := grads[0], grads[1], grads[2]
+	wxGrad.Zero()
+	whGrad.Zero()
+	biasGrad.Zero()
+	inputs, h := s.Inputs(), s.Matrix(0, r.steps*r.hidden, n)
+	// dh holds the gradient of the state of the step being worked out, and
+	// dz that of its weighted inputs
+	dh := s.Matrix(2, r.hidden, n)
+	dz := s.Matrix(3, r.hidden, n)
+	wxStep := s.Matrix(4, r.hidden, r.features)
+	whStep := s.Matrix(5, r.hidden, r.hidden)
+	biasStep := s.Matrix(6, r.hidden, 1)
+	var errors *mat.Dense
+	if s.InputGradient() {
+		errors = s.Matrix(7, r.steps*r.features, n)
+	}
+
+	dh.Zero()
+	for t := r.steps - 1; t >= 0; t-- {
+		if r.sequences {
+			if err := helpers.AddTo(dh, dh, step(grad, t, r.hidden)); err != nil {
+				return nil, err
+			}
+		} else if t == r.steps-1 {
+			dh.Copy(grad)
+		}
+		helpers.Tanh{}.Derivative(dz, step(h, t, r.hidden))
+		if err := helpers.MultiplyTo(dz, dz, dh); err != nil {
+			return nil, err
+		}
+		if err := outerProduct(s, r.wx.precision, wxStep, dz, step(inputs, t, r.features)); err != nil {
+			return nil, err
+		}
+		if err := helpers.AddTo(wxGrad, wxGrad, wxStep); err != nil {
+			return nil, err
+		}
+		// multiplying by a column of 1/n averages the deltas over the batch
+		if err := helpers.DotTo(biasStep, dz, s.ones(n)); err != nil {
+			return nil, err
+		}
+		if err := helpers.AddTo(biasGrad, biasGrad, biasStep); err != nil {
+			return nil, err
+		}
+		if errors != nil {
+			if err := r.wx.product(s, step(errors, t, r.features), true, dz); err != nil {
+				return nil, err
+			}
+		}
+		if t == 0 {
+			break
+		}
+		if err := outerProduct(s, r.wh.precision, whStep, dz, step(h, t-1, r.hidden)); err != nil {
+			return nil, err
+		}
+		if err := helpers.AddTo(whGrad, whGrad, whStep); err != nil {
+			return nil, err
+		}
+		if err := r.wh.product(s, dh, true, dz); err != nil {
+			return nil, err
+		}
+	}
+	if err := helpers.ScaleTo(wxGrad, 1/float64(n), wxGrad); err != nil {
+		return nil, err
+	}
+	return errors, helpers.ScaleTo(whGrad, 1/float64(n), whGrad)
+}
+
+func (r *rnn) Params() []*mat.Dense {
+	return []*mat.Dense{r.wx.Dense, r.wh.Dense, r.biases}
+}
+
+func (r *rnn) clone() Layer {
+	return &rnn{recurrent: r.recurrent, wx: r.wx.clone(), wh: r.wh.clone(), biases: mat.DenseCopyOf(r.biases)}
+}
+
+func (r *rnn) init(i Initializer, rng *rand.Rand) {
+	i.Init(r.wx.Dense, rng)
+	i.Init(r.wh.Dense, rng)
+}
+
+func (r *rnn) weights() []int {
+	return []int{0, 1}
+}
+
+func (r *rnn) sync(p Precision) {
+	r.wx.sync(p, r.biases)
+	r.wh.sync(p, r.biases)
+}
+
+func (r *rnn) spec() string {
+	return r.recurrent.spec("rnn")
+}
+
+func (r *rnn) paramNames() []string {
+	return []string{"input weights", "recurrent weights", "biases"}
+}
+
+// step returns the rows of m holding step t of sequences with size values
+// for each step.
+func step(m *mat.Dense, t, size int) *mat.Dense {
+	_, n := m.Dims()
+	return m.Slice(t*size, (t+1)*size, 0, n).(*mat.Dense)
+}
@@ -0,0 +1,45 @@
+package nn
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Saliency returns the gradient of the score the network gives class for
+// inputData with respect to each input, which shows how much each input,
+// such as a pixel, drove the prediction. The score is the output of the
+// network, or its input to the softmax for a softmax output layer, as the
+// softmax ties every class to every other.
+func (net Network) Saliency(inputData []float64, class int) ([]float64, error) {
+	if class < 0 || class >= net.Outputs() {
+		return nil, fmt.Errorf("nn: class %d out of range for %d outputs", class, net.Outputs())
+	}
+	ws := net.workspaces.Get().(*workspace)
+	defer net.workspaces.Put(ws)
+	last := len(net.layers)
+	if softmax(net.layers[last-1]) {
+		last--
+	}
+	outputs, err := net.forwardTo(ws, mat.NewDense(len(inputData), 1, inputData), last, false)
+	if err != nil {
+		return nil, err
+	}
+	rows, _ := outputs.Dims()
+	grad := resize(&ws.errors, rows, 1)
+	grad.Zero()
+	grad.Set(class, 0, 1)
+	for i := last - 1; i >= 0; i-- {
+		grads := ws.layerGrads[i]
+		for j, p := range net.layers[i].Params() {
+			r, c := p.Dims()
+			resize(grads[j], r, c)
+		}
+		s := ws.scratch[i]
+		s.inputGrad = true
+		if grad, err = net.layers[i].Backward(s, grad, grads); err != nil {
+			return nil, fmt.Errorf("nn: layer %d: %w", i+1, err)
+		}
+	}
+	return mat.Col(nil, 0, grad), nil
+}
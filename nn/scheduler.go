@@ -0,0 +1,144 @@
+package nn
+
+import "math"
+
+// Scheduler decides the learning rate for each epoch of training from the
+// base rate the network was created with.
+type Scheduler interface {
+	Rate(base float64, epoch int) float64
+}
+
+// ConstantRate keeps the learning rate fixed for the whole run.
+type ConstantRate struct{}
+
+func (ConstantRate) Rate(base float64, epoch int) float64 {
+	return base
+}
+
+// StepDecay multiplies the learning rate by Gamma every Step epochs.
+type StepDecay struct {
+	Step  int
+	Gamma float64
+}
+
+func (s StepDecay) Rate(base float64, epoch int) float64 {
+	if s.Step <= 0 {
+		return base
+	}
+	return base * math.Pow(s.Gamma, float64(epoch/s.Step))
+}
+
+// ExponentialDecay multiplies the learning rate by Gamma every epoch.
+type ExponentialDecay struct {
+	Gamma float64
+}
+
+func (s ExponentialDecay) Rate(base float64, epoch int) float64 {
+	return base * math.Pow(s.Gamma, float64(epoch))
+}
+
+// CosineAnnealing lowers the learning rate from the base rate to Min along a
+// half cosine over Epochs epochs.
+type CosineAnnealing struct {
+	Epochs int
+	Min    float64
+}
+
+func (s CosineAnnealing) Rate(base float64, epoch int) float64 {
+	if s.Epochs <= 1 {
+		return base
+	}
+	progress := math.Min(float64(epoch)/float64(s.Epochs-1), 1)
+	return s.Min + (base-s.Min)*(1+math.Cos(math.Pi*progress))/2
+}
+
+// CyclicCosine lowers the learning rate from the base rate towards Min
+// along a half cosine over Cycle epochs, then starts again from the base
+// rate for the next cycle. Unlike CosineAnnealing the last epoch of a cycle
+// stops short of Min, so it still trains with a small rate. Taking a
+// snapshot of the network at the end of each cycle makes a snapshot
+// ensemble.
+type CyclicCosine struct {
+	Cycle int
+	Min   float64
+}
+
+func (s CyclicCosine) Rate(base float64, epoch int) float64 {
+	if s.Cycle <= 1 {
+		return base
+	}
+	progress := float64(epoch%s.Cycle) / float64(s.Cycle)
+	return s.Min + (base-s.Min)*(1+math.Cos(math.Pi*progress))/2
+}
+
+// MomentumScheduler is a Scheduler that also sets the momentum of the
+// optimizer for each epoch: the Momentum of Momentum, or Beta1 of Adam and
+// AdamW, including when wrapped in a Lookahead. Other optimizers keep
+// theirs.
+type MomentumScheduler interface {
+	Scheduler
+	Momentum(epoch int) float64
+}
+
+// OneCycle raises the learning rate from the base rate over Div to the base
+// rate over the first Warmup of Epochs epochs, then lowers it along a half
+// cosine to Min by the last, while the momentum moves the other way, from
+// MaxMomentum down to MinMomentum and back. The high rates in the middle
+// of the cycle train fast while the momentum steadies them, which usually
+// trains to better accuracy in fewer epochs, with a base rate found by
+// ml lrfind. Warmup defaults to 0.3, Div to 25, Min to the starting rate
+// over 10^4, and the momentum to between 0.85 and 0.95 when left as zero.
+type OneCycle struct {
+	Epochs                   int
+	Warmup, Div, Min         float64
+	MaxMomentum, MinMomentum float64
+}
+
+// phase returns how far into the cycle epoch is, from 0 at the start and
+// end to 1 at the peak, along with whether it is still rising.
+func (s OneCycle) phase(epoch int) (float64, bool) {
+	last := s.Epochs - 1
+	peak := int(math.Round(orDefault(s.Warmup, 0.3) * float64(last)))
+	if epoch >= last {
+		return 0, false
+	}
+	if epoch < peak {
+		return (1 - math.Cos(math.Pi*float64(epoch)/float64(peak))) / 2, true
+	}
+	return (1 + math.Cos(math.Pi*float64(epoch-peak)/float64(last-peak))) / 2, false
+}
+
+func (s OneCycle) Rate(base float64, epoch int) float64 {
+	if s.Epochs <= 1 {
+		return base
+	}
+	t, rising := s.phase(epoch)
+	lo := base / orDefault(s.Div, 25)
+	if !rising {
+		lo = orDefault(s.Min, lo/1e4)
+	}
+	return lo + (base-lo)*t
+}
+
+func (s OneCycle) Momentum(epoch int) float64 {
+	hi, lo := orDefault(s.MaxMomentum, 0.95), orDefault(s.MinMomentum, 0.85)
+	if s.Epochs <= 1 {
+		return hi
+	}
+	t, _ := s.phase(epoch)
+	return hi - (hi-lo)*t
+}
+
+// setMomentum sets the momentum of opt, if it has one, to m.
+func setMomentum(opt Optimizer, m float64) {
+	switch o := opt.(type) {
+	case *Momentum:
+		o.Momentum = m
+	case *Adam:
+		o.Beta1 = m
+	case *AdamW:
+		o.Beta1 = m
+	case *Lookahead:
+		setMomentum(o.Inner, m)
+	}
+}
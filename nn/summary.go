@@ -0,0 +1,92 @@
+package nn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// shaped layers take and give images, so have shapes to show in a summary
+// rather than just sizes.
+type shaped interface {
+	shapes() (in, out Shape)
+}
+
+func (c *conv) shapes() (in, out Shape) {
+	return c.in, c.out
+}
+
+func (p *pool) shapes() (in, out Shape) {
+	return p.in, p.out
+}
+
+// Summary describes the network layer by layer, in a table giving the kind
+// of each layer, the shape of its outputs, the activation applied to them
+// and its number of parameters, followed by the total number of parameters
+// and how many of them are trained, much like model.summary() in Keras. An
+// activation is shown along with the layer before it.
+func (net Network) Summary() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tLayer\tOutput shape\tActivation\tParams")
+	input := strconv.Itoa(net.Inputs())
+	for _, l := range net.layers {
+		if s, ok := l.(shaped); ok {
+			in, _ := s.shapes()
+			input = in.String()
+			break
+		}
+		if _, ok := l.(sized); ok {
+			break
+		}
+	}
+	fmt.Fprintf(w, "\tinput\t%s\t\t0\n", input)
+
+	total, trained := 0, 0
+	n := net.Inputs()
+	for i, row := 0, 1; i < len(net.layers); i, row = i+1, row+1 {
+		l := net.layers[i]
+		// the layers are known to fit together from when they were built
+		n, _ = l.Outputs(n)
+		shape := strconv.Itoa(n)
+		if s, ok := l.(shaped); ok {
+			_, out := s.shapes()
+			shape = out.String()
+		}
+		kind, act := layerKind(l), ""
+		params := 0
+		for _, p := range l.Params() {
+			r, c := p.Dims()
+			params += r * c
+		}
+		total += params
+		if net.Frozen(i) {
+			kind += " (frozen)"
+		} else {
+			trained += params
+		}
+		if a, ok := l.(activation); ok {
+			kind, act = "activation", a.Name()
+		} else if i+1 < len(net.layers) {
+			if a, ok := net.layers[i+1].(activation); ok {
+				act = a.Name()
+				i++
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\n", row, kind, shape, act, params)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "\nTotal params: %d\nTrainable params: %d\nNon-trainable params: %d\n", total, trained, total-trained)
+	return b.String()
+}
+
+// layerKind returns the kind of layer l is, as named at the start of its
+// spec, or its Go type if it has none.
+func layerKind(l Layer) string {
+	kind := layerName(l)
+	if i := strings.Index(kind, ":"); i >= 0 {
+		kind = kind[:i]
+	}
+	return kind
+}
@@ -0,0 +1,100 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/kheob/ml/helpers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// workspace holds the matrices a pass over a mini-batch works in. Training
+// takes one from the network's pool for each batch, so that after the
+// first few batches the matrices are reused rather than allocated afresh.
+// The matrices are resized to fit each batch, keeping their backing arrays
+// when they are big enough.
+type workspace struct {
+	inputs, targets mat.Dense
+	// scratch holds what each layer works with on a pass, including its
+	// inputs and outputs.
+	scratch []*Scratch
+	// errors holds the gradient of the loss with respect to the outputs.
+	errors mat.Dense
+	// rng holds the random numbers dropout masks are drawn from.
+	rng *rand.Rand
+	// ones is a column of 1/n for summing the deltas over a batch of n.
+	ones mat.Dense
+	// grads holds the gradients of the parameters of every layer, layer
+	// by layer, and layerGrads the gradients of each layer.
+	grads      []*mat.Dense
+	layerGrads [][]*mat.Dense
+	// a32, b32 and c32 hold the operands and result of a float32 matrix
+	// product.
+	a32, b32, c32 []float32
+}
+
+func newWorkspace(layers []Layer) *workspace {
+	ws := &workspace{
+		scratch:    make([]*Scratch, len(layers)),
+		layerGrads: make([][]*mat.Dense, len(layers)),
+	}
+	for i, l := range layers {
+		ws.scratch[i] = &Scratch{ws: ws}
+		for range l.Params() {
+			ws.grads = append(ws.grads, &mat.Dense{})
+		}
+	}
+	k := 0
+	for i, l := range layers {
+		n := len(l.Params())
+		ws.layerGrads[i] = ws.grads[k : k+n]
+		k += n
+	}
+	return ws
+}
+
+// newWorkspacePool returns a pool of workspaces for a network with the
+// given layers.
+func newWorkspacePool(layers []Layer) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			return newWorkspace(layers)
+		},
+	}
+}
+
+// resize makes m an r x c matrix, keeping its backing array if it is big
+// enough. m is zeroed if its shape changes, and otherwise left as it is.
+func resize(m *mat.Dense, r, c int) *mat.Dense {
+	if mr, mc := m.Dims(); mr != r || mc != c {
+		m.Reset()
+		m.ReuseAs(r, c)
+	}
+	return m
+}
+
+// checkColumns returns an error unless every sample in data has n inputs or
+// targets, what names which.
+func checkColumns(data [][]float64, what string, n int) error {
+	for j, d := range data {
+		if len(d) != n {
+			return fmt.Errorf("nn: %w: sample %d has %d %s, want %d", helpers.ErrShape, j+1, len(d), what, n)
+		}
+	}
+	return nil
+}
+
+// setColumns stacks the inputs or targets of samples, what names which, as
+// the columns of m. It returns an error, before touching m, unless every
+// sample has n of them.
+func setColumns(m *mat.Dense, data [][]float64, what string, n int) (*mat.Dense, error) {
+	if err := checkColumns(data, what, n); err != nil {
+		return nil, err
+	}
+	resize(m, n, len(data))
+	for j, d := range data {
+		m.SetCol(j, d)
+	}
+	return m, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/nn"
+	"gonum.org/v1/gonum/mat"
+)
+
+func predictCmd(args []string) error {
+	fs := flag.NewFlagSet("predict", flag.ExitOnError)
+	modelPath := fs.String("model", "data/mnist.model", "Path of the model or ensemble to predict with, or its name in the model registry")
+	models := fs.String("models", "", "Comma separated models to predict with as an ensemble instead of -model")
+	combine := fs.String("combine", "average", "How to combine the outputs of an ensemble: average or vote")
+	name := fs.String("dataset", "mnist", "Dataset the model was trained on, used to name the predicted classes")
+	image := fs.String("image", "", "PNG or JPEG image to classify instead of reading stdin")
+	in := fs.String("in", "", "CSV file of images to classify instead of reading stdin")
+	out := fs.String("out", "", "CSV file to write the predicted class and the probability of each class to for every image")
+	skipBadRows := fs.Bool("skip-bad-rows", false, "Skip the rows that cannot be read, logging each and counting them, rather than stopping at the first")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ml predict [flags] < samples.csv")
+		fmt.Fprintln(fs.Output(), "       ml predict [flags] -in samples.csv -out predictions.csv")
+		fmt.Fprintln(fs.Output(), "       ml predict [flags] -image digit.png")
+		fmt.Fprintln(fs.Output(), "\nReads one image of 784 pixel values per line and prints the predicted class for each,")
+		fmt.Fprintln(fs.Output(), "or classifies a single image file and prints the probability of each class.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	set, err := imageSet(*name)
+	if err != nil {
+		return err
+	}
+	var net predictor
+	if *models != "" {
+		c, err := nn.CombinationByName(*combine)
+		if err != nil {
+			return err
+		}
+		e, err := loadEnsemble(strings.Split(*models, ","), c)
+		if err != nil {
+			return err
+		}
+		if err := checkOutputs(e.Networks[0], set); err != nil {
+			return err
+		}
+		net = e
+	} else if e, err := loadEnsembleFile(*modelPath); err != nil {
+		return err
+	} else if e != nil {
+		if err := checkOutputs(e.Networks[0], set); err != nil {
+			return err
+		}
+		net = *e
+	} else {
+		n, err := loadModel(*modelPath)
+		if err != nil {
+			return err
+		}
+		if err := checkOutputs(n, set); err != nil {
+			return err
+		}
+		net = n
+	}
+
+	if *image != "" {
+		return predictImage(net, set, *image)
+	}
+
+	var r io.Reader = os.Stdin
+	path := "stdin"
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, path = f, *in
+	}
+	var bad *badRows
+	if *skipBadRows {
+		bad = &badRows{}
+	}
+	// stdout may be the predictions
+	defer bad.report(os.Stderr)
+	if *out == "" {
+		return predictRows(net, r, path, bad, func(inputs []float64) error {
+			outputs, err := net.PredictErr(inputs)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Println(set.Classes[net.ClassOf(mat.Col(nil, 0, outputs))])
+			return err
+		})
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(bufio.NewWriter(f))
+	w.Write(append([]string{"class"}, set.Classes...))
+	err = predictRows(net, r, path, bad, func(inputs []float64) error {
+		outputs, err := net.PredictErr(inputs)
+		if err != nil {
+			return err
+		}
+		record := []string{set.Classes[net.ClassOf(mat.Col(nil, 0, outputs))]}
+		for _, p := range probabilities(outputs) {
+			record = append(record, strconv.FormatFloat(p, 'f', 6, 64))
+		}
+		return w.Write(record)
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// predictor is a network, or an ensemble of them, to predict with.
+type predictor interface {
+	Inputs() int
+	PredictErr(inputData []float64) (mat.Matrix, error)
+	ClassOf(outputs []float64) int
+}
+
+// loadEnsemble loads the models at paths, or named so in the registry, as
+// an ensemble combining their outputs with c.
+func loadEnsemble(paths []string, c nn.Combination) (nn.Ensemble, error) {
+	var nets []nn.Network
+	for _, path := range paths {
+		net, err := loadModel(strings.TrimSpace(path))
+		if err != nil {
+			return nn.Ensemble{}, err
+		}
+		nets = append(nets, net)
+	}
+	return nn.NewEnsemble(nets, c)
+}
+
+// predictRows reads images of pixel values from r, the file at path, one per
+// CSV row, and calls fn with the network inputs for each. A header row is
+// skipped. A row that cannot be read ends the read with a *dataset.RowError,
+// or is passed to bad and skipped if bad is not nil.
+func predictRows(net predictor, r io.Reader, path string, bad *badRows, fn func(inputs []float64) error) error {
+	skip := bad.skipper()
+	cr := csv.NewReader(bufio.NewReader(r))
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var inputs []float64
+		if pe, ok := err.(*csv.ParseError); ok {
+			err = &dataset.RowError{Path: path, Line: pe.StartLine, Err: pe.Err}
+		} else if err == nil {
+			if _, headerErr := strconv.ParseFloat(record[0], 64); headerErr != nil && row == 1 {
+				// a header such as pixel0,pixel1,...
+				continue
+			}
+			line, _ := cr.FieldPos(0)
+			inputs, err = rowInputs(net, path, line, record)
+		}
+		if re, ok := err.(*dataset.RowError); ok && skip != nil {
+			skip(re)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(inputs); err != nil {
+			return err
+		}
+	}
+}
+
+// rowInputs parses the pixel values of the record on line of the file at
+// path, returning a *dataset.RowError if they are not numbers or not as many
+// as net takes.
+func rowInputs(net predictor, path string, line int, record []string) ([]float64, error) {
+	if len(record) != net.Inputs() {
+		return nil, &dataset.RowError{Path: path, Line: line, Err: fmt.Errorf("got %d values, want %d", len(record), net.Inputs())}
+	}
+	inputs := make([]float64, len(record))
+	for i, v := range record {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, &dataset.RowError{Path: path, Line: line, Column: i + 1, Err: fmt.Errorf("invalid pixel %q", v)}
+		}
+		inputs[i] = dataset.PixelInput(x)
+	}
+	return inputs, nil
+}
+
+// probabilities returns the outputs of the network as probabilities. A
+// softmax output already sums to one, anything else is scaled to.
+func probabilities(outputs mat.Matrix) []float64 {
+	sum := mat.Sum(outputs)
+	r, _ := outputs.Dims()
+	p := make([]float64, r)
+	for i := range p {
+		p[i] = outputs.At(i, 0) / sum
+	}
+	return p
+}
+
+// predictImage classifies the image file at path and prints the predicted
+// class followed by the probability of each class.
+func predictImage(net predictor, set dataset.ImageSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	inputs, err := dataset.DecodeImage(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	outputs, err := net.PredictErr(inputs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	p := probabilities(outputs)
+	fmt.Println(set.Classes[net.ClassOf(mat.Col(nil, 0, outputs))])
+	for i, class := range set.Classes {
+		fmt.Printf("%s\t%.4f\n", class, p[i])
+	}
+	return nil
+}
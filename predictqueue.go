@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kheob/ml/nn"
+)
+
+// predictQueue gathers concurrent predictions into batches, so that the
+// network runs one matrix multiplication per layer for the whole batch
+// rather than one for each request.
+type predictQueue struct {
+	model    func() nn.Network
+	size     int
+	wait     time.Duration
+	requests chan queuedPrediction
+}
+
+type queuedPrediction struct {
+	inputs []float64
+	result chan predictResult
+}
+
+type predictResult struct {
+	outputs []float64
+	err     error
+}
+
+// newPredictQueue returns a queue that runs batches of up to size
+// predictions through the network returned by model, waiting at most wait
+// after the first prediction of a batch for the rest to arrive.
+func newPredictQueue(model func() nn.Network, size int, wait time.Duration) *predictQueue {
+	return &predictQueue{
+		model:    model,
+		size:     size,
+		wait:     wait,
+		requests: make(chan queuedPrediction, size),
+	}
+}
+
+// predict queues inputs to be run through the network with the next batch
+// and returns the outputs, or the error of the batch.
+func (q *predictQueue) predict(inputs []float64) ([]float64, error) {
+	result := make(chan predictResult, 1)
+	q.requests <- queuedPrediction{inputs, result}
+	r := <-result
+	return r.outputs, r.err
+}
+
+// run collects and runs batches for as long as the program runs.
+func (q *predictQueue) run() {
+	batch := make([]queuedPrediction, 0, q.size)
+	for {
+		batch = append(batch[:0], <-q.requests)
+		timeout := time.NewTimer(q.wait)
+	collect:
+		for len(batch) < q.size {
+			select {
+			case p := <-q.requests:
+				batch = append(batch, p)
+			case <-timeout.C:
+				break collect
+			}
+		}
+		timeout.Stop()
+
+		inputs := make([][]float64, len(batch))
+		for i, p := range batch {
+			inputs[i] = p.inputs
+		}
+		outputs, err := predictBatch(q.model(), inputs)
+		for i, p := range batch {
+			if err != nil {
+				p.result <- predictResult{err: err}
+				continue
+			}
+			p.result <- predictResult{outputs: outputs[i]}
+		}
+	}
+}
+
+// predictBatch runs inputs through net. Any panic of the network is
+// returned as an error too, rather than taking the server down.
+func predictBatch(net nn.Network, inputs [][]float64) (outputs [][]float64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("predicting: %v", r)
+		}
+	}()
+	return net.PredictBatchErr(inputs)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// progress draws a status line for the epoch being trained, redrawn in
+// place as batches complete.
+type progress struct {
+	w      io.Writer
+	epochs int
+
+	epoch int
+	// total is the number of samples in an epoch, or zero until it is
+	// known.
+	total int
+	// skipped is the number of samples the epoch started with already
+	// done, when resuming.
+	skipped int
+	start   time.Time
+	drawn   time.Time
+}
+
+// newProgress returns a progress display on stderr, or nil if stderr is
+// not a terminal.
+func newProgress(epochs int) *progress {
+	info, err := os.Stderr.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progress{w: os.Stderr, epochs: epochs}
+}
+
+// begin starts the display for an epoch, with done samples of it already
+// trained on.
+func (p *progress) begin(epoch, done int) {
+	p.epoch, p.skipped = epoch, done
+	p.start = time.Now()
+	p.drawn = time.Time{}
+}
+
+// update redraws the status line, at most ten times a second.
+func (p *progress) update(done int, loss float64) {
+	if time.Since(p.drawn) < 100*time.Millisecond {
+		return
+	}
+	p.drawn = time.Now()
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "epoch %d/%d", p.epoch+1, p.epochs)
+	if p.total > 0 {
+		const width = 20
+		filled := width * done / p.total
+		if filled > width {
+			filled = width
+		}
+		fmt.Fprintf(&line, " [%s%s] %3d%%", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), 100*done/p.total)
+	} else {
+		fmt.Fprintf(&line, " %d samples", done)
+	}
+
+	elapsed := time.Since(p.start).Seconds()
+	rate := float64(done-p.skipped) / elapsed
+	fmt.Fprintf(&line, " %.0f samples/s loss %.4f", rate, loss)
+	if p.total > 0 && rate > 0 {
+		eta := time.Duration(float64(p.total-done) / rate * float64(time.Second))
+		fmt.Fprintf(&line, " ETA %s", eta.Round(time.Second))
+	}
+	fmt.Fprintf(p.w, "\r%s\033[K", line.String())
+}
+
+// clear erases the status line so other output can be printed.
+func (p *progress) clear() {
+	fmt.Fprint(p.w, "\r\033[K")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/kheob/ml/dataset"
+)
+
+func reconstructCmd(args []string) error {
+	fs := flag.NewFlagSet("reconstruct", flag.ExitOnError)
+	modelPath := fs.String("model", "data/autoencoder.model", "Path of the autoencoder to reconstruct images with")
+	name := fs.String("dataset", "mnist", "Image dataset to take the images from")
+	testData := fs.String("test-data", "", "Path of the test data, either a CSV file or a directory of IDX files (default <dataset>_dataset)")
+	n := fs.Int("n", 10, "Number of images to reconstruct")
+	out := fs.String("out", "reconstructions.png", "PNG file to write the images to, with the originals above their reconstructions")
+	fs.Parse(args)
+
+	if *n <= 0 {
+		return fmt.Errorf("-n must be positive, got %d", *n)
+	}
+	set, err := imageSet(*name)
+	if err != nil {
+		return err
+	}
+	net, err := loadModel(*modelPath)
+	if err != nil {
+		return err
+	}
+	if !net.IsAutoencoder() || net.Inputs() != dataset.ImagePixels || net.Outputs() != dataset.ImagePixels {
+		return fmt.Errorf("%s is not an autoencoder of %d pixel images, train one with ml train -task autoencoder", *modelPath, dataset.ImagePixels)
+	}
+
+	var originals [][]float64
+	errEnough := errors.New("enough images")
+	err = imageData(set, *testData, true, nil).Each(func(s dataset.Sample) error {
+		originals = append(originals, s.Inputs)
+		if len(originals) == *n {
+			return errEnough
+		}
+		return nil
+	})
+	if err != nil && err != errEnough {
+		return err
+	}
+	if len(originals) == 0 {
+		return fmt.Errorf("no images in the %s test data", set.Name)
+	}
+
+	reconstructed, err := net.PredictBatchErr(originals)
+	if err != nil {
+		return err
+	}
+	if err := writePNG(*out, imageGrid([][][]float64{originals, reconstructed})); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d images and their reconstructions to %s\n", len(originals), *out)
+	return nil
+}
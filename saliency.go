@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+
+	"github.com/kheob/ml/dataset"
+)
+
+func saliencyCmd(args []string) error {
+	fs := flag.NewFlagSet("saliency", flag.ExitOnError)
+	modelPath := fs.String("model", "data/mnist.model", "Path of the model to explain")
+	name := fs.String("dataset", "mnist", "Dataset the model was trained on, used to name the classes")
+	imagePath := fs.String("image", "", "PNG or JPEG image to explain the prediction for")
+	testData := fs.String("test-data", "", "Path of the test data to take the image from when no -image is given, either a CSV file or a directory of IDX files (default <dataset>_dataset)")
+	index := fs.Int("index", 0, "Index of the test image to explain when no -image is given, counting from 0")
+	className := fs.String("class", "", "Class to explain the score of (default the predicted class)")
+	out := fs.String("out", "saliency.png", "PNG file to write the image and its saliency map to, side by side")
+	scale := fs.Int("scale", 8, "Number of pixels to draw each image pixel as")
+	fs.Parse(args)
+
+	if *scale <= 0 {
+		return fmt.Errorf("-scale must be positive, got %d", *scale)
+	}
+	set, err := imageSet(*name)
+	if err != nil {
+		return err
+	}
+	net, err := loadModel(*modelPath)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputs(net, set); err != nil {
+		return err
+	}
+	if net.Inputs() != dataset.ImagePixels {
+		return fmt.Errorf("model takes %d inputs, not the %d pixels of an image", net.Inputs(), dataset.ImagePixels)
+	}
+
+	var inputs []float64
+	if *imagePath != "" {
+		f, err := os.Open(*imagePath)
+		if err != nil {
+			return err
+		}
+		inputs, err = dataset.DecodeImage(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", *imagePath, err)
+		}
+	} else if inputs, err = testImage(imageData(set, *testData, true, nil), *index); err != nil {
+		return err
+	}
+
+	predicted := net.Classify(inputs)
+	class := predicted
+	if *className != "" {
+		if class = classIndex(set.Classes, *className); class < 0 {
+			return fmt.Errorf("unknown class %q for %s", *className, set.Name)
+		}
+	}
+	grads, err := net.Saliency(inputs, class)
+	if err != nil {
+		return err
+	}
+
+	side := imageSide * *scale
+	img := image.NewRGBA(image.Rect(0, 0, 2*side, side))
+	draw.Draw(img, image.Rect(0, 0, side, side), scaled(pixelImage(inputs), *scale), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(side, 0, 2*side, side), scaled(heatmap(grads), *scale), image.Point{}, draw.Src)
+	if err := writePNG(*out, img); err != nil {
+		return err
+	}
+	fmt.Printf("predicted %s, explaining %s\n", set.Classes[predicted], set.Classes[class])
+	fmt.Printf("wrote the image and its saliency map to %s\n", *out)
+	return nil
+}
+
+// testImage returns the inputs of the image at index i of data.
+func testImage(data dataset.Dataset, i int) ([]float64, error) {
+	if i < 0 {
+		return nil, fmt.Errorf("-index must not be negative, got %d", i)
+	}
+	var inputs []float64
+	n := 0
+	errFound := errors.New("found image")
+	err := data.Each(func(s dataset.Sample) error {
+		if n == i {
+			inputs = append([]float64(nil), s.Inputs...)
+			return errFound
+		}
+		n++
+		return nil
+	})
+	if err != nil && err != errFound {
+		return nil, err
+	}
+	if inputs == nil {
+		return nil, fmt.Errorf("no image %d in the test data, which has %d", i, n)
+	}
+	return inputs, nil
+}
+
+// classIndex returns the index of the class called name, or -1 if there is
+// no such class.
+func classIndex(classes []string, name string) int {
+	for i, c := range classes {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// heatmap draws the size of each gradient relative to the largest, from
+// black through red and yellow to white.
+func heatmap(grads []float64) *image.RGBA {
+	max := 0.0
+	for _, g := range grads {
+		max = math.Max(max, math.Abs(g))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, imageSide, imageSide))
+	for p, g := range grads {
+		v := 0.0
+		if max > 0 {
+			v = math.Abs(g) / max
+		}
+		channel := func(from float64) uint8 {
+			return uint8(255*math.Min(math.Max(3*v-from, 0), 1) + 0.5)
+		}
+		img.SetRGBA(p%imageSide, p/imageSide, color.RGBA{R: channel(0), G: channel(1), B: channel(2), A: 255})
+	}
+	return img
+}
+
+// scaled returns img enlarged by the given factor, each pixel drawn as a
+// square.
+func scaled(img image.Image, factor int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			out.Set(x, y, img.At(b.Min.X+x/factor, b.Min.Y+y/factor))
+		}
+	}
+	return out
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/proto/inferencepb"
+)
+
+type predictRequest struct {
+	// Pixels holds the 784 pixel values of the image, each in the range
+	// 0-255.
+	Pixels []float64 `json:"pixels"`
+}
+
+type predictResponse struct {
+	Label   int       `json:"label"`
+	Class   string    `json:"class"`
+	Outputs []float64 `json:"outputs"`
+}
+
+func serveCmd(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	modelPath := fs.String("model", "data/mnist.model", "Path of the model to serve, or its name in the model registry")
+	name := fs.String("dataset", "mnist", "Dataset the model was trained on, used to name the predicted classes")
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	grpcAddr := fs.String("grpc-addr", "", "Address to also serve the Inference gRPC service on, empty to serve only HTTP")
+	watch := fs.Duration("watch", 0, "How often to check the model file for changes and reload it, 0 to only reload on POST /reload")
+	batchSize := fs.Int("batch-size", 1, "Most requests to run through the network together in one batch")
+	batchWait := fs.Duration("batch-wait", 5*time.Millisecond, "Longest to wait for a batch to fill up")
+	fs.Parse(args)
+
+	set, err := imageSet(*name)
+	if err != nil {
+		return err
+	}
+	metrics := newServerMetrics(set.Classes)
+	model, err := newServedModel(*modelPath, set, metrics)
+	if err != nil {
+		return err
+	}
+	if *watch > 0 {
+		go model.watch(*watch)
+	}
+
+	predict := func(inputs []float64) ([]float64, error) {
+		outputs, err := predictBatch(model.get(), [][]float64{inputs})
+		if err != nil {
+			return nil, err
+		}
+		return outputs[0], nil
+	}
+	if *batchSize > 1 {
+		q := newPredictQueue(model.get, *batchSize, *batchWait)
+		go q.run()
+		predict = q.predict
+	}
+
+	http.HandleFunc("/predict", metrics.instrument("/predict", predictHandler(predict, set, metrics)))
+	http.HandleFunc("/reload", metrics.instrument("/reload", model.reloadHandler))
+	http.Handle("/metrics", metrics)
+
+	errs := make(chan error, 2)
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+		srv := grpc.NewServer()
+		inferencepb.RegisterInferenceServer(srv, &inferenceServer{model: model, predict: predict})
+		log.Printf("serving %s over gRPC on %s", *modelPath, *grpcAddr)
+		go func() { errs <- srv.Serve(lis) }()
+	}
+	log.Printf("serving %s on %s", *modelPath, *addr)
+	go func() { errs <- http.ListenAndServe(*addr, nil) }()
+	return <-errs
+}
+
+// predictHandler classifies the image POSTed as JSON, using predict to get
+// the outputs of the network, and counts the predictions in metrics.
+func predictHandler(predict func(inputs []float64) ([]float64, error), set dataset.ImageSet, metrics *serverMetrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req predictRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Pixels) != dataset.ImagePixels {
+			http.Error(w, fmt.Sprintf("got %d pixels, want %d", len(req.Pixels), dataset.ImagePixels), http.StatusBadRequest)
+			return
+		}
+
+		inputs := make([]float64, len(req.Pixels))
+		for i, x := range req.Pixels {
+			inputs[i] = dataset.PixelInput(x)
+		}
+		outputs, err := predict(inputs)
+		if err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp := predictResponse{Label: bestOutput(outputs), Outputs: outputs}
+		resp.Class = set.Classes[resp.Label]
+		metrics.predicted(resp.Label)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// bestOutput returns the index of the largest of outputs, the label the
+// network predicts.
+func bestOutput(outputs []float64) int {
+	best := 0
+	for i := range outputs {
+		if outputs[i] > outputs[best] {
+			best = i
+		}
+	}
+	return best
+}
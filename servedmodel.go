@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/nn"
+)
+
+// servedModel holds the network being served and swaps in a new one when
+// the model file is reloaded. Requests already running carry on with the
+// network they started with.
+type servedModel struct {
+	path    string
+	set     dataset.ImageSet
+	metrics *serverMetrics
+
+	net atomic.Value // nn.Network
+
+	// mu serializes reloads. file is the model file loaded, which is path
+	// unless that names a model in the registry.
+	mu      sync.Mutex
+	file    string
+	modTime time.Time
+}
+
+// newServedModel loads the model at path to serve it.
+func newServedModel(path string, set dataset.ImageSet, metrics *serverMetrics) (*servedModel, error) {
+	m := &servedModel{path: path, set: set, metrics: metrics}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// get returns the network currently being served.
+func (m *servedModel) get() nn.Network {
+	return m.net.Load().(nn.Network)
+}
+
+// reload loads the model file again and starts serving it. The network
+// being served is left alone if the new one cannot be loaded.
+func (m *servedModel) reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, err := modelPath(m.path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	net, err := loadModel(path)
+	if err != nil {
+		return err
+	}
+	if net.Inputs() != dataset.ImagePixels {
+		return fmt.Errorf("model takes %d inputs but %s images have %d pixels", net.Inputs(), m.set.Name, dataset.ImagePixels)
+	}
+	if err := checkOutputs(net, m.set); err != nil {
+		return err
+	}
+	m.net.Store(net)
+	m.file, m.modTime = path, info.ModTime()
+
+	layers := sizes(net.Sizes())
+	m.metrics.setInfo(map[string]string{
+		"model":   m.path,
+		"dataset": m.set.Name,
+		"sizes":   layers.String(),
+	})
+	return nil
+}
+
+// watch reloads the model whenever the file changes, checking every
+// interval. It never returns.
+func (m *servedModel) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		path, err := modelPath(m.path)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		m.mu.Lock()
+		changed := path != m.file || !info.ModTime().Equal(m.modTime)
+		m.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := m.reload(); err != nil {
+			log.Printf("reloading %s: %v", m.path, err)
+			continue
+		}
+		log.Printf("reloaded %s", m.path)
+	}
+}
+
+// reloadHandler reloads the model when POSTed to.
+func (m *servedModel) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := m.reload(); err != nil {
+		log.Printf("reloading %s: %v", m.path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("reloaded %s", m.path)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the request latency
+// histogram.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// serverMetrics counts the requests handled by ml serve and the classes it
+// predicts, and serves them on /metrics in the Prometheus text format.
+type serverMetrics struct {
+	mu sync.Mutex
+	// requests counts requests by path and status code.
+	requests map[[2]string]int
+	// latency counts requests by the first bucket their latency fits in,
+	// with a final bucket for anything slower.
+	latency      []int
+	latencySum   float64
+	latencyCount int
+	predictions  []int
+
+	classes []string
+	// info holds the labels describing the model being served.
+	info map[string]string
+}
+
+func newServerMetrics(classes []string) *serverMetrics {
+	return &serverMetrics{
+		requests:    map[[2]string]int{},
+		latency:     make([]int, len(latencyBuckets)+1),
+		predictions: make([]int, len(classes)),
+		classes:     classes,
+	}
+}
+
+// setInfo sets the labels describing the model being served.
+func (m *serverMetrics) setInfo(info map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.info = info
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrument wraps h to count its requests and how long they take.
+func (m *serverMetrics) instrument(path string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		elapsed := time.Since(start).Seconds()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.requests[[2]string{path, strconv.Itoa(rec.status)}]++
+		i := sort.SearchFloat64s(latencyBuckets, elapsed)
+		m.latency[i]++
+		m.latencySum += elapsed
+		m.latencyCount++
+	}
+}
+
+// predicted counts a prediction of the class with the given label.
+func (m *serverMetrics) predicted(label int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.predictions[label]++
+}
+
+func (m *serverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+
+	b.WriteString("# HELP ml_model_info The model being served.\n# TYPE ml_model_info gauge\n")
+	fmt.Fprintf(&b, "ml_model_info%s 1\n", labels(m.info))
+
+	b.WriteString("# HELP ml_requests_total Requests handled, by path and status code.\n# TYPE ml_requests_total counter\n")
+	keys := make([][2]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0]+" "+keys[i][1] < keys[j][0]+" "+keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "ml_requests_total%s %d\n", labels(map[string]string{"path": k[0], "code": k[1]}), m.requests[k])
+	}
+
+	b.WriteString("# HELP ml_request_duration_seconds Time taken to handle requests.\n# TYPE ml_request_duration_seconds histogram\n")
+	cumulative := 0
+	for i, le := range latencyBuckets {
+		cumulative += m.latency[i]
+		fmt.Fprintf(&b, "ml_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(&b, "ml_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(&b, "ml_request_duration_seconds_sum %s\n", strconv.FormatFloat(m.latencySum, 'g', -1, 64))
+	fmt.Fprintf(&b, "ml_request_duration_seconds_count %d\n", m.latencyCount)
+
+	b.WriteString("# HELP ml_predictions_total Predictions made, by class.\n# TYPE ml_predictions_total counter\n")
+	for i, n := range m.predictions {
+		fmt.Fprintf(&b, "ml_predictions_total%s %d\n", labels(map[string]string{"class": m.classes[i]}), n)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// labels formats a set of Prometheus labels, sorted by name.
+func labels(l map[string]string) string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=\"%s\"", name, escape.Replace(l[name]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
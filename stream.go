@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/nn"
+)
+
+// reportBatches is the number of mini-batches between reports of the
+// training loss of a stream.
+const reportBatches = 100
+
+// trainStream trains the network described by cfg online on CSV rows read
+// from source as they arrive, - for stdin or an address to listen on for
+// connections that each send rows. The training data only sets the layout
+// and normalization of the rows. Checkpoints are saved every
+// cfg.Checkpoint.Minutes, and the model once stdin ends or training is
+// interrupted.
+func trainStream(cfg trainConfig, resume, source string) error {
+	if cfg.Dataset != "csv" {
+		return fmt.Errorf("-stream needs the csv dataset")
+	}
+	if cfg.Ensemble.Method != "" || cfg.Optimizer == "lbfgs" || cfg.ValSplit > 0 || cfg.EarlyStop.Patience > 0 {
+		return fmt.Errorf("-stream cannot train an ensemble, with L-BFGS, or with a validation split or early stopping")
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UTC().UnixNano()
+	}
+	if resume == "" || cfg.RunID == "" {
+		cfg.RunID = newRunID()
+	}
+	netOpts, err := networkOptions(cfg)
+	if err != nil {
+		return err
+	}
+	set, err := loadTrainingSet(cfg)
+	if err != nil {
+		return err
+	}
+	network, _, err := buildNetwork(cfg, set, netOpts, resume)
+	if err != nil {
+		return err
+	}
+	s := &streamer{cfg: cfg, csv: set.csv, online: nn.Online{Net: &network, BatchSize: cfg.BatchSize}, stop: interrupts()}
+	if s.checkpoints, err = newCheckpointer(cfg); err != nil {
+		return err
+	}
+
+	if source == "-" {
+		err = s.read()
+	} else {
+		err = s.listen(source)
+	}
+	if err != nil && err != errInterrupted {
+		return fmt.Errorf("training: %w", err)
+	}
+	if _, _, err := s.online.Flush(); err != nil {
+		return fmt.Errorf("training: %w", err)
+	}
+	fmt.Printf("trained on %d samples\n", s.samples)
+
+	network.SetMetadata(nn.Metadata{Created: time.Now().UTC(), Dataset: cfg.Dataset, Commit: buildCommit(), Comment: cfg.Comment})
+	if err := keepVersions(cfg.Model, cfg.KeepVersions); err != nil {
+		return fmt.Errorf("keeping the earlier model: %w", err)
+	}
+	if err := network.Save(cfg.Model); err != nil {
+		return fmt.Errorf("saving model: %w", err)
+	}
+	if err := cfg.save(cfg.Model + ".yaml"); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	return nil
+}
+
+// streamer trains a network on the samples of a stream.
+type streamer struct {
+	cfg         trainConfig
+	csv         *dataset.CSV
+	online      nn.Online
+	checkpoints *checkpointer
+	stop        <-chan struct{}
+
+	samples int
+	// loss and batches add up the losses of the mini-batches since the
+	// last report.
+	loss    float64
+	batches int
+	// err is an error from training, as opposed to from reading the
+	// stream, which ends it.
+	err error
+}
+
+// read trains on the rows of stdin until it ends.
+func (s *streamer) read() error {
+	go func() {
+		// unblock a read waiting for the next row
+		<-s.stop
+		os.Stdin.Close()
+	}()
+	err := s.each("stdin", os.Stdin)
+	if stopped(s.stop) {
+		return errInterrupted
+	}
+	return err
+}
+
+// listen trains on the rows sent by each connection to addr in turn, until
+// interrupted. A connection that sends a bad row is dropped.
+func (s *streamer) listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("listening for samples on %s\n", l.Addr())
+	var mu sync.Mutex
+	var conn net.Conn
+	go func() {
+		<-s.stop
+		l.Close()
+		mu.Lock()
+		if conn != nil {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+	for {
+		c, err := l.Accept()
+		if stopped(s.stop) {
+			return errInterrupted
+		}
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		conn = c
+		mu.Unlock()
+		if stopped(s.stop) {
+			c.Close()
+			return errInterrupted
+		}
+		name := c.RemoteAddr().String()
+		err = s.each(name, c)
+		c.Close()
+		switch {
+		case stopped(s.stop):
+			return errInterrupted
+		case s.err != nil:
+			return s.err
+		case err != nil:
+			log.Printf("dropping the connection: %v", err)
+		}
+	}
+}
+
+// each trains on the rows read from r, named name in errors.
+func (s *streamer) each(name string, r io.Reader) error {
+	var data dataset.Dataset = s.csv.Stream(name, r)
+	if s.cfg.Task == "autoencoder" {
+		data = dataset.NewReconstruction(data)
+	}
+	return data.Each(func(sample dataset.Sample) error {
+		if stopped(s.stop) {
+			return errInterrupted
+		}
+		trained, loss, err := s.online.PartialFit(sample.Inputs, sample.Targets)
+		if err != nil {
+			s.err = fmt.Errorf("sample %d: %w", s.samples+1, err)
+			return s.err
+		}
+		s.samples++
+		if !trained {
+			return nil
+		}
+		s.loss += loss
+		s.batches++
+		if s.batches == reportBatches {
+			if !s.cfg.Quiet {
+				fmt.Printf("samples %d: loss %.4f\n", s.samples, s.loss/float64(s.batches))
+			}
+			s.loss, s.batches = 0, 0
+		}
+		if s.checkpoints.ready() {
+			if s.err = s.checkpoints.save(*s.online.Net, nn.Checkpoint{Samples: s.samples}); s.err != nil {
+				return s.err
+			}
+		}
+		return nil
+	})
+}
+
+// stopped reports whether stop is closed.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+func summaryCmd(args []string) error {
+	fs := flag.NewFlagSet("summary", flag.ExitOnError)
+	modelPath := fs.String("model", "data/mnist.model", "Path of the model to describe")
+	fs.Parse(args)
+
+	net, err := loadModel(*modelPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s weights\n\n", *modelPath, net.Precision())
+	fmt.Print(net.Summary())
+	return nil
+}
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/helpers"
+	"github.com/kheob/ml/nn"
+)
+
+func trainCmd(args []string) error {
+	cfg := defaultTrainConfig()
+	fs := flag.NewFlagSet("train", flag.ExitOnError)
+	configPath := fs.String("config", "", "YAML file to read the training configuration from; other flags override it")
+	fs.StringVar(&cfg.Model, "model", cfg.Model, "Path to save the trained model to")
+	fs.StringVar(&cfg.Dataset, "dataset", cfg.Dataset, "Dataset to train on: mnist, fashion-mnist, emnist-{digits,letters,balanced,byclass} or csv for tabular data")
+	fs.StringVar(&cfg.Task, "task", cfg.Task, "What to train the network to do: classify, or autoencoder to reproduce its inputs through the last of the hidden layers")
+	fs.StringVar(&cfg.TrainData, "train-data", cfg.TrainData, "Path of the training data, either a CSV file or a directory of IDX files (default <dataset>_dataset)")
+	fs.BoolVar(&cfg.SkipBadRows, "skip-bad-rows", cfg.SkipBadRows, "Skip the rows of CSV training data that cannot be read, logging each and counting them, rather than stopping at the first")
+	cfg.CSV.register(fs)
+	fs.BoolVar(&cfg.Softmax, "softmax", cfg.Softmax, "Use a softmax output layer trained with cross-entropy loss")
+	fs.StringVar(&cfg.Loss, "loss", cfg.Loss, "Loss to train with: mse, cross-entropy, binary-cross-entropy or huber (default cross-entropy with -softmax, mse otherwise)")
+	fs.Float64Var(&cfg.LabelSmoothing, "label-smoothing", cfg.LabelSmoothing, "Fraction of the way to move the one-hot training targets towards an even spread over the classes, e.g. 0.1")
+	fs.Float64Var(&cfg.Mixup, "mixup", cfg.Mixup, "Train on pairs of samples of each mini-batch mixed with weights drawn from a Beta(alpha, alpha) distribution with this alpha, e.g. 0.2, 0 for none")
+	fs.IntVar(&cfg.Epochs, "epochs", cfg.Epochs, "Number of passes over the training data")
+	fs.BoolVar(&cfg.Shuffle, "shuffle", cfg.Shuffle, "Shuffle the training data between epochs")
+	fs.StringVar(&cfg.Sampler, "sampler", cfg.Sampler, "Pick the samples of each epoch at random to even out the classes: oversample to repeat those of the smaller classes, or undersample to leave out some of the larger ones")
+	fs.StringVar(&cfg.Augment, "augment", cfg.Augment, "Comma separated transforms to apply to the training images at random every epoch: shift:pixels, rotate:degrees and scale:fraction, elastic:alpha:sigma, noise:sigma and salt-pepper:probability, e.g. shift:2,rotate:15,scale:0.1 or elastic:34:4,noise:0.05")
+	fs.Float64Var(&cfg.ValSplit, "val-split", cfg.ValSplit, "Fraction of the training data to hold back for validation after each epoch")
+	fs.IntVar(&cfg.EarlyStop.Patience, "early-stop-patience", cfg.EarlyStop.Patience, "Stop after this many epochs without the validation metric improving and keep the best network, 0 to never stop early")
+	fs.StringVar(&cfg.EarlyStop.Metric, "early-stop-metric", cfg.EarlyStop.Metric, "Validation metric to watch for early stopping: loss or accuracy")
+	fs.Int64Var(&cfg.MemoryLimit, "mem-limit", cfg.MemoryLimit, "Megabytes of training data to keep in memory before streaming it from disk instead, 0 for no limit")
+	fs.Var(&cfg.Conv, "conv", "Comma separated convolutional layers to put before the hidden layers of an image dataset, each filters:kernel[:stride[:padding]] optionally followed by max:kernel[:stride] or avg:kernel[:stride] pooling, e.g. 8:5,max:2,16:5")
+	fs.Var(&cfg.Hidden, "hidden", "Comma separated sizes of the hidden layers, e.g. 512,256")
+	fs.IntVar(&cfg.Embedding, "embedding-dims", cfg.Embedding, "csv: number of learned values to embed each category of the -categorical columns as")
+	fs.Var(&cfg.Dropout, "dropout", "Dropout rate of the hidden layers while training, either one for all of them or a comma separated rate for each")
+	fs.StringVar(&cfg.Init, "init", cfg.Init, "Initializer for the starting weights: uniform, xavier-uniform, xavier-normal, he, lecun or orthogonal")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "Random seed for the initial weights and shuffling, 0 to pick one from the current time")
+	fs.Float64Var(&cfg.LearningRate, "lr", cfg.LearningRate, "Learning rate")
+	fs.Float64Var(&cfg.WeightDecay, "weight-decay", cfg.WeightDecay, "L2 penalty on the weights, added to the training loss, or the decoupled weight decay of the adamw optimizer")
+	fs.Float64Var(&cfg.L1, "l1", cfg.L1, "L1 penalty on the weights, added to the training loss")
+	fs.IntVar(&cfg.BatchSize, "batch-size", cfg.BatchSize, "Number of samples per mini-batch")
+	fs.StringVar(&cfg.Precision, "precision", cfg.Precision, "Precision to keep the weights in and work out the matrix products in: float64 or float32")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "Number of goroutines to split each mini-batch between")
+	fs.BoolVar(&cfg.CheckFinite, "check-finite", cfg.CheckFinite, "Stop with the epoch, batch and layer at fault as soon as training runs into a NaN or infinity")
+	cfg.registerOptimizer(fs)
+	fs.StringVar(&cfg.Schedule.Name, "lr-schedule", cfg.Schedule.Name, "Learning rate schedule: constant, step, exp, cosine, or onecycle, which warms up to -lr and anneals back down while cycling the momentum of the momentum, nesterov, adam and adamw optimizers between 0.95 and 0.85")
+	fs.Float64Var(&cfg.Schedule.Min, "lr-min", cfg.Schedule.Min, "Final learning rate for the cosine and onecycle schedules, 0 for -lr over 250000 with onecycle")
+	fs.IntVar(&cfg.Schedule.WarmupSteps, "warmup-steps", cfg.Schedule.WarmupSteps, "Number of mini-batches to raise the learning rate linearly over at the start of training, before the schedule takes over, which steadies training with large batches or adam")
+	fs.Float64Var(&cfg.Schedule.Warmup, "lr-warmup", cfg.Schedule.Warmup, "Fraction of the epochs the onecycle schedule spends raising the learning rate (default 0.3)")
+	fs.IntVar(&cfg.Schedule.Step, "lr-step", cfg.Schedule.Step, "Number of epochs between decays for the step schedule")
+	fs.Float64Var(&cfg.Schedule.Gamma, "lr-gamma", cfg.Schedule.Gamma, "Decay factor for the step and exp schedules")
+	fs.StringVar(&cfg.Checkpoint.Dir, "checkpoint-dir", cfg.Checkpoint.Dir, "Directory to save checkpoints in (default <model>_checkpoints)")
+	fs.IntVar(&cfg.Checkpoint.Epochs, "checkpoint-every", cfg.Checkpoint.Epochs, "Save a checkpoint every this many epochs, 0 for never")
+	fs.Float64Var(&cfg.Checkpoint.Minutes, "checkpoint-minutes", cfg.Checkpoint.Minutes, "Save a checkpoint every this many minutes, 0 for never")
+	fs.StringVar(&cfg.Ensemble.Method, "ensemble", cfg.Ensemble.Method, "Train an ensemble of networks rather than one, saved together as the model: bagging to train each on a bootstrap resample of the training data, or snapshot to take snapshots of one network trained with a cyclic cosine learning rate at the end of each cycle")
+	fs.IntVar(&cfg.Ensemble.Size, "n", cfg.Ensemble.Size, "Number of networks in the ensemble, which is the number of learning rate cycles of a snapshot ensemble")
+	fs.IntVar(&cfg.Ensemble.Parallel, "parallel", cfg.Ensemble.Parallel, "Number of networks of a bagging ensemble to train at once")
+	fs.StringVar(&cfg.Ensemble.Combine, "combine", cfg.Ensemble.Combine, "How the ensemble combines the outputs of its networks: average or vote")
+	fs.StringVar(&cfg.Log, "log", cfg.Log, "File to append a log of the metrics of every epoch to, as CSV if it ends in .csv and JSON lines otherwise")
+	fs.Var(&cfg.Metrics, "metrics", "Comma separated metrics to report on the validation data after every epoch: accuracy, top-k for a k such as top-5, precision, recall, f1 or mse")
+	fs.StringVar(&cfg.Comment, "comment", cfg.Comment, "Comment to save in the metadata of the model")
+	fs.IntVar(&cfg.KeepVersions, "keep-versions", cfg.KeepVersions, "Number of earlier versions of the model to keep when saving over it, as <model>.1, <model>.2 and so on")
+	fs.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "Do not show training progress, for scripted runs")
+	resume := fs.String("resume", "", "Checkpoint to carry on training from, or a checkpoint directory to use the latest one in it")
+	stream := fs.String("stream", "", "csv: train online on rows as they arrive from - for stdin, or from connections to a host:port to listen on, rather than for epochs over -train-data, which still sets the layout and normalization of the rows; checkpoints are saved every -checkpoint-minutes and the model once stdin ends or training is interrupted")
+	fs.Parse(args)
+
+	var checkpoint string
+	if *resume != "" {
+		var err error
+		if checkpoint, err = resolveCheckpoint(*resume); err != nil {
+			return err
+		}
+		if err := cfg.load(checkpoint + ".yaml"); err != nil {
+			return err
+		}
+	}
+	if *configPath != "" {
+		if err := cfg.load(*configPath); err != nil {
+			return err
+		}
+	}
+	if *resume != "" || *configPath != "" {
+		// parse again so that flags given explicitly win over the files
+		fs.Parse(args)
+	}
+	if *stream != "" {
+		return trainStream(cfg, checkpoint, *stream)
+	}
+	return train(cfg, checkpoint)
+}
+
+// train runs the training described by cfg and saves the model along with
+// the resolved config. If resume is not empty training carries on from the
+// checkpoint at that path.
+func train(cfg trainConfig, resume string) error {
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UTC().UnixNano()
+	}
+	if resume == "" || cfg.RunID == "" {
+		cfg.RunID = newRunID()
+	}
+	if cfg.Ensemble.Method != "" {
+		if resume != "" {
+			return fmt.Errorf("an ensemble cannot be resumed from a checkpoint")
+		}
+		return trainEnsemble(cfg)
+	}
+	netOpts, err := networkOptions(cfg)
+	if err != nil {
+		return err
+	}
+	set, err := loadTrainingSet(cfg)
+	if err != nil {
+		return err
+	}
+	net, start, err := buildNetwork(cfg, set, netOpts, resume)
+	if err != nil {
+		return err
+	}
+	data, opts, err := fitSetup(cfg, set)
+	if err != nil {
+		return err
+	}
+	opts.start = start
+	if opts.lbfgs {
+		if resume != "" {
+			return fmt.Errorf("L-BFGS training cannot be resumed from a checkpoint")
+		}
+		if cfg.Checkpoint.Epochs > 0 || cfg.Checkpoint.Minutes > 0 {
+			return fmt.Errorf("checkpoints cannot be saved while training with L-BFGS")
+		}
+	} else if opts.checkpoints, err = newCheckpointer(cfg); err != nil {
+		return err
+	}
+	if cfg.Log != "" {
+		if opts.log, err = openTrainingLog(cfg.Log, cfg); err != nil {
+			return fmt.Errorf("opening training log: %w", err)
+		}
+		defer opts.log.Close()
+	}
+	opts.stop = interrupts()
+	if !cfg.Quiet {
+		opts.progress = newProgress(cfg.Epochs)
+	}
+	var res fitResult
+	opts.result = &res
+	if err := fit(&net, data, opts); err == errInterrupted {
+		set.bad.report(os.Stdout)
+		fmt.Printf("training interrupted, carry on with: ml train -resume %s\n", opts.checkpoints.latest)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("training: %w", err)
+	}
+	set.bad.report(os.Stdout)
+	meta, err := trainedMetadata(cfg, net, data, res)
+	if err != nil {
+		return err
+	}
+	net.SetMetadata(meta)
+	if err := keepVersions(cfg.Model, cfg.KeepVersions); err != nil {
+		return fmt.Errorf("keeping the earlier model: %w", err)
+	}
+	if err := net.Save(cfg.Model); err != nil {
+		return fmt.Errorf("saving model: %w", err)
+	}
+	if err := cfg.save(cfg.Model + ".yaml"); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	return nil
+}
+
+// networkOptions checks the settings of cfg that need no training data and
+// returns the options to create the network with.
+func networkOptions(cfg trainConfig) ([]nn.Option, error) {
+	if n := len(cfg.Dropout); n > 1 && n != len(cfg.Hidden) {
+		return nil, fmt.Errorf("got %d dropout rates for %d hidden layers", n, len(cfg.Hidden))
+	}
+	for _, rate := range cfg.Dropout {
+		if rate < 0 || rate >= 1 {
+			return nil, fmt.Errorf("dropout rate %g is not between 0 and 1", rate)
+		}
+	}
+	if cfg.WeightDecay < 0 || cfg.L1 < 0 {
+		return nil, fmt.Errorf("weight decay and l1 must not be negative")
+	}
+	initializer, err := nn.InitializerByName(cfg.Init)
+	if err != nil {
+		return nil, err
+	}
+	precision, err := nn.PrecisionByName(cfg.Precision)
+	if err != nil {
+		return nil, err
+	}
+	var loss nn.Loss
+	if cfg.Loss != "" {
+		if loss, err = nn.LossByName(cfg.Loss); err != nil {
+			return nil, err
+		}
+		if _, ok := loss.(nn.CrossEntropy); cfg.Softmax && !ok {
+			return nil, fmt.Errorf("a softmax output layer needs cross-entropy loss, not %s", cfg.Loss)
+		}
+	}
+	if cfg.Task != "classify" && cfg.Task != "autoencoder" {
+		return nil, fmt.Errorf("unknown task %q, want classify or autoencoder", cfg.Task)
+	}
+	autoencoder := cfg.Task == "autoencoder"
+	regression := cfg.Dataset == "csv" && cfg.CSV.Regression
+	if regression && cfg.Softmax {
+		return nil, fmt.Errorf("regression cannot use a softmax output layer")
+	}
+	if autoencoder && cfg.Softmax {
+		return nil, fmt.Errorf("an autoencoder cannot use a softmax output layer")
+	}
+	if regression && cfg.EarlyStop.Patience > 0 && cfg.EarlyStop.Metric == "accuracy" {
+		return nil, fmt.Errorf("regression has no accuracy to stop early on, use -early-stop-metric loss")
+	}
+	if autoencoder && cfg.EarlyStop.Patience > 0 && cfg.EarlyStop.Metric == "accuracy" {
+		return nil, fmt.Errorf("an autoencoder has no accuracy to stop early on, use -early-stop-metric loss")
+	}
+	if len(cfg.Conv) > 0 && cfg.Dataset == "csv" {
+		return nil, fmt.Errorf("convolutional layers need an image dataset")
+	}
+	if len(cfg.Conv) > 0 && autoencoder {
+		return nil, fmt.Errorf("an autoencoder is made of dense layers only, so cannot take -conv")
+	}
+	if autoencoder && len(cfg.Hidden) == 0 {
+		return nil, fmt.Errorf("an autoencoder needs at least one hidden layer to encode its inputs with")
+	}
+	if cfg.ValSplit < 0 || cfg.ValSplit >= 1 {
+		return nil, fmt.Errorf("validation split must be between 0 and 1, got %g", cfg.ValSplit)
+	}
+	if cfg.Mixup < 0 {
+		return nil, fmt.Errorf("mixup alpha must not be negative, got %g", cfg.Mixup)
+	}
+	if cfg.Mixup > 0 && cfg.BatchSize < 2 {
+		return nil, fmt.Errorf("mixup mixes the samples of a mini-batch, so needs a batch size over 1")
+	}
+	if cfg.LabelSmoothing < 0 || cfg.LabelSmoothing >= 1 {
+		return nil, fmt.Errorf("label smoothing must be between 0 and 1, got %g", cfg.LabelSmoothing)
+	}
+	if cfg.LabelSmoothing > 0 && (regression || autoencoder || cfg.Dataset == "csv" && !cfg.CSV.OneHot) {
+		return nil, fmt.Errorf("label smoothing needs one-hot class targets")
+	}
+	if cfg.Augment != "" {
+		if cfg.Dataset == "csv" {
+			return nil, fmt.Errorf("augmentation transforms images, so needs an image dataset")
+		}
+		if _, err := dataset.ParseAugmentation(cfg.Augment); err != nil {
+			return nil, err
+		}
+	}
+	opt, err := cfg.optimizer()
+	if err != nil {
+		return nil, err
+	}
+
+	var sched nn.Scheduler
+	switch cfg.Schedule.Name {
+	case "constant":
+		sched = nn.ConstantRate{}
+	case "step":
+		sched = nn.StepDecay{Step: cfg.Schedule.Step, Gamma: cfg.Schedule.Gamma}
+	case "exp":
+		sched = nn.ExponentialDecay{Gamma: cfg.Schedule.Gamma}
+	case "cosine":
+		sched = nn.CosineAnnealing{Epochs: cfg.Epochs, Min: cfg.Schedule.Min}
+	case "onecycle":
+		if cfg.Schedule.Warmup < 0 || cfg.Schedule.Warmup >= 1 {
+			return nil, fmt.Errorf("onecycle warmup must be between 0 and 1, got %g", cfg.Schedule.Warmup)
+		}
+		sched = nn.OneCycle{Epochs: cfg.Epochs, Warmup: cfg.Schedule.Warmup, Min: cfg.Schedule.Min}
+	default:
+		return nil, fmt.Errorf("unknown learning rate schedule %q", cfg.Schedule.Name)
+	}
+	if cfg.Schedule.WarmupSteps < 0 {
+		return nil, fmt.Errorf("warmup steps must not be negative, got %d", cfg.Schedule.WarmupSteps)
+	}
+
+	// adamw decays the weights itself, in place of the L2 penalty
+	decay := cfg.WeightDecay
+	inner := opt
+	if l, ok := opt.(*nn.Lookahead); ok {
+		inner = l.Inner
+	}
+	if _, ok := inner.(*nn.AdamW); ok {
+		decay = 0
+	}
+	netOpts := []nn.Option{nn.WithOptimizer(opt), nn.WithScheduler(sched), nn.WithWorkers(cfg.Workers), nn.WithPrecision(precision), nn.WithSeed(cfg.Seed), nn.WithInitializer(initializer), nn.WithWeightDecay(decay), nn.WithL1(cfg.L1), nn.WithWarmup(cfg.Schedule.WarmupSteps)}
+	netOpts = append(netOpts, cfg.LayerRates.options()...)
+	if len(cfg.Conv) > 0 {
+		netOpts = append(netOpts, nn.WithConv2D(imageShape, cfg.Conv...))
+	}
+	if len(cfg.Dropout) > 0 {
+		netOpts = append(netOpts, nn.WithDropout(dropoutRates(cfg)...))
+	}
+	if loss != nil {
+		netOpts = append(netOpts, nn.WithLoss(loss))
+	}
+	if cfg.CheckFinite {
+		netOpts = append(netOpts, nn.WithFiniteCheck())
+	}
+	if cfg.LabelSmoothing > 0 {
+		netOpts = append(netOpts, nn.WithLabelSmoothing(cfg.LabelSmoothing))
+	}
+	return netOpts, nil
+}
+
+// trainingSet is the training data of a run, loaded and ready to fit.
+type trainingSet struct {
+	data            dataset.Dataset
+	inputs, outputs int
+	// categories holds the number of categories of each categorical
+	// column of a csv dataset.
+	categories []int
+	// csv is the training data of a csv dataset as read from its file.
+	csv *dataset.CSV
+	// bad holds the rows skipped with cfg.SkipBadRows, or is nil.
+	bad *badRows
+}
+
+// loadTrainingSet loads the training data described by cfg into memory, up
+// to its memory limit, with the inputs as the targets for an autoencoder.
+func loadTrainingSet(cfg trainConfig) (trainingSet, error) {
+	var set trainingSet
+	if cfg.SkipBadRows {
+		set.bad = &badRows{}
+	}
+	data, inputs, outputs, err := trainingData(cfg, set.bad)
+	if err != nil {
+		return set, err
+	}
+	autoencoder := cfg.Task == "autoencoder"
+	if d, ok := data.(*dataset.CSV); ok {
+		set.categories, set.csv = d.CategoryCounts(), d
+	}
+	if len(set.categories) > 0 && autoencoder {
+		return set, fmt.Errorf("an autoencoder cannot reproduce categorical columns")
+	}
+	if len(set.categories) > 0 && cfg.Mixup > 0 {
+		return set, fmt.Errorf("mixup cannot mix the categories of categorical columns")
+	}
+	if len(set.categories) > 0 && cfg.Embedding <= 0 {
+		return set, fmt.Errorf("embedding dims must be positive, got %d", cfg.Embedding)
+	}
+	if autoencoder {
+		data, outputs = dataset.NewReconstruction(data), inputs
+	}
+	if set.data, err = dataset.Load(data, inputs, outputs, cfg.MemoryLimit<<20); err != nil {
+		return set, fmt.Errorf("loading training data: %w", err)
+	}
+	set.inputs, set.outputs = inputs, outputs
+	return set, nil
+}
+
+// buildNetwork creates the network described by cfg for set with the given
+// options, or loads it from the checkpoint at resume if that is not empty,
+// returning where in training the checkpoint was saved.
+func buildNetwork(cfg trainConfig, set trainingSet, netOpts []nn.Option, resume string) (nn.Network, nn.Checkpoint, error) {
+	var net nn.Network
+	var start nn.Checkpoint
+	autoencoder := cfg.Task == "autoencoder"
+	regression := cfg.Dataset == "csv" && cfg.CSV.Regression
+
+	// an input for each pixel or column of the training data, e.g. 784 for
+	// 28 x 28 pixel images
+	// convolutional layers as given by -conv, with ReLU activations
+	// hidden layers as given by -hidden, 200 neurons by default
+	// an output for each class, e.g. 10 for the digits 0 to 9
+	// sigmoid activations, optionally with a softmax output, or a linear
+	// one for regression
+	// an autoencoder has the hidden layers again in reverse after the last,
+	// which holds the code, and an output for each input
+	sizes := []int{set.inputs}
+	if len(cfg.Conv) > 0 {
+		shape := imageShape
+		for i, c := range cfg.Conv {
+			if shape = c.Output(shape); shape.Height <= 0 || shape.Width <= 0 {
+				return net, start, fmt.Errorf("convolutional layer %d does not fit its input", i+1)
+			}
+		}
+		sizes = nn.ConvSizes(imageShape, cfg.Conv...)
+	}
+	sizes = append(sizes, cfg.Hidden...)
+	if autoencoder {
+		for i := len(cfg.Hidden) - 2; i >= 0; i-- {
+			sizes = append(sizes, cfg.Hidden[i])
+		}
+	}
+	sizes = append(sizes, set.outputs)
+	activations := make([]helpers.Activation, len(sizes)-1)
+	for i := range activations {
+		activations[i] = helpers.Sigmoid{}
+		if i < len(cfg.Conv) {
+			activations[i] = helpers.ReLU{}
+		}
+	}
+	if cfg.Softmax {
+		activations[len(activations)-1] = helpers.Softmax{}
+	}
+	if regression || autoencoder && cfg.Dataset == "csv" {
+		activations[len(activations)-1] = helpers.Linear{}
+	}
+	// categorical columns are embedded first, so the dense layers take the
+	// embedded inputs
+	var embedding nn.Layer
+	if len(set.categories) > 0 {
+		embedding = nn.Embedding(set.inputs, set.categories, cfg.Embedding)
+		sizes[0], _ = embedding.Outputs(set.inputs)
+	}
+	if resume != "" {
+		var err error
+		net, start, err = nn.LoadCheckpoint(resume, append(netOpts, nn.WithLearningRate(cfg.LearningRate))...)
+		if err != nil {
+			return net, start, fmt.Errorf("resuming: %w", err)
+		}
+		want := sizes
+		if embedding != nil {
+			want = append([]int{set.inputs}, sizes...)
+		}
+		if fmt.Sprint(net.Sizes()) != fmt.Sprint(want) {
+			return net, start, fmt.Errorf("resuming: checkpoint has layers %v, config has %v", net.Sizes(), want)
+		}
+		if net.IsAutoencoder() != autoencoder {
+			return net, start, fmt.Errorf("resuming: checkpoint is not for the %s task", cfg.Task)
+		}
+		fmt.Printf("resuming from %s at epoch %d\n", resume, start.Epoch+1)
+	} else if embedding != nil {
+		net = append(nn.NewSequential(embedding), denseLayers(sizes, activations)...).Network(cfg.LearningRate, netOpts...)
+	} else if autoencoder {
+		net = autoencoderLayers(sizes, activations, len(cfg.Hidden)).Network(cfg.LearningRate, netOpts...)
+	} else {
+		net = nn.CreateNetwork(sizes, activations, cfg.LearningRate, netOpts...)
+	}
+	if err := cfg.LayerRates.check(len(net.Sizes()) - 1); err != nil {
+		return net, start, err
+	}
+	return net, start, nil
+}
+
+// fitSetup returns the options to fit a network to set as described by
+// cfg, along with the data to train on, which leaves out any samples held
+// back for validation.
+func fitSetup(cfg trainConfig, set trainingSet) (dataset.Dataset, fitOptions, error) {
+	data := set.data
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	opts := fitOptions{epochs: cfg.Epochs, batchSize: cfg.BatchSize, regression: cfg.Dataset == "csv" && cfg.CSV.Regression || cfg.Task == "autoencoder"}
+	if cfg.ValSplit > 0 {
+		indexed, ok := data.(dataset.Indexed)
+		if !ok {
+			return nil, opts, fmt.Errorf("a validation split needs the training data to fit in memory")
+		}
+		var val *dataset.Subset
+		data, val = dataset.Split(indexed, cfg.ValSplit, rng)
+		opts.validation = val
+	}
+	if len(cfg.Metrics) > 0 {
+		if opts.validation == nil {
+			return nil, opts, fmt.Errorf("-metrics needs a validation split, set one with -val-split")
+		}
+		var err error
+		if opts.metrics, err = cfg.Metrics.metrics(); err != nil {
+			return nil, opts, err
+		}
+	}
+	if cfg.EarlyStop.Patience > 0 {
+		if opts.validation == nil {
+			return nil, opts, fmt.Errorf("early stopping needs a validation split, set one with -val-split")
+		}
+		var err error
+		if opts.earlyStop, err = newEarlyStop(cfg.EarlyStop.Patience, cfg.EarlyStop.Metric); err != nil {
+			return nil, opts, err
+		}
+	}
+	if cfg.Shuffle {
+		if _, ok := data.(dataset.Indexed); !ok {
+			log.Printf("training data is over the memory limit, so it will not be shuffled")
+		}
+		opts.rng = rng
+	}
+	if cfg.Sampler != "" {
+		if opts.regression {
+			return nil, opts, fmt.Errorf("the %s sampler evens out classes, so needs a classification task", cfg.Sampler)
+		}
+		indexed, ok := data.(dataset.Indexed)
+		if !ok {
+			return nil, opts, fmt.Errorf("the %s sampler needs the training data to fit in memory", cfg.Sampler)
+		}
+		var err error
+		if opts.sampler, err = dataset.SamplerByName(cfg.Sampler, indexed); err != nil {
+			return nil, opts, err
+		}
+		opts.rng = rng
+	}
+	if cfg.Augment != "" {
+		t, err := dataset.ParseAugmentation(cfg.Augment)
+		if err != nil {
+			return nil, opts, err
+		}
+		// augment with a source of its own, leaving the shuffles as they
+		// would be without it
+		data = dataset.Augment(data, t, rand.New(rand.NewSource(cfg.Seed+1)))
+	}
+	if cfg.Mixup > 0 {
+		opts.mixup, opts.mixupRNG = cfg.Mixup, rand.New(rand.NewSource(cfg.Seed+2))
+	}
+	if cfg.Optimizer == "lbfgs" {
+		// L-BFGS needs the same loss on every pass over the data
+		switch {
+		case cfg.Sampler != "" || cfg.Augment != "" || cfg.Mixup > 0:
+			return nil, opts, fmt.Errorf("L-BFGS trains on the data as it is, so cannot sample, augment or mix it up")
+		case opts.earlyStop != nil:
+			return nil, opts, fmt.Errorf("L-BFGS stops once the loss stops falling, so cannot stop early on the validation metrics")
+		}
+		opts.lbfgs = true
+	}
+	return data, opts, nil
+}
+
+// imageShape is the shape of the images in the image datasets.
+var imageShape = nn.Shape{Channels: 1, Height: 28, Width: 28}
+
+// dropoutRates returns the dropout rate of every hidden layer of the
+// network, which has none for its convolutional layers. The hidden layers
+// of the decoder of an autoencoder mirror those of the encoder.
+func dropoutRates(cfg trainConfig) []float64 {
+	if cfg.Task == "autoencoder" && len(cfg.Dropout) > 1 {
+		rates := append([]float64(nil), cfg.Dropout...)
+		for i := len(cfg.Dropout) - 2; i >= 0; i-- {
+			rates = append(rates, cfg.Dropout[i])
+		}
+		return rates
+	}
+	if len(cfg.Conv) == 0 {
+		return cfg.Dropout
+	}
+	rates := make([]float64, len(cfg.Conv), len(cfg.Conv)+len(cfg.Hidden))
+	for i := range cfg.Hidden {
+		rate := cfg.Dropout[0]
+		if len(cfg.Dropout) > 1 {
+			rate = cfg.Dropout[i]
+		}
+		rates = append(rates, rate)
+	}
+	return rates
+}
+
+// denseLayers returns dense layers of the given sizes, each followed by its
+// activation, as CreateNetwork makes them.
+func denseLayers(sizes []int, activations []helpers.Activation) nn.Sequential {
+	var layers nn.Sequential
+	for i, a := range activations {
+		layers = append(layers, nn.Dense(sizes[i], sizes[i+1]), nn.Activation(a))
+	}
+	return layers
+}
+
+// autoencoderLayers returns dense layers of the given sizes and activations,
+// split into an encoder of the first code layers and a decoder of the rest.
+func autoencoderLayers(sizes []int, activations []helpers.Activation, code int) nn.Sequential {
+	return nn.Autoencoder(denseLayers(sizes[:code+1], activations[:code]), denseLayers(sizes[code:], activations[code:]))
+}
+
+// trainingData returns the training data described by cfg along with the
+// number of inputs and target outputs of each sample. Rows of CSV data that
+// cannot be read are skipped into bad if it is not nil.
+func trainingData(cfg trainConfig, bad *badRows) (data dataset.Dataset, inputs, outputs int, err error) {
+	if cfg.Dataset == "csv" {
+		d, err := openCSV(cfg.CSV, cfg.TrainData, bad)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return d, d.Inputs(), d.Outputs(), nil
+	}
+
+	set, err := imageSet(cfg.Dataset)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return imageData(set, cfg.TrainData, false, bad), dataset.ImagePixels, len(set.Classes), nil
+}
+
+// openCSV opens the tabular training data at path, skipping the rows that
+// cannot be read into bad if it is not nil.
+func openCSV(c csvConfig, path string, bad *badRows) (*dataset.CSV, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the csv dataset needs -train-data")
+	}
+	opts, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+	opts.BadRow = bad.skipper()
+	return dataset.OpenCSV(path, opts)
+}
+
+// interrupts returns a channel that is closed on the first SIGINT or
+// SIGTERM, so training can stop cleanly. A second signal exits straight
+// away.
+func interrupts() <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		<-sigs
+		fmt.Fprintln(os.Stderr, "\nstopping after the current batch, interrupt again to quit now")
+		close(stop)
+		<-sigs
+		os.Exit(130)
+	}()
+	return stop
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kheob/ml/dataset"
+	"github.com/kheob/ml/eval"
+	"github.com/kheob/ml/nn"
+)
+
+// imageSet returns the image dataset with the given name.
+func imageSet(name string) (dataset.ImageSet, error) {
+	set, ok := dataset.ImageSets[name]
+	if !ok {
+		names := make([]string, 0, len(dataset.ImageSets))
+		for n := range dataset.ImageSets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return set, fmt.Errorf("unknown dataset %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	return set, nil
+}
+
+// imageData returns the training or test part of set found at path, which
+// is either a CSV file or a directory as described by ImageSet.Dir. An empty
+// path means the default directory for the dataset, such as mnist_dataset.
+// The rows of a CSV file that cannot be read are skipped into bad if it is
+// not nil.
+func imageData(set dataset.ImageSet, path string, test bool, bad *badRows) dataset.Dataset {
+	if path == "" {
+		path = set.Name + "_dataset"
+	}
+	var data dataset.Dataset
+	if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+		data = set.CSV(path)
+	} else {
+		data = set.Dir(path, test)
+	}
+	if d, ok := data.(dataset.ImageCSV); ok {
+		d.BadRow = bad.skipper()
+		data = d
+	}
+	return data
+}
+
+// badRows logs and counts the rows of CSV data skipped for not being
+// readable. Each is logged once, however many times the data is read.
+type badRows struct {
+	seen map[string]bool
+}
+
+// skipper returns the function to pass skipped rows to as the BadRow of a
+// dataset, nil for none if b is nil.
+func (b *badRows) skipper() func(*dataset.RowError) {
+	if b == nil {
+		return nil
+	}
+	return func(err *dataset.RowError) {
+		key := fmt.Sprintf("%s:%d", err.Path, err.Line)
+		if b.seen[key] {
+			return
+		}
+		if b.seen == nil {
+			b.seen = map[string]bool{}
+		}
+		b.seen[key] = true
+		log.Printf("skipping bad row %v", err)
+	}
+}
+
+// report writes how many rows were skipped, if any, to w.
+func (b *badRows) report(w io.Writer) {
+	if b != nil && len(b.seen) > 0 {
+		fmt.Fprintf(w, "skipped %d bad rows\n", len(b.seen))
+	}
+}
+
+// checkOutputs returns an error if net does not have an output for every
+// class of set.
+func checkOutputs(net nn.Network, set dataset.ImageSet) error {
+	if net.Outputs() != len(set.Classes) {
+		return fmt.Errorf("model has %d outputs but %s has %d classes", net.Outputs(), set.Name, len(set.Classes))
+	}
+	return nil
+}
+
+// batcher collects samples into mini-batches and trains the network on each
+// one as it fills up, keeping track of the loss along the way.
+type batcher struct {
+	net             *nn.Network
+	size            int
+	inputs, targets [][]float64
+	// epoch is the zero-based epoch the batches are from, for errors.
+	epoch int
+	// skip is the number of samples still to pass over without training,
+	// when resuming part way through an epoch.
+	skip int
+	// mixup mixes the samples of each batch in pairs, with weights drawn
+	// from rng, if it is above zero.
+	mixup float64
+	rng   *rand.Rand
+	// onBatch is called after training on each full batch if it is not nil.
+	onBatch func() error
+	// stop asks for training to stop after the current batch once it is
+	// closed.
+	stop <-chan struct{}
+
+	loss    float64
+	samples int
+	// done counts the samples of the epoch trained on or skipped.
+	done int
+}
+
+func (b *batcher) add(s dataset.Sample) error {
+	if b.skip > 0 {
+		b.skip--
+		b.done++
+		return nil
+	}
+	b.inputs = append(b.inputs, s.Inputs)
+	b.targets = append(b.targets, s.Targets)
+	if len(b.inputs) >= b.size {
+		if err := b.flush(); err != nil {
+			return err
+		}
+		select {
+		case <-b.stop:
+			return errInterrupted
+		default:
+		}
+		if b.onBatch != nil {
+			return b.onBatch()
+		}
+	}
+	return nil
+}
+
+// errInterrupted is returned by fit when training is asked to stop early.
+var errInterrupted = errors.New("training interrupted")
+
+// flush trains on whatever is left in the current batch.
+func (b *batcher) flush() error {
+	n := len(b.inputs)
+	if n == 0 {
+		return nil
+	}
+	inputs, targets := b.inputs, b.targets
+	if b.mixup > 0 {
+		inputs, targets = dataset.Mixup(inputs, targets, b.mixup, b.rng)
+	}
+	loss, err := b.net.TrainBatch(inputs, targets)
+	if err != nil {
+		return fmt.Errorf("epoch %d, batch %d: %w", b.epoch+1, b.done/b.size+1, err)
+	}
+	b.loss += loss * float64(n)
+	b.samples += n
+	b.done += n
+	b.inputs, b.targets = b.inputs[:0], b.targets[:0]
+	return nil
+}
+
+// meanLoss returns the mean training loss over the samples seen so far.
+func (b *batcher) meanLoss() float64 {
+	if b.samples == 0 {
+		return 0
+	}
+	return b.loss / float64(b.samples)
+}
+
+// fitOptions controls how fit trains a network.
+type fitOptions struct {
+	epochs    int
+	batchSize int
+	// rng shuffles the training data before every epoch if it is not nil
+	// and the data can be indexed.
+	rng *rand.Rand
+	// sampler picks the samples for each epoch with rng in place of a
+	// plain shuffle if it is not nil.
+	sampler dataset.Sampler
+	// mixup trains on the samples of each mini-batch mixed in pairs, with
+	// Beta(mixup, mixup) weights drawn from mixupRNG, if it is above zero.
+	mixup    float64
+	mixupRNG *rand.Rand
+	// validation is evaluated after every epoch if it is not nil, with
+	// regression metrics if regression is set.
+	validation dataset.Dataset
+	regression bool
+	// metrics are measured on the validation data and reported beside
+	// its loss.
+	metrics []eval.Metric
+	// earlyStop ends training once the validation metrics stop improving
+	// if it is not nil. It needs validation data.
+	earlyStop *earlyStop
+	// checkpoints saves checkpoints as training goes if it is not nil.
+	checkpoints *checkpointer
+	// start is where to resume training from.
+	start nn.Checkpoint
+	// stop interrupts training once closed. A checkpoint is saved after the
+	// batch in progress if checkpoints is not nil.
+	stop <-chan struct{}
+	// progress shows how each epoch is going if it is not nil.
+	progress *progress
+	// log records the metrics of each epoch if it is not nil.
+	log *trainingLog
+	// output is where the metrics of each epoch are printed, os.Stdout if
+	// it is nil.
+	output io.Writer
+	// result is filled in with how training went if it is not nil.
+	result *fitResult
+	// snapshots keeps copies of the network as training goes if it is not
+	// nil.
+	snapshots *snapshots
+	// lbfgs trains with full-batch L-BFGS in place of the optimizer of the
+	// network, taking epochs as the most iterations to run. It leaves out
+	// batchSize, rng, sampler, mixup, checkpoints and progress.
+	lbfgs bool
+}
+
+// fitResult is how training went.
+type fitResult struct {
+	// epochs is the number of epochs the network ends up trained for, up
+	// to the best one if early stopping restored it.
+	epochs int
+	// val holds the validation metrics after that epoch, or nil if there
+	// was no validation data.
+	val *eval.Metrics
+}
+
+// snapshots keeps a copy of the network at the end of every cycle of a
+// cyclic learning rate schedule, cycle epochs long, for a snapshot ensemble.
+type snapshots struct {
+	cycle int
+	nets  []nn.Network
+	// epochs and val hold the epochs each copy was taken after and its
+	// validation metrics, if there was validation data.
+	epochs []int
+	val    []*eval.Metrics
+}
+
+// take keeps a copy of the network if the epoch ends a cycle.
+func (s *snapshots) take(net nn.Network, epoch int, val *eval.Metrics) error {
+	if (epoch+1)%s.cycle != 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	if err := net.SaveTo(&b); err != nil {
+		return err
+	}
+	snapshot, err := nn.ReadNetwork(&b)
+	if err != nil {
+		return err
+	}
+	s.nets, s.epochs, s.val = append(s.nets, snapshot), append(s.epochs, epoch+1), append(s.val, val)
+	return nil
+}
+
+// earlyStop watches a validation metric and calls for training to stop
+// once it has gone patience epochs without improving, keeping a copy of
+// the best network seen so far.
+type earlyStop struct {
+	patience int
+	// metric is loss or accuracy.
+	metric string
+
+	best        float64
+	bestEpoch   int
+	bestMetrics eval.Metrics
+	stale       int
+	snapshot    bytes.Buffer
+}
+
+func newEarlyStop(patience int, metric string) (*earlyStop, error) {
+	if metric != "loss" && metric != "accuracy" {
+		return nil, fmt.Errorf("unknown early stopping metric %q, want loss or accuracy", metric)
+	}
+	return &earlyStop{patience: patience, metric: metric, best: math.Inf(1), bestEpoch: -1}, nil
+}
+
+// update records the validation metrics for an epoch and reports whether
+// training should stop.
+func (e *earlyStop) update(net nn.Network, epoch int, m eval.Metrics) (bool, error) {
+	// lower is better, so negate the accuracy
+	score := m.Loss
+	if e.metric == "accuracy" {
+		score = -m.Accuracy
+	}
+	if score < e.best {
+		e.best, e.bestEpoch, e.bestMetrics, e.stale = score, epoch, m, 0
+		e.snapshot.Reset()
+		return false, net.SaveTo(&e.snapshot)
+	}
+	e.stale++
+	return e.stale >= e.patience, nil
+}
+
+// restore loads the best network seen back into net.
+func (e *earlyStop) restore(net *nn.Network) error {
+	if e.bestEpoch < 0 {
+		return nil
+	}
+	return net.LoadFrom(bytes.NewReader(e.snapshot.Bytes()))
+}
+
+// fit trains the network on data, reporting the training loss and any
+// validation metrics after every epoch.
+func fit(net *nn.Network, data dataset.Dataset, opts fitOptions) error {
+	t1 := time.Now()
+	out := opts.output
+	if out == nil {
+		out = os.Stdout
+	}
+	if opts.lbfgs {
+		return fitLBFGS(net, data, opts, out)
+	}
+
+	indexed, canShuffle := data.(dataset.Indexed)
+	var sampler dataset.Sampler
+	if opts.rng != nil && canShuffle {
+		sampler = opts.sampler
+		if sampler == nil {
+			sampler = dataset.Shuffled(indexed)
+		}
+	}
+	if opts.progress != nil && canShuffle {
+		opts.progress.total = indexed.Len()
+	}
+	if sampler != nil {
+		// replay the shuffles of the epochs already done when resuming, so
+		// the rest of the run sees the same order it would have
+		for epoch := 0; epoch < opts.start.Epoch; epoch++ {
+			sampler.Epoch(opts.rng)
+		}
+	}
+	if opts.start.Epoch > 0 || opts.start.Samples > 0 {
+		// carry on any warm-up from the mini-batches already trained on,
+		// taking it to be over after an epoch of streamed data
+		steps := (opts.start.Samples + opts.batchSize - 1) / opts.batchSize
+		if !canShuffle && opts.start.Epoch > 0 {
+			steps = math.MaxInt32
+		} else if opts.start.Epoch > 0 {
+			steps += opts.start.Epoch * ((indexed.Len() + opts.batchSize - 1) / opts.batchSize)
+		}
+		net.SetStep(steps)
+	}
+
+	for epoch := opts.start.Epoch; epoch < opts.epochs; epoch++ {
+		net.SetEpoch(epoch)
+		b := batcher{net: net, size: opts.batchSize, epoch: epoch, stop: opts.stop, mixup: opts.mixup, rng: opts.mixupRNG}
+		if epoch == opts.start.Epoch {
+			b.skip = opts.start.Samples
+		}
+		bar := opts.progress
+		if bar != nil {
+			bar.begin(epoch, b.skip)
+		}
+		epoch := epoch
+		b.onBatch = func() error {
+			if bar != nil {
+				bar.update(b.done, b.meanLoss())
+			}
+			if opts.checkpoints != nil && opts.checkpoints.ready() {
+				if bar != nil {
+					bar.clear()
+				}
+				return opts.checkpoints.save(*net, nn.Checkpoint{Epoch: epoch, Samples: b.done})
+			}
+			return nil
+		}
+		var err error
+		if sampler != nil {
+			order := sampler.Epoch(opts.rng)
+			if bar != nil {
+				bar.total = len(order)
+			}
+			for _, i := range order {
+				if err = b.add(indexed.At(i)); err != nil {
+					break
+				}
+			}
+		} else {
+			err = data.Each(b.add)
+		}
+		if bar != nil {
+			bar.clear()
+		}
+		if err == errInterrupted {
+			fmt.Fprintf(out, "epoch %d: interrupted after %d samples, loss %.4f\n", epoch+1, b.done, b.meanLoss())
+			if opts.log != nil {
+				if err := opts.log.interrupted(epoch, b.done, b.meanLoss(), net.LearningRate(), time.Since(t1)); err != nil {
+					return fmt.Errorf("writing training log: %w", err)
+				}
+			}
+			if opts.checkpoints != nil {
+				if err := opts.checkpoints.save(*net, nn.Checkpoint{Epoch: epoch, Samples: b.done}); err != nil {
+					return err
+				}
+			}
+		}
+		if err == nil {
+			err = b.flush()
+		}
+		if err != nil {
+			return err
+		}
+		if bar != nil && bar.total == 0 {
+			// streamed data, so the size of an epoch is known after the first
+			bar.total = b.done
+		}
+
+		fmt.Fprintf(out, "epoch %d: loss %.4f", epoch+1, b.meanLoss())
+		m, err := validate(*net, opts, out)
+		if err != nil {
+			return err
+		}
+		if opts.result != nil {
+			opts.result.epochs = epoch + 1
+			if opts.validation != nil {
+				opts.result.val = &m
+			}
+		}
+		if opts.log != nil {
+			var val *eval.Metrics
+			if opts.validation != nil {
+				val = &m
+			}
+			if err := opts.log.epoch(epoch, b.meanLoss(), val, net.LearningRate(), time.Since(t1)); err != nil {
+				return fmt.Errorf("writing training log: %w", err)
+			}
+		}
+
+		if opts.checkpoints != nil {
+			if err := opts.checkpoints.epochDone(*net, epoch); err != nil {
+				return err
+			}
+		}
+		if opts.snapshots != nil {
+			var val *eval.Metrics
+			if opts.validation != nil {
+				val = &m
+			}
+			if err := opts.snapshots.take(*net, epoch, val); err != nil {
+				return err
+			}
+		}
+		if opts.earlyStop != nil {
+			stop, err := opts.earlyStop.update(*net, epoch, m)
+			if err != nil {
+				return err
+			}
+			if stop {
+				fmt.Fprintf(out, "no improvement in val %s for %d epochs, stopping early\n", opts.earlyStop.metric, opts.earlyStop.patience)
+				break
+			}
+		}
+	}
+	if opts.earlyStop != nil && opts.earlyStop.bestEpoch >= 0 {
+		fmt.Fprintf(out, "restoring the network from epoch %d\n", opts.earlyStop.bestEpoch+1)
+		if err := opts.earlyStop.restore(net); err != nil {
+			return err
+		}
+		if opts.result != nil {
+			opts.result.epochs, opts.result.val = opts.earlyStop.bestEpoch+1, &opts.earlyStop.bestMetrics
+		}
+	}
+	elapsed := time.Since(t1)
+	fmt.Fprintf(out, "\nTime taken to train: %s\n", elapsed)
+	return nil
+}
+
+// validate evaluates net on the validation data of opts, if there is any,
+// and ends the line of output for the epoch with the metrics.
+func validate(net nn.Network, opts fitOptions, out io.Writer) (eval.Metrics, error) {
+	var m eval.Metrics
+	if opts.validation != nil {
+		var err error
+		if m, err = eval.Evaluate(net, opts.validation, eval.Options{Regression: opts.regression, Metrics: opts.metrics}); err != nil {
+			return m, err
+		}
+		if m.Regression {
+			fmt.Fprintf(out, ", val loss %.4f, val rmse %.4f, val mae %.4f", m.Loss, m.RMSE, m.MAE)
+		} else {
+			fmt.Fprintf(out, ", val loss %.4f, val accuracy %.2f%%", m.Loss, 100*m.Accuracy)
+		}
+		for _, metric := range opts.metrics {
+			fmt.Fprintf(out, ", val %s %.4f", metric.Name(), m.Scores[metric.Name()])
+		}
+	}
+	fmt.Fprintln(out)
+	return m, nil
+}
+
+// fitLBFGS fits net to the whole of data at once with L-BFGS, running at
+// most opts.epochs iterations, each reported as an epoch would be. The
+// data must fit in memory. When stopped, it keeps the network as far as it
+// got rather than saving a checkpoint to resume from.
+func fitLBFGS(net *nn.Network, data dataset.Dataset, opts fitOptions, out io.Writer) error {
+	t1 := time.Now()
+	indexed, ok := data.(dataset.Indexed)
+	if !ok {
+		return fmt.Errorf("L-BFGS needs the training data to fit in memory")
+	}
+	if opts.snapshots != nil {
+		return fmt.Errorf("L-BFGS cannot train a snapshot ensemble")
+	}
+	inputs := make([][]float64, indexed.Len())
+	targets := make([][]float64, len(inputs))
+	for i := range inputs {
+		s := indexed.At(i)
+		inputs[i], targets[i] = s.Inputs, s.Targets
+	}
+
+	progress := func(iteration int, loss float64) error {
+		fmt.Fprintf(out, "iteration %d: loss %.4f", iteration, loss)
+		m, err := validate(*net, opts, out)
+		if err != nil {
+			return err
+		}
+		var val *eval.Metrics
+		if opts.validation != nil {
+			val = &m
+		}
+		if opts.result != nil {
+			opts.result.epochs, opts.result.val = iteration, val
+		}
+		if opts.log != nil {
+			if err := opts.log.epoch(iteration-1, loss, val, 0, time.Since(t1)); err != nil {
+				return fmt.Errorf("writing training log: %w", err)
+			}
+		}
+		select {
+		case <-opts.stop:
+			return errInterrupted
+		default:
+			return nil
+		}
+	}
+	_, err := net.TrainLBFGS(inputs, targets, nn.LBFGS{Iterations: opts.epochs, Progress: progress})
+	if err == errInterrupted {
+		fmt.Fprintln(out, "training interrupted, keeping the network as it is")
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nTime taken to train: %s\n", time.Since(t1))
+	return nil
+}
+
+// evalOptions controls what evaluate reports.
+type evalOptions struct {
+	// Options are passed on to eval.Evaluate.
+	eval.Options
+	// classes holds the name of each class.
+	classes []string
+	// confusion prints the confusion matrix.
+	confusion bool
+	// confusionOut is a file to write the confusion matrix to, as CSV if it
+	// ends in .csv and JSON otherwise.
+	confusionOut string
+	// misclassified is a directory to write the misclassified images to.
+	misclassified string
+}
+
+func evaluate(net eval.Model, data dataset.Dataset, opts evalOptions) error {
+	t1 := time.Now()
+
+	opts.Misclassified = opts.misclassified != ""
+	m, err := eval.Evaluate(net, data, opts.Options)
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(t1)
+	fmt.Printf("Time taken to check: %s\n", elapsed)
+	if m.Regression {
+		return m.Print(os.Stdout, nil)
+	}
+	fmt.Printf("Tests run: %d\n", m.Confusion.Total())
+	fmt.Println("score:", m.Confusion.Correct())
+
+	names := opts.classes
+	if len(names) != len(m.Confusion) {
+		names = make([]string, len(m.Confusion))
+		for i := range names {
+			names[i] = strconv.Itoa(i)
+		}
+	}
+	fmt.Println()
+	if err := m.Print(os.Stdout, names); err != nil {
+		return err
+	}
+	if opts.confusion {
+		fmt.Println("\nConfusion matrix, actual classes by predicted:")
+		if err := m.Confusion.Print(os.Stdout, names); err != nil {
+			return err
+		}
+	}
+	if opts.misclassified != "" {
+		if err := writeMisclassified(opts.misclassified, m.Misclassified, names); err != nil {
+			return err
+		}
+	}
+	if opts.confusionOut != "" {
+		f, err := os.Create(opts.confusionOut)
+		if err != nil {
+			return err
+		}
+		if filepath.Ext(opts.confusionOut) == ".csv" {
+			err = m.Confusion.WriteCSV(f, names)
+		} else {
+			err = m.Confusion.WriteJSON(f, names)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kheob/ml/eval"
+	"gopkg.in/yaml.v3"
+)
+
+// trainingLog writes a record of every epoch of a run to a file so runs can
+// be compared later. The file is CSV if its name ends in .csv and JSON lines
+// otherwise. Each run starts with a header holding its ID and config, and
+// runs are appended to an existing file.
+type trainingLog struct {
+	f   *os.File
+	csv *csv.Writer
+	run string
+}
+
+// epochRecord is the line written to a training log for each epoch.
+type epochRecord struct {
+	Run          string   `json:"run"`
+	Epoch        int      `json:"epoch"`
+	Loss         float64  `json:"loss"`
+	ValLoss      *float64 `json:"val_loss,omitempty"`
+	ValAccuracy  *float64 `json:"val_accuracy,omitempty"`
+	LearningRate float64  `json:"learning_rate"`
+	// Seconds is the wall time since training started.
+	Seconds float64 `json:"seconds"`
+	// Samples is set for an epoch training was interrupted part way
+	// through, to the number of its samples done.
+	Samples *int `json:"samples,omitempty"`
+
+	// ValScores holds any other validation metrics by name. Only JSON
+	// lines logs have them, as CSV logs have the same columns for every
+	// run.
+	ValScores map[string]float64 `json:"val_scores,omitempty"`
+}
+
+var logColumns = []string{"run", "epoch", "loss", "val_loss", "val_accuracy", "learning_rate", "seconds", "samples"}
+
+// newRunID returns a unique ID for a training run, starting with the time
+// so IDs sort in the order runs were started.
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// openTrainingLog opens the log at path for the run described by cfg and
+// writes the header for the run.
+func openTrainingLog(path string, cfg trainConfig) (*trainingLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// round trip the config through YAML so the header uses the same
+	// field names as the config file
+	var config map[string]interface{}
+	b, err := yaml.Marshal(cfg)
+	if err == nil {
+		err = yaml.Unmarshal(b, &config)
+	}
+	if err == nil {
+		b, err = json.Marshal(config)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &trainingLog{f: f, run: cfg.RunID}
+	if filepath.Ext(path) == ".csv" {
+		l.csv = csv.NewWriter(f)
+		_, err = fmt.Fprintf(f, "# run %s\n# config %s\n", cfg.RunID, b)
+		if err == nil && info.Size() == 0 {
+			l.csv.Write(logColumns)
+			l.csv.Flush()
+			err = l.csv.Error()
+		}
+	} else {
+		_, err = fmt.Fprintf(f, "{\"run\":%q,\"config\":%s}\n", cfg.RunID, b)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// epoch writes the record of an epoch, counting from zero, with the
+// validation metrics if val is not nil.
+func (l *trainingLog) epoch(epoch int, loss float64, val *eval.Metrics, rate float64, elapsed time.Duration) error {
+	r := epochRecord{
+		Run:          l.run,
+		Epoch:        epoch + 1,
+		Loss:         loss,
+		LearningRate: rate,
+		Seconds:      elapsed.Seconds(),
+	}
+	if val != nil {
+		r.ValLoss = &val.Loss
+		r.ValScores = val.Scores
+		if !val.Regression {
+			r.ValAccuracy = &val.Accuracy
+		}
+	}
+	return l.write(r)
+}
+
+// interrupted writes the record of an epoch, counting from zero, that
+// training was interrupted part way through, after samples of it with a
+// mean loss of loss.
+func (l *trainingLog) interrupted(epoch, samples int, loss, rate float64, elapsed time.Duration) error {
+	return l.write(epochRecord{
+		Run:          l.run,
+		Epoch:        epoch + 1,
+		Loss:         loss,
+		LearningRate: rate,
+		Seconds:      elapsed.Seconds(),
+		Samples:      &samples,
+	})
+}
+
+func (l *trainingLog) write(r epochRecord) error {
+	if l.csv == nil {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = l.f.Write(append(b, '\n'))
+		return err
+	}
+
+	optional := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return formatFloat(*v)
+	}
+	samples := ""
+	if r.Samples != nil {
+		samples = strconv.Itoa(*r.Samples)
+	}
+	l.csv.Write([]string{
+		r.Run,
+		strconv.Itoa(r.Epoch),
+		formatFloat(r.Loss),
+		optional(r.ValLoss),
+		optional(r.ValAccuracy),
+		formatFloat(r.LearningRate),
+		formatFloat(r.Seconds),
+		samples,
+	})
+	l.csv.Flush()
+	return l.csv.Error()
+}
+
+func (l *trainingLog) Close() error {
+	return l.f.Close()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
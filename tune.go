@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kheob/ml/eval"
+	"github.com/kheob/ml/nn"
+	"gopkg.in/yaml.v3"
+)
+
+// tuneConfig is a training config along with the values to try for some of
+// its settings. Every combination of the values in the grid is trained, or
+// with random search a number of runs each taking a value at random from
+// the grid or the range in random. A setting left out keeps its value from
+// the config.
+type tuneConfig struct {
+	trainConfig `yaml:",inline"`
+	Grid        struct {
+		Hidden       []sizes   `yaml:"hidden"`
+		LearningRate []float64 `yaml:"learning_rate"`
+		BatchSize    []int     `yaml:"batch_size"`
+		Epochs       []int     `yaml:"epochs"`
+	} `yaml:"grid"`
+	Random struct {
+		// Hidden is the range of the size of each hidden layer, keeping the
+		// number of hidden layers of the config.
+		Hidden       *searchRange `yaml:"hidden"`
+		LearningRate *searchRange `yaml:"learning_rate"`
+		BatchSize    *searchRange `yaml:"batch_size"`
+	} `yaml:"random"`
+}
+
+// searchRange is a range of values to sample from in random search.
+type searchRange struct {
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+	// Log samples evenly over the orders of magnitude in the range rather
+	// than the values, as suits learning rates.
+	Log bool `yaml:"log"`
+}
+
+// check returns an error if the range is empty or cannot be sampled.
+func (r *searchRange) check(name string) error {
+	if r == nil {
+		return nil
+	}
+	if r.Min > r.Max {
+		return fmt.Errorf("random %s: min %g is over max %g", name, r.Min, r.Max)
+	}
+	if r.Log && r.Min <= 0 {
+		return fmt.Errorf("random %s: a log range must be above 0, got min %g", name, r.Min)
+	}
+	return nil
+}
+
+// sample returns a value from the range picked at random.
+func (r searchRange) sample(rng *rand.Rand) float64 {
+	if r.Log {
+		return math.Exp(math.Log(r.Min) + rng.Float64()*(math.Log(r.Max)-math.Log(r.Min)))
+	}
+	return r.Min + rng.Float64()*(r.Max-r.Min)
+}
+
+// sampleInt returns a whole number from the range picked at random.
+func (r searchRange) sampleInt(rng *rand.Rand) int {
+	n := int(math.Round(r.sample(rng)))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// load reads the YAML tuning file at path over the top of c.
+func (c *tuneConfig) load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(b)))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.Random.Hidden.check("hidden"); err != nil {
+		return err
+	}
+	if err := c.Random.LearningRate.check("learning_rate"); err != nil {
+		return err
+	}
+	return c.Random.BatchSize.check("batch_size")
+}
+
+// configs returns a training config for every combination of the values in
+// the grid.
+func (c tuneConfig) configs() []trainConfig {
+	configs := []trainConfig{c.trainConfig}
+	vary := func(n int, set func(cfg *trainConfig, i int)) {
+		if n == 0 {
+			return
+		}
+		var next []trainConfig
+		for _, cfg := range configs {
+			for i := 0; i < n; i++ {
+				cfg := cfg
+				set(&cfg, i)
+				next = append(next, cfg)
+			}
+		}
+		configs = next
+	}
+	vary(len(c.Grid.Hidden), func(cfg *trainConfig, i int) { cfg.Hidden = c.Grid.Hidden[i] })
+	vary(len(c.Grid.LearningRate), func(cfg *trainConfig, i int) { cfg.LearningRate = c.Grid.LearningRate[i] })
+	vary(len(c.Grid.BatchSize), func(cfg *trainConfig, i int) { cfg.BatchSize = c.Grid.BatchSize[i] })
+	vary(len(c.Grid.Epochs), func(cfg *trainConfig, i int) { cfg.Epochs = c.Grid.Epochs[i] })
+	return configs
+}
+
+// sample returns n training configs with settings picked at random, from
+// the range in random if there is one and from the values in the grid
+// otherwise.
+func (c tuneConfig) sample(n int, rng *rand.Rand) []trainConfig {
+	configs := make([]trainConfig, n)
+	for i := range configs {
+		cfg := c.trainConfig
+		if r := c.Random.Hidden; r != nil {
+			cfg.Hidden = make(sizes, len(c.Hidden))
+			for j := range cfg.Hidden {
+				cfg.Hidden[j] = r.sampleInt(rng)
+			}
+		} else if len(c.Grid.Hidden) > 0 {
+			cfg.Hidden = c.Grid.Hidden[rng.Intn(len(c.Grid.Hidden))]
+		}
+		if r := c.Random.LearningRate; r != nil {
+			cfg.LearningRate = r.sample(rng)
+		} else if len(c.Grid.LearningRate) > 0 {
+			cfg.LearningRate = c.Grid.LearningRate[rng.Intn(len(c.Grid.LearningRate))]
+		}
+		if r := c.Random.BatchSize; r != nil {
+			cfg.BatchSize = r.sampleInt(rng)
+		} else if len(c.Grid.BatchSize) > 0 {
+			cfg.BatchSize = c.Grid.BatchSize[rng.Intn(len(c.Grid.BatchSize))]
+		}
+		if len(c.Grid.Epochs) > 0 {
+			cfg.Epochs = c.Grid.Epochs[rng.Intn(len(c.Grid.Epochs))]
+		}
+		configs[i] = cfg
+	}
+	return configs
+}
+
+// trial is a run with one combination of settings, which may be trained a
+// few epochs at a time.
+type trial struct {
+	id  int
+	cfg trainConfig
+	net nn.Network
+	// epochs is the number of epochs trained so far.
+	epochs int
+	// val holds the metrics of the network on the validation split after
+	// the last epoch trained.
+	val     eval.Metrics
+	elapsed time.Duration
+	// pruned is set once the run is dropped for doing worse than the
+	// others.
+	pruned bool
+}
+
+// train carries on training the network of the trial up to the given
+// number of epochs, creating it first if need be, and evaluates it on the
+// validation split.
+func (t *trial) train(set trainingSet, epochs int) error {
+	t1 := time.Now()
+	if t.epochs == 0 {
+		netOpts, err := networkOptions(t.cfg)
+		if err != nil {
+			return err
+		}
+		if t.net, _, err = buildNetwork(t.cfg, set, netOpts, ""); err != nil {
+			return err
+		}
+	}
+	// the split and shuffles are set up afresh from the seed, and fit
+	// replays the shuffles of the epochs already done
+	data, opts, err := fitSetup(t.cfg, set)
+	if err != nil {
+		return err
+	}
+	opts.start = nn.Checkpoint{Epoch: t.epochs}
+	opts.epochs = epochs
+	opts.output = io.Discard
+	if err := fit(&t.net, data, opts); err != nil {
+		return err
+	}
+	t.epochs = epochs
+	if t.val, err = eval.Evaluate(t.net, opts.validation, eval.Options{Regression: opts.regression}); err != nil {
+		return err
+	}
+	t.elapsed += time.Since(t1)
+	return nil
+}
+
+func tuneCmd(args []string) error {
+	fs := flag.NewFlagSet("tune", flag.ExitOnError)
+	gridPath := fs.String("grid", "", "YAML file holding a training config as for ml train -config, with a grid section listing the hidden, learning_rate, batch_size and epochs values to try, and for random search a random section giving a min, max and log for hidden, learning_rate and batch_size")
+	trials := fs.Int("trials", 0, "Number of runs to train with settings picked at random, rather than every combination in the grid")
+	prune := fs.Int("prune", 0, "Every this many epochs, drop the worse half of the runs still training, 0 to train every run to the end")
+	parallel := fs.Int("parallel", 1, "Number of runs to train at once")
+	metric := fs.String("metric", "loss", "Validation metric to rank the runs by: loss or accuracy")
+	out := fs.String("out", "tune_results.csv", "CSV file to write the ranked results to, empty for none")
+	best := fs.String("best", "", "YAML file to write the config of the best run to, for ml train -config")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ml tune -grid grid.yaml [flags]")
+		fmt.Fprintln(fs.Output(), "\nTrains a network for every combination of the settings in the grid, or for -trials")
+		fmt.Fprintln(fs.Output(), "combinations picked at random, and ranks them on the validation split, 0.1 of the")
+		fmt.Fprintln(fs.Output(), "training data unless the file sets val_split.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *gridPath == "" {
+		return fmt.Errorf("tune needs a -grid file")
+	}
+	if *parallel <= 0 {
+		return fmt.Errorf("-parallel must be positive, got %d", *parallel)
+	}
+	if *trials < 0 || *prune < 0 {
+		return fmt.Errorf("-trials and -prune must not be negative")
+	}
+	if *metric != "loss" && *metric != "accuracy" {
+		return fmt.Errorf("unknown metric %q, want loss or accuracy", *metric)
+	}
+	tc := tuneConfig{trainConfig: defaultTrainConfig()}
+	tc.ValSplit = 0.1
+	if err := tc.load(*gridPath); err != nil {
+		return err
+	}
+	if tc.ValSplit <= 0 {
+		return fmt.Errorf("tuning needs a validation split to rank the runs on, set val_split")
+	}
+	if *metric == "accuracy" && (tc.Dataset == "csv" && tc.CSV.Regression || tc.Task == "autoencoder") {
+		return fmt.Errorf("there is no accuracy to rank the runs by, use -metric loss")
+	}
+	if tc.Random.Hidden != nil || tc.Random.LearningRate != nil || tc.Random.BatchSize != nil {
+		if *trials == 0 {
+			return fmt.Errorf("the random section is for random search, set the number of runs with -trials")
+		}
+	}
+	if *prune > 0 && tc.EarlyStop.Patience > 0 {
+		return fmt.Errorf("pruning already stops the worse runs early, so cannot be used with early stopping")
+	}
+	// every run shares the seed, so is validated on the same samples
+	if tc.Seed == 0 {
+		tc.Seed = time.Now().UTC().UnixNano()
+	}
+	configs := tc.configs()
+	if *trials > 0 {
+		configs = tc.sample(*trials, rand.New(rand.NewSource(tc.Seed)))
+	}
+	for _, cfg := range configs {
+		if _, err := networkOptions(cfg); err != nil {
+			return err
+		}
+	}
+	set, err := loadTrainingSet(tc.trainConfig)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("training %d runs\n", len(configs))
+	all := make([]*trial, len(configs))
+	for i, cfg := range configs {
+		all[i] = &trial{id: i + 1, cfg: cfg}
+	}
+	better := func(a, b *trial) bool {
+		if *metric == "accuracy" {
+			return a.val.Accuracy > b.val.Accuracy
+		}
+		return a.val.Loss < b.val.Loss
+	}
+	if *prune == 0 {
+		if err := trainTrials(all, set, *parallel, func(t *trial) int { return t.cfg.Epochs }); err != nil {
+			return err
+		}
+	}
+	// successive halving: train the runs left a few epochs at a time,
+	// dropping the worse half of those with epochs still to go each time
+	active := all
+	for epochs := *prune; *prune > 0 && len(active) > 0; epochs += *prune {
+		epochs := epochs
+		if err := trainTrials(active, set, *parallel, func(t *trial) int {
+			if epochs < t.cfg.Epochs {
+				return epochs
+			}
+			return t.cfg.Epochs
+		}); err != nil {
+			return err
+		}
+		var going []*trial
+		for _, t := range active {
+			if t.epochs < t.cfg.Epochs {
+				going = append(going, t)
+			}
+		}
+		sort.SliceStable(going, func(i, j int) bool { return better(going[i], going[j]) })
+		keep := (len(going) + 1) / 2
+		for _, t := range going[keep:] {
+			t.pruned = true
+		}
+		if len(going) > keep {
+			fmt.Printf("epoch %d: pruned %d of %d runs\n", epochs, len(going)-keep, len(going))
+		}
+		active = going[:keep]
+	}
+
+	// runs trained for longer rank above those pruned sooner
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].epochs != all[j].epochs {
+			return all[i].epochs > all[j].epochs
+		}
+		return better(all[i], all[j])
+	})
+	fmt.Println()
+	if err := writeTrials(os.Stdout, all); err != nil {
+		return err
+	}
+	if *out != "" {
+		if err := writeTrialsCSV(*out, all); err != nil {
+			return err
+		}
+		fmt.Printf("\nwrote the results to %s\n", *out)
+	}
+	if *best != "" {
+		if err := all[0].cfg.save(*best); err != nil {
+			return err
+		}
+		fmt.Printf("wrote the config of the best run to %s, train it with ml train -config %s\n", *best, *best)
+	}
+	return nil
+}
+
+// trainTrials trains each of trials up to the number of epochs given by
+// epochs, parallel at a time, stopping at the first error.
+func trainTrials(trials []*trial, set trainingSet, parallel int, epochs func(t *trial) int) error {
+	runs := make(chan *trial)
+	errs := make(chan error, parallel)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range runs {
+				if err := t.train(set, epochs(t)); err != nil {
+					errs <- fmt.Errorf("run %d with %s: %w", t.id, settings(t.cfg), err)
+					return
+				}
+				mu.Lock()
+				fmt.Printf("run %d: %s: epoch %d, %s\n", t.id, settings(t.cfg), t.epochs, valSummary(t.val))
+				mu.Unlock()
+			}
+		}()
+	}
+	var err error
+feed:
+	for _, t := range trials {
+		select {
+		case runs <- t:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(runs)
+	wg.Wait()
+	if err == nil && len(errs) > 0 {
+		err = <-errs
+	}
+	return err
+}
+
+// settings describes the settings of cfg that are tuned.
+func settings(cfg trainConfig) string {
+	return fmt.Sprintf("hidden %s, lr %.4g, batch size %d, epochs %d", cfg.Hidden.String(), cfg.LearningRate, cfg.BatchSize, cfg.Epochs)
+}
+
+// valSummary describes the validation metrics m.
+func valSummary(m eval.Metrics) string {
+	if m.Regression {
+		return fmt.Sprintf("val loss %.4f, val rmse %.4f", m.Loss, m.RMSE)
+	}
+	return fmt.Sprintf("val loss %.4f, val accuracy %.2f%%", m.Loss, 100*m.Accuracy)
+}
+
+// writeTrials prints the ranked trials as a table.
+func writeTrials(w io.Writer, trials []*trial) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	last := "Val accuracy"
+	if trials[0].val.Regression {
+		last = "Val RMSE"
+	}
+	fmt.Fprintf(tw, "Rank\tRun\tHidden\tLR\tBatch size\tEpochs\tTime\tVal loss\t%s\n", last)
+	for i, t := range trials {
+		score := fmt.Sprintf("%.2f%%", 100*t.val.Accuracy)
+		if t.val.Regression {
+			score = fmt.Sprintf("%.4f", t.val.RMSE)
+		}
+		epochs := strconv.Itoa(t.epochs)
+		if t.pruned {
+			epochs += " (pruned)"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%.4g\t%d\t%s\t%s\t%.4f\t%s\n", i+1, t.id, t.cfg.Hidden.String(), t.cfg.LearningRate, t.cfg.BatchSize, epochs, t.elapsed.Round(time.Millisecond), t.val.Loss, score)
+	}
+	return tw.Flush()
+}
+
+// writeTrialsCSV writes the ranked trials to the CSV file at path.
+func writeTrialsCSV(path string, trials []*trial) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"rank", "run", "hidden", "learning_rate", "batch_size", "epochs", "pruned", "seconds", "val_loss", "val_accuracy", "val_rmse"})
+	for i, t := range trials {
+		w.Write([]string{
+			strconv.Itoa(i + 1),
+			strconv.Itoa(t.id),
+			t.cfg.Hidden.String(),
+			strconv.FormatFloat(t.cfg.LearningRate, 'g', -1, 64),
+			strconv.Itoa(t.cfg.BatchSize),
+			strconv.Itoa(t.epochs),
+			strconv.FormatBool(t.pruned),
+			strconv.FormatFloat(t.elapsed.Seconds(), 'f', 3, 64),
+			strconv.FormatFloat(t.val.Loss, 'g', -1, 64),
+			strconv.FormatFloat(t.val.Accuracy, 'g', -1, 64),
+			strconv.FormatFloat(t.val.RMSE, 'g', -1, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}